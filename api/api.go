@@ -0,0 +1,792 @@
+// Package api - This package serves a versioned JSON API under /api/v1 for everything the GUI can
+// do, so Lynx can be scripted and driven by other front ends. Lynks and files are addressed by
+// name rather than by their index in the GUI's tables.
+package api
+
+import (
+	"../client"
+	"../events"
+	"../jobs"
+	"../logs"
+	"../lynxutil"
+	"../ratelimit"
+	"../server"
+	"../tracker"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix - The path every API route lives under
+const Prefix = "/api/v1/"
+
+// The largest request body we will read
+const maxBody = 1 << 16
+
+// The settings of our node
+var config = lynxutil.DefaultConfig()
+
+// LynkJSON - A Lynk as the API returns it
+type LynkJSON struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Path    string `json:"path"` // The folder the Lynk is kept in
+	Owner   string `json:"owner"`
+	Tracker string `json:"tracker"`
+	Files   int    `json:"files"`
+	State   string `json:"state"`
+	Sync    string `json:"sync"` // Synced, Syncing, Out of date or Error
+	Mode    string `json:"mode"` // two-way, receive-only or send-only
+
+	Encrypted bool `json:"encrypted"`
+	Trusted   bool `json:"trusted"` // Whether or not we have the key of an encrypted Lynk
+}
+
+// ModeJSON - Whether a Lynk is synced both ways, only receives or only sends, and the files
+// added locally to a receive-only Lynk which are not pushed
+type ModeJSON struct {
+	Mode         string   `json:"mode"`
+	LocalChanges []string `json:"localChanges"`
+}
+
+// FileJSON - A file of a Lynk as the API returns it
+type FileJSON struct {
+	Name   string `json:"name"`
+	Length int    `json:"length"`
+	Sync   string `json:"sync"`
+	Error  string `json:"error,omitempty"`
+
+	PlainName string `json:"plainName,omitempty"` // The name a file of an encrypted Lynk was sealed from
+}
+
+// InviteJSON - An invite to a Lynk, and the fingerprint of the key it is signed with
+type InviteJSON struct {
+	ValidFor    string    `json:"validFor,omitempty"` // How long a new invite works for
+	Invite      string    `json:"invite,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Expires     time.Time `json:"expires,omitempty"`
+}
+
+// KeyJSON - The key of an encrypted Lynk as it is handed to a trusted member. Exporting takes the
+// member's "publicKey" and returns the encrypted "key", which the member imports along with
+// their "privateKey" and its "passphrase".
+type KeyJSON struct {
+	PublicKey  string `json:"publicKey,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Key        []byte `json:"key,omitempty"` // Base64 in JSON
+}
+
+// PeerJSON - A peer of a Lynk as the API returns it
+type PeerJSON struct {
+	Addrs  []string `json:"addrs"`
+	Source string   `json:"source"`
+}
+
+// StatusJSON - The state of our node as the API returns it
+type StatusJSON struct {
+	Home        string   `json:"home"`
+	Server      []string `json:"server"`
+	TrackerPort string   `json:"trackerPort"`
+	DHT         bool     `json:"dht"`
+	Lynks       int      `json:"lynks"`
+	Downloading int      `json:"downloading"`
+	Paused      int      `json:"paused"`
+}
+
+// The body of a request to create or join a Lynk
+type lynkRequest struct {
+	Name      string `json:"name"`
+	MetaPath  string `json:"metaPath"`
+	Invite    string `json:"invite"`    // Join with an invite rather than a meta.info file
+	Path      string `json:"path"`      // The folder to create the Lynk from or join it into
+	Encrypted bool   `json:"encrypted"` // Create the Lynk with a key so storage peers cannot read it
+}
+
+// The body of every error response
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// Register - Adds the API's routes to mux
+// @param *http.ServeMux mux - The mux to register with - usually http.DefaultServeMux
+// @param lynxutil.Config cfg - The config of our node
+func Register(mux *http.ServeMux, cfg lynxutil.Config) {
+	config = cfg
+	mux.HandleFunc(Prefix, Handler)
+}
+
+// Handler - Routes a request under /api/v1/ to the function that handles it. A <lynk> is the name
+// or ID of a Lynk. The routes are status (GET), events (GET - a Server-Sent Events stream of
+// transfer progress), lynks (GET, POST with "name" to create or "metaPath" or "invite" to join),
+// lynks/<lynk>/invite (POST with an optional "validFor" such as "72h"), lynks/<lynk> (GET, DELETE
+// with ?delete=true to remove its files too), lynks/<lynk>/name (PUT with "name" - renames our
+// folder for it), lynks/<lynk>/files (GET), lynks/<lynk>/files/<file> (DELETE),
+// lynks/<lynk>/files/<file>/fetch (POST - download the file even if it is not selected),
+// lynks/<lynk>/peers (GET), lynks/<lynk>/pause, resume or cancel (POST - these act on the Lynk's
+// transfers too), lynks/<lynk>/limits (GET, PUT), lynks/<lynk>/selection (GET, PUT with "include"
+// and "exclude" patterns), lynks/<lynk>/mode (GET, PUT with "mode"), jobs (GET - every transfer in
+// progress), lynks/<lynk>/key/export (POST with "publicKey"), lynks/<lynk>/key/import (POST with
+// "key", "privateKey" and "passphrase"), jobs/<id>/pause, resume or cancel (POST), limits (GET -
+// the bandwidth limits in force) and logs (GET - recent log entries, filtered by ?level=,
+// component=, lynk=, peer=, text= and limit=).
+// Every route needs a caller on this host or our APIToken as a bearer token - see authorized.
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+func Handler(rw http.ResponseWriter, req *http.Request) {
+	if !authorized(req) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(rw, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	parts, err := splitPath(req.URL.EscapedPath())
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "status":
+		if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, status())
+		}
+	case len(parts) == 1 && parts[0] == "events":
+		if allow(rw, req, "GET") {
+			events.ServeSSE(rw, req)
+		}
+	case len(parts) == 1 && parts[0] == "lynks":
+		if req.Method == "POST" {
+			createOrJoin(rw, req)
+		} else if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, listLynks())
+		}
+	case len(parts) >= 2 && parts[0] == "lynks":
+		handleLynk(rw, req, parts[1], parts[2:])
+	case len(parts) == 1 && parts[0] == "limits":
+		if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, currentLimits())
+		}
+	case len(parts) == 1 && parts[0] == "jobs":
+		if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, jobs.List())
+		}
+	case len(parts) == 3 && parts[0] == "jobs":
+		if allow(rw, req, "POST") {
+			controlJob(rw, parts[1], parts[2])
+		}
+	case len(parts) == 1 && parts[0] == "logs":
+		if allow(rw, req, "GET") {
+			recentLogs(rw, req)
+		}
+	default:
+		writeError(rw, http.StatusNotFound, "No Such Route")
+	}
+}
+
+// Helper function which handles every route under a single Lynk
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+// @param string name - The name or ID of the Lynk
+// @param []string rest - The parts of the path after the Lynk's name
+func handleLynk(rw http.ResponseWriter, req *http.Request, name string, rest []string) {
+	if lynkName, ok := client.ResolveLynk(name); ok {
+		name = lynkName
+	}
+	lynk := lynxutil.GetLynk(client.GetLynks(), name)
+	if lynk == nil {
+		writeError(rw, http.StatusNotFound, "Lynk "+name+" Not Found")
+		return
+	}
+
+	switch {
+	case len(rest) == 0:
+		if req.Method == "DELETE" {
+			client.DeleteLynk(lynk.Name, req.URL.Query().Get("delete") == "true")
+			rw.WriteHeader(http.StatusNoContent)
+		} else if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, toLynkJSON(*lynk))
+		}
+	case len(rest) == 1 && rest[0] == "files":
+		if allow(rw, req, "GET") {
+			files := []FileJSON{}
+			for _, file := range lynk.Files {
+				files = append(files, toFileJSON(file))
+			}
+			writeJSON(rw, http.StatusOK, files)
+		}
+	case len(rest) == 2 && rest[0] == "files":
+		if allow(rw, req, "DELETE") {
+			removeFile(rw, lynk.Name, rest[1])
+		}
+	case len(rest) == 3 && rest[0] == "files" && rest[2] == "fetch":
+		if allow(rw, req, "POST") {
+			fetchFile(rw, *lynk, rest[1])
+		}
+	case len(rest) == 1 && rest[0] == "peers":
+		if allow(rw, req, "GET") {
+			peers := []PeerJSON{}
+			for _, peer := range lynk.Peers {
+				peers = append(peers, PeerJSON{peer.Addresses(), peerSource(peer)})
+			}
+			writeJSON(rw, http.StatusOK, peers)
+		}
+	case len(rest) == 1 && rest[0] == "pause":
+		if allow(rw, req, "POST") {
+			client.PauseLynk(lynk.Name)
+			writeJSON(rw, http.StatusOK, toLynkJSON(*lynk))
+		}
+	case len(rest) == 1 && rest[0] == "resume":
+		if allow(rw, req, "POST") {
+			client.ResumeLynk(lynk.Name)
+			writeJSON(rw, http.StatusOK, toLynkJSON(*lynk))
+		}
+	case len(rest) == 1 && rest[0] == "limits":
+		if req.Method == "PUT" {
+			setLimits(rw, req, lynk.Name)
+		} else if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, lynk.Limits)
+		}
+	case len(rest) == 1 && rest[0] == "selection":
+		if req.Method == "PUT" {
+			setSelection(rw, req, lynk.Name)
+		} else if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, toSelectionJSON(lynk.Selection))
+		}
+	case len(rest) == 2 && rest[0] == "key" && (rest[1] == "export" || rest[1] == "import"):
+		if allow(rw, req, "POST") {
+			handleKey(rw, req, lynk.Name, rest[1])
+		}
+	case len(rest) == 1 && rest[0] == "name":
+		if allow(rw, req, "PUT") {
+			renameLynk(rw, req, lynk.Name)
+		}
+	case len(rest) == 1 && rest[0] == "invite":
+		if allow(rw, req, "POST") {
+			createInvite(rw, req, lynk.Name)
+		}
+	case len(rest) == 1 && rest[0] == "mode":
+		if req.Method == "PUT" {
+			setMode(rw, req, lynk.Name)
+		} else if allow(rw, req, "GET") {
+			writeJSON(rw, http.StatusOK, toModeJSON(*lynk))
+		}
+	case len(rest) == 1 && rest[0] == "cancel":
+		if allow(rw, req, "POST") {
+			client.StopDownload(lynk.Name)
+			jobs.CancelLynk(lynk.Name) // Our uploads of the Lynk's files
+			writeJSON(rw, http.StatusOK, toLynkJSON(*lynk))
+		}
+	default:
+		writeError(rw, http.StatusNotFound, "No Such Route")
+	}
+}
+
+// Helper function which sets the bandwidth limits of a Lynk from a body such as
+// {"upload": 100, "download": 0} - in kilobytes per second, 0 for unlimited
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+// @param string lynkName - The name of the Lynk
+func setLimits(rw http.ResponseWriter, req *http.Request, lynkName string) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		writeError(rw, http.StatusUnsupportedMediaType, "Content-Type Must Be application/json")
+		return
+	}
+
+	var limits lynxutil.Limits
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxBody)).Decode(&limits); err != nil {
+		writeError(rw, http.StatusBadRequest, "Invalid JSON Body: "+err.Error())
+		return
+	}
+	if err := client.SetLimits(lynkName, limits); err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, limits)
+}
+
+// Helper function which describes the limits in force, listing the limits of each Lynk under our
+// name for it rather than its ID
+// @return ratelimit.Status - The limits
+func currentLimits() ratelimit.Status {
+	status := ratelimit.Current()
+	byName := make(map[string]lynxutil.Limits)
+	for _, lynk := range client.GetLynks() {
+		if limits, ok := status.LynkLimits[lynk.ID]; ok {
+			byName[lynk.Name] = limits
+		}
+	}
+	status.LynkLimits = byName
+	return status
+}
+
+// Helper function which chooses which of a Lynk's files we download from a body such as
+// {"include": ["Photos/", "*.txt"], "exclude": ["*.tmp"]}
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+// @param string lynkName - The name of the Lynk
+func setSelection(rw http.ResponseWriter, req *http.Request, lynkName string) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		writeError(rw, http.StatusUnsupportedMediaType, "Content-Type Must Be application/json")
+		return
+	}
+
+	var selection lynxutil.Selection
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxBody)).Decode(&selection); err != nil {
+		writeError(rw, http.StatusBadRequest, "Invalid JSON Body: "+err.Error())
+		return
+	}
+	if err := client.SetSelection(lynkName, selection); err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, toSelectionJSON(selection))
+}
+
+// Helper function which sets whether a Lynk is synced both ways, only receives changes or only
+// sends them from a body such as {"mode": "receive-only"}
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+// @param string lynkName - The name of the Lynk
+func setMode(rw http.ResponseWriter, req *http.Request, lynkName string) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		writeError(rw, http.StatusUnsupportedMediaType, "Content-Type Must Be application/json")
+		return
+	}
+
+	var body ModeJSON
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxBody)).Decode(&body); err != nil {
+		writeError(rw, http.StatusBadRequest, "Invalid JSON Body: "+err.Error())
+		return
+	}
+	if err := client.SetMode(lynkName, body.Mode); err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	lynk, _ := client.GetLynk(lynkName)
+	writeJSON(rw, http.StatusOK, toModeJSON(lynk))
+}
+
+// Helper function which renames our folder for a Lynk from a body such as {"name": "Photos"}.
+// Its ID and the name its owner gave it stay the same.
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+// @param string lynkName - The name of the Lynk
+func renameLynk(rw http.ResponseWriter, req *http.Request, lynkName string) {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		writeError(rw, http.StatusUnsupportedMediaType, "Content-Type Must Be application/json")
+		return
+	}
+
+	var body lynkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxBody)).Decode(&body); err != nil {
+		writeError(rw, http.StatusBadRequest, "Invalid JSON Body: "+err.Error())
+		return
+	}
+	if !validName(body.Name) {
+		writeError(rw, http.StatusBadRequest, "Invalid Lynk Name")
+		return
+	}
+	if lynxutil.GetLynk(client.GetLynks(), body.Name) != nil {
+		writeError(rw, http.StatusConflict, "Lynk "+body.Name+" Already Exists")
+		return
+	}
+	if err := client.RenameLynk(lynkName, body.Name); err != nil {
+		writeError(rw, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	lynk, _ := client.GetLynk(body.Name)
+	rw.Header().Set("Location", Prefix+"lynks/"+url.PathEscape(body.Name))
+	writeJSON(rw, http.StatusOK, toLynkJSON(lynk))
+}
+
+// Helper function which creates an invite to a Lynk from an optional body such as
+// {"validFor": "72h"} - invites work for a week by default
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+// @param string lynkName - The name of the Lynk
+func createInvite(rw http.ResponseWriter, req *http.Request, lynkName string) {
+	var body InviteJSON
+	if req.ContentLength != 0 {
+		if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+			writeError(rw, http.StatusUnsupportedMediaType, "Content-Type Must Be application/json")
+			return
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxBody)).Decode(&body); err != nil {
+			writeError(rw, http.StatusBadRequest, "Invalid JSON Body: "+err.Error())
+			return
+		}
+	}
+
+	validFor := client.DefaultInviteLife
+	if body.ValidFor != "" {
+		var err error
+		if validFor, err = time.ParseDuration(body.ValidFor); err != nil {
+			writeError(rw, http.StatusBadRequest, "Invalid validFor: "+body.ValidFor)
+			return
+		}
+	}
+	invite, err := client.CreateInvite(lynkName, validFor)
+	if err != nil {
+		writeError(rw, http.StatusConflict, err.Error())
+		return
+	}
+	parsed, _ := lynxutil.ParseInvite(invite)
+	writeJSON(rw, http.StatusCreated, InviteJSON{Invite: invite, Fingerprint: parsed.Fingerprint,
+		Expires: time.Unix(parsed.Expires, 0).UTC()})
+}
+
+// Helper function which exports the key of an encrypted Lynk for a trusted member, or imports a
+// key exported for us. Keys are only handled for local callers, who must also send our APIToken
+// when one is set - a token alone does not let a caller on another host move keys.
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+// @param string lynkName - The name of the Lynk
+// @param string action - "export" or "import"
+func handleKey(rw http.ResponseWriter, req *http.Request, lynkName, action string) {
+	if !LocalCaller(req) || (config.APIToken != "" && !hasToken(req)) {
+		writeError(rw, http.StatusForbidden, "Keys Can Only Be Moved By This Machine's Owner")
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		writeError(rw, http.StatusUnsupportedMediaType, "Content-Type Must Be application/json")
+		return
+	}
+
+	var body KeyJSON
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxBody)).Decode(&body); err != nil {
+		writeError(rw, http.StatusBadRequest, "Invalid JSON Body: "+err.Error())
+		return
+	}
+
+	if action == "export" {
+		key, err := client.ExportLynkKey(lynkName, []byte(body.PublicKey))
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(rw, http.StatusOK, KeyJSON{Key: key})
+		return
+	}
+
+	err := client.ImportLynkKey(lynkName, body.Key, []byte(body.PrivateKey), []byte(body.Passphrase))
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	lynk, _ := client.GetLynk(lynkName)
+	writeJSON(rw, http.StatusOK, toLynkJSON(lynk))
+}
+
+// Helper function which starts downloading a single file of a Lynk, selected or not
+// @param http.ResponseWriter rw - The response
+// @param lynxutil.Lynk lynk - The Lynk
+// @param string fileName - The name of the file
+func fetchFile(rw http.ResponseWriter, lynk lynxutil.Lynk, fileName string) {
+	for _, file := range lynk.Files {
+		if file.Name != fileName {
+			continue
+		}
+		if err := client.FetchFile(lynk.Name, fileName); err != nil {
+			writeError(rw, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(rw, http.StatusAccepted, toFileJSON(file))
+		return
+	}
+	writeError(rw, http.StatusNotFound, "File "+fileName+" Not Found")
+}
+
+// Helper function which pauses, resumes or cancels a single transfer
+// @param http.ResponseWriter rw - The response
+// @param string id - The ID of the transfer's job
+// @param string action - "pause", "resume" or "cancel"
+func controlJob(rw http.ResponseWriter, id, action string) {
+	job := jobs.Find(id)
+	if job == nil {
+		writeError(rw, http.StatusNotFound, "Job "+id+" Not Found")
+		return
+	}
+
+	var err error
+	switch action {
+	case "pause":
+		err = job.Pause()
+	case "resume":
+		err = job.Resume()
+	case "cancel":
+		err = job.Cancel()
+	default:
+		writeError(rw, http.StatusNotFound, "No Such Route")
+		return
+	}
+	if err != nil {
+		writeError(rw, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(rw, http.StatusOK, job.Info())
+}
+
+// Helper function which returns the recent log entries matching the query's filters
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+func recentLogs(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	filter := logs.Filter{Level: logs.Debug, Component: query.Get("component"),
+		Lynk: query.Get("lynk"), Peer: query.Get("peer"), Text: query.Get("text")}
+
+	if level := query.Get("level"); level != "" {
+		var err error
+		if filter.Level, err = logs.ParseLevel(level); err != nil {
+			writeError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeError(rw, http.StatusBadRequest, "Invalid Limit: "+limit)
+			return
+		}
+		filter.Limit = n
+	}
+	writeJSON(rw, http.StatusOK, logs.Recent(filter))
+}
+
+// Helper function which creates a Lynk from a folder, or joins one from a meta.info file, depending
+// on which field of the body is set. The folder is the one named after the Lynk in our home
+// directory unless the body has a path.
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+func createOrJoin(rw http.ResponseWriter, req *http.Request) {
+	// Only JSON bodies are accepted so a web page cannot post to us with a plain form
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		writeError(rw, http.StatusUnsupportedMediaType, "Content-Type Must Be application/json")
+		return
+	}
+
+	var body lynkRequest
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxBody)).Decode(&body); err != nil {
+		writeError(rw, http.StatusBadRequest, "Invalid JSON Body: "+err.Error())
+		return
+	}
+
+	// Only the user sitting at this machine chooses where on it a Lynk's files are kept
+	if body.Path != "" && !LocalCaller(req) {
+		writeError(rw, http.StatusForbidden, "Only Local Callers Can Choose A Lynk's Path")
+		return
+	}
+	if body.Invite != "" {
+		body.MetaPath = body.Invite // JoinLynk tells the two apart
+	}
+	if body.MetaPath != "" {
+		if err := client.JoinLynkAt(body.MetaPath, body.Path); err != nil {
+			writeError(rw, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if body.Name == "" && body.Path != "" {
+		body.Name = filepath.Base(body.Path)
+	}
+	if !validName(body.Name) {
+		writeError(rw, http.StatusBadRequest, "Invalid Lynk Name")
+		return
+	}
+	if lynxutil.GetLynk(client.GetLynks(), body.Name) != nil {
+		writeError(rw, http.StatusConflict, "Lynk "+body.Name+" Already Exists")
+		return
+	}
+	if err := client.CreateMetaAt(body.Name, body.Path, body.Encrypted); err != nil {
+		writeError(rw, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	tracker.CreateSwarm(body.Name, client.MetaPath(body.Name))
+
+	lynk := lynxutil.GetLynk(client.GetLynks(), body.Name)
+	rw.Header().Set("Location", Prefix+"lynks/"+url.PathEscape(body.Name))
+	writeJSON(rw, http.StatusCreated, toLynkJSON(*lynk))
+}
+
+// Helper function which deletes a file from a Lynk and pushes the new meta.info to our peers
+// @param http.ResponseWriter rw - The response
+// @param string lynkName - The name of the Lynk
+// @param string fileName - The name of the file
+func removeFile(rw http.ResponseWriter, lynkName, fileName string) {
+	if err := client.RemoveFile(lynkName, fileName); err != nil {
+		writeError(rw, http.StatusNotFound, err.Error())
+		return
+	}
+	client.CreateMeta(lynkName)
+	server.PushMeta(client.MetaPath(lynkName))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Helper function which lists our Lynks
+func listLynks() []LynkJSON {
+	lynks := []LynkJSON{}
+	for _, lynk := range client.GetLynks() {
+		lynks = append(lynks, toLynkJSON(lynk))
+	}
+	return lynks
+}
+
+// Helper function which describes our node
+func status() StatusJSON {
+	s := StatusJSON{
+		Home:        config.HomePath,
+		Server:      lynxutil.GetAddrs(config.ServerPort),
+		TrackerPort: config.TrackerPort,
+		DHT:         config.DHT,
+		Lynks:       client.GetLynksLen(),
+	}
+	for _, lynk := range client.GetLynks() {
+		if lynk.DLing {
+			s.Downloading++
+		}
+		if lynk.Paused {
+			s.Paused++
+		}
+	}
+	return s
+}
+
+// Helper function which converts a Lynk to its JSON form
+func toLynkJSON(lynk lynxutil.Lynk) LynkJSON {
+	state := "synced"
+	if lynk.Paused {
+		state = "paused"
+	} else if lynk.DLing {
+		state = "downloading"
+	}
+	return LynkJSON{lynk.Name, lynk.ID, client.LynkRoot(lynk.Name), lynk.Owner, lynk.Tracker,
+		len(lynk.Files), state, lynk.Synced, lynk.Mode, lynk.Encrypted,
+		lynk.Encrypted && lynk.Key != nil}
+}
+
+// Helper function which converts a file to its JSON form
+func toFileJSON(file lynxutil.File) FileJSON {
+	return FileJSON{file.Name, file.Length, file.State, file.Error, file.PlainName}
+}
+
+// Helper function which returns the mode of a Lynk with an empty list rather than a null one
+func toModeJSON(lynk lynxutil.Lynk) ModeJSON {
+	localChanges := lynk.LocalChanges
+	if localChanges == nil {
+		localChanges = []string{}
+	}
+	return ModeJSON{lynk.Mode, localChanges}
+}
+
+// Helper function which returns a selection with empty lists rather than null ones
+func toSelectionJSON(selection lynxutil.Selection) lynxutil.Selection {
+	if selection.Include == nil {
+		selection.Include = []string{}
+	}
+	if selection.Exclude == nil {
+		selection.Exclude = []string{}
+	}
+	return selection
+}
+
+// Helper function which describes where we learned of a peer
+func peerSource(peer lynxutil.Peer) string {
+	if peer.LAN {
+		return "lan"
+	} else if peer.PEX {
+		return "pex"
+	}
+	return "tracker"
+}
+
+// Helper function which splits the path after /api/v1/ into its unescaped parts
+// @param string path - The escaped path of the request
+// @return []string - The unescaped parts
+// @return error - An error is produced if a part is not escaped properly
+func splitPath(path string) ([]string, error) {
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(strings.TrimPrefix(path, Prefix), "/"), "/") {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, unescaped)
+	}
+	return parts, nil
+}
+
+// Helper function which checks that a Lynk name is a single folder in our home directory
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\:")
+}
+
+// LocalCaller - Returns whether or not a request was sent from this machine to a loopback name of
+// ours by something other than a web page of another site. The Host is checked so a site whose
+// name is pointed at 127.0.0.1 cannot pass for us, and the Origin so its pages cannot post to us.
+// @param *http.Request req - The request
+// @return bool - Whether or not the caller is local
+func LocalCaller(req *http.Request) bool {
+	if origin := req.Header.Get("Origin"); origin != "" {
+		if parsed, err := url.Parse(origin); err != nil || parsed.Host != req.Host {
+			return false
+		}
+	}
+	return loopback(req.RemoteAddr) && loopback(req.Host)
+}
+
+// Helper function which checks that a request comes from a local caller or carries our APIToken
+// as a bearer token
+// @return bool - Whether or not the request may use the API
+func authorized(req *http.Request) bool {
+	return hasToken(req) || LocalCaller(req)
+}
+
+// Helper function which checks the bearer token of a request against our APIToken
+// @return bool - Whether or not the request carries our APIToken - never when none is set
+func hasToken(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return config.APIToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.APIToken)) == 1
+}
+
+// Helper function which returns whether a host, with or without a port, is a loopback address
+// or localhost
+func loopback(hostPort string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = strings.Trim(hostPort, "[]")
+	}
+	ip := net.ParseIP(host)
+	return strings.EqualFold(host, "localhost") || (ip != nil && ip.IsLoopback())
+}
+
+// Helper function which checks the method of a request, writing a 405 if it is not allowed
+// @return bool - Whether or not the method is allowed
+func allow(rw http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		rw.Header().Set("Allow", method)
+		writeError(rw, http.StatusMethodNotAllowed, "Method "+req.Method+" Not Allowed")
+		return false
+	}
+	return true
+}
+
+// Helper function which writes a value as JSON with the given status code
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// Helper function which writes an error as JSON with the given status code
+func writeError(rw http.ResponseWriter, code int, msg string) {
+	writeJSON(rw, code, errorJSON{msg})
+}
@@ -0,0 +1,170 @@
+// The unit tests for our JSON API
+package api
+
+import (
+	"../jobs"
+	"../logs"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 12
+
+// Helper function which sends a request straight to our handler from this machine
+func request(method, path, body, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr, req.Host = "127.0.0.1:40000", "localhost:5000"
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rw := httptest.NewRecorder()
+	Handler(rw, req)
+	return rw
+}
+
+// Unit tests for which callers may use the API.
+// @param *testing.T t - The wrapper for the test
+func TestAuthorized(t *testing.T) {
+	fmt.Println("\n----------------TestAuthorized----------------")
+
+	// httptest requests come from 192.0.2.1 to example.com
+	remote := httptest.NewRequest("POST", "/api/v1/jobs/1/pause", nil)
+	rw := httptest.NewRecorder()
+	Handler(rw, remote)
+	if rw.Code != http.StatusUnauthorized {
+		t.Error("Test failed, expected 401 for a caller on another host. Got ", rw.Code)
+	} else {
+		fmt.Println("Successfully Turned Away Remote Caller")
+		successful++
+	}
+
+	config.APIToken = "secret"
+	defer func() { config.APIToken = "" }()
+	remote = httptest.NewRequest("GET", "/api/v1/status", nil)
+	remote.Header.Set("Authorization", "Bearer secret")
+	rw = httptest.NewRecorder()
+	Handler(rw, remote)
+	if rw.Code != http.StatusOK {
+		t.Error("Test failed, expected a caller with our token to be let in. Got ", rw.Code)
+	} else {
+		fmt.Println("Successfully Let In Caller With Token")
+		successful++
+	}
+
+	// Keys stay on this machine even for callers with our token, and local callers need it too
+	remote = httptest.NewRequest("POST", "/api/v1/lynks/Tests/key/export",
+		strings.NewReader(`{"publicKey": "key"}`))
+	remote.Header.Set("Authorization", "Bearer secret")
+	remote.Header.Set("Content-Type", "application/json")
+	rw = httptest.NewRecorder()
+	handleKey(rw, remote, "Tests", "export")
+	local := httptest.NewRecorder()
+	remote.RemoteAddr, remote.Host = "127.0.0.1:40000", "localhost:5000"
+	remote.Header.Del("Authorization")
+	handleKey(local, remote, "Tests", "export")
+	if rw.Code != http.StatusForbidden || local.Code != http.StatusForbidden {
+		t.Error("Test failed, expected keys to need a local caller with our token. Got ", rw.Code,
+			local.Code)
+	} else {
+		fmt.Println("Successfully Kept Keys To Local Callers With Token")
+		successful++
+	}
+
+	// A page of another site posting to us, and one whose name was pointed at 127.0.0.1
+	crossSite := httptest.NewRequest("POST", "/api/v1/jobs/1/pause", nil)
+	crossSite.RemoteAddr, crossSite.Host = "127.0.0.1:40000", "localhost:5000"
+	crossSite.Header.Set("Origin", "http://evil.example")
+	rebound := httptest.NewRequest("GET", "/api/v1/status", nil)
+	rebound.RemoteAddr, rebound.Host = "127.0.0.1:40000", "evil.example:5000"
+	if LocalCaller(crossSite) || LocalCaller(rebound) {
+		t.Error("Test failed, expected pages of other sites not to count as local callers")
+	} else {
+		fmt.Println("Successfully Turned Away Other Sites")
+		successful++
+	}
+}
+
+// Unit tests for the status codes our routes return.
+// @param *testing.T t - The wrapper for the test
+func TestHandler(t *testing.T) {
+	fmt.Println("\n----------------TestHandler----------------")
+
+	rw := request("GET", "/api/v1/status", "", "")
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"trackerPort"`) {
+		t.Error("Test failed, expected status as JSON. Got ", rw.Code, rw.Body.String())
+	} else {
+		fmt.Println("Successfully Got Status")
+		successful++
+	}
+
+	rw = request("GET", "/api/v1/lynks/Not%20A%20Lynk/files", "", "")
+	if rw.Code != http.StatusNotFound || !strings.Contains(rw.Body.String(), "Not A Lynk") {
+		t.Error("Test failed, expected 404 for unknown Lynk. Got ", rw.Code, rw.Body.String())
+	} else {
+		fmt.Println("Successfully Got 404 For Unknown Lynk")
+		successful++
+	}
+
+	rw = request("POST", "/api/v1/lynks", `{"name": "../etc"}`, "application/json")
+	if rw.Code != http.StatusBadRequest {
+		t.Error("Test failed, expected 400 for a name outside our home. Got ", rw.Code)
+	} else {
+		fmt.Println("Successfully Rejected Invalid Lynk Name")
+		successful++
+	}
+
+	rw = request("DELETE", "/api/v1/status", "", "")
+	if rw.Code != http.StatusMethodNotAllowed || rw.Header().Get("Allow") != "GET" {
+		t.Error("Test failed, expected 405 for DELETE on status. Got ", rw.Code)
+	} else {
+		fmt.Println("Successfully Got 405 For Wrong Method")
+		successful++
+	}
+
+	job := jobs.New("download", "Tests", "test.txt")
+	defer job.Finish(nil)
+	rw = request("POST", "/api/v1/jobs/"+job.ID+"/pause", "", "")
+	second := request("POST", "/api/v1/jobs/"+job.ID+"/pause", "", "")
+	if rw.Code != http.StatusOK || job.State() != jobs.Paused || second.Code != http.StatusConflict {
+		t.Error("Test failed, expected the job to be paused once. Got ", rw.Code, second.Code)
+	} else {
+		fmt.Println("Successfully Paused Job")
+		successful++
+	}
+
+	logs.New(logs.Client).Lynk("Tests").Warn("Api Test Entry")
+	logs.New(logs.Client).Lynk("Other").Warn("Api Test Entry")
+	rw = request("GET", "/api/v1/logs?lynk=Tests&level=warn&text=Api+Test", "", "")
+	if rw.Code != http.StatusOK || strings.Count(rw.Body.String(), "Api Test Entry") != 1 {
+		t.Error("Test failed, expected one filtered log entry. Got ", rw.Code, rw.Body.String())
+	} else {
+		fmt.Println("Successfully Got Filtered Logs")
+		successful++
+	}
+
+	rw = request("PUT", "/api/v1/lynks/Not%20A%20Lynk/selection", `{"include": ["*.txt"]}`,
+		"application/json")
+	if rw.Code != http.StatusNotFound {
+		t.Error("Test failed, expected 404 selecting files of an unknown Lynk. Got ", rw.Code)
+	} else {
+		fmt.Println("Successfully Got 404 For Selection Of Unknown Lynk")
+		successful++
+	}
+
+	rw = request("GET", "/api/v1/logs?level=loud", "", "")
+	if rw.Code != http.StatusBadRequest {
+		t.Error("Test failed, expected 400 for an invalid log level. Got ", rw.Code)
+	} else {
+		fmt.Println("Successfully Rejected Invalid Log Level")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
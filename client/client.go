@@ -8,24 +8,52 @@ package client
 import (
 	"bufio"
 	"bytes"
+	"../dht"
+	"../events"
+	"../jobs"
+	"../logs"
 	"../lynxutil"
+	"../metrics"
 	"../mycrypt"
+	"../ratelimit"
+	"../store"
+	"../transport"
+	"compress/flate"
 	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/textproto"
 	"os"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// An array of the lynks found from parsing the lynks.txt file
-var lynks []lynxutil.Lynk
+// Our lynks as loaded from the database - shared with the server, tracker and GUI goroutines, so
+// they are only reached through the registry
+var lynks = lynxutil.NewRegistry()
+
+// Writes the client's log entries
+var logger = logs.New(logs.Client)
+
+// The database our lynks are kept in - nil until Configure has opened it
+var db *store.Store
+
+// Guards db
+var dbMu sync.Mutex
+
+// When each lynk first had files we did not have yet - a lynk missing from the map is in sync
+var behindSince = make(map[string]time.Time)
+
+// Guards behindSince
+var behindMu sync.Mutex
 
 // A special symbol we use to denote the end of 1 entry in the metainfo file
 const endOfEntry = ":#!"
@@ -36,40 +64,271 @@ const metaValueIndex = 1
 //holds the variable for the table lynk index
 var fileTableIndex = -1
 
+// Guards fileTableIndex, which is set and read from different HTTP handlers
+var fileTableMu sync.Mutex
+
+// Our node in the DHT - nil unless DHT mode has been enabled with SetDHT
+var dhtNode *dht.Node
+
+// The announce value a meta.info uses for a Lynk which has no tracker and relies on the DHT only
+const dhtAnnounce = "dht"
+
+// The settings of our node - replaced by Configure before the client is used
+var config = lynxutil.DefaultConfig()
+
+// Function init registers the gauge of how far behind each of our lynks is
+func init() {
+	metrics.NewGaugeFunc("lynx_sync_lag_seconds",
+		"Seconds since a Lynk first had files we have not received yet - 0 once in sync.",
+		"lynk", func() map[string]float64 {
+			behindMu.Lock()
+			defer behindMu.Unlock()
+			lags := make(map[string]float64)
+			for _, lynk := range lynks.List() {
+				lags[lynk.Name] = 0
+				if since, ok := behindSince[lynk.Name]; ok {
+					lags[lynk.Name] = time.Since(since).Seconds()
+				}
+			}
+			return lags
+		})
+}
+
+// Configure - Sets the ports, home directory and behaviour the client uses and reloads our
+// lynks from the database in the configured home directory
+// @param lynxutil.Config cfg - The config loaded by our main package
+func Configure(cfg lynxutil.Config) {
+	config = cfg
+	// Tracker folders of older versions must be out of our lynks before their files are read
+	if _, err := lynxutil.MigrateTrackers(config); err != nil {
+		logger.Error("Could Not Migrate Trackers: " + err.Error())
+	}
+	if err := openStore(); err != nil {
+		logger.Error("Could Not Open Database: " + err.Error())
+	}
+}
+
+// Helper function which opens the database in our home directory, migrates lynks.txt into it the
+// first time and loads our lynks from it
+// @return error - An error is produced if the database cannot be opened or migrated
+func openStore() error {
+	dbMu.Lock()
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+	dbMu.Unlock()
+	lynks.Reset(nil)
+
+	opened, err := store.Open(config.HomePath + store.FileName)
+	if err != nil {
+		return err
+	}
+	if !opened.Migrated() {
+		if err := migrateLynks(opened); err != nil {
+			opened.Close()
+			return err
+		}
+	}
+
+	loaded, err := opened.Lynks()
+	if err != nil {
+		opened.Close()
+		return err
+	}
+	lynks.Reset(loaded)
+
+	dbMu.Lock()
+	db = opened
+	dbMu.Unlock()
+	genLynks() // The meta.info files may have changed while we were not running
+	for _, lynk := range lynks.List() {
+		ratelimit.SetLynkLimits(lynk.ID, lynk.Limits)
+	}
+	return nil
+}
+
+// Helper function which moves the lynks in lynks.txt and their meta.info files into the database.
+// lynks.txt is kept as lynks.txt.migrated once the database has them.
+// @param *store.Store opened - The database, which has not been migrated yet
+// @return error - An error is produced if the database could not be written
+func migrateLynks(opened *store.Store) error {
+	lynksPath := config.HomePath + "lynks.txt"
+	ParseLynks(lynksPath) // A missing lynks.txt simply means there is nothing to migrate
+	genLynks()
+
+	if err := opened.Migrate(lynks.List()); err != nil {
+		return err
+	}
+	if _, err := os.Stat(lynksPath); err == nil {
+		return os.Rename(lynksPath, lynksPath+".migrated")
+	}
+	return nil
+}
+
+// Helper function which writes a lynk from the registry to the database, or removes it from the
+// database if it is no longer in the registry
+// @param string lynkName - The name of the lynk
+// @return error - An error is produced if the database could not be written
+func saveLynk(lynkName string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if db == nil {
+		return errors.New("Database Not Open")
+	}
+
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return db.DeleteLynk(lynkName)
+	}
+	lynk.DLing = false // Nothing is downloading when the lynk is next loaded
+	files := make([]lynxutil.File, len(lynk.Files))
+	for i, file := range lynk.Files {
+		if file.State == lynxutil.Syncing {
+			file.State = lynxutil.OutOfDate
+		}
+		files[i] = file
+	}
+	lynk.Files = files
+	lynk.Synced = lynxutil.SyncState(files)
+	return db.SaveLynk(lynk)
+}
+
+// Helper function which sets the sync state of one of a lynk's files, works out the lynk's state
+// again and saves both
+// @param string lynkName - The name of the lynk
+// @param string fileName - The name of the file
+// @param string state - The file's new state
+// @param string reason - Why the file failed when state is lynxutil.SyncError
+func setFileState(lynkName, fileName, state, reason string) {
+	found := false
+	lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		for i := range lynk.Files {
+			if lynk.Files[i].Name == fileName {
+				lynk.Files[i].State, lynk.Files[i].Error = state, reason
+				found = true
+			}
+		}
+		lynk.Synced = lynxutil.SyncState(lynk.Files)
+	})
+	if found {
+		saveLynk(lynkName)
+	}
+}
+
+// Helper function which works out the sync state of a file by comparing its meta.info entry with
+// our copy. While its entry is unchanged a file keeps the state a download gave it, and a file
+// already found in sync is only hashed again once its size or mtime changes. A trusted member of
+// an encrypted lynk keeps its copy in plain text, so it is sealed before it is compared.
+// @param string lynkName - The name of the lynk
+// @param []byte key - The key of the lynk - nil unless it is encrypted and we are trusted
+// @param lynxutil.File file - The file's entry in meta.info
+// @param lynxutil.File old - The file as we last knew it - empty if it is new to meta.info
+// @param bool wanted - Whether or not the file is selected for download
+// @return lynxutil.File - file with its State, Error and ModTime filled in
+func fileState(lynkName string, key []byte, file, old lynxutil.File, wanted bool) lynxutil.File {
+	sameEntry := old.Name == file.Name && old.Length == file.Length && old.Hash == file.Hash
+	if sameEntry && (old.State == lynxutil.Syncing || old.State == lynxutil.SyncError) {
+		file.State, file.Error = old.State, old.Error
+		return file
+	}
+
+	behind := lynxutil.OutOfDate
+	if !wanted {
+		behind = lynxutil.Skipped
+	}
+	path := LynkRoot(lynkName) + "/" + file.LocalName()
+	length := int64(file.Length)
+	if file.PlainName != "" {
+		length -= mycrypt.Overhead
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != length {
+		file.State = behind
+		return file
+	}
+	file.ModTime = info.ModTime().UnixNano()
+	if (sameEntry && old.State == lynxutil.Synced && old.ModTime == file.ModTime) ||
+		file.Hash == "" {
+		file.State = lynxutil.Synced
+		return file
+	}
+
+	hash := ""
+	if file.PlainName != "" {
+		var sealed []byte
+		if sealed, err = sealFile(key, path); err == nil {
+			hash = hashBytes(sealed)
+		}
+	} else {
+		hash, err = lynxutil.HashFile(path)
+	}
+	if err != nil || hash != file.Hash {
+		file.State, file.ModTime = behind, 0
+		return file
+	}
+	file.State = lynxutil.Synced
+	return file
+}
+
 // DeleteFile - Function that deletes an entry from a lynk's files array.
 // @param string nameToDelete - This is the name of the file we want to delete
 // @param string lynkName - The lynk we want to delete it from
 func DeleteFile(nameToDelete, lynkName string) error {
 	// Need to delete the local file too - so parseMeta properly picks it up
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	var err error
+	found := lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		i := 0
+		for i < len(lynk.Files) {
+			if nameToDelete == lynk.Files[i].Name {
+				lynk.Files = append(lynk.Files[:i], lynk.Files[i+1:]...)
+			}
+			i++
+		}
+	})
 
-	if lynk == nil {
-		err = errors.New("Could not delete file")
+	if !found {
+		return errors.New("Could not delete file")
 	}
+	return nil
+}
 
-	i := 0
-	for i < len(lynk.Files) {
-		if nameToDelete == lynk.Files[i].Name {
-			lynk.Files = append(lynk.Files[:i], lynk.Files[i+1:]...)
+// RemoveFile - Deletes a file from a lynk's files array and from our disk
+// @param string lynkName - The lynk the file belongs to
+// @param string fileName - The name of the file
+// @return error - An error is produced if the lynk or file does not exist
+func RemoveFile(lynkName, fileName string) error {
+	path := ""
+	found := lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		for i := range lynk.Files {
+			if lynk.Files[i].Name == fileName {
+				path = lynk.Files[i].Path
+				if lynk.Files[i].PlainName != "" {
+					path = LynkRoot(lynkName) + "/" + lynk.Files[i].PlainName
+				}
+				lynk.Files = append(lynk.Files[:i], lynk.Files[i+1:]...)
+				return
+			}
 		}
-		i++
+	})
+	if !found {
+		return errors.New("Lynk Not Found")
+	} else if path == "" {
+		return errors.New("File Not Found")
 	}
 
-	return err
+	os.Remove(path)
+	return nil
 }
 
 // DeleteFileIndex - Deletes a file from a lynk
 // fileDelete - the index of the file in the array
 // lynkIndex - the lynk which the file corresponds to
 func DeleteFileIndex(fileDelete, lynkIndex int) {
-	lynk := lynks[lynkIndex]
-	os.Remove(lynk.Files[fileDelete].Path)
-	lynk.Files = append(lynk.Files[:fileDelete], lynk.Files[fileDelete+1:]...)
-
-	//fmt.Println(lynks[lynkIndex].Files)
-	//fmt.Println(lynk.Files)
-	lynks[lynkIndex].Files = lynk.Files
+	lynk, ok := lynks.At(lynkIndex)
+	if !ok || fileDelete < 0 || fileDelete >= len(lynk.Files) {
+		return
+	}
+	RemoveFile(lynk.Name, lynk.Files[fileDelete].Name)
 }
 
 // UpdateMetainfo - Deletes the current meta.info and replaces it with a new version that
@@ -79,48 +338,71 @@ func DeleteFileIndex(fileDelete, lynkIndex int) {
 func UpdateMetainfo(metaPath string) error {
 	ParseMetainfo(metaPath)
 	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	lynk, _ := lynks.Get(lynkName)
+	// Keeps the name the owner gave the lynk, and counts its revision up like createMeta does
+	header, _ := lynxutil.ReadMetaHeader(metaPath)
+	if header.Name == "" {
+		header.Name = lynk.Name
+	}
 
-	err := os.Remove(metaPath)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	// The new meta.info is built up in memory and then replaces the old one in a single step
+	var newMetainfo bytes.Buffer
+	newMetainfo.WriteString("announce:::" + lynk.Tracker + "\n") // Write tracker IP
+	newMetainfo.WriteString("id:::" + lynk.ID + "\n")
+	newMetainfo.WriteString("lynkName:::" + header.Name + "\n")
+	newMetainfo.WriteString("owner:::" + lynk.Owner + "\n")
+	if header.OwnerKey != "" {
+		newMetainfo.WriteString("ownerKey:::" + header.OwnerKey + "\n")
+	}
+	newMetainfo.WriteString("revision:::" + strconv.Itoa(header.Revision+1) + "\n")
+	if lynk.Encrypted {
+		newMetainfo.WriteString("encrypted:::true\n")
+	}
+	for _, file := range lynk.Files {
+		writeMetaEntry(&newMetainfo, file)
 	}
 
-	newMetainfo, err := os.Create(metaPath)
+	err := lynxutil.WriteFileAtomic(metaPath, newMetainfo.Bytes(), 0644)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		logger.Lynk(lynkName).Error("Could Not Write meta.info: " + err.Error())
 	}
+	return err
+}
 
-	newMetainfo.WriteString("announce:::" + lynk.Tracker + "\n") // Write tracker IP
-	newMetainfo.WriteString("lynkName:::" + lynk.Name + "\n")
-	newMetainfo.WriteString("owner:::" + lynk.Owner + "\n")
-	i := 0
-	for i < len(lynk.Files) {
-		newMetainfo.WriteString("length:::" + strconv.Itoa(lynk.Files[i].Length) + "\n") // str conv
-		newMetainfo.WriteString("path:::" + lynk.Files[i].Path + "\n")
-		newMetainfo.WriteString("name:::" + lynk.Files[i].Name + "\n")
-		newMetainfo.WriteString("chunkLength:::" + strconv.Itoa(lynk.Files[i].ChunkLength) + "\n")
-		newMetainfo.WriteString("chunks:::" + lynk.Files[i].Chunks + "\n")
-		newMetainfo.WriteString(endOfEntry + "\n")
-		i++
+// Helper function which writes the entry of one file in a meta.info
+// @param *bytes.Buffer metainfo - The meta.info being built
+// @param lynxutil.File file - The file
+func writeMetaEntry(metainfo *bytes.Buffer, file lynxutil.File) {
+	metainfo.WriteString("length:::" + strconv.Itoa(file.Length) + "\n") // str conv
+	metainfo.WriteString("path:::" + file.Path + "\n")
+	metainfo.WriteString("name:::" + file.Name + "\n")
+	metainfo.WriteString("chunkLength:::" + strconv.Itoa(file.ChunkLength) + "\n")
+	metainfo.WriteString("chunks:::" + file.Chunks + "\n")
+	if file.Hash != "" {
+		metainfo.WriteString("hash:::" + file.Hash + "\n")
 	}
-
-	return newMetainfo.Close()
+	metainfo.WriteString(endOfEntry + "\n")
 }
 
 // ParseMetainfo - Parses the information in meta.info file and places each entry into a File
 // struct and appends that struct to the array of structs
 // @param string metaPath - The path to the metainfo file
 // @return error - An error can be produced when issues arise from trying to access
-// the meta file or from an invalid meta file type - otherwise error will be nil.
+// the meta file, from an invalid meta file type or from a meta file with an invalid ID or the ID
+// of another lynk - otherwise error will be nil.
 func ParseMetainfo(metaPath string) error {
-	lynk := lynxutil.GetLynk(lynks, GetLynkName(metaPath))
-	if lynk == nil {
+	lynkName := GetLynkName(metaPath)
+	stored, _ := lynks.Get(lynkName) // What the database has, so we only write it on changes
+	// A lynk never changes its ID - a meta.info which tries to is left alone
+	if header, err := lynxutil.ReadMetaHeader(metaPath); err == lynxutil.ErrInvalidLynkID {
+		return err
+	} else if err == nil && stored.ID != "" && header.ID != stored.ID {
+		return errors.New("meta.info Is For Another Lynk")
+	}
+	// The files array is reset even if the meta.info cannot be read
+	if !lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Files = nil }) {
 		return errors.New("Lynk Not Found")
 	}
-	lynk.Files = nil // Resets files array
 
 	metaFile, err := os.Open(metaPath)
 	if err != nil {
@@ -129,16 +411,27 @@ func ParseMetainfo(metaPath string) error {
 		return errors.New("Invalid File Type")
 	}
 
+	// The file is parsed into a copy which then replaces the registry's lynk in one go
+	lynk, _ := lynks.Get(lynkName)
+	lynk.Encrypted = false
+	lynk.ID = ""
+	metaName := "" // The name the owner gave the lynk - ours is the name of its folder
 	scanner := bufio.NewScanner(metaFile)
 	tempFile := lynxutil.File{}
 	for scanner.Scan() { // Scan each line
 		split := strings.Split(strings.TrimSpace(scanner.Text()), ":::")
 		if split[0] == "announce" {
 			lynk.Tracker = split[metaValueIndex]
+		} else if split[0] == "id" {
+			lynk.ID = split[metaValueIndex] // Checked by ReadMetaHeader above
 		} else if split[0] == "owner" {
 			lynk.Owner = split[metaValueIndex]
 		} else if split[0] == "lynkName" {
-			lynk.Name = split[metaValueIndex]
+			metaName = split[metaValueIndex]
+		} else if split[0] == "encrypted" {
+			lynk.Encrypted = split[metaValueIndex] == "true"
+		} else if split[0] == "revision" {
+			lynk.Revision, _ = strconv.Atoi(split[metaValueIndex])
 		} else if split[0] == "chunkLength" {
 			tempFile.ChunkLength, _ = strconv.Atoi(split[metaValueIndex])
 		} else if split[0] == "length" {
@@ -149,11 +442,49 @@ func ParseMetainfo(metaPath string) error {
 			tempFile.Name = split[metaValueIndex]
 		} else if split[0] == "chunks" {
 			tempFile.Chunks = split[metaValueIndex]
+		} else if split[0] == "hash" {
+			tempFile.Hash = split[metaValueIndex]
 		} else if split[0] == endOfEntry {
 			lynk.Files = append(lynk.Files, tempFile) // Append the current file to the file array
 			tempFile = lynxutil.File{}                // Empty the current file
 		}
 	}
+	if lynk.ID == "" {
+		lynk.ID = lynxutil.LegacyLynkID(metaName, lynk.Owner)
+	}
+
+	// Works out how our copy of each file compares with meta.info. A new revision is checked from
+	// scratch, so no file keeps a state it was given under an older one.
+	known := make(map[string]lynxutil.File)
+	for _, file := range stored.Files {
+		if lynk.Revision == stored.Revision {
+			known[file.Name] = file
+		}
+	}
+	for i, file := range lynk.Files {
+		if lynk.Encrypted && lynk.Key != nil {
+			// With the key we know each file by its plain name - without it only by its sealed one
+			file.PlainName, _ = mycrypt.DecryptName(lynk.Key, file.Name)
+			lynk.Files[i].PlainName = file.PlainName
+		}
+		wanted := lynk.Selection.Wants(lynkName, file)
+		lynk.Files[i] = fileState(lynkName, lynk.Key, file, known[file.Name], wanted)
+	}
+
+	changed := false
+	lynks.Update(lynkName, func(current *lynxutil.Lynk) {
+		current.Tracker, current.Owner, current.ID = lynk.Tracker, lynk.Owner, lynk.ID
+		current.Encrypted, current.Revision = lynk.Encrypted, lynk.Revision
+		current.Files = lynk.Files
+		current.Synced = lynxutil.SyncState(lynk.Files)
+		changed = current.Tracker != stored.Tracker || current.Owner != stored.Owner ||
+			current.ID != stored.ID || current.Revision != stored.Revision ||
+			current.Synced != stored.Synced || current.Encrypted != stored.Encrypted ||
+			!reflect.DeepEqual(current.Files, stored.Files)
+	})
+	if changed {
+		saveLynk(lynkName)
+	}
 	return metaFile.Close()
 }
 
@@ -164,70 +495,113 @@ func ParseMetainfo(metaPath string) error {
 // the meta file or if the file to be added already exists in the meta file - otherwise
 // error will be nil.
 func AddToMetainfo(addPath, metaPath string) error {
-	metaFile, err := os.OpenFile(metaPath, os.O_APPEND|os.O_WRONLY, 0644) // Opens for appending
+	return addEntry(addPath, metaPath, true)
+}
+
+// Helper function which adds a file to the meta.info, counting up its revision unless the
+// meta.info is being written by createMeta, which has already done so
+// @param string addPath - the path of the file to be added
+// @param string metaPath - the path of the metainfo file
+// @param bool newRevision - Whether or not the meta.info gets a new revision
+// @return error - An error is produced as for AddToMetainfo
+func addEntry(addPath, metaPath string, newRevision bool) error {
+	oldMetainfo, err := ioutil.ReadFile(metaPath)
 	if err != nil {
-		fmt.Println(err)
+		logger.Lynk(GetLynkName(metaPath)).Error("Could Not Read meta.info: " + err.Error())
 		return err
 	}
 
 	addStat, err := os.Stat(addPath)
 	if err != nil {
-		fmt.Println(err)
+		logger.Lynk(GetLynkName(metaPath)).Warn("Could Not Add File: " + err.Error())
 		return err
 	}
 
 	ParseMetainfo(metaPath)
 	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	lynk, _ := lynks.Get(lynkName)
 
 	i := 0
 	for i < len(lynk.Files) {
-		if lynk.Files[i].Name == addStat.Name() {
+		if lynk.Files[i].LocalName() == addStat.Name() {
 			return errors.New("Can't Add Duplicates To Metainfo")
 		}
 		i++
 	}
 
-	lengthStr := strconv.FormatInt(addStat.Size(), 10) // Convert int64 to string
-	metaFile.WriteString("length:::" + lengthStr + "\n")
-
 	tempPath, err := filepath.Abs(addPath) // Find the path of the current file
 	if err != nil {
 		return err
 	}
 
-	// Write to metainfo file using ::: to separate keys and values
-	metaFile.WriteString("path:::" + tempPath + "\n")
-	metaFile.WriteString("name:::" + addStat.Name() + "\n")
-	metaFile.WriteString("chunkLength:::32\n")
-	metaFile.WriteString("chunks:::256\n")
-	metaFile.WriteString(endOfEntry + "\n")
-	return metaFile.Close()
+	hash, err := lynxutil.HashFile(addPath) // Lets peers verify the file once they download it
+	if err != nil {
+		return err
+	}
+
+	entry := lynxutil.File{Length: int(addStat.Size()), Path: tempPath, Name: addStat.Name(),
+		ChunkLength: 32, Chunks: "256", Hash: hash}
+	if lynk.Encrypted && lynk.Key != nil {
+		if entry, err = sealEntry(lynk.Key, addPath, entry); err != nil {
+			return err
+		}
+	}
+
+	// Write to metainfo file using ::: to separate keys and values - the old entries and the new
+	// one replace the old meta.info in a single step
+	if newRevision {
+		oldMetainfo = nextRevision(oldMetainfo)
+	}
+	newMetainfo := bytes.NewBuffer(oldMetainfo)
+	writeMetaEntry(newMetainfo, entry)
+	return lynxutil.WriteFileAtomic(metaPath, newMetainfo.Bytes(), 0644)
+}
+
+// Helper function which counts the revision of a meta.info up by one. A meta.info written before
+// there were revisions gets a revision line after its owner.
+// @param []byte meta - The meta.info
+// @return []byte - The meta.info with its next revision
+func nextRevision(meta []byte) []byte {
+	header, _ := lynxutil.ParseMetaHeader(meta)
+	revision := "revision:::" + strconv.Itoa(header.Revision+1) + "\n"
+	lines := strings.SplitAfter(string(meta), "\n")
+	at := 0
+	for i, line := range lines {
+		if strings.HasPrefix(line, "revision:::") {
+			lines[i] = revision
+			return []byte(strings.Join(lines, ""))
+		} else if strings.HasPrefix(line, "owner:::") {
+			at = i + 1
+		}
+	}
+	lines = append(lines[:at], append([]string{revision}, lines[at:]...)...)
+	return []byte(strings.Join(lines, ""))
 }
 
 // HaveFile - Checks to see if we have the passed in file.
 // @param string filePath - The name of the file to check for - This includes the lynk name.
 // E.G. - 'Cool_Lynk/coolFile.txt'
 // @return bool - A boolean indicating whether or not we have a file in our
-// files array.
+// files array and our copy of it is in sync with meta.info.
 func HaveFile(filePath string) bool {
 	have := false
 
 	lynkInfo := strings.Split(filePath, "/")
 	if len(lynkInfo) != 2 {
-		fmt.Println(filePath + " is an invalid filepath")
+		logger.Warn(filePath + " is an invalid filepath")
 		return have
 	}
 
 	lynkName := lynkInfo[0]
 	fileName := lynkInfo[1]
-	metaPath := lynxutil.HomePath + lynkName + "/meta.info"
+	metaPath := MetaPath(lynkName)
 	ParseMetainfo(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	lynk, _ := lynks.Get(lynkName)
 
 	i := 0
 	for i < len(lynk.Files) && !have {
-		if lynk.Files[i].Name == fileName {
+		// Only a copy which matches meta.info is worth sending
+		if lynk.Files[i].Name == fileName && lynk.Files[i].State == lynxutil.Synced {
 			have = true
 		}
 		i++
@@ -242,11 +616,13 @@ func HaveFile(filePath string) bool {
 func GetTracker(metaPath string) string {
 	ParseMetainfo(metaPath)
 	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	lynk, _ := lynks.Get(lynkName)
 	return lynk.Tracker
 }
 
-// Gets a file from the peer(s)
+// Gets a file from the peer(s). The download is a job - while it is paused we wait to be resumed
+// and then ask the same peer for the rest of the file, and if it is cancelled the part we have
+// is thrown away.
 // @param string fileName - The name of the file to find in the peers
 // @param string metaPath - The meta.info path associated with the lynk we're interested in
 // @return error - An error can be produced if there are connection issues,
@@ -256,28 +632,62 @@ func getFile(fileName, metaPath string) error {
 	// Will parseMetainfo file and then ask tracker for list of peers
 	ParseMetainfo(metaPath)
 	lynkName := GetLynkName(metaPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	//fmt.Println("Asking For File From: " + metaPath)
 	askTrackerForPeers(lynkName)
-	//fmt.Println(lynk.Peers)
+	lynk, _ := lynks.Get(lynkName)
+	logger.Lynk(lynkName).Debug("Asking " + strconv.Itoa(len(lynk.Peers)) + " Peers For " + fileName)
+
+	// Retries of a file carry on with the same job, so a paused download stays paused
+	job := jobs.Get(events.Download, lynkName, fileName)
+	setFileState(lynkName, fileName, lynxutil.Syncing, "")
 
+	peers := preferLAN(lynk.Peers) // Peers on our local network are tried first
 	i := 0
 	gotFile := false
-	for i < len(lynk.Peers) && !gotFile {
-		conn, err := net.Dial("tcp", lynk.Peers[i].IP+":"+lynk.Peers[i].Port)
+	for i < len(peers) && !gotFile {
+		if err := job.Wait(); err != nil {
+			break // The download was cancelled while it was paused
+		}
+
+		// Peers we cannot reach directly are reached through the tracker
+		conn, err := transport.Dial(peers[i], lynk.Tracker)
 		// We don't want to return on err because we might be able to connect to next peer.
 		if err == nil {
-			gotFile = askForFile(lynkName, fileName, conn)
+			gotFile = askForFile(job, lynkName, fileName, conn)
+			// Swaps peer lists while we know this peer is up
+			exchangePeers(lynkName, peers[i])
+			if job.State() == jobs.Paused {
+				continue // Asks the same peer for the rest once we are resumed
+			}
+		} else if peers[i].PEX {
+			// Unverified peers are dropped as soon as they fail
+			peer := peers[i]
+			lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { dropPEXPeer(lynk, peer) })
 		}
 		//fmt.Println(i)
 		i++
 	}
 
+	if job.State() == jobs.Cancelled {
+		os.Remove(partPath(lynkName, fileName))
+		metrics.Downloads.Inc(lynkName, "cancelled")
+		setFileState(lynkName, fileName, lynxutil.OutOfDate, "")
+		job.Finish(jobs.ErrCancelled)
+		return jobs.ErrCancelled
+	}
+
 	if gotFile {
+		metrics.Downloads.Inc(lynkName, "received")
+		setFileState(lynkName, fileName, lynxutil.Synced, "")
+		job.Finish(nil)
 		return nil
 	}
 
-	return errors.New("Did not receive file") // If we got here - we didn't have the file.
+	err := errors.New("Did not receive file") // If we got here - we didn't have the file.
+	metrics.Downloads.Inc(lynkName, "failed")
+	setFileState(lynkName, fileName, lynxutil.SyncError, "Not Received From Any Of "+
+		strconv.Itoa(len(peers))+" Peers")
+	job.Finish(err)
+	return err
 }
 
 /*
@@ -320,56 +730,179 @@ func getFile(fileName, metaPath string) error {
 }
 */
 
-// The function responsible for actually asking for a file from a peer
+// The function responsible for actually asking for a file from a peer. The file is written to a
+// part file as it arrives - if we already have part of it we ask only for the rest - and moved
+// into the lynk once it is complete. Pausing or cancelling the job closes the connection.
+// @param *jobs.Job job - The download's job
 // @param string lynkName - The name of the lynk we're asking about
 // @param string fileName - The name of the file to find in the peers
 // @param net.Conn conn - The connection to the peer
 // @return bool - True or false is returned based on whether or not we successfully received a file
-func askForFile(lynkName, fileName string, conn net.Conn) bool {
-	fmt.Fprintf(conn, "Do_You_Have_FileName:"+lynkName+"/"+fileName+"\n")
+func askForFile(job *jobs.Job, lynkName, fileName string, conn net.Conn) bool {
+	stop := jobs.Watch(job.Context(), conn)
+	defer stop()
+
+	log := logger.Lynk(lynkName).Peer(conn.RemoteAddr().String())
+	part := partPath(lynkName, fileName)
+	offset := int64(0)
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
 
-	fmt.Println("Downloading: " + fileName + " From " + conn.LocalAddr().String())
+	if offset > 0 {
+		fmt.Fprint(conn, "Resume_FileName:"+strconv.FormatInt(offset, 10)+":"+lynkID(lynkName)+
+			"/"+fileName+"\n")
+		log.Info("Resuming " + fileName + " From Byte " + strconv.FormatInt(offset, 10))
+	} else {
+		fmt.Fprint(conn, "Do_You_Have_FileName:"+lynkID(lynkName)+"/"+fileName+"\n")
+		log.Info("Downloading " + fileName)
+	}
 
-	reply, err := bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n') // Waits for a String ending in newline
 	reply = strings.TrimSpace(reply)
 	gotFile := false
 
-	// Has file and no errors
+	// Has file and no errors - the reply is "YES:<Length>", or just "YES" from older peers
 	if reply != "NO" && err == nil {
-		bufIn, err := ioutil.ReadAll(conn)
+		total := int64(-1)
+		if tmpArr := strings.Split(reply, ":"); len(tmpArr) == 2 {
+			if length, err := strconv.ParseInt(tmpArr[1], 10, 64); err == nil {
+				total = length
+			}
+		}
+
+		transfer := events.Start(job.ID, events.Download, lynkName, fileName,
+			conn.RemoteAddr().String(), total)
+
+		os.MkdirAll(filepath.Dir(part), 0755)
+		file, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
-			fmt.Println("Did Not Receive File!")
+			log.Error("Could Not Open Part File: " + err.Error())
+			transfer.Fail(err)
 			return gotFile
 		}
+		limited := ratelimit.Reader(job.Context(), lynkID(lynkName), reader) // Our download limits
+		err = receiveFile(transfer.Reader(metrics.BytesReceived.Reader(limited, lynkName)), file)
+		if syncErr := file.Sync(); err == nil {
+			err = syncErr // What we have must be on disk before we resume from it or move it
+		}
+		file.Close()
 
-		// Decrypt
-		key := []byte(lynxutil.PrivateKey)
-		var plainFile []byte
-		if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
-			//log.Fatal(err)
+		if err != nil {
+			switch job.Err() {
+			case jobs.ErrPaused:
+				log.Info("Paused " + fileName)
+				transfer.End(events.Paused)
+			case jobs.ErrCancelled:
+				log.Info("Cancelled " + fileName)
+				transfer.End(events.Cancelled)
+			default:
+				log.Warn("Did Not Receive " + fileName + ": " + err.Error())
+				transfer.Fail(err)
+			}
 			return gotFile
 		}
 
-		// Decompress
-		r, _ := gzip.NewReader(bytes.NewBuffer(plainFile))
-		bufOut, _ := ioutil.ReadAll(r)
-		r.Read(bufOut)
-		r.Close()
-
-		file, err := os.Create(lynxutil.HomePath + lynkName + "/" + fileName)
-		if err != nil {
+		// A file which is not what meta.info describes is thrown away rather than put in the lynk
+		if err = verifyFile(lynkName, fileName, part); err != nil {
+			log.Warn(fileName + " Failed Verification: " + err.Error())
+			os.Remove(part)
+			transfer.Fail(err)
 			return gotFile
 		}
-		defer file.Close()
 
-		//fmt.Println(len(bufIn), "Bytes Received")
-		file.Write(bufOut)
+		if err = commitFile(lynkName, fileName, part); err != nil {
+			log.Error("Could Not Move " + fileName + " Into Lynk: " + err.Error())
+			transfer.Fail(err)
+			return gotFile
+		}
+		transfer.Finish()
+		log.Info("Received " + fileName)
 		gotFile = true
 	}
 
 	return gotFile
 }
 
+// Helper function which checks a downloaded file against the length and hash meta.info has for it.
+// Files from meta.info files without a hash are only checked by length.
+// @param string lynkName - The name of the lynk
+// @param string fileName - The name of the file
+// @param string path - Where the downloaded file is
+// @return error - An error is produced if the file does not match
+func verifyFile(lynkName, fileName, path string) error {
+	lynk, _ := lynks.Get(lynkName)
+	for _, file := range lynk.Files {
+		if file.Name != fileName {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		} else if info.Size() != int64(file.Length) {
+			return errors.New("File Length Does Not Match Metainfo")
+		}
+		if file.Hash == "" {
+			return nil
+		}
+		hash, err := lynxutil.HashFile(path)
+		if err != nil {
+			return err
+		} else if hash != file.Hash {
+			return errors.New("File Hash Does Not Match Metainfo")
+		}
+		return nil
+	}
+	return errors.New("File Not In Metainfo")
+}
+
+// Helper function which decrypts and decompresses a file as it is received. Whatever arrived
+// before an error has already been written, so an interrupted file can be resumed.
+// @param io.Reader r - The encrypted file
+// @param io.Writer w - Where the file is written
+// @return error - An error is produced if the file did not arrive in full
+func receiveFile(r io.Reader, w io.Writer) error {
+	// Decrypt
+	key := []byte(lynxutil.PrivateKey)
+	plain, err := mycrypt.NewDecryptReader(key, r)
+	if err != nil {
+		return err
+	}
+
+	// Decompress
+	gz, err := gzip.NewReader(plain)
+	if err != nil {
+		countDecryptError(err)
+		return err
+	}
+	defer gz.Close()
+
+	_, err = io.Copy(w, gz)
+	countDecryptError(err)
+	return err
+}
+
+// Helper function which counts an error as a failed decrypt if it means what we decrypted is not
+// gzip - which is what a file encrypted with another key looks like
+// @param error err - The error from decompressing a file
+func countDecryptError(err error) {
+	if _, corrupt := err.(flate.CorruptInputError); corrupt || err == gzip.ErrHeader ||
+		err == gzip.ErrChecksum {
+		metrics.DecryptFailures.Inc(logs.Client)
+	}
+}
+
+// Helper function which returns where the part of a file we are still downloading is kept. Part
+// files live outside the lynk so they are never mistaken for local changes.
+// @param string lynkName - The name of the lynk
+// @param string fileName - The name of the file
+// @return string - The path of the part file
+func partPath(lynkName, fileName string) string {
+	return config.HomePath + ".lynxpart/" + lynkName + "/" + fileName
+}
+
 // SPECIAL VERSION FOR PRESENTATION ONLY!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!
 // The function responsible for actually asking for a file from a peer
 // @param string lynkName - The name of the lynk we're asking about
@@ -377,7 +910,7 @@ func askForFile(lynkName, fileName string, conn net.Conn) bool {
 // @param net.Conn conn - The connection to the peer
 // @return bool - True or false is returned based on whether or not we successfully received a file
 func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
-	fmt.Fprintf(conn, "Do_You_Have_FileName:"+lynkName+"/"+fileName+"\n")
+	fmt.Fprint(conn, "Do_You_Have_FileName:"+lynkID(lynkName)+"/"+fileName+"\n")
 
 	fmt.Println("Downloading: " + fileName + " From " + conn.RemoteAddr().String())
 
@@ -392,7 +925,7 @@ func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 		time.Sleep(time.Duration(10) * time.Second) // Waits X amount of time and then continues
 
 		if err != nil || reply == "YES" {
-			lynk := lynxutil.GetLynk(lynks, lynkName)
+			lynk, _ := lynks.Get(lynkName)
 			var file lynxutil.File
 			for _, f := range lynk.Files {
 				if f.Name == lynkName {
@@ -400,7 +933,7 @@ func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 				}
 			}
 			fmt.Println("Disconnected From", conn.RemoteAddr().String(), "On Chunk", int(
-				(len(bufIn) + file.Length/lynxutil.ChunkLength)))
+				(len(bufIn) + file.Length/config.ChunkLength)))
 			return gotFile
 		}
 
@@ -418,7 +951,7 @@ func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 		r.Read(bufOut)
 		r.Close()
 
-		file, err := os.Create(lynxutil.HomePath + lynkName + "/" + fileName)
+		file, err := os.Create(LynkRoot(lynkName) + "/" + fileName)
 		if err != nil {
 			return gotFile
 		}
@@ -432,10 +965,15 @@ func askForFilePres(lynkName, fileName string, conn net.Conn) bool {
 	return gotFile
 }
 
-// Asks the tracker for a list of peers and then places them into a lynk's peers array
+// Asks the tracker for a list of peers and then places them into a lynk's peers array. If the
+// tracker cannot be reached and DHT mode is enabled the DHT is asked instead.
 // @param string lynkName - The name of the lynk we're interested in
 func askTrackerForPeers(lynkName string) error {
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	lynk, _ := lynks.Get(lynkName)
+	if lynk.Tracker == dhtAnnounce {
+		return askDHTForPeers(lynkName)
+	}
+
 	// Connects to tracker
 	conn, err := net.Dial("tcp", lynk.Tracker)
 
@@ -443,24 +981,32 @@ func askTrackerForPeers(lynkName string) error {
 	if err != nil {
 		i := 0
 		for i < len(lynk.Peers) && err != nil {
-			pConn, _ := net.Dial("tcp", lynk.Peers[i].IP+":"+lynk.Peers[i].Port)
-			fmt.Fprintf(pConn, "Tracker_Request:"+lynkName+"/\n")
+			pConn, pErr := lynxutil.DialPeer(lynk.Peers[i])
+			i++
+			if pErr != nil {
+				continue
+			}
+			fmt.Fprint(pConn, "Tracker_Request:"+lynk.ID+"/\n")
 			reply := ""
 			reply, err = bufio.NewReader(pConn).ReadString('\n') // Waits for a String ending in newline
 			reply = strings.TrimSpace(reply)
+			pConn.Close()
 
 			conn, err = net.Dial("tcp", reply)
-			i++
 		}
 
-		// We could not connect to the tracker
+		// We could not connect to the tracker - the DHT is our last resort
 		if err != nil {
+			if dhtNode != nil {
+				return askDHTForPeers(lynkName)
+			}
 			return err
 		}
 	}
 
-	// Gives IP and ServerPort So It Can Be Added To swarm.info
-	fmt.Fprintf(conn, "Swarm_Request:"+lynxutil.GetIP()+":"+lynxutil.ServerPort+":"+lynkName+"\n")
+	// Gives all of our IPs with our ServerPort So We Can Be Added To swarm.info
+	addrs := strings.Join(lynxutil.GetAddrs(config.ServerPort), ",")
+	fmt.Fprint(conn, "Swarm_Request:"+lynkID(lynkName)+":"+addrs+"\n")
 	reader := bufio.NewReader(conn)
 	tp := textproto.NewReader(reader)
 
@@ -468,24 +1014,111 @@ func askTrackerForPeers(lynkName string) error {
 	//fmt.Println(reply)
 
 	// Tracker will close connection when finished - which will break us out of this loop
+	var received []lynxutil.Peer
 	for err == nil {
-		peerArray := strings.Split(reply, ":::")
-		tmpPeer := lynxutil.Peer{IP: peerArray[0], Port: peerArray[1]}
-		if !contains(lynk.Peers, tmpPeer) {
-			lynk.Peers = append(lynk.Peers, tmpPeer)
+		if tmpPeer, pErr := lynxutil.ParsePeer(reply); pErr == nil {
+			received = append(received, tmpPeer)
 		}
 		reply, err = tp.ReadLine()
 	}
+	addPeers(lynkName, received)
 
 	return nil // Did not have an error if we reached this point
 }
 
-// Simple helper method that checks peers array for specific peer.
+// Asks the DHT for the peers which have announced a lynk and places them into the lynk's peers
+// array.
+// @param string lynkName - The name of the lynk we're interested in
+// @return error - An error is produced if DHT mode is off or no peers could be found
+func askDHTForPeers(lynkName string) error {
+	if dhtNode == nil {
+		return errors.New("DHT Mode Is Not Enabled")
+	}
+
+	lynk, _ := lynks.Get(lynkName)
+	bootstrapDHT(lynk)
+	addrs, err := dhtNode.GetPeers(lynxutil.LynkInfoHash(lynk.ID))
+	if err != nil {
+		return err
+	}
+
+	var received []lynxutil.Peer
+	for _, addr := range addrs {
+		if tmpPeer, err := lynxutil.NewPeer([]string{addr}); err == nil {
+			received = append(received, tmpPeer)
+		}
+	}
+	addPeers(lynkName, received)
+
+	return nil
+}
+
+// Helper function which adds peers we do not know of yet to a lynk's peers array
+// @param string lynkName - The name of the lynk
+// @param []lynxutil.Peer peers - The peers to add
+func addPeers(lynkName string, peers []lynxutil.Peer) {
+	added := false
+	lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		for _, peer := range peers {
+			if !contains(lynk.Peers, peer) {
+				lynk.Peers = append(lynk.Peers, peer)
+				added = true
+			}
+		}
+	})
+	if added {
+		saveLynk(lynkName)
+	}
+}
+
+// Helper function which bootstraps our DHT node off of the tracker's host and the known peers of a
+// lynk if our routing table is empty.
+// @param lynxutil.Lynk lynk - The lynk whose peers we can bootstrap from
+func bootstrapDHT(lynk lynxutil.Lynk) {
+	if dhtNode.Size() > 0 {
+		return
+	}
+
+	var addrs []string
+	if host, _, err := net.SplitHostPort(lynk.Tracker); err == nil {
+		addrs = append(addrs, net.JoinHostPort(host, config.DHTPort))
+	}
+	for _, peer := range lynk.Peers {
+		addrs = append(addrs, net.JoinHostPort(peer.IP, config.DHTPort))
+	}
+	dhtNode.Bootstrap(addrs)
+}
+
+// SetDHT - Enables DHT mode by giving the client a running DHT node to announce to and look up
+// peers from.
+// @param *dht.Node node - The node to use, or nil to disable DHT mode
+func SetDHT(node *dht.Node) {
+	dhtNode = node
+}
+
+// AnnounceLynks - Announces every lynk we have to the DHT so peers can find us without the
+// tracker. Does nothing if DHT mode is not enabled.
+func AnnounceLynks() {
+	if dhtNode == nil {
+		return
+	}
+
+	for _, lynk := range lynks.List() {
+		bootstrapDHT(lynk)
+		err := dhtNode.Announce(lynxutil.LynkInfoHash(lynk.ID), config.ServerPort)
+		if err != nil {
+			logger.Lynk(lynk.Name).Warn("Could Not Announce In DHT: " + err.Error())
+		}
+	}
+}
+
+// Simple helper method that checks peers array for specific peer. Peers are the same when they
+// share any address so a peer found on our LAN matches the same peer returned by the tracker.
 // @param s []peers - The peers array
 // @param e Peer - The peer we are checking for
 func contains(s []lynxutil.Peer, e lynxutil.Peer) bool {
 	for _, a := range s {
-		if a == e {
+		if a.Equal(e) {
 			return true
 		}
 	}
@@ -495,85 +1128,125 @@ func contains(s []lynxutil.Peer, e lynxutil.Peer) bool {
 // CreateMeta - This function creates a new metainfo file for use within the GUI server
 // @param name string - The name of the new lynk
 func CreateMeta(name string) error {
-	tDir, err := os.Stat(lynxutil.HomePath + name) // Checks to see if the directory exists
+	return createMeta(name, "", nil)
+}
+
+// Helper function which creates a new metainfo file for a lynk, which stays encrypted if it was
+// @param name string - The name of the lynk
+// @param string root - The folder of a new lynk kept outside our home directory - empty otherwise
+// @param []byte key - The key of a new encrypted lynk - nil otherwise
+// @return error - An error is produced if the directory does not exist or meta.info cannot be
+// written
+func createMeta(name, root string, key []byte) error {
+	dir := root
+	if dir == "" {
+		dir = LynkRoot(name)
+	}
+	tDir, err := os.Stat(dir) // Checks to see if the directory exists
 	if err != nil || !tDir.IsDir() {
-		return errors.New("Directory " + name + "does not exist in the Lynx directory.")
+		return errors.New("Directory " + dir + " Does Not Exist")
 	}
 
-	metaFile, err := os.Create(lynxutil.HomePath + name + "/meta.info")
+	// A lynk keeps its ID, name and owner when someone else changes it, so its invites stay valid
+	currentUser, _ := user.Current()
+	owner, metaName := currentUser.Name, name
+	lynk, ok := lynks.Get(name)
+	if ok && lynk.Owner != "" {
+		owner = lynk.Owner
+	}
+	revision, ownerKey := 1, ""
+	if header, err := lynxutil.ReadMetaHeader(dir + "/meta.info"); ok && err == nil {
+		if header.Name != "" {
+			metaName = header.Name
+		}
+		revision, ownerKey = header.Revision+1, header.OwnerKey
+	}
+	// The owner's public key lets trackers check the owner's invites - only the owner adds it
+	if ownerKey == "" && currentUser.Name == owner {
+		if ownerKey, err = identityKey(); err != nil {
+			logger.Lynk(name).Warn("Could Not Load Identity Key: " + err.Error())
+		}
+	}
+	id := lynk.ID
+	if id == "" {
+		if id, err = lynxutil.NewLynkID(); err != nil {
+			return err
+		}
+	}
+
+	header := "announce:::" + net.JoinHostPort(lynxutil.GetIP(), config.TrackerPort) + "\n" +
+		"id:::" + id + "\n" + "lynkName:::" + metaName + "\n" + "owner:::" + owner + "\n"
+	if ownerKey != "" {
+		header += "ownerKey:::" + ownerKey + "\n"
+	}
+	header += "revision:::" + strconv.Itoa(revision) + "\n"
+	if key != nil || (ok && lynk.Encrypted) {
+		header += "encrypted:::true\n"
+	}
+	err = lynxutil.WriteFileAtomic(dir+"/meta.info", []byte(header), 0644)
 	if err != nil {
-		fmt.Println(err)
+		logger.Lynk(name).Error("Could Not Create meta.info: " + err.Error())
 		return err
 	}
 
-	currentUser, _ := user.Current()
-	metaFile.WriteString("announce:::" + lynxutil.GetIP() + ":" + lynxutil.TrackerPort + "\n")
-	metaFile.WriteString("lynkName:::" + name + "\n")
-	metaFile.WriteString("owner:::" + currentUser.Name + "\n")
-
-	addLynk(name, currentUser.Name)
-	filepath.Walk(lynxutil.HomePath+name, visitFiles)
+	addLynk(name, owner, id, root)
+	if key != nil {
+		lynks.Update(name, func(lynk *lynxutil.Lynk) { lynk.Key = key })
+		saveLynk(name)
+	}
+	filepath.Walk(dir, visitFiles(dir+"/meta.info"))
 
-	ParseMetainfo(lynxutil.HomePath + name + "/meta.info")
+	ParseMetainfo(dir + "/meta.info")
 
 	return nil // Everything was fine if we reached this point
 }
 
-// Function which visits each file within a directory
-// @param path string - the path where the root directory is located
-// @param file os.FileInfo - each file within the root or inner directories
-// @param err error - any error we way encoutner along the way
-// @return error - An error can produced if we encounter an invalid file.
-func visitFiles(path string, file os.FileInfo, err error) error {
-	// Don't add directories, trackers, or a meta.info file to the new meta.info
-	if !file.IsDir() && !strings.Contains(path, "_Tracker") && file.Name() != "meta.info" {
-		//fmt.Println(file.Name())
-		slashes := strings.Replace(path, "\\", "/", -1)
-		//fmt.Println(slashes)
-		tmpStr := strings.TrimPrefix(slashes, lynxutil.HomePath)
-		tmpArr := strings.Split(tmpStr, "/")
-		AddToMetainfo(path, lynxutil.HomePath+tmpArr[0]+"/meta.info")
-	}
+// Function which returns a function that visits each file within a lynk's directory
+// @param metaPath string - the meta.info of the lynk each file is added to
+// @return filepath.WalkFunc - the function which visits each file - it returns an error if we
+// encounter an invalid file.
+func visitFiles(metaPath string) filepath.WalkFunc {
+	return func(path string, file os.FileInfo, err error) error {
+		// Don't add directories or a meta.info file to the new meta.info
+		if err == nil && !file.IsDir() && file.Name() != "meta.info" &&
+			!lynxutil.IsTempFile(file.Name()) {
+			addEntry(path, metaPath, false)
+		}
 
-	return nil
+		return nil
+	}
 }
 
-// Function which adds a lynk to list of lynks and also will added it to lynks.txt file as well
+// Function which adds a lynk to list of lynks and saves it to the database
 // @param name string - the name of the lynk
 // @param owner string - the owner of the lynk
-// @return error - An error can be produced if the lynks.txt file cannot be opened
-func addLynk(name, owner string) error {
-
-	lynkFile, err := os.OpenFile(lynxutil.HomePath+"lynks.txt", os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println(err)
-		// create file if not real
-	}
-
-	i := 0
-	for i < len(lynks) {
-		// Will have to validate directory names
-		fmt.Println()
-		if strings.TrimSpace(lynks[i].Name+lynks[i].Owner) == strings.TrimSpace(name+owner) {
+// @param id string - the ID of the lynk from its meta.info
+// @param root string - the folder of a lynk kept outside our home directory - empty otherwise
+// @return error - An error can be produced if the lynk already exists or cannot be saved
+func addLynk(name, owner, id, root string) error {
+	for _, lynk := range lynks.List() {
+		if lynk.ID == id {
 			return errors.New("Can't Add Duplicate Lynk")
 		}
-		i++
 	}
 
-	lynkFile.WriteString(name + ":::Unsynced:::" + owner + "\n")
-
-	ParseLynks(lynxutil.HomePath + "lynks.txt")
-	genLynks()
+	if !lynks.Add(lynxutil.Lynk{Name: name, ID: id, Root: root, Synced: lynxutil.OutOfDate,
+		Owner: owner, Mode: lynxutil.TwoWay}) {
+		return errors.New("Can't Add Duplicate Lynk")
+	}
+	ParseMetainfo(MetaPath(name))
 
-	return lynkFile.Close()
+	return saveLynk(name)
 }
 
-// ParseLynks - Parses the information in lynks file and places each entry into a the lynks array.
+// ParseLynks - Parses the information in a lynks.txt file from before our lynks were kept in the
+// database and places each entry into the lynks array. Malformed lines are skipped.
 // @param string lynksFilePath - The path to the lynks.txt file
 // @return error - An error can be produced when issues arise from trying to access
 // the lynks.txt file.
 func ParseLynks(lynksFilePath string) error {
-	lynks = nil // Resets files array
+	var parsed []lynxutil.Lynk
+	defer func() { lynks.Reset(parsed) }() // Resets the lynks array even if nothing was parsed
 
 	lynksFile, err := os.Open(lynksFilePath)
 	if err != nil {
@@ -581,19 +1254,19 @@ func ParseLynks(lynksFilePath string) error {
 	}
 
 	scanner := bufio.NewScanner(lynksFile)
-	tempLynk := lynxutil.Lynk{}
 
 	// Scan each line
 	for scanner.Scan() {
 
 		line := strings.TrimSpace(scanner.Text()) // Trim helps with errors in \n
 		split := strings.Split(line, ":::")
-		tempLynk.Name = split[0]
-		tempLynk.Synced = split[1]
-		tempLynk.Owner = split[2]
+		if len(split) < 3 || split[0] == "" {
+			logger.Warn("Skipping Malformed Line In " + lynksFilePath + ": " + line)
+			continue
+		}
 
-		lynks = append(lynks, tempLynk) // Append the current file to the file array
-		tempLynk = lynxutil.Lynk{}      // Empty the current file
+		// Append the current lynk to the lynk array
+		parsed = append(parsed, lynxutil.Lynk{Name: split[0], Synced: split[1], Owner: split[2]})
 	}
 
 	return lynksFile.Close()
@@ -602,75 +1275,123 @@ func ParseLynks(lynksFilePath string) error {
 // DeleteLynk - This function deletes a Lynk based upon its name from the list of lynks
 // @param nameToDelete string - the lynk we want to remove
 func DeleteLynk(nameToDelete string, deleteLocal bool) {
-	i := 0
-	for i < len(lynks) {
-		if nameToDelete == lynks[i].Name {
-			// Removes this peer from swarm.info file
-			//fmt.Println("deleted lynk")
-			lynks = append(lynks[:i], lynks[i+1:]...)
-		}
-		i++
-	}
-	updateLynksFile()
+	// Removes this peer from swarm.info file
+	//fmt.Println("deleted lynk")
+	root := LynkRoot(nameToDelete)
+	ratelimit.SetLynkLimits(lynkID(nameToDelete), lynxutil.Limits{})
+	lynks.Remove(nameToDelete)
+	saveLynk(nameToDelete)
 
 	if deleteLocal {
-		os.RemoveAll(lynxutil.HomePath + nameToDelete)
+		os.RemoveAll(root)
 	}
 }
 
-// Function which removes the lynks.txt file and creates a new one based on the current lynks array
-// @returns error - will produce an error if we cannot open the lynks.txt file.
-func updateLynksFile() error {
-	newLynks, err := os.Create(lynxutil.HomePath + "lynks.txt")
-	if err != nil {
-		fmt.Println(err)
-		return err
+// RenameLynk - Renames one of our lynks, and its folder unless the lynk is kept outside our home
+// directory. Its ID and the name its owner gave it stay the same, so peers and trackers are not
+// affected.
+// @param string lynkName - Our name for the lynk
+// @param string newName - The new name
+// @return error - An error is produced if the lynk is syncing, the new name is invalid or taken,
+// or the folder cannot be renamed
+func RenameLynk(lynkName, newName string) error {
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk Not Found")
+	} else if !validLynkName(newName) {
+		return errors.New("Invalid Lynk Name: " + newName)
+	} else if lynk.DLing {
+		return errors.New("Can't Rename A Lynk While It Is Syncing")
+	}
+	if _, taken := lynks.Get(newName); taken {
+		return errors.New("Can't Add Duplicate Lynk")
 	}
 
-	i := 0
-	for i < len(lynks) {
-		newLynks.WriteString(lynks[i].Name + ":::" + lynks[i].Synced + ":::" +
-			lynks[i].Owner + "\n")
-
-		i++
+	if lynk.Root == "" {
+		if _, err := os.Stat(config.HomePath + newName); err == nil {
+			return errors.New("Directory " + newName + " Already Exists")
+		}
+		if err := os.Rename(config.HomePath+lynkName, config.HomePath+newName); err != nil {
+			return err
+		}
+	}
+	// The parts of its downloads move with it
+	os.Rename(config.HomePath+".lynxpart/"+lynkName, config.HomePath+".lynxpart/"+newName)
+
+	lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Name = newName })
+	saveLynk(lynkName) // Removes the lynk from the database under its old name
+	behindMu.Lock()
+	if since, ok := behindSince[lynkName]; ok {
+		behindSince[newName] = since
+		delete(behindSince, lynkName)
 	}
+	behindMu.Unlock()
+	logger.Lynk(newName).Info("Renamed From " + lynkName)
+	return saveLynk(newName)
+}
+
+// Helper function which returns whether or not a name can be the name of a lynk's folder
+// @param string name - The name
+func validLynkName(name string) bool {
+	return strings.TrimSpace(name) != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\") && !strings.HasPrefix(name, ".lynx")
+}
 
-	return newLynks.Close()
+// Helper function which returns a name for the folder of a lynk we join - the name its owner gave
+// it, or that name with a number after it if we already have a lynk or folder with that name
+// @param string name - The name the owner gave the lynk
+// @param string id - The ID of the lynk, which names it if its name cannot be a folder's
+// @return string - The name of the folder
+func freeLynkName(name, id string) string {
+	if !validLynkName(name) {
+		name = "Lynk-" + id
+	}
+	free := name
+	for i := 2; ; i++ {
+		_, taken := lynks.Get(free)
+		if _, err := os.Stat(config.HomePath + free); !taken && os.IsNotExist(err) {
+			return free
+		}
+		free = name + "-" + strconv.Itoa(i)
+	}
 }
 
 // JoinLynk - Function which will allow a user to join an existing link by way of its meta.info file
+// or an invite from its owner
 // @param metaPath string - the path to the meta.info file which will be used to find the
-// information about the lynk
+// information about the lynk, or an invite
 func JoinLynk(metaPath string) error {
-	metaFile, err := os.Open(metaPath)
+	return JoinLynkAt(metaPath, "")
+}
+
+// Helper function which joins a lynk by way of its meta.info file
+// @param metaPath string - the path to the meta.info file
+// @param root string - the folder to join the lynk into - our home directory if it is empty
+// @return error - An error is produced if the meta.info cannot be read
+func joinMeta(metaPath, root string) error {
+	header, err := lynxutil.ReadMetaHeader(metaPath)
 	if err != nil {
 		return err
 	}
-	lynkName := ""
-	owner := ""
-	scanner := bufio.NewScanner(metaFile)
-	tempPeer := lynxutil.Peer{}
-
-	// Scan each line
-	for scanner.Scan() {
-
-		line := strings.TrimSpace(scanner.Text()) // Trim helps with errors in \n
-		split := strings.Split(line, ":::")
-
-		if split[0] == "announce" {
-			tempPeer.IP = split[metaValueIndex]
-		} else if split[0] == "port" {
-			tempPeer.Port = split[metaValueIndex]
-		} else if split[0] == "lynkName" {
-			lynkName = split[metaValueIndex]
-		} else if split[0] == "owner" {
-			owner = split[metaValueIndex]
-		}
+	if lynk, ok := lynks.Resolve(header.ID); ok && lynk.ID == header.ID {
+		return errors.New("Already Joined Lynk As " + lynk.Name)
+	}
 
+	// Our name for the lynk is the name its owner gave it, unless we already have a lynk with it
+	lynkName := freeLynkName(header.Name, header.ID)
+	if err = createJoin(lynkName, root, metaPath); err != nil {
+		return err
+	}
+	if err = addLynk(lynkName, header.Owner, header.ID, root); err != nil {
+		return err
 	}
 
-	createJoin(lynkName, metaPath)
-	addLynk(lynkName, owner)
+	// Without the key of an encrypted lynk we can only store and serve its sealed files
+	if lynk, _ := lynks.Get(lynkName); lynk.Encrypted && lynk.Key == nil {
+		lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Mode = lynxutil.ReceiveOnly })
+		saveLynk(lynkName)
+		logger.Lynk(lynkName).Info("Joined Encrypted Lynk As A Storage Peer")
+	}
 
 	return UpdateLynk(lynkName) // Gets all of the files for the lynk over the network
 }
@@ -679,57 +1400,91 @@ func JoinLynk(metaPath string) error {
 // @param lynkName string - the name of the Lynk we want to update
 func UpdateLynk(lynkName string) error {
 	// We actually get the files we need over the network.
-	lynk := lynxutil.GetLynk(lynks, lynkName)
+	start := false
+	found := lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		// Paused lynks are fetched once they are resumed, and a lynk already being updated
+		// carries on with its paused downloads when they are resumed. Send-only lynks ignore
+		// remote changes.
+		if !lynk.Paused && !lynk.DLing && lynk.Mode != lynxutil.SendOnly {
+			lynk.DLing = true
+			start = true
+		}
+	})
+	if !found {
+		return errors.New("Lynk Not Found")
+	} else if !start {
+		return nil
+	}
+	defer lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.DLing = false })
+	lynk, _ := lynks.Get(lynkName)
+
+	behindMu.Lock()
+	if _, ok := behindSince[lynkName]; !ok {
+		behindSince[lynkName] = time.Now()
+	}
+	behindMu.Unlock()
+
 	var err error // Creates nil error
+	synced := true
 	for _, file := range lynk.Files {
-		err = getFile(file.Name, lynxutil.HomePath+lynkName+"/meta.info")
-		// If we fail to get the file the first time, we attempt again.
-		if err != nil {
-			for i := 0; i < lynxutil.ReconnAttempts; i++ {
-				err = getFile(file.Name, lynxutil.HomePath+lynkName+"/meta.info")
+		if !IsDownloading(lynkName) {
+			synced = false
+			break // StopDownload was called
+		} else if file.State == lynxutil.Synced || file.State == lynxutil.Skipped {
+			continue // We already have this version of the file, or have not selected it
+		}
+		err = getFile(file.Name, MetaPath(lynkName))
+		// If we fail to get the file the first time, we attempt again - unless it was cancelled.
+		if err != nil && err != jobs.ErrCancelled {
+			for i := 0; i < config.ReconnAttempts; i++ {
+				err = getFile(file.Name, MetaPath(lynkName))
 			}
 		}
+		synced = synced && err == nil
 	}
 
+	if synced {
+		behindMu.Lock()
+		delete(behindSince, lynkName)
+		behindMu.Unlock()
+	}
 	return err
 }
 
 // Function which creates the directory for a newly joined lynk.
 // @params name string - the name of the new lynk
+// @params root string - the folder of a lynk kept outside our home directory, which is empty or
+// does not exist yet - empty otherwise
 // @params oldMetaPath string - the name of the metaPath we are using to create our new metaPath
-func createJoin(name, oldMetaPath string) error {
-	tDir, err := os.Stat(lynxutil.HomePath + name)
-	// Checks to see if the directory exists so we don't overwrite
-	if err == nil && tDir.IsDir() {
-		fmt.Println("ERROR!" + tDir.Name() + " Already Exists")
-		return errors.New("Directory " + name + " Already Exists")
+func createJoin(name, root, oldMetaPath string) error {
+	newLynkDir := root
+	if newLynkDir == "" {
+		newLynkDir = config.HomePath + name
+		tDir, err := os.Stat(newLynkDir)
+		// Checks to see if the directory exists so we don't overwrite
+		if err == nil && tDir.IsDir() {
+			logger.Lynk(name).Error("Directory " + tDir.Name() + " Already Exists")
+			return errors.New("Directory " + name + " Already Exists")
+		}
+	}
+	if err := os.MkdirAll(newLynkDir, 0755); err != nil {
+		return err
 	}
 
-	newLynkDir := lynxutil.HomePath + name
-	os.Mkdir(newLynkDir, 0755)
-
-	err = lynxutil.FileCopy(oldMetaPath, newLynkDir+"/meta.info")
+	err := lynxutil.FileCopy(oldMetaPath, newLynkDir+"/meta.info")
 	if err != nil {
-		fmt.Println(err)
+		logger.Lynk(name).Error("Could Not Copy meta.info: " + err.Error())
 		return err
 	}
 
 	return nil // Everything was fine if we reached this point
 }
 
-// Function init runs as soon as this class is imported and allows us to create an array of Lynks.
-func init() {
-	ParseLynks(lynxutil.HomePath + "lynks.txt")
-	genLynks()
-}
-
 // Helper function that generates all the data for our lynks array by parsing each corresponding
 // meta.info file.
 func genLynks() {
-	i := 0
-	for i < len(lynks) {
-		ParseMetainfo(lynxutil.HomePath + lynks[i].Name + "/meta.info")
-		i++
+	for _, lynk := range lynks.List() {
+		ParseMetainfo(MetaPath(lynk.Name))
 	}
 }
 
@@ -737,66 +1492,288 @@ func genLynks() {
 // @param string metaPath - The meta.info path associated with the lynk we're interested in
 // @returns string - The lynk name
 func GetLynkName(metaPath string) string {
-	return strings.TrimSuffix(strings.TrimPrefix(metaPath, lynxutil.HomePath), "/meta.info")
+	root := strings.TrimSuffix(metaPath, "/meta.info")
+	if lynk, ok := lynks.FindRoot(root); ok {
+		return lynk.Name
+	}
+	return strings.TrimPrefix(root, config.HomePath)
 }
 
-// GetLynks - Simply returns our current lynks array.
+// Helper function which returns the ID peers and trackers know one of our lynks by
+// @param string lynkName - Our name for the lynk
+// @return string - The ID - or the name, for a lynk whose meta.info has not been read
+func lynkID(lynkName string) string {
+	if lynk, ok := lynks.Get(lynkName); ok && lynk.ID != "" {
+		return lynk.ID
+	}
+	return lynkName
+}
+
+// ResolveLynk - Returns our name for a lynk a peer or tracker asked about
+// @param string idOrName - The ID of the lynk - or its name, from peers running older versions
+// @return string - Our name for the lynk, which is the name of its folder
+// @return bool - Whether or not we have the lynk
+func ResolveLynk(idOrName string) (string, bool) {
+	lynk, ok := lynks.Resolve(idOrName)
+	return lynk.Name, ok
+}
+
+// GetLynks - Returns a copy of our current lynks array. Changes made to the copy are not seen by
+// the client.
 // @returns - The current lynks array.
 func GetLynks() []lynxutil.Lynk {
-	return lynks
+	return lynks.List()
+}
+
+// GetLynk - Returns a copy of one of our lynks
+// @param string lynkName - The name of the lynk
+// @returns lynxutil.Lynk - The lynk
+// @returns bool - Whether or not we have the lynk
+func GetLynk(lynkName string) (lynxutil.Lynk, bool) {
+	return lynks.Get(lynkName)
 }
 
 // GetLynksLen - Returns the size of our lynks array.
 // @returns - The current size of our lynks array.
 func GetLynksLen() int {
-	return len(lynks)
+	return lynks.Len()
 }
 
 // PopulateFilesAndSize - Fills Our Lynks Array With File And Size Information
 func PopulateFilesAndSize() {
-	i := 0
-	for i < len(lynks) {
-		files := lynks[i].Files
+	lynks.UpdateAll(func(lynk *lynxutil.Lynk) {
+		files := lynk.Files
 		j := 0
-		if len(lynks[i].FileNames) == 0 && len(lynks[i].FileSize) == 0 {
+		if len(lynk.FileNames) == 0 && len(lynk.FileSize) == 0 {
 			for j < len(files) {
-				lynks[i].FileNames = append(lynks[i].FileNames, files[j].Name)
-				lynks[i].FileSize = append(lynks[i].FileSize, files[j].Length)
+				lynk.FileNames = append(lynk.FileNames, files[j].Name)
+				lynk.FileSize = append(lynk.FileSize, files[j].Length)
 				j++
 			}
 		}
-		i++
-	}
-
+	})
 }
 
 // IsDownloading - Returns whether or not the client associated the specified lynk is downloading
 // @param lynkName - the name of the lynk
 // @returns - Returns whether or not the client associated the specified lynk is downloading
 func IsDownloading(lynkName string) bool {
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	return lynk.DLing
+	lynk, ok := lynks.Get(lynkName)
+	return ok && lynk.DLing
 }
 
-// StopDownload - Sets a boolean to stop the lynk from downloading
+// StopDownload - Sets a boolean to stop the lynk from downloading and cancels the downloads in
+// progress
 func StopDownload(lynkName string) {
-	lynk := lynxutil.GetLynk(lynks, lynkName)
-	lynk.DLing = false
+	lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.DLing = false })
+	for _, job := range jobs.ForLynk(lynkName) {
+		if job.Direction == events.Download {
+			job.Cancel()
+		}
+	}
+}
+
+// PauseLynk - Stops a lynk from pushing local changes or downloading files until it is resumed,
+// and pauses its transfers in progress
+// @param string lynkName - The name of the lynk
+// @return error - An error is produced if the lynk does not exist
+func PauseLynk(lynkName string) error {
+	if !lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Paused = true }) {
+		return errors.New("Lynk Not Found")
+	}
+	saveLynk(lynkName)
+	jobs.PauseLynk(lynkName)
+	return nil
+}
+
+// ResumeLynk - Resumes syncing a paused lynk and fetches anything that changed while it was paused
+// @param string lynkName - The name of the lynk
+// @return error - An error is produced if the lynk does not exist
+func ResumeLynk(lynkName string) error {
+	if !lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Paused = false }) {
+		return errors.New("Lynk Not Found")
+	}
+	saveLynk(lynkName)
+	jobs.ResumeLynk(lynkName)
+	go UpdateLynk(lynkName)
+	return nil
+}
+
+// SetSelection - Chooses which of a lynk's files we download and fetches any newly selected ones.
+// Files we already have are kept when they are no longer selected.
+// @param string lynkName - The name of the lynk
+// @param lynxutil.Selection selection - The include and exclude patterns
+// @return error - An error is produced if the lynk does not exist or a pattern is invalid
+func SetSelection(lynkName string, selection lynxutil.Selection) error {
+	if err := selection.Validate(); err != nil {
+		return err
+	}
+	paused := false
+	found := lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		lynk.Selection = selection
+		paused = lynk.Paused
+	})
+	if !found {
+		return errors.New("Lynk Not Found")
+	}
+
+	ParseMetainfo(MetaPath(lynkName))
+	saveLynk(lynkName)
+	if !paused {
+		go UpdateLynk(lynkName)
+	}
+	return nil
+}
+
+// SetMode - Sets whether a lynk is synced both ways, only receives changes or only sends them.
+// Remote changes ignored while the lynk was send-only are fetched once it receives again.
+// @param string lynkName - The name of the lynk
+// @param string mode - lynxutil.TwoWay, lynxutil.ReceiveOnly or lynxutil.SendOnly
+// @return error - An error is produced if the lynk does not exist or the mode is invalid
+func SetMode(lynkName, mode string) error {
+	if err := lynxutil.ValidMode(mode); err != nil {
+		return err
+	}
+	paused := false
+	found := lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		lynk.Mode = mode
+		lynk.LocalChanges = nil
+		paused = lynk.Paused
+	})
+	if !found {
+		return errors.New("Lynk Not Found")
+	}
+	err := saveLynk(lynkName)
+	if !paused && mode != lynxutil.SendOnly {
+		go UpdateLynk(lynkName)
+	}
+	return err
+}
+
+// SetLimits - Sets the bandwidth limits of a lynk. They are kept with the lynk, so they stay with
+// it when it is renamed and are set again when we next start.
+// @param string lynkName - The name of the lynk
+// @param lynxutil.Limits limits - The limits in kilobytes per second - zero values remove them
+// @return error - An error is produced if the lynk does not exist or a limit is negative
+func SetLimits(lynkName string, limits lynxutil.Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	id := ""
+	found := lynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		lynk.Limits = limits
+		id = lynk.ID
+	})
+	if !found {
+		return errors.New("Lynk Not Found")
+	}
+	ratelimit.SetLynkLimits(id, limits)
+	return saveLynk(lynkName)
+}
+
+// HasLocalChanges - Returns whether a lynk's folder holds a file its meta.info does not, which
+// is how the GUI and lynxd notice files added or changed while they run. Temporary files of
+// downloads and atomic writes in progress are not counted.
+// @param lynxutil.Lynk lynk - The lynk to check
+// @return bool - Whether or not a file has been added or changed
+func HasLocalChanges(lynk lynxutil.Lynk) bool {
+	inMeta := make(map[string]bool)
+	for _, file := range lynk.Files {
+		inMeta[file.LocalName()] = true
+	}
+	changed := false
+	filepath.Walk(LynkRoot(lynk.Name), func(path string, file os.FileInfo, err error) error {
+		// The lynk's folder may have been removed
+		if err == nil && !file.IsDir() && file.Name() != "meta.info" &&
+			!lynxutil.IsTempFile(file.Name()) && !inMeta[file.Name()] {
+			logger.Lynk(lynk.Name).Info("File: " + file.Name() + " has been added or changed")
+			changed = true
+		}
+		return nil
+	})
+	return changed
+}
+
+// RevertLocalChanges - Undoes local changes to a receive-only lynk. Files which were edited or
+// removed no longer match meta.info, so they are downloaded again - an edit is found by the size
+// or mtime of the file changing, even if its length stays the same. Files which were added are
+// never deleted - they are flagged in the lynk's LocalChanges instead.
+// @param string lynkName - The name of the lynk
+// @return error - An error is produced if the lynk does not exist or is not receive-only
+func RevertLocalChanges(lynkName string) error {
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk Not Found")
+	} else if lynk.Mode != lynxutil.ReceiveOnly {
+		return errors.New("Lynk Is Not Receive-Only")
+	}
+
+	inMeta := make(map[string]bool)
+	for _, file := range lynk.Files {
+		inMeta[file.LocalName()] = true
+	}
+	var added []string
+	filepath.Walk(LynkRoot(lynkName), func(path string, file os.FileInfo, err error) error {
+		if err == nil && !file.IsDir() && file.Name() != "meta.info" &&
+			!lynxutil.IsTempFile(file.Name()) && !inMeta[file.Name()] {
+			added = append(added, file.Name())
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(added, lynk.LocalChanges) {
+		for _, name := range added {
+			logger.Lynk(lynkName).Warn("File: " + name + " was added to a receive-only Lynk and " +
+				"will not be pushed")
+		}
+		lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.LocalChanges = added })
+	}
+
+	ParseMetainfo(MetaPath(lynkName))
+	if lynk, _ = lynks.Get(lynkName); lynk.Synced == lynxutil.OutOfDate && !lynk.Paused {
+		go UpdateLynk(lynkName)
+	}
+	return nil
+}
+
+// FetchFile - Downloads one file of a lynk in the background, whether or not it is selected. The
+// copy is kept, but a newer version of a file which is not selected has to be fetched again.
+// @param string lynkName - The name of the lynk
+// @param string fileName - The name of the file
+// @return error - An error is produced if the lynk or file does not exist or the lynk is paused
+func FetchFile(lynkName, fileName string) error {
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk Not Found")
+	} else if lynk.Paused {
+		return errors.New("Lynk Is Paused")
+	}
+	for _, file := range lynk.Files {
+		if file.Name == fileName {
+			go getFile(fileName, MetaPath(lynkName))
+			return nil
+		}
+	}
+	return errors.New("File Not Found")
 }
 
 // GetFileTableIndex - Gets the file table index
 func GetFileTableIndex() int {
+	fileTableMu.Lock()
+	defer fileTableMu.Unlock()
 	return fileTableIndex
 }
 
 // SetFileTableIndex - Sets the file table index
 // @param index - the index of the file in the GUI Table
 func SetFileTableIndex(index int) {
+	fileTableMu.Lock()
+	defer fileTableMu.Unlock()
 	fileTableIndex = index
 }
 
 // GetLynkNameFromIndex - Gets Lynk name based on inde
 // @param index - the index of the file in the GUI Table
 func GetLynkNameFromIndex(index int) string {
-	return lynks[index].Name
+	lynk, _ := lynks.At(index)
+	return lynk.Name
 }
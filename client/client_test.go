@@ -5,19 +5,28 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"capstone/lynxutil"
+	"capstone/mycrypt"
+	"capstone/mypgp"
+	"capstone/ratelimit"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
 	"os/user"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Count of the # of successful tests.
 var successful = 0
 
 // Total # of the tests.
-const total = 19
+const total = 52
 
 // Gets user's home directory
 var cU, _ = user.Current()
@@ -28,6 +37,11 @@ var hPath = cU.HomeDir + "/Lynx/"
 // Uses homePath and our Tests Lynk to create mPath
 var mPath = hPath + "Tests/meta.info"
 
+// Loads our lynks from the database in the default home directory before any test runs
+func init() {
+	Configure(lynxutil.DefaultConfig())
+}
+
 // Unit tests for our FileCopy function.
 // @param *testing.T t - The wrapper for the test
 func TestFileCopy(t *testing.T) {
@@ -78,7 +92,7 @@ func TestAddToMetainfo(t *testing.T) {
 
 	ParseMetainfo(mPath)
 	hasTest := false
-	tLynk := lynxutil.GetLynk(lynks, "Tests")
+	tLynk, _ := lynks.Get("Tests")
 
 	i := 0
 	for i < len(tLynk.Files) {
@@ -101,6 +115,7 @@ func TestAddToMetainfo(t *testing.T) {
 	}
 
 	ParseMetainfo(mPath)
+	tLynk, _ = lynks.Get("Tests")
 
 	// check that test.txt is in the File struct list
 	i = 0
@@ -189,7 +204,7 @@ func TestDeleteFile(t *testing.T) {
 	lynkName := GetLynkName(mPath)
 	DeleteFile("test.txt", lynkName)
 
-	tLynk := lynxutil.GetLynk(lynks, lynkName)
+	tLynk, _ := lynks.Get(lynkName)
 
 	i := 0
 	for i < len(tLynk.Files) {
@@ -208,6 +223,7 @@ func TestDeleteFile(t *testing.T) {
 	}
 
 	DeleteFile("test11.txt", lynkName)
+	tLynk, _ = lynks.Get(lynkName)
 
 	i = 0
 	for i < len(tLynk.Files) {
@@ -274,6 +290,636 @@ func TestHaveFile(t *testing.T) {
 
 }
 
+// Unit tests which create, push and pull Lynks from many goroutines at once - run with -race to
+// check the locking around our lynks.
+// @param *testing.T t - The wrapper for the test
+func TestConcurrentLynks(t *testing.T) {
+	fmt.Println("\n----------------TestConcurrentLynks----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+
+	names := []string{"One", "Two"}
+	for _, name := range names {
+		os.Mkdir(home+"/"+name, 0755)
+		ioutil.WriteFile(home+"/"+name+"/"+name+".txt", []byte(name), 0644)
+	}
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(2)
+		go func(name string) {
+			defer wg.Done()
+			CreateMeta(name)
+		}(name)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				HaveFile(name + "/" + name + ".txt")
+				PopulateFilesAndSize()
+				HandlePeerExchange("unknown", nil)
+				StopDownload(name)
+				IsDownloading(name)
+				GetLynks()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	if GetLynksLen() != len(names) {
+		t.Error("Test failed, expected 2 lynks. Got ", GetLynks())
+	} else {
+		fmt.Println("Successfully Created Lynks Concurrently")
+		successful++
+	}
+
+	if !HaveFile("One/One.txt") || !HaveFile("Two/Two.txt") {
+		t.Error("Test failed, expected both lynks to have their file. Got ", GetLynks())
+	} else {
+		fmt.Println("Successfully Pulled Files Concurrently")
+		successful++
+	}
+}
+
+// Unit tests for moving lynks.txt and meta.info files into the database
+// @param *testing.T t - The wrapper for the test
+func TestMigrateLynks(t *testing.T) {
+	fmt.Println("\n----------------TestMigrateLynks----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+
+	os.Mkdir(home+"/Old", 0755)
+	ioutil.WriteFile(home+"/Old/meta.info", []byte("announce:::1.2.3.4:9000\nlynkName:::Old\n"+
+		"owner:::Max\nlength:::3\nname:::a.txt\n"+endOfEntry+"\n"), 0644)
+	ioutil.WriteFile(home+"/lynks.txt", []byte("Old:::Synced:::Max\nmalformed\n"), 0644)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+	Configure(cfg) // The second time our lynks come from the database alone
+
+	lynk, ok := GetLynk("Old")
+	_, err = os.Stat(home + "/lynks.txt.migrated")
+	if !ok || GetLynksLen() != 1 || len(lynk.Files) != 1 || lynk.Tracker != "1.2.3.4:9000" ||
+		err != nil {
+		t.Error("Test failed, expected the migrated lynk with its file. Got ", GetLynks(), err)
+	} else {
+		fmt.Println("Successfully Migrated Lynks")
+		successful++
+	}
+
+	DeleteLynk("Old", false)
+	Configure(cfg)
+	if GetLynksLen() != 0 {
+		t.Error("Test failed, expected the deleted lynk to stay deleted. Got ", GetLynks())
+	} else {
+		fmt.Println("Successfully Deleted Lynk From Database")
+		successful++
+	}
+}
+
+// Unit tests for checking downloads against their meta.info before they are put in a lynk
+// @param *testing.T t - The wrapper for the test
+func TestVerifyFile(t *testing.T) {
+	fmt.Println("\n----------------TestVerifyFile----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.Mkdir(home+"/Verify", 0755)
+	ioutil.WriteFile(home+"/Verify/a.txt", []byte("abc"), 0644)
+	CreateMeta("Verify")
+
+	ioutil.WriteFile(home+"/good.txt", []byte("abc"), 0644)
+	if err := verifyFile("Verify", "a.txt", home+"/good.txt"); err != nil {
+		t.Error("Test failed, expected the matching file to be verified. Got ", err)
+	} else {
+		fmt.Println("Successfully Verified File")
+		successful++
+	}
+
+	ioutil.WriteFile(home+"/bad.txt", []byte("abd"), 0644)
+	if err := verifyFile("Verify", "a.txt", home+"/bad.txt"); err == nil {
+		t.Error("Test failed, expected a file with the wrong hash to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Corrupt File")
+		successful++
+	}
+}
+
+// Unit tests for the sync state of a lynk and its files
+// @param *testing.T t - The wrapper for the test
+func TestSyncState(t *testing.T) {
+	fmt.Println("\n----------------TestSyncState----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.Mkdir(home+"/Sync", 0755)
+	ioutil.WriteFile(home+"/Sync/a.txt", []byte("abc"), 0644)
+	ioutil.WriteFile(home+"/Sync/b.txt", []byte("def"), 0644)
+	CreateMeta("Sync")
+	lynk, _ := lynks.Get("Sync")
+	if lynk.Synced != lynxutil.Synced || !HaveFile("Sync/a.txt") {
+		t.Error("Test failed, expected our own files to be synced. Got ", lynk.Synced)
+	} else {
+		fmt.Println("Successfully Found Lynk Synced")
+		successful++
+	}
+
+	os.Remove(home + "/Sync/b.txt")
+	ParseMetainfo(home + "/Sync/meta.info")
+	lynk, _ = lynks.Get("Sync")
+	if lynk.Synced != lynxutil.OutOfDate || HaveFile("Sync/b.txt") || !HaveFile("Sync/a.txt") {
+		t.Error("Test failed, expected a missing file to leave the lynk out of date. Got ",
+			lynk.Synced)
+	} else {
+		fmt.Println("Successfully Found Lynk Out Of Date")
+		successful++
+	}
+
+	setFileState("Sync", "b.txt", lynxutil.SyncError, "Peer Went Away")
+	Configure(cfg) // The state is kept in the database
+	lynk, _ = lynks.Get("Sync")
+	if lynk.Synced != lynxutil.SyncError || lynk.Files[1].Error != "Peer Went Away" {
+		t.Error("Test failed, expected the failed file to be kept. Got ", lynk.Synced, lynk.Files)
+	} else {
+		fmt.Println("Successfully Kept Failed File State")
+		successful++
+	}
+
+	// An edit which keeps the length is found by the new mtime, and rewriting meta.info counts up
+	// its revision
+	revision := lynk.Revision
+	ioutil.WriteFile(home+"/Sync/a.txt", []byte("xyz"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(home+"/Sync/a.txt", later, later)
+	ParseMetainfo(home + "/Sync/meta.info")
+	edited, _ := lynks.Get("Sync")
+	CreateMeta("Sync")
+	lynk, _ = lynks.Get("Sync")
+	header, _ := lynxutil.ReadMetaHeader(home + "/Sync/meta.info")
+	if edited.Files[0].State != lynxutil.OutOfDate || revision < 1 ||
+		lynk.Revision != revision+1 || header.Revision != revision+1 {
+		t.Error("Test failed, expected the edit to be found and a new revision. Got ",
+			edited.Files[0].State, revision, lynk.Revision)
+	} else {
+		fmt.Println("Successfully Found Edit Of Same Length")
+		successful++
+	}
+
+	// Adding a file and rewriting meta.info from the files array count up the revision as well
+	ioutil.WriteFile(home+"/Sync/c.txt", []byte("ghi"), 0644)
+	addErr := AddToMetainfo(home+"/Sync/c.txt", home+"/Sync/meta.info")
+	added, _ := lynxutil.ReadMetaHeader(home + "/Sync/meta.info")
+	updateErr := UpdateMetainfo(home + "/Sync/meta.info")
+	updated, _ := lynxutil.ReadMetaHeader(home + "/Sync/meta.info")
+	legacy := nextRevision([]byte("announce:::dht\nid:::x\nlynkName:::A\nowner:::Max\n"))
+	if addErr != nil || added.Revision != revision+2 || updateErr != nil ||
+		updated.Revision != revision+3 || updated.ID != lynk.ID || string(legacy) !=
+		"announce:::dht\nid:::x\nlynkName:::A\nowner:::Max\nrevision:::1\n" {
+		t.Error("Test failed, expected every rewrite of meta.info to count up its revision. Got ",
+			addErr, added.Revision, updateErr, updated.Revision, string(legacy))
+	} else {
+		fmt.Println("Successfully Counted Up Revisions")
+		successful++
+	}
+}
+
+// Unit tests for choosing which files of a lynk we download
+// @param *testing.T t - The wrapper for the test
+func TestSelection(t *testing.T) {
+	fmt.Println("\n----------------TestSelection----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.Mkdir(home+"/Select", 0755)
+	ioutil.WriteFile(home+"/Select/a.txt", []byte("abc"), 0644)
+	ioutil.WriteFile(home+"/Select/b.iso", []byte("def"), 0644)
+	CreateMeta("Select")
+	PauseLynk("Select") // Nothing is downloaded while we test
+	os.Remove(home + "/Select/a.txt")
+	os.Remove(home + "/Select/b.iso")
+
+	SetSelection("Select", lynxutil.Selection{Exclude: []string{"*.iso"}})
+	Configure(cfg) // The selection is kept in the database
+	lynk, _ := lynks.Get("Select")
+	if len(lynk.Selection.Exclude) != 1 || lynk.Files[0].State != lynxutil.OutOfDate ||
+		lynk.Files[1].State != lynxutil.Skipped || lynk.Synced != lynxutil.OutOfDate {
+		t.Error("Test failed, expected b.iso to be skipped. Got ", lynk.Selection, lynk.Files)
+	} else {
+		fmt.Println("Successfully Skipped Unselected File")
+		successful++
+	}
+
+	if SetSelection("Select", lynxutil.Selection{Include: []string{"[a-"}}) == nil ||
+		FetchFile("Select", "b.iso") == nil || FetchFile("Nope", "b.iso") == nil {
+		t.Error("Test failed, expected an invalid pattern and a paused fetch to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Invalid Selection And Fetch")
+		successful++
+	}
+}
+
+// Unit tests for our receive-only and send-only modes
+// @param *testing.T t - The wrapper for the test
+func TestModes(t *testing.T) {
+	fmt.Println("\n----------------TestModes----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.Mkdir(home+"/Modes", 0755)
+	ioutil.WriteFile(home+"/Modes/a.txt", []byte("abc"), 0644)
+	CreateMeta("Modes")
+	PauseLynk("Modes") // Nothing is downloaded while we test
+
+	// A write in progress is not a change, a new file is
+	ioutil.WriteFile(home+"/Modes/c.txt"+lynxutil.TempSuffix, []byte("partial"), 0644)
+	lynk, _ := lynks.Get("Modes")
+	tempChanged := HasLocalChanges(lynk)
+	os.Remove(home + "/Modes/c.txt" + lynxutil.TempSuffix)
+	ioutil.WriteFile(home+"/Modes/c.txt", []byte("new"), 0644)
+	if tempChanged || !HasLocalChanges(lynk) {
+		t.Error("Test failed, expected only the new file to count as a change. Got ", tempChanged)
+	} else {
+		fmt.Println("Successfully Detected Local Changes")
+		successful++
+	}
+	os.Remove(home + "/Modes/c.txt")
+
+	SetMode("Modes", lynxutil.ReceiveOnly)
+	// An edit of the same length is only told apart by its mtime
+	ioutil.WriteFile(home+"/Modes/a.txt", []byte("xyz"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(home+"/Modes/a.txt", later, later)
+	ioutil.WriteFile(home+"/Modes/b.txt", []byte("new"), 0644)
+
+	RevertLocalChanges("Modes")
+	lynk, _ = lynks.Get("Modes")
+	if lynk.Mode != lynxutil.ReceiveOnly || len(lynk.LocalChanges) != 1 ||
+		lynk.LocalChanges[0] != "b.txt" || lynk.Files[0].State != lynxutil.OutOfDate {
+		t.Error("Test failed, expected a.txt out of date and b.txt flagged. Got ", lynk.LocalChanges,
+			lynk.Files)
+	} else {
+		fmt.Println("Successfully Flagged Local Changes")
+		successful++
+	}
+
+	SetMode("Modes", lynxutil.SendOnly)
+	lynks.Update("Modes", func(lynk *lynxutil.Lynk) { lynk.Paused = false })
+	err = UpdateLynk("Modes")
+	lynk, _ = lynks.Get("Modes")
+	if err != nil || lynk.DLing || SetMode("Modes", "both") == nil ||
+		RevertLocalChanges("Modes") == nil {
+		t.Error("Test failed, expected a send-only Lynk not to download. Got ", err, lynk.DLing)
+	} else {
+		fmt.Println("Successfully Ignored Remote Changes")
+		successful++
+	}
+}
+
+// Unit tests for encrypted lynks, as seen by a trusted member and by a storage peer
+// @param *testing.T t - The wrapper for the test
+func TestEncryptedLynk(t *testing.T) {
+	fmt.Println("\n----------------TestEncryptedLynk----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.Mkdir(home+"/Secret", 0755)
+	ioutil.WriteFile(home+"/Secret/a.txt", []byte("top secret"), 0644)
+	CreateEncryptedMeta("Secret")
+	PauseLynk("Secret") // Nothing is downloaded while we test
+
+	meta, _ := ioutil.ReadFile(home + "/Secret/meta.info")
+	lynk, _ := lynks.Get("Secret")
+	sealedName := ""
+	var sealed []byte
+	if len(lynk.Files) == 1 {
+		sealedName = lynk.Files[0].Name
+		sealed, _ = ReadFile("Secret/" + sealedName)
+	}
+	plain, _ := mycrypt.Open(lynk.Key, sealed)
+	if !strings.Contains(string(meta), "encrypted:::true") || strings.Contains(string(meta), "a.txt") ||
+		len(lynk.Files) != 1 || lynk.Files[0].PlainName != "a.txt" ||
+		lynk.Files[0].State != lynxutil.Synced || hashBytes(sealed) != lynk.Files[0].Hash ||
+		string(plain) != "top secret" {
+		t.Error("Test failed, expected a sealed meta.info and file. Got ", string(meta), lynk.Files)
+	} else {
+		fmt.Println("Successfully Sealed Encrypted Lynk")
+		successful++
+	}
+
+	// We become a storage peer holding only the sealed file, and are then handed the key
+	pgpConfig := mypgp.Config{Expiry: time.Hour}
+	pgpKey, _ := mypgp.CreateKey("Trusted", "test key", "trusted@example.com", &pgpConfig)
+	publicKey, _ := pgpKey.Armor()
+	privateKey, _ := pgpKey.ArmorPrivate(&pgpConfig)
+	message, err := ExportLynkKey("Secret", []byte(publicKey))
+	lynks.Update("Secret", func(lynk *lynxutil.Lynk) { lynk.Key = nil })
+	os.Remove(home + "/Secret/a.txt")
+	ioutil.WriteFile(home+"/Secret/"+sealedName, sealed, 0644)
+	ParseMetainfo(home + "/Secret/meta.info")
+	stored, _ := lynks.Get("Secret")
+
+	ImportLynkKey("Secret", message, []byte(privateKey), nil)
+	lynk, _ = lynks.Get("Secret")
+	opened, _ := ioutil.ReadFile(home + "/Secret/a.txt")
+	_, sealedErr := os.Stat(home + "/Secret/" + sealedName)
+	if err != nil || stored.Files[0].State != lynxutil.Synced || stored.Files[0].PlainName != "" ||
+		!bytes.Equal(opened, []byte("top secret")) || !os.IsNotExist(sealedErr) ||
+		lynk.Files[0].State != lynxutil.Synced || ImportLynkKey("Nope", message, nil, nil) == nil {
+		t.Error("Test failed, expected the stored file to be opened with the imported key. Got ",
+			err, stored.Files, lynk.Files)
+	} else {
+		fmt.Println("Successfully Imported Lynk Key")
+		successful++
+	}
+}
+
+// Unit tests for lynks with the same name, which are told apart by their IDs, and renaming our
+// folder for a lynk
+// @param *testing.T t - The wrapper for the test
+func TestLynkIDs(t *testing.T) {
+	fmt.Println("\n----------------TestLynkIDs----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.Mkdir(home+"/Photos", 0755)
+	CreateMeta("Photos")
+	PauseLynk("Photos")
+	ours, _ := lynks.Get("Photos")
+
+	// Someone else's lynk which is also called Photos
+	otherID, _ := lynxutil.NewLynkID()
+	ioutil.WriteFile(home+"/other.info", []byte("announce:::127.0.0.1:1\nid:::"+otherID+
+		"\nlynkName:::Photos\nowner:::Someone Else\n"), 0644)
+	joinErr := JoinLynk(home + "/other.info")
+	theirs, ok := lynks.Get("Photos-2")
+	resolved, _ := ResolveLynk(otherID)
+	if len(ours.ID) != 40 || joinErr != nil || !ok || theirs.ID != otherID ||
+		resolved != "Photos-2" || lynkID("Photos") != ours.ID ||
+		JoinLynk(home+"/other.info") == nil {
+		t.Error("Test failed, expected both lynks called Photos to be kept apart. Got ", ours,
+			theirs, joinErr)
+	} else {
+		fmt.Println("Successfully Joined Lynks With The Same Name")
+		successful++
+	}
+
+	limitErr := SetLimits("Photos-2", lynxutil.Limits{Download: 20})
+	err = RenameLynk("Photos-2", "Holiday")
+	renamed, ok := lynks.Get("Holiday")
+	UpdateMetainfo(home + "/Holiday/meta.info")
+	header, _ := lynxutil.ReadMetaHeader(home + "/Holiday/meta.info")
+	_, oldErr := os.Stat(home + "/Photos-2")
+	Configure(cfg) // The new name is kept in the database
+	_, stillOld := lynks.Get("Photos-2")
+	reloaded, _ := lynks.Get("Holiday")
+	if err != nil || !ok || renamed.ID != otherID || header.Name != "Photos" ||
+		limitErr != nil || reloaded.Limits.Download != 20 ||
+		ratelimit.LynkLimits(otherID).Download != 20 ||
+		header.ID != otherID || !os.IsNotExist(oldErr) || stillOld ||
+		RenameLynk("Holiday", "Photos") == nil || RenameLynk("Holiday", "../x") == nil {
+		t.Error("Test failed, expected the folder to be renamed and the ID kept. Got ", err,
+			renamed, header)
+	} else {
+		fmt.Println("Successfully Renamed Lynk")
+		successful++
+	}
+
+	// A meta.info never re-keys a lynk, and IDs which break our messages are refused
+	ioutil.WriteFile(home+"/Holiday/meta.info", []byte("announce:::127.0.0.1:1\nid:::"+ours.ID+
+		"\nlynkName:::Photos\nowner:::Someone Else\n"), 0644)
+	rekeyErr := ParseMetainfo(home + "/Holiday/meta.info")
+	holiday, _ := lynks.Get("Holiday")
+	ioutil.WriteFile(home+"/bad.info", []byte("announce:::127.0.0.1:1\nid:::x/y:1"+
+		"\nlynkName:::Bad\nowner:::Someone Else\n"), 0644)
+	badErr := JoinLynk(home + "/bad.info")
+	_, badJoined := lynks.Get("Bad")
+	if rekeyErr == nil || holiday.ID != otherID || badErr == nil || badJoined {
+		t.Error("Test failed, expected other and invalid IDs to be refused. Got ", rekeyErr,
+			holiday.ID, badErr)
+	} else {
+		fmt.Println("Successfully Refused Other And Invalid IDs")
+		successful++
+	}
+}
+
+// Unit tests for lynks kept in folders outside the home directory
+// @param *testing.T t - The wrapper for the test
+func TestLynkRoots(t *testing.T) {
+	fmt.Println("\n----------------TestLynkRoots----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	elsewhere, _ := ioutil.TempDir("", "lynx")
+	defer os.RemoveAll(elsewhere)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.MkdirAll(elsewhere+"/Docs/sub", 0755)
+	ioutil.WriteFile(elsewhere+"/Docs/a.txt", []byte("abc"), 0644)
+	err = CreateMetaAt("", elsewhere+"/Docs", false)
+	Configure(cfg) // The folder is kept in the database
+	docs, ok := lynks.Get("Docs")
+	_, homeErr := os.Stat(home + "/Docs")
+	if err != nil || !ok || docs.Root != elsewhere+"/Docs" || len(docs.Files) != 1 ||
+		MetaPath("Docs") != elsewhere+"/Docs/meta.info" ||
+		GetLynkName(MetaPath("Docs")) != "Docs" || !os.IsNotExist(homeErr) || CreateMetaAt("Sub", elsewhere+"/Docs/sub", false) == nil ||
+		CreateMetaAt("Rel", "Docs", false) == nil {
+		t.Error("Test failed, expected the lynk to be created where its folder is. Got ", err, docs)
+	} else {
+		fmt.Println("Successfully Created Lynk Outside The Lynx Directory")
+		successful++
+	}
+
+	otherID, _ := lynxutil.NewLynkID()
+	ioutil.WriteFile(home+"/other.info", []byte("announce:::127.0.0.1:1\nid:::"+otherID+
+		"\nlynkName:::Shared\nowner:::Someone Else\n"), 0644)
+	notEmpty := JoinLynkAt(home+"/other.info", elsewhere+"/Docs")
+	err = JoinLynkAt(home+"/other.info", elsewhere+"/Joined/Shared")
+	shared, ok := lynks.Get("Shared")
+	header, _ := lynxutil.ReadMetaHeader(elsewhere + "/Joined/Shared/meta.info")
+	if notEmpty == nil || err != nil || !ok || shared.Root != elsewhere+"/Joined/Shared" ||
+		header.ID != otherID || RenameLynk("Shared", "Ours") != nil ||
+		LynkRoot("Ours") != elsewhere+"/Joined/Shared" {
+		t.Error("Test failed, expected the lynk to be joined into its folder. Got ", notEmpty, err,
+			shared)
+	} else {
+		fmt.Println("Successfully Joined Lynk Outside The Lynx Directory")
+		successful++
+	}
+
+	userHome, _ := os.UserHomeDir()
+	os.MkdirAll(elsewhere+"/.hidden", 0755)
+	os.Symlink("/etc", elsewhere+"/etc-link")
+	thirdID, _ := lynxutil.NewLynkID()
+	ioutil.WriteFile(home+"/third.info", []byte("announce:::127.0.0.1:1\nid:::"+thirdID+
+		"\nlynkName:::Third\nowner:::Someone Else\n"), 0644)
+	for _, root := range []string{"/etc", "/usr/share", "/", userHome, userHome + "/.ssh",
+		elsewhere + "/.hidden", elsewhere + "/etc-link"} {
+		joinable := root == userHome || root == "/" // Folders inside these may hold a Lynk
+		if CreateMetaAt("Sensitive", root, false) == nil ||
+			(!joinable && JoinLynkAt(home+"/third.info", root+"/Third") == nil) {
+			t.Error("Test failed, expected a Lynk not to be kept in ", root)
+			return
+		}
+	}
+	fmt.Println("Successfully Turned Down Hidden And System Directories")
+	successful++
+}
+
+// Unit tests for inviting a peer to a lynk and joining with the invite
+// @param *testing.T t - The wrapper for the test
+func TestInvite(t *testing.T) {
+	fmt.Println("\n----------------TestInvite----------------")
+
+	owner, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(owner)
+	peer, _ := ioutil.TempDir("", "lynx")
+	defer os.RemoveAll(peer)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = owner + "/"
+	Configure(cfg)
+
+	os.Mkdir(owner+"/Invited", 0755)
+	CreateMeta("Invited")
+	meta, _ := ioutil.ReadFile(owner + "/Invited/meta.info")
+	invited, _ := lynks.Get("Invited")
+
+	// A tracker which answers one Meta_Request for the lynk which carries an invite, with the
+	// meta.info at metaPath when it is asked
+	serveMeta := func(metaPath string) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			defer listener.Close()
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			request, _ := reader.ReadString('\n')
+			invite, _ := reader.ReadString('\n')
+			if strings.HasPrefix(request, "Meta_Request:"+invited.ID+":") &&
+				lynxutil.IsInvite(invite) {
+				served, _ := ioutil.ReadFile(metaPath)
+				conn.Write(served)
+			}
+		}()
+		return listener.Addr().String()
+	}
+	inviteVia := func(tracker string) (string, error) {
+		lynks.Update("Invited", func(lynk *lynxutil.Lynk) { lynk.Tracker = tracker })
+		return CreateInvite("Invited", time.Hour)
+	}
+
+	// A tracker which hands out the lynk under a key of its own is caught
+	ioutil.WriteFile(owner+"/forged.info",
+		[]byte(strings.Replace(string(meta), "ownerKey:::", "ownerKey:::AAAA", 1)), 0644)
+	evilInvite, _ := inviteVia(serveMeta(owner + "/forged.info"))
+	// An invite keeps working after the lynk changes, but a meta.info older than it is refused
+	invite, inviteErr := inviteVia(serveMeta(owner + "/Invited/meta.info"))
+	CreateMeta("Invited")
+	ioutil.WriteFile(owner+"/stale.info", meta, 0644)
+	staleInvite, _ := inviteVia(serveMeta(owner + "/stale.info"))
+
+	cfg.HomePath = peer + "/"
+	Configure(cfg)
+	evilErr := JoinLynk(evilInvite)
+	staleErr := JoinLynk(staleInvite)
+	err = JoinLynk(invite)
+	lynk, ok := lynks.Get("Invited")
+	tampered := invite[:len(invite)-4] + "AAAA"
+	if inviteErr != nil || err != nil || !ok || lynk.Owner != cU.Name || lynk.ID != invited.ID ||
+		lynk.Revision != 2 || JoinLynk(tampered) == nil || evilErr == nil || staleErr == nil {
+		t.Error("Test failed, expected to join the lynk with the invite. Got ", inviteErr, err,
+			evilErr, staleErr, lynk)
+	} else {
+		fmt.Println("Successfully Joined With Invite")
+		successful++
+	}
+}
+
 // Unit tests for GetTrackerIP function
 // @param *testing.T t - The wrapper for the test
 func TestGetTracker(t *testing.T) {
@@ -291,14 +937,150 @@ func TestGetTracker(t *testing.T) {
 	}
 }
 
+// Unit tests for discovering peers from the announcements of servers on our local network
+// @param *testing.T t - The wrapper for the test
+func TestLAN(t *testing.T) {
+	fmt.Println("\n----------------TestLAN----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	os.Mkdir(home+"/Photos", 0755)
+	CreateMeta("Photos")
+	lynks.Update("Photos", func(lynk *lynxutil.Lynk) {
+		lynk.Peers = []lynxutil.Peer{{IP: "10.0.0.7", Port: "8080"}} // Known from the tracker
+	})
+	id := lynxutil.LynkInfoHash(lynkID("Photos")).String()
+
+	handleAnnouncement("Lynk_Announce:8081:other,"+id+"\n", "10.0.0.9")
+	handleAnnouncement("Lynk_Announce:8080:"+id+"\n", "10.0.0.7")
+	lynk, _ := lynks.Get("Photos")
+	if len(lynk.Peers) != 2 || !lynk.Peers[0].LAN || lynk.Peers[1].IP != "10.0.0.9" ||
+		lynk.Peers[1].Port != "8081" || !lynk.Peers[1].LAN {
+		t.Error("Test failed, expected a new LAN peer and the known peer marked LAN. Got ",
+			lynk.Peers)
+	} else {
+		fmt.Println("Successfully Handled Announcements")
+		successful++
+	}
+
+	handleAnnouncement("Lynk_Announce:8085:"+lynkID("Photos"), "10.0.0.14") // The raw ID
+	handleAnnouncement("Lynk_Announce:port:"+id, "10.0.0.10")
+	handleAnnouncement("Swarm_Request:8082:"+id, "10.0.0.11")
+	handleAnnouncement("Lynk_Announce:8083", "10.0.0.12")
+	handleAnnouncement("Lynk_Announce:8084:"+id+","+strings.Repeat("x", maxAnnouncement),
+		"10.0.0.13")
+	lynk, _ = lynks.Get("Photos")
+	if len(lynk.Peers) != 2 {
+		t.Error("Test failed, expected bad and oversized announcements to be ignored. Got ",
+			lynk.Peers)
+	} else {
+		fmt.Println("Successfully Ignored Bad Announcements")
+		successful++
+	}
+
+	ordered := preferLAN([]lynxutil.Peer{{IP: "1.1.1.1"}, {IP: "10.0.0.1", LAN: true},
+		{IP: "2.2.2.2"}, {IP: "10.0.0.2", LAN: true}})
+	if len(ordered) != 4 || ordered[0].IP != "10.0.0.1" || ordered[1].IP != "10.0.0.2" ||
+		ordered[2].IP != "1.1.1.1" || ordered[3].IP != "2.2.2.2" {
+		t.Error("Test failed, expected LAN peers first in their own order. Got ", ordered)
+	} else {
+		fmt.Println("Successfully Preferred LAN Peers")
+		successful++
+	}
+
+	// An announcement sent to the multicast group over loopback reaches us like any other
+	group, _ := net.ResolveUDPAddr("udp4", lynxutil.LANGroup)
+	listener, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		t.Skip("Multicast Is Unavailable: " + err.Error())
+	}
+	go serveLAN(listener)
+	defer listener.Close()
+	sender, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		t.Skip("Multicast Is Unavailable: " + err.Error())
+	}
+	defer sender.Close()
+
+	heard := false
+	for i := 0; i < 20 && !heard; i++ {
+		sender.Write([]byte("Lynk_Announce:8099:" + id + "\n"))
+		time.Sleep(100 * time.Millisecond)
+		lynk, _ = lynks.Get("Photos")
+		for _, peer := range lynk.Peers {
+			heard = heard || (peer.Port == "8099" && peer.LAN)
+		}
+	}
+	if !heard {
+		t.Skip("Multicast Is Not Looped Back On This Host")
+	}
+	fmt.Println("Successfully Heard Announcement Over Multicast")
+	successful++
+}
+
+// Unit tests for parsePEXPeer function
+// @param *testing.T t - The wrapper for the test
+func TestParsePEXPeer(t *testing.T) {
+	fmt.Println("\n----------------TestParsePEXPeer----------------")
+
+	peer, valid := parsePEXPeer("10.0.0.5:::8080")
+
+	if !valid || peer.IP != "10.0.0.5" || peer.Port != "8080" || !peer.PEX {
+		t.Error("Test failed, expected valid PEX peer 10.0.0.5:8080. Got ", peer)
+	} else {
+		fmt.Println("Successfully Parsed PEX Peer")
+		successful++
+	}
+
+	_, valid = parsePEXPeer("not-an-ip:::8080")
+
+	if valid {
+		t.Error("Test failed, expected invalid IP to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Invalid IP")
+		successful++
+	}
+
+	_, valid = parsePEXPeer("10.0.0.5:::99999")
+
+	if valid {
+		t.Error("Test failed, expected invalid port to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Invalid Port")
+		successful++
+	}
+
+	rejected := true
+	for _, line := range []string{"127.0.0.1:::8080", "0.0.0.0:::8080", "224.0.0.1:::8080",
+		"[::1]:8080", "10.0.0.5:8080 [::]:8080"} {
+		if _, valid = parsePEXPeer(line); valid {
+			rejected = false
+			t.Error("Test failed, expected unusable address to be rejected. Got ", line)
+		}
+	}
+	if rejected {
+		fmt.Println("Successfully Rejected Loopback, Unspecified And Multicast Addresses")
+		successful++
+	}
+}
+
 // Unit tests for GetTrackerIP function
 // @param *testing.T t - The wrapper for the test
 func TestAskTrackerForPeers(t *testing.T) {
 	fmt.Println("\n----------------TestAskTracker----------------")
 
 	lynkName := GetLynkName(mPath)
-	lynk := lynxutil.GetLynk(lynks, lynkName)
 	askTrackerForPeers(lynkName)
+	lynk, _ := lynks.Get(lynkName)
 
 	if len(lynk.Peers) <= 0 {
 		t.Error("Did Not Get Correct List Of Peers")
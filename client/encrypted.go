@@ -0,0 +1,213 @@
+// Package client - This file holds encrypted Lynks. The meta.info of an encrypted Lynk holds only
+// sealed file names, and the lengths and hashes of the sealed files. Trusted members have the
+// Lynk's key and keep the files in plain text, sealing them as they are sent and opening them as
+// they arrive. Storage peers do not have the key - they store and serve the sealed files as they
+// are, so an always-on box can seed a Lynk without being able to read it. The key is handed to
+// trusted members encrypted with their OpenPGP public key.
+package client
+
+import (
+	"../lynxutil"
+	"../mycrypt"
+	"../mypgp"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CreateEncryptedMeta - Creates a new encrypted lynk from a directory with a new key. Our copies
+// of its files stay in plain text.
+// @param string name - The name of the new lynk
+// @return error - An error is produced if the lynk already exists, the directory does not exist
+// or a key cannot be created
+func CreateEncryptedMeta(name string) error {
+	if _, ok := lynks.Get(name); ok {
+		return errors.New("Can't Add Duplicate Lynk")
+	}
+	key, err := mycrypt.NewKey()
+	if err != nil {
+		return err
+	}
+	return createMeta(name, "", key)
+}
+
+// ExportLynkKey - Encrypts the key of an encrypted lynk for a trusted member, who can then
+// import it with ImportLynkKey
+// @param string lynkName - The name of the lynk
+// @param []byte publicKey - The member's armored OpenPGP public key
+// @return []byte - The encrypted key
+// @return error - An error is produced if we do not have the lynk's key or the public key is
+// invalid
+func ExportLynkKey(lynkName string, publicKey []byte) ([]byte, error) {
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return nil, errors.New("Lynk Not Found")
+	} else if lynk.Key == nil {
+		return nil, errors.New("Lynk Has No Key")
+	}
+
+	var message bytes.Buffer
+	if err := mypgp.Encode(publicKey, bytes.NewReader(lynk.Key), &message); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
+
+// ImportLynkKey - Decrypts the key of an encrypted lynk exported for us by ExportLynkKey. Sealed
+// files we stored as a storage peer are opened in place, and we become a trusted member.
+// @param string lynkName - The name of the lynk
+// @param []byte message - The encrypted key
+// @param []byte privateKey - Our armored OpenPGP private key
+// @param []byte passphrase - The passphrase of the private key - empty if it has none
+// @return error - An error is produced if the key cannot be decrypted or is not the lynk's key
+func ImportLynkKey(lynkName string, message, privateKey, passphrase []byte) error {
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk Not Found")
+	} else if !lynk.Encrypted {
+		return errors.New("Lynk Is Not Encrypted")
+	}
+
+	var key bytes.Buffer
+	if err := mypgp.Decode(privateKey, passphrase, bytes.NewReader(message), &key); err != nil {
+		return err
+	}
+	for _, file := range lynk.Files {
+		if _, err := mycrypt.DecryptName(key.Bytes(), file.Name); err != nil {
+			return errors.New("Key Does Not Match Lynk")
+		}
+	}
+
+	lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Key = key.Bytes() })
+	saveLynk(lynkName)
+	for _, file := range lynk.Files {
+		if err := openStoredFile(lynkName, key.Bytes(), file.Name); err != nil {
+			logger.Lynk(lynkName).Warn("Could Not Open Stored File " + file.Name + ": " + err.Error())
+		}
+	}
+	logger.Lynk(lynkName).Info("Imported Lynk Key")
+	return ParseMetainfo(MetaPath(lynkName))
+}
+
+// ReadFile - Returns one of our files as it is sent to peers. Trusted members of an encrypted
+// lynk seal their plain text copy first - every other file is sent as it is.
+// @param string filePath - The name of the file including the lynk name, e.g. 'Cool_Lynk/coolFile.txt'
+// @return []byte - The contents of the file
+// @return error - An error is produced if the file cannot be read
+func ReadFile(filePath string) ([]byte, error) {
+	i := strings.Index(filePath, "/")
+	if i < 0 {
+		return ioutil.ReadFile(config.HomePath + filePath)
+	}
+	lynk, _ := lynks.Get(filePath[:i])
+	for _, file := range lynk.Files {
+		if file.Name == filePath[i+1:] && file.PlainName != "" {
+			return sealFile(lynk.Key, LynkRoot(lynk.Name)+"/"+file.PlainName)
+		}
+	}
+	return ioutil.ReadFile(LynkRoot(filePath[:i]) + filePath[i:])
+}
+
+// Helper function which moves a downloaded file into its lynk. A trusted member of an encrypted
+// lynk opens the sealed file and keeps it under its plain name.
+// @param string lynkName - The name of the lynk
+// @param string fileName - The name of the file in meta.info
+// @param string part - Where the downloaded file is
+// @return error - An error is produced if the file cannot be opened or moved
+func commitFile(lynkName, fileName, part string) error {
+	lynk, _ := lynks.Get(lynkName)
+	for _, file := range lynk.Files {
+		if file.Name != fileName || file.PlainName == "" {
+			continue
+		}
+
+		sealed, err := ioutil.ReadFile(part)
+		if err != nil {
+			return err
+		}
+		plain, err := mycrypt.Open(lynk.Key, sealed)
+		if err != nil {
+			return err
+		}
+		err = lynxutil.WriteFileAtomic(LynkRoot(lynkName)+"/"+file.PlainName, plain, 0644)
+		if err != nil {
+			return err
+		}
+		return os.Remove(part)
+	}
+	return lynxutil.Commit(part, LynkRoot(lynkName)+"/"+fileName)
+}
+
+// Helper function which replaces a sealed file we stored as a storage peer with its plain text.
+// It is fine if we do not have the file.
+// @param string lynkName - The name of the lynk
+// @param []byte key - The lynk's key
+// @param string sealedName - The name of the file in meta.info
+// @return error - An error is produced if the file cannot be opened or written
+func openStoredFile(lynkName string, key []byte, sealedName string) error {
+	path := LynkRoot(lynkName) + "/" + sealedName
+	sealed, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	name, err := mycrypt.DecryptName(key, sealedName)
+	if err != nil {
+		return err
+	}
+	plain, err := mycrypt.Open(key, sealed)
+	if err != nil {
+		return err
+	}
+	if err = lynxutil.WriteFileAtomic(LynkRoot(lynkName)+"/"+name, plain, 0644); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Helper function which describes a file of an encrypted lynk in meta.info by its sealed name,
+// length and hash. The path is left out so nothing about the file is given away.
+// @param []byte key - The lynk's key
+// @param string path - The path of our plain text copy
+// @param lynxutil.File entry - The entry describing the plain text copy
+// @return lynxutil.File - The sealed entry
+// @return error - An error is produced if the file cannot be read or sealed
+func sealEntry(key []byte, path string, entry lynxutil.File) (lynxutil.File, error) {
+	sealed, err := sealFile(key, path)
+	if err != nil {
+		return entry, err
+	}
+	if entry.Name, err = mycrypt.EncryptName(key, entry.Name); err != nil {
+		return entry, err
+	}
+	entry.Path = entry.Name
+	entry.Length = len(sealed)
+	entry.Hash = hashBytes(sealed)
+	return entry, nil
+}
+
+// Helper function which reads a file and seals it with a lynk's key
+// @param []byte key - The lynk's key
+// @param string path - The path of the file
+// @return []byte - The sealed file
+// @return error - An error is produced if the file cannot be read or sealed
+func sealFile(key []byte, path string) ([]byte, error) {
+	plain, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return mycrypt.Seal(key, plain)
+}
+
+// Helper function which returns the SHA-256 hash of data in hex, the way lynxutil.HashFile
+// hashes a file
+func hashBytes(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,172 @@
+// Package client - This file lets an owner invite peers to a Lynk. The invite is signed with the
+// owner's identity key, and joining with it fetches meta.info from the Lynk's tracker with a
+// Meta_Request instead of copying the file by hand. The tracker only answers the request for an
+// unexpired invite signed by the owner key kept in the Lynk's meta.info.
+package client
+
+import (
+	"../lynxutil"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// DefaultInviteLife - How long an invite works for unless told otherwise
+const DefaultInviteLife = 7 * 24 * time.Hour
+
+// CreateInvite - Creates an invite to one of our lynks, signed with our identity key over the
+// lynk's ID, owner and current revision. It keeps working as the lynk changes, but a meta.info
+// older than the invite is refused.
+// @param string lynkName - The name of the lynk
+// @param time.Duration validFor - How long the invite works for
+// @return string - The invite
+// @return error - An error is produced if we are not the lynk's owner, it has no tracker, our
+// identity key cannot be loaded or its meta.info does not hold our key
+func CreateInvite(lynkName string, validFor time.Duration) (string, error) {
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return "", errors.New("Lynk Not Found")
+	}
+	if currentUser, _ := user.Current(); currentUser == nil || currentUser.Name != lynk.Owner {
+		return "", errors.New("Only The Owner Can Invite Peers")
+	} else if lynk.Tracker == dhtAnnounce || lynk.Tracker == "" {
+		return "", errors.New("Lynk Has No Tracker")
+	} else if validFor <= 0 {
+		return "", errors.New("Invalid Invite Lifetime")
+	}
+
+	key, err := lynxutil.LoadIdentity(config.HomePath + lynxutil.IdentityFile)
+	if err != nil {
+		return "", err
+	}
+	meta, err := ioutil.ReadFile(MetaPath(lynkName))
+	if err != nil {
+		return "", err
+	}
+	header, err := lynxutil.ParseMetaHeader(meta)
+	if err != nil {
+		return "", err
+	} else if header.OwnerKey != lynxutil.EncodeOwnerKey(key.Public().(ed25519.PublicKey)) {
+		// meta.info files written before there were owner keys get ours once the lynk changes
+		return "", errors.New("meta.info Does Not Hold Our Key - Sync A Change To Add It")
+	}
+	invite := lynxutil.Invite{LynkID: lynk.ID, Name: header.Name, Owner: lynk.Owner,
+		Trackers: []string{lynk.Tracker}, Revision: header.Revision}
+	logger.Lynk(lynkName).Info("Created Invite Valid For " + validFor.String())
+	return lynxutil.NewInvite(invite, key, validFor)
+}
+
+// Helper function which joins a lynk with an invite. meta.info is fetched from the first tracker
+// which answers and checked against the invite before we join.
+// @param string s - The invite
+// @param string root - The folder to join the lynk into - our home directory if it is empty
+// @return error - An error is produced if the invite is invalid or no tracker gave us a
+// meta.info for the invited lynk
+func joinInvite(s, root string) error {
+	invite, err := lynxutil.ParseInvite(s)
+	if err != nil {
+		return err
+	}
+	log := logger.Lynk(invite.Name)
+	log.Info("Joining With Invite From " + invite.Owner + " - Key " + invite.Fingerprint)
+
+	err = errors.New("No Tracker In Invite")
+	for _, tracker := range invite.Trackers {
+		var meta []byte
+		if meta, err = requestMeta(tracker, invite.LynkID, s); err == nil {
+			if err = verifyMeta(meta, invite); err == nil {
+				return joinFetchedMeta(meta, root)
+			}
+		}
+		log.Peer(tracker).Warn("Could Not Fetch meta.info: " + err.Error())
+	}
+	return err
+}
+
+// Helper function which asks a tracker for the meta.info of a lynk, showing it our invite. We are
+// added to the lynk's swarm while we are at it.
+// Syntax is "Meta_Request:<LynkID>:<Addr>,<Addr>\n<Invite>\n"
+// @param string tracker - The host:port of the tracker
+// @param string lynkID - The ID of the lynk
+// @param string invite - The invite
+// @return []byte - The meta.info
+// @return error - An error is produced if the tracker cannot be reached or sends nothing
+func requestMeta(tracker, lynkID, invite string) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", tracker, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	addrs := strings.Join(lynxutil.GetAddrs(config.ServerPort), ",")
+	request := "Meta_Request:" + lynkID + ":" + addrs + "\n" + strings.TrimSpace(invite) + "\n"
+	if _, err = conn.Write([]byte(request)); err != nil {
+		return nil, err
+	}
+	// The tracker closes the connection once meta.info has been sent
+	meta, err := ioutil.ReadAll(io.LimitReader(conn, lynxutil.MaxMetaSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(meta) > lynxutil.MaxMetaSize {
+		return nil, errors.New("meta.info Is Too Large")
+	} else if len(meta) == 0 {
+		return nil, errors.New("Tracker Refused The Invite Or Does Not Have The Lynk")
+	}
+	return meta, nil
+}
+
+// Helper function which checks that a meta.info is the one the owner invited us to - it must
+// hold the ID, owner and key the invite was signed with and be no older than the invite, so a
+// tracker cannot hand us another lynk or roll this one back
+// @param []byte meta - The meta.info
+// @param lynxutil.Invite invite - The invite
+// @return error - An error is produced if the meta.info is not the invited one
+func verifyMeta(meta []byte, invite lynxutil.Invite) error {
+	header, err := lynxutil.ParseMetaHeader(meta)
+	if err == nil {
+		err = lynxutil.CheckInvite(invite, header)
+	}
+	if err != nil {
+		return errors.New("meta.info Does Not Match Invite: " + err.Error())
+	}
+	return nil
+}
+
+// Helper function which returns our public identity key the way meta.info keeps it
+// @return string - The key
+// @return error - An error is produced if our identity key cannot be loaded
+func identityKey() (string, error) {
+	key, err := lynxutil.LoadIdentity(config.HomePath + lynxutil.IdentityFile)
+	if err != nil {
+		return "", err
+	}
+	return lynxutil.EncodeOwnerKey(key.Public().(ed25519.PublicKey)), nil
+}
+
+// Helper function which joins a lynk from a meta.info we fetched, the way JoinLynk joins from a
+// meta.info file
+// @param []byte meta - The meta.info
+// @param string root - The folder to join the lynk into - our home directory if it is empty
+// @return error - An error is produced if the meta.info cannot be written or the lynk joined
+func joinFetchedMeta(meta []byte, root string) error {
+	temp, err := ioutil.TempFile("", "meta.info")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(meta)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return joinMeta(temp.Name(), root)
+}
@@ -0,0 +1,117 @@
+// Package client - This file is responsible for discovering peers on our local network from the
+// announcements multicast by their servers.
+package client
+
+import (
+	"../lynxutil"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The largest announcement we will read
+const maxAnnouncement = 8192
+
+// ListenLAN - Listens for Lynk announcements multicast by other peers on our local network and
+// merges any peers which share one of our Lynks into that Lynk's peers array. This function does
+// not return unless the multicast group cannot be joined.
+// @return error - An error is produced if we cannot join the multicast group
+func ListenLAN() error {
+	group, err := net.ResolveUDPAddr("udp4", lynxutil.LANGroup)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		logger.Error("Could Not Listen For LAN Announcements: " + err.Error())
+		return err
+	}
+	defer conn.Close()
+	return serveLAN(conn)
+}
+
+// Helper function which handles the announcements read from conn until it is closed
+// @param *net.UDPConn conn - The socket joined to the multicast group
+// @return error - The error which stopped us once conn was closed
+func serveLAN(conn *net.UDPConn) error {
+	// One byte more than we accept, so announcements which are too long can be told apart
+	buf := make([]byte, maxAnnouncement+1)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		} else if err != nil {
+			continue
+		}
+		handleAnnouncement(string(buf[:n]), from.IP.String())
+	}
+}
+
+// Helper function which handles a single announcement from a peer on our local network
+// @param string announcement - The announcement - "Lynk_Announce:<ServerPort>:<InfoHash>,..."
+// @param string ip - The IP address the announcement came from
+func handleAnnouncement(announcement, ip string) {
+	if len(announcement) > maxAnnouncement {
+		return
+	}
+	// tmpArr[0] - Lynk_Announce | tmpArr[1] - <ServerPort> | tmpArr[2] - <InfoHashes>
+	tmpArr := strings.Split(strings.TrimSpace(announcement), ":")
+	if len(tmpArr) != 3 || tmpArr[0] != "Lynk_Announce" {
+		return
+	}
+
+	port := tmpArr[1]
+	if _, err := strconv.Atoi(port); err != nil {
+		return
+	}
+	if port == config.ServerPort {
+		for _, ourIP := range lynxutil.GetIPs() {
+			if ip == ourIP {
+				return // We heard our own announcement
+			}
+		}
+	}
+
+	for _, hash := range strings.Split(tmpArr[2], ",") {
+		lynks.UpdateAll(func(lynk *lynxutil.Lynk) {
+			if lynxutil.LynkInfoHash(lynk.ID).String() == hash {
+				addLANPeer(lynk, lynxutil.Peer{IP: ip, Port: port, LAN: true})
+			}
+		})
+	}
+}
+
+// Helper function which adds a LAN peer to a lynk, or marks the peer as being on our LAN if we
+// already know of it from the tracker.
+// @param *lynxutil.Lynk lynk - The lynk the peer shares with us
+// @param lynxutil.Peer peer - The peer we discovered
+func addLANPeer(lynk *lynxutil.Lynk, peer lynxutil.Peer) {
+	for i := range lynk.Peers {
+		if lynk.Peers[i].Equal(peer) {
+			lynk.Peers[i].LAN = true
+			return
+		}
+	}
+	lynk.Peers = append(lynk.Peers, peer)
+}
+
+// Helper function which orders a lynk's peers so that peers on our local network are tried first
+// @param []lynxutil.Peer peers - The peers array
+// @return []lynxutil.Peer - A copy of the peers array with LAN peers first
+func preferLAN(peers []lynxutil.Peer) []lynxutil.Peer {
+	var ordered []lynxutil.Peer
+	for _, peer := range peers {
+		if peer.LAN {
+			ordered = append(ordered, peer)
+		}
+	}
+	for _, peer := range peers {
+		if !peer.LAN {
+			ordered = append(ordered, peer)
+		}
+	}
+	return ordered
+}
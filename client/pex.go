@@ -0,0 +1,184 @@
+// Package client - This file is responsible for peer exchange (PEX) - swapping known peer lists
+// for a shared Lynk with the peers we connect to so the swarm stays connected without a tracker.
+package client
+
+import (
+	"../lynxutil"
+	"../transport"
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The maximum number of peers sent or accepted in a single exchange
+const maxPEXPeers = 50
+
+// The maximum number of peers we will hold for a single lynk
+const maxLynkPeers = 200
+
+// The maximum number of addresses we accept for a single peer
+const maxPEXAddrs = 8
+
+// Swaps peer lists for a lynk with a peer we have connected to. The request syntax is
+// "Peer_Exchange:<LynkID>\n" followed by up to maxPEXPeers peer lines and a blank line.
+// The peer replies with its own list in the same format and closes the connection.
+// @param string lynkName - The name of the lynk whose peers we are exchanging
+// @param lynxutil.Peer peer - The peer to exchange with
+// @return error - An error can be produced if we cannot connect to the peer
+func exchangePeers(lynkName string, peer lynxutil.Peer) error {
+	lynk, ok := lynks.Get(lynkName)
+	if !ok {
+		return errors.New("Lynk Not Found")
+	}
+
+	conn, err := transport.Dial(peer, lynk.Tracker)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(lynxutil.PEXTimeout))
+
+	fmt.Fprint(conn, "Peer_Exchange:"+lynk.ID+"\n")
+	WritePEXPeers(conn, PEXPeers(&lynk))
+
+	received := ReadPEXPeers(bufio.NewReader(conn))
+	lynks.Update(lynkName, func(lynk *lynxutil.Lynk) { mergePEXPeers(lynk, received) })
+	return nil
+}
+
+// HandlePeerExchange - Handles the lynk side of a peer exchange started by another peer. The
+// received peers are merged into the matching lynk and our own list is returned.
+// @param string lynkID - The ID of the lynk the peer is asking about
+// @param []lynxutil.Peer received - The peers the other side sent us
+// @return []lynxutil.Peer - The peers we should send back
+// @return error - An error is produced if we do not have the lynk
+func HandlePeerExchange(lynkID string, received []lynxutil.Peer) ([]lynxutil.Peer, error) {
+	var reply []lynxutil.Peer
+	found := false
+	lynks.UpdateAll(func(lynk *lynxutil.Lynk) {
+		if !found && lynk.ID == lynkID {
+			reply = PEXPeers(lynk)
+			mergePEXPeers(lynk, received)
+			found = true
+		}
+	})
+
+	if !found {
+		return nil, errors.New("Lynk Not Found")
+	}
+	return reply, nil
+}
+
+// PEXPeers - Returns the peers of a lynk that we are willing to share. Only peers that came from
+// the tracker, the DHT or our LAN are shared - peers we only heard about through exchange are
+// never passed on so a bad list cannot spread through the swarm.
+// @param *lynxutil.Lynk lynk - The lynk whose peers we want to share
+// @return []lynxutil.Peer - At most maxPEXPeers peers
+func PEXPeers(lynk *lynxutil.Lynk) []lynxutil.Peer {
+	var peers []lynxutil.Peer
+	for _, peer := range lynk.Peers {
+		if len(peers) == maxPEXPeers {
+			break
+		}
+		if !peer.PEX {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// WritePEXPeers - Writes a peer list - one lynxutil.FormatPeer line per peer followed by a blank
+// line
+// @param net.Conn conn - The connection to write to
+// @param []lynxutil.Peer peers - The peers to write
+func WritePEXPeers(conn net.Conn, peers []lynxutil.Peer) {
+	for _, peer := range peers {
+		fmt.Fprintln(conn, lynxutil.FormatPeer(peer))
+	}
+	fmt.Fprintln(conn)
+}
+
+// ReadPEXPeers - Reads a peer list written by WritePEXPeers. Lines which are not valid peers are
+// dropped and at most maxPEXPeers peers are returned.
+// @param *bufio.Reader reader - The reader to read from
+// @return []lynxutil.Peer - The valid peers read
+func ReadPEXPeers(reader *bufio.Reader) []lynxutil.Peer {
+	var peers []lynxutil.Peer
+	for lines := 0; lines < maxPEXPeers; lines++ {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if peer, valid := parsePEXPeer(line); valid {
+			peers = append(peers, peer)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return peers
+}
+
+// Helper function that validates and parses a single peer line from an exchange. Every address
+// on the line must be a usable IP and port or the whole peer is rejected - loopback, unspecified
+// and multicast addresses only ever point a peer at itself or nowhere.
+// @param string line - The line to parse
+// @return lynxutil.Peer - The parsed peer
+// @return bool - Whether or not the line was a valid peer
+func parsePEXPeer(line string) (lynxutil.Peer, bool) {
+	peer, err := lynxutil.ParsePeer(line)
+	if err != nil || len(peer.Addrs) != len(strings.Fields(line)) || len(peer.Addrs) > maxPEXAddrs {
+		return lynxutil.Peer{}, false
+	}
+
+	for _, addr := range peer.Addrs {
+		host, portStr, _ := net.SplitHostPort(addr)
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+			return lynxutil.Peer{}, false
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return lynxutil.Peer{}, false
+		}
+	}
+
+	peer.PEX = true
+	return peer, true
+}
+
+// Helper function which merges peers learned through exchange into a lynk's peers array. We skip
+// ourselves, peers we already know of, and stop once the lynk holds maxLynkPeers peers.
+// @param *lynxutil.Lynk lynk - The lynk to merge into
+// @param []lynxutil.Peer peers - The peers received
+func mergePEXPeers(lynk *lynxutil.Lynk, peers []lynxutil.Peer) {
+	self := lynxutil.Peer{Addrs: lynxutil.GetAddrs(config.ServerPort)}
+	for _, peer := range peers {
+		if len(lynk.Peers) >= maxLynkPeers {
+			return
+		}
+		if contains([]lynxutil.Peer{self}, peer) || contains(lynk.Peers, peer) {
+			continue
+		}
+		peer.PEX = true
+		lynk.Peers = append(lynk.Peers, peer)
+	}
+}
+
+// Helper function which drops a peer we learned through exchange once it fails to answer
+// @param *lynxutil.Lynk lynk - The lynk the peer belongs to
+// @param lynxutil.Peer peer - The peer to drop
+func dropPEXPeer(lynk *lynxutil.Lynk, peer lynxutil.Peer) {
+	for i := range lynk.Peers {
+		if lynk.Peers[i].Equal(peer) && lynk.Peers[i].PEX {
+			lynk.Peers = append(lynk.Peers[:i], lynk.Peers[i+1:]...)
+			return
+		}
+	}
+}
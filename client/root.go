@@ -0,0 +1,176 @@
+// Package client - This file holds where our Lynks are kept. A Lynk is kept in a folder named after
+// it in the home directory unless it was created from, or joined into, a folder somewhere else.
+// That folder is the Lynk's root and is kept in the database, and every path of the Lynk is built
+// from it.
+package client
+
+import (
+	"../lynxutil"
+	"../mycrypt"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LynkRoot - Returns the folder one of our lynks is kept in
+// @param string lynkName - Our name for the lynk
+// @return string - The absolute path of the folder, without a trailing slash
+func LynkRoot(lynkName string) string {
+	if lynk, ok := lynks.Get(lynkName); ok && lynk.Root != "" {
+		return lynk.Root
+	}
+	return config.HomePath + lynkName
+}
+
+// MetaPath - Returns the path of the meta.info of one of our lynks
+// @param string lynkName - Our name for the lynk
+// @return string - The path of its meta.info
+func MetaPath(lynkName string) string {
+	return LynkRoot(lynkName) + "/meta.info"
+}
+
+// CreateMetaAt - Creates a new lynk from a folder anywhere on disk. The folder stays where it is.
+// @param string name - Our name for the new lynk - the name of the folder if it is empty
+// @param string root - The absolute path of the folder - the folder named after the lynk in our
+// home directory if it is empty
+// @param bool encrypted - Whether or not the lynk is created with a key, like CreateEncryptedMeta
+// @return error - An error is produced if the name is invalid or taken, the folder does not exist
+// or overlaps another lynk, or meta.info cannot be written
+func CreateMetaAt(name, root string, encrypted bool) error {
+	var err error
+	if root != "" {
+		if root, err = checkRoot(root, false); err != nil {
+			return err
+		}
+		if name == "" {
+			name = filepath.Base(root)
+		}
+	}
+	if !validLynkName(name) {
+		return errors.New("Invalid Lynk Name: " + name)
+	} else if _, ok := lynks.Get(name); ok {
+		return errors.New("Can't Add Duplicate Lynk")
+	}
+
+	var key []byte
+	if encrypted {
+		if key, err = mycrypt.NewKey(); err != nil {
+			return err
+		}
+	}
+	return createMeta(name, root, key)
+}
+
+// JoinLynkAt - Joins a lynk like JoinLynk, but keeps its files in a folder anywhere on disk
+// @param string metaPath - The path to the lynk's meta.info file, or an invite
+// @param string root - The absolute path of the folder, which must be empty or not exist yet - the
+// lynk is joined into our home directory if it is empty
+// @return error - An error is produced if the folder is in use or the lynk cannot be joined
+func JoinLynkAt(metaPath, root string) error {
+	if root != "" {
+		var err error
+		if root, err = checkRoot(root, true); err != nil {
+			return err
+		}
+	}
+	if lynxutil.IsInvite(metaPath) {
+		return joinInvite(metaPath, root)
+	}
+	return joinMeta(metaPath, root)
+}
+
+// Folders of the system which can never hold a lynk
+var systemDirs = []string{"/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/proc", "/run",
+	"/sbin", "/sys", "/usr", "/System", "/Library", "C:/Windows", "C:/Program Files"}
+
+// Helper function which checks a folder a lynk is about to be kept in. It must not hold our home
+// directory or overlap the folder of another lynk, and it must not be hidden, be a folder of the
+// system or hold the user's home directory - so a lynk cannot share ~/.ssh or /etc.
+// @param string root - The absolute path of the folder
+// @param bool join - Whether a lynk is joined into the folder, which must then be empty or not
+// exist yet - otherwise a lynk is created from it and it must exist
+// @return string - The cleaned up path
+// @return error - An error is produced if the folder cannot hold the lynk
+func checkRoot(root string, join bool) (string, error) {
+	if !filepath.IsAbs(root) {
+		return "", errors.New("Path Must Be Absolute: " + root)
+	}
+	root = filepath.ToSlash(filepath.Clean(root))
+
+	info, err := os.Stat(root)
+	if join && err == nil {
+		if entries, _ := ioutil.ReadDir(root); !info.IsDir() || len(entries) > 0 {
+			return "", errors.New("Directory " + root + " Is Not Empty")
+		}
+	} else if join && !os.IsNotExist(err) {
+		return "", err
+	} else if !join && (err != nil || !info.IsDir()) {
+		return "", errors.New("Directory " + root + " Does Not Exist")
+	}
+
+	// A link must not lead somewhere we would turn down
+	for _, path := range []string{root, resolveLinks(root)} {
+		if err = checkSensitive(path); err != nil {
+			return "", err
+		}
+	}
+	if within(config.HomePath, root) {
+		return "", errors.New("Directory " + root + " Holds The Lynx Directory")
+	}
+	for _, lynk := range lynks.List() {
+		other := LynkRoot(lynk.Name)
+		if within(root, other) || within(other, root) {
+			return "", errors.New("Directory " + root + " Overlaps Lynk " + lynk.Name)
+		}
+	}
+	return root, nil
+}
+
+// Helper function which turns down a folder which is hidden, a folder of the system or holds the
+// user's home directory
+// @param string root - The clean absolute path of the folder
+// @return error - An error is produced if a lynk must not be kept in the folder
+func checkSensitive(root string) error {
+	for _, part := range strings.Split(root, "/") {
+		if strings.HasPrefix(part, ".") {
+			return errors.New("Directory " + root + " Is Hidden")
+		}
+	}
+	for _, dir := range systemDirs {
+		if within(strings.ToLower(root), strings.ToLower(dir)) {
+			return errors.New("Directory " + root + " Belongs To The System")
+		}
+	}
+	userHome, err := os.UserHomeDir()
+	if filepath.VolumeName(root)+"/" == root ||
+		(err == nil && within(filepath.ToSlash(userHome), root)) {
+		return errors.New("Directory " + root + " Holds The Home Directory")
+	}
+	return nil
+}
+
+// Helper function which follows the links in a path. Only the part of the path which exists
+// is followed, as a lynk may be joined into a folder which does not exist yet.
+// @param string path - The clean absolute path
+// @return string - The path with its links followed
+func resolveLinks(path string) string {
+	rest := ""
+	for dir := path; ; dir = filepath.ToSlash(filepath.Dir(dir)) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.ToSlash(filepath.Join(resolved, rest))
+		} else if dir == filepath.ToSlash(filepath.Dir(dir)) {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// Helper function which returns whether or not a path is a folder or inside it
+// @param string path - The path
+// @param string dir - The folder
+func within(path, dir string) bool {
+	path, dir = strings.TrimSuffix(path, "/"), strings.TrimSuffix(dir, "/")
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,362 @@
+// Package daemon - This file holds the protocol lynx commands use to talk to lynxd over a local
+// socket. A request is a single line "<Command>:<Arg>:<Arg>\n". The daemon answers "OK\n" or
+// "Error:<Message>\n" followed by the command's output, one tab separated row per line, and then
+// closes the connection.
+package daemon
+
+import (
+	"../client"
+	"../logs"
+	"../lynxutil"
+	"../server"
+	"../tracker"
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The number of arguments each command takes - the last argument may itself contain ":"
+var commandArgs = map[string]int{
+	"Create":      1,
+	"Create_Enc":  1,
+	"Create_At":   2,
+	"Join":        1,
+	"Join_At":     2,
+	"Leave":       1,
+	"Rename":      2,
+	"List":        0,
+	"Status":      0,
+	"Logs":        0,
+	"Files":       1,
+	"Remove_File": 2,
+	"Peers":       1,
+	"Pause":       1,
+	"Resume":      1,
+	"Select":      3,
+	"Fetch":       2,
+	"Mode":        2,
+	"Export_Key":  3,
+	"Invite":      1,
+	"Import_Key":  3,
+}
+
+// The number of log entries the Logs command shows
+const recentLogs = 50
+
+// Serve - Listens on a local socket and answers lynx commands. Only the user running the daemon
+// can connect. This function does not return unless the socket cannot be opened.
+// @param string socketPath - The path of the socket
+// @return error - An error is produced if another daemon is answering on the socket or it cannot
+// be opened
+func Serve(socketPath string) error {
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return errors.New("lynxd Is Already Running On " + socketPath)
+	}
+	os.Remove(socketPath) // Clears out a socket left behind by a daemon that did not shut down
+
+	// The socket is created owner only, so there is no moment where anyone else could connect
+	oldMask := syscall.Umask(0077)
+	welcomeSocket, err := net.Listen("unix", socketPath)
+	syscall.Umask(oldMask)
+	if err != nil {
+		logger.Error("Could Not Open Control Socket: " + err.Error())
+		return err
+	}
+	defer welcomeSocket.Close()
+
+	for {
+		conn, err := welcomeSocket.Accept()
+		if err != nil {
+			return err
+		}
+		go handleCommand(conn)
+	}
+}
+
+// Call - Sends a command to the daemon and returns its output
+// @param string socketPath - The path of the daemon's socket
+// @param string command - The command, e.g. "List" or "Remove_File"
+// @param ...string args - The command's arguments
+// @return string - The output of the command
+// @return error - An error is produced if the daemon cannot be reached or the command failed
+func Call(socketPath, command string, args ...string) (string, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return "", errors.New("Could Not Reach lynxd - Is It Running? " + err.Error())
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, strings.Join(append([]string{command}, args...), ":"))
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	status = strings.TrimSpace(status)
+	if status != "OK" {
+		return "", errors.New(strings.TrimPrefix(status, "Error:"))
+	}
+
+	output, err := ioutil.ReadAll(reader)
+	return string(output), err
+}
+
+// Handles a single command sent by lynx
+// @param net.Conn conn - The socket the command came in on
+// @return error - An error is produced if the command was invalid or failed
+func handleCommand(conn net.Conn) error {
+	defer conn.Close()
+
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	request = strings.TrimSpace(request)
+
+	command := strings.SplitN(request, ":", 2)[0]
+	numArgs, ok := commandArgs[command]
+	var args []string
+	if numArgs > 0 {
+		args = strings.SplitN(strings.TrimPrefix(request, command+":"), ":", numArgs)
+	}
+	if !ok || len(args) != numArgs || (numArgs > 0 && !strings.Contains(request, ":")) {
+		fmt.Fprintln(conn, "Error:Invalid Command "+request)
+		return errors.New("Invalid Command")
+	}
+
+	output, err := runCommand(command, args)
+	if err != nil {
+		fmt.Fprintln(conn, "Error:"+err.Error())
+		return err
+	}
+
+	fmt.Fprintln(conn, "OK")
+	for _, line := range output {
+		fmt.Fprintln(conn, line)
+	}
+	return nil
+}
+
+// Helper function which carries out a command
+// @param string command - The command
+// @param []string args - The command's arguments
+// @return []string - The lines of output
+// @return error - An error is produced if the command failed
+func runCommand(command string, args []string) ([]string, error) {
+	if command == "List" {
+		return listLynks(), nil
+	} else if command == "Status" {
+		return status(), nil
+	} else if command == "Logs" {
+		var lines []string
+		for _, entry := range logs.Recent(logs.Filter{Limit: recentLogs}) {
+			lines = append(lines, entry.String())
+		}
+		return lines, nil
+	} else if command == "Create" {
+		if err := client.CreateMeta(args[0]); err != nil {
+			return nil, err
+		}
+		tracker.CreateSwarm(args[0], client.MetaPath(args[0]))
+		return []string{"Created " + args[0]}, nil
+	} else if command == "Create_Enc" {
+		if err := client.CreateEncryptedMeta(args[0]); err != nil {
+			return nil, err
+		}
+		tracker.CreateSwarm(args[0], client.MetaPath(args[0]))
+		return []string{"Created Encrypted " + args[0]}, nil
+	} else if command == "Create_At" {
+		if err := client.CreateMetaAt(args[0], args[1], false); err != nil {
+			return nil, err
+		}
+		tracker.CreateSwarm(args[0], client.MetaPath(args[0]))
+		return []string{"Created " + args[0] + " From " + args[1]}, nil
+	} else if command == "Join" {
+		if err := client.JoinLynk(args[0]); err != nil {
+			return nil, err
+		}
+		return []string{"Joined Lynk From " + args[0]}, nil
+	} else if command == "Join_At" {
+		if err := client.JoinLynkAt(args[1], args[0]); err != nil {
+			return nil, err
+		}
+		return []string{"Joined Lynk Into " + args[0]}, nil
+	}
+
+	// The rest of the commands act on a lynk we already have, named by our name for it or its ID
+	if lynkName, ok := client.ResolveLynk(args[0]); ok {
+		args[0] = lynkName
+	}
+	lynk := lynxutil.GetLynk(client.GetLynks(), args[0])
+	if lynk == nil {
+		return nil, errors.New("Lynk " + args[0] + " Not Found")
+	}
+
+	if command == "Leave" {
+		client.DeleteLynk(lynk.Name, false)
+		return []string{"Left " + args[0] + " - its files have been kept"}, nil
+	} else if command == "Rename" {
+		if err := client.RenameLynk(lynk.Name, args[1]); err != nil {
+			return nil, err
+		}
+		return []string{"Renamed " + lynk.Name + " To " + args[1]}, nil
+	} else if command == "Files" {
+		var lines []string
+		for _, file := range lynk.Files {
+			lines = append(lines, file.LocalName()+"\t"+strconv.Itoa(file.Length)+"\t"+file.State)
+		}
+		return lines, nil
+	} else if command == "Remove_File" {
+		if err := client.RemoveFile(lynk.Name, args[1]); err != nil {
+			return nil, err
+		}
+		// Creates a new meta.info and pushes it so our peers remove the file too
+		client.CreateMeta(lynk.Name)
+		server.PushMeta(client.MetaPath(lynk.Name))
+		return []string{"Removed " + args[1] + " From " + lynk.Name}, nil
+	} else if command == "Peers" {
+		var lines []string
+		for _, peer := range lynk.Peers {
+			lines = append(lines, lynxutil.FormatPeer(peer)+"\t"+peerSource(peer))
+		}
+		return lines, nil
+	} else if command == "Pause" {
+		return []string{"Paused " + lynk.Name}, client.PauseLynk(lynk.Name)
+	} else if command == "Select" {
+		selection := lynxutil.Selection{Include: splitPatterns(args[1]),
+			Exclude: splitPatterns(args[2])}
+		if err := client.SetSelection(lynk.Name, selection); err != nil {
+			return nil, err
+		}
+		return []string{"Include\t" + strings.Join(selection.Include, " "),
+			"Exclude\t" + strings.Join(selection.Exclude, " ")}, nil
+	} else if command == "Fetch" {
+		return []string{"Fetching " + args[1]}, client.FetchFile(lynk.Name, args[1])
+	} else if command == "Mode" {
+		if err := client.SetMode(lynk.Name, args[1]); err != nil {
+			return nil, err
+		}
+		return []string{lynk.Name + " Is Now " + args[1]}, nil
+	} else if command == "Invite" {
+		invite, err := client.CreateInvite(lynk.Name, client.DefaultInviteLife)
+		if err != nil {
+			return nil, err
+		}
+		parsed, _ := lynxutil.ParseInvite(invite)
+		return []string{invite, "Fingerprint\t" + parsed.Fingerprint,
+			"Expires\t" + time.Unix(parsed.Expires, 0).Format(time.RFC1123)}, nil
+	} else if command == "Export_Key" {
+		// The key is encrypted with the public key in args[1] and written to args[2]
+		publicKey, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		key, err := client.ExportLynkKey(lynk.Name, publicKey)
+		if err == nil {
+			err = ioutil.WriteFile(args[2], key, 0600)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{"Wrote The Key Of " + lynk.Name + " To " + args[2]}, nil
+	} else if command == "Import_Key" {
+		// The key in args[1] is decrypted with the private key in args[2], which cannot have a
+		// passphrase - the API takes one
+		key, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := ioutil.ReadFile(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if err = client.ImportLynkKey(lynk.Name, key, privateKey, nil); err != nil {
+			return nil, err
+		}
+		return []string{"Imported The Key Of " + lynk.Name}, nil
+	}
+	// Resume
+	return []string{"Resumed " + lynk.Name}, client.ResumeLynk(lynk.Name)
+}
+
+// Helper function which splits a comma separated list of selection patterns - an empty list is
+// given as ""
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// Helper function which lists our lynks - name, owner, tracker, number of files, state, mode
+// and ID
+func listLynks() []string {
+	var lines []string
+	for _, lynk := range client.GetLynks() {
+		lines = append(lines, lynk.Name+"\t"+lynk.Owner+"\t"+lynk.Tracker+"\t"+
+			strconv.Itoa(len(lynk.Files))+" files\t"+lynkState(lynk)+"\t"+lynk.Synced+"\t"+lynk.Mode+
+			"\t"+lynk.ID+"\t"+client.LynkRoot(lynk.Name))
+	}
+	return lines
+}
+
+// Helper function which describes our node
+func status() []string {
+	dht := "off"
+	if config.DHT {
+		dht = "on"
+	}
+
+	downloading := 0
+	paused := 0
+	for _, lynk := range client.GetLynks() {
+		if lynk.DLing {
+			downloading++
+		}
+		if lynk.Paused {
+			paused++
+		}
+	}
+
+	return []string{
+		"Home\t" + config.HomePath,
+		"Server\t" + strings.Join(lynxutil.GetAddrs(config.ServerPort), " "),
+		"Tracker Port\t" + config.TrackerPort,
+		"DHT\t" + dht,
+		"Lynks\t" + strconv.Itoa(client.GetLynksLen()),
+		"Downloading\t" + strconv.Itoa(downloading),
+		"Paused\t" + strconv.Itoa(paused),
+	}
+}
+
+// Helper function which describes what a lynk is doing
+func lynkState(lynk lynxutil.Lynk) string {
+	if lynk.Paused {
+		return "paused"
+	} else if lynk.DLing {
+		return "downloading"
+	}
+	return "synced"
+}
+
+// Helper function which describes where we learned of a peer
+func peerSource(peer lynxutil.Peer) string {
+	if peer.LAN {
+		return "lan"
+	} else if peer.PEX {
+		return "pex"
+	}
+	return "tracker"
+}
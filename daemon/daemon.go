@@ -0,0 +1,97 @@
+// Package daemon - This package runs a Lynx node without the GUI. It starts the server, tracker
+// and peer discovery, keeps our Lynks synced and answers lynx commands on a local socket.
+package daemon
+
+import (
+	"../client"
+	"../dht"
+	"../logs"
+	"../lynxutil"
+	"../metrics"
+	"../ratelimit"
+	"../server"
+	"../tracker"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How often we look through our Lynks for files which have been added or changed
+const syncInterval = 10 * time.Second
+
+// How often our Lynks are announced in the DHT when DHT mode is on
+const dhtInterval = 5 * time.Minute
+
+// The settings of our node
+var config = lynxutil.DefaultConfig()
+
+// Writes the daemon's log entries
+var logger = logs.New(logs.Daemon)
+
+// Run - Configures every package with config, starts the node and then serves lynx commands.
+// This function does not return unless the control socket cannot be opened.
+// @param lynxutil.Config cfg - The config loaded by lynxd
+// @return error - An error is produced if the control socket cannot be opened
+func Run(cfg lynxutil.Config) error {
+	config = cfg
+	if err := logs.Configure(config); err != nil {
+		fmt.Println(err)
+	}
+	client.Configure(config)
+	server.Configure(config)
+	tracker.Configure(config)
+	ratelimit.Configure(config)
+
+	go server.Listen()
+	go tracker.Listen()
+	go server.AnnounceLAN()
+	go client.ListenLAN()
+	go server.ListenTraversal()
+	go syncLoop()
+
+	if config.DHT {
+		startDHT()
+	}
+	if config.MetricsAddr != "" {
+		go serveMetrics(config.MetricsAddr)
+	}
+
+	logger.Info("Lynx Daemon Listening On " + config.ControlSocket)
+	return Serve(config.ControlSocket)
+}
+
+// Helper function which pushes local changes every syncInterval
+func syncLoop() {
+	for {
+		server.SyncLocalChanges()
+		time.Sleep(syncInterval)
+	}
+}
+
+// Helper function which serves our metrics on /metrics for Prometheus to scrape
+// @param string addr - The host:port to listen on
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.Handler)
+	logger.Info("Serving Metrics On " + addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Error("Could Not Serve Metrics: " + err.Error())
+	}
+}
+
+// Helper function that starts our DHT node and announces our Lynks every dhtInterval
+func startDHT() {
+	node, err := dht.Listen(config.DHTPort)
+	if err != nil {
+		logger.Error("Could Not Start DHT: " + err.Error())
+		return
+	}
+
+	client.SetDHT(node)
+	go func() {
+		for {
+			client.AnnounceLynks()
+			time.Sleep(dhtInterval)
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+// The unit tests for our daemon's control socket
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 5
+
+// Unit tests for sending commands to the daemon over its socket.
+// @param *testing.T t - The wrapper for the test
+func TestCall(t *testing.T) {
+	fmt.Println("\n----------------TestCall----------------")
+
+	dir, _ := ioutil.TempDir("", "lynxd")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "lynxd.sock")
+	go Serve(socket)
+	time.Sleep(100 * time.Millisecond) // Gives the socket time to open
+
+	_, err := Call(socket, "Status")
+	if err != nil {
+		t.Error("Test failed, expected no errors. Got ", err)
+	} else {
+		fmt.Println("Successfully Got Daemon Status")
+		successful++
+	}
+
+	_, err = Call(socket, "Files", "Not A Real Lynk")
+	if err == nil {
+		t.Error("Test failed, expected an error for a Lynk we do not have")
+	} else {
+		fmt.Println("Successfully Got Error For Unknown Lynk")
+		successful++
+	}
+
+	_, err = Call(socket, "Explode")
+	if err == nil {
+		t.Error("Test failed, expected an error for an unknown command")
+	} else {
+		fmt.Println("Successfully Got Error For Unknown Command")
+		successful++
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil || info.Mode().Perm()&0077 != 0 {
+		t.Error("Test failed, expected a socket only we can use. Got ", info, err)
+	} else {
+		fmt.Println("Successfully Opened Owner Only Socket")
+		successful++
+	}
+
+	// A second daemon must not take the socket from the one answering on it
+	if err = Serve(socket); err == nil {
+		t.Error("Test failed, expected an error for a daemon already running")
+	} else if _, err = Call(socket, "Status"); err != nil {
+		t.Error("Test failed, expected the first daemon to still answer. Got ", err)
+	} else {
+		fmt.Println("Successfully Refused To Replace Running Daemon")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -0,0 +1,702 @@
+// Package dht - This package is a small Kademlia style distributed hash table which lets Lynx
+// peers announce and look up Lynks without having to reach the Lynk's tracker.
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// K - The maximum number of contacts held in a single bucket and returned by a lookup
+const K = 8
+
+// The number of nodes we query in parallel during an iterative lookup
+const alpha = 3
+
+// The length of a NodeID in bytes (160 bits - the size of a sha1 hash)
+const idLength = 20
+
+// How long an announced peer is remembered before it has to announce again
+const peerTTL = 30 * time.Minute
+
+// How long we wait on a reply before giving up on a node
+const rpcTimeout = 2 * time.Second
+
+// The largest UDP packet we will read
+const maxPacket = 8192
+
+// The most peers we remember for a single infohash - the oldest is forgotten to make room
+const maxPeersPerHash = 64
+
+// The most peers we remember across every infohash - further announcements are refused
+const maxStoredPeers = 16384
+
+// NodeID - A 160 bit identifier used for both nodes and the infohashes of Lynks
+type NodeID [idLength]byte
+
+// Contact - A struct which represents another node in the DHT
+type Contact struct {
+	ID   NodeID
+	Addr string
+}
+
+// A single message sent between two nodes. On the wire this is
+// "<Kind>:<TxID>:<SenderID>:<Args>\n"
+type message struct {
+	kind   string
+	txid   string
+	sender NodeID
+	args   string
+	from   *net.UDPAddr
+}
+
+// Node - A struct which represents our own node in the DHT
+type Node struct {
+	ID      NodeID
+	conn    *net.UDPConn
+	table   *routingTable
+	mu      sync.Mutex
+	pending map[string]pendingCall
+	peers   map[NodeID]map[string]time.Time // infohash -> peer address -> time announced
+	stored  int                             // number of peers held across every infohash
+	closed  chan struct{}
+}
+
+// A call waiting on its reply - only a reply from the node we asked is handed to it
+type pendingCall struct {
+	addr  *net.UDPAddr
+	reply chan message
+}
+
+// NewNodeID - Generates a new random NodeID
+// @return NodeID - The random id
+func NewNodeID() NodeID {
+	var id NodeID
+	rand.Read(id[:])
+	return id
+}
+
+// HashKey - Hashes an arbitrary key (such as a Lynk's name and owner) into a NodeID
+// @param string key - The key to hash
+// @return NodeID - The sha1 hash of the key
+func HashKey(key string) NodeID {
+	return NodeID(sha1.Sum([]byte(key)))
+}
+
+// InfoHash - Derives the infohash of a Lynk from the values stored in its meta.info file.
+// @param string lynkName - The lynkName value from meta.info
+// @param string owner - The owner value from meta.info
+// @return NodeID - The infohash peers announce the Lynk under
+func InfoHash(lynkName, owner string) NodeID {
+	return HashKey("lynk:::" + lynkName + ":::" + owner)
+}
+
+// ParseNodeID - Parses a hex encoded NodeID
+// @param string s - The hex string
+// @return NodeID - The parsed id
+// @return error - An error is produced if the string is not a valid hex NodeID
+func ParseNodeID(s string) (NodeID, error) {
+	var id NodeID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != idLength {
+		return id, errors.New("Invalid Node ID")
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// String - Returns the hex representation of a NodeID
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Returns the XOR distance between two ids
+func (id NodeID) xor(other NodeID) NodeID {
+	var d NodeID
+	for i := range id {
+		d[i] = id[i] ^ other[i]
+	}
+	return d
+}
+
+// Returns the number of leading zero bits in an id - used to pick a bucket
+func (id NodeID) prefixLen() int {
+	for i, b := range id {
+		for j := 0; j < 8; j++ {
+			if (b>>uint(7-j))&1 != 0 {
+				return i*8 + j
+			}
+		}
+	}
+	return idLength*8 - 1
+}
+
+// The routing table of a node - one bucket of up to K contacts per bit of the id
+type routingTable struct {
+	self    NodeID
+	mu      sync.Mutex
+	buckets [idLength * 8][]Contact
+}
+
+// Adds or refreshes a contact in the table. Full buckets keep their older contacts since
+// long lived nodes are the most likely to stay online.
+// @param Contact c - The contact we have just heard from
+func (rt *routingTable) update(c Contact) {
+	if c.ID == rt.self {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	index := rt.self.xor(c.ID).prefixLen()
+	bucket := rt.buckets[index]
+	for i := range bucket {
+		if bucket[i].ID == c.ID {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			rt.buckets[index] = append(bucket, c) // Move to the tail as most recently seen
+			return
+		}
+	}
+	if len(bucket) < K {
+		rt.buckets[index] = append(bucket, c)
+	}
+}
+
+// Removes a contact from the table - used when a node stops answering
+// @param string addr - The address of the contact to remove
+func (rt *routingTable) remove(addr string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for index, bucket := range rt.buckets {
+		for i := range bucket {
+			if bucket[i].Addr == addr {
+				rt.buckets[index] = append(bucket[:i], bucket[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Returns up to n contacts ordered by their distance to target
+// @param NodeID target - The id we are looking for
+// @param int n - The maximum number of contacts to return
+func (rt *routingTable) closest(target NodeID, n int) []Contact {
+	rt.mu.Lock()
+	var all []Contact
+	for _, bucket := range rt.buckets {
+		all = append(all, bucket...)
+	}
+	rt.mu.Unlock()
+
+	sortByDistance(all, target)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// Returns how many contacts are in the table
+func (rt *routingTable) size() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	total := 0
+	for _, bucket := range rt.buckets {
+		total += len(bucket)
+	}
+	return total
+}
+
+// Helper function that sorts contacts by their XOR distance to target
+func sortByDistance(contacts []Contact, target NodeID) {
+	sort.Slice(contacts, func(i, j int) bool {
+		di := contacts[i].ID.xor(target)
+		dj := contacts[j].ID.xor(target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+}
+
+// Listen - Creates a new DHT node listening for UDP packets on the given port
+// @param string port - The port to listen on
+// @return *Node - The running node
+// @return error - An error is produced if the socket cannot be created
+func Listen(port string) (*Node, error) {
+	return ListenAddr(":" + port)
+}
+
+// ListenAddr - Creates a new DHT node listening for UDP packets on the given address
+// @param string addr - The host:port to listen on - e.g. "127.0.0.1:0"
+// @return *Node - The running node
+// @return error - An error is produced if the socket cannot be created
+func ListenAddr(addr string) (*Node, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	id := NewNodeID()
+	n := &Node{
+		ID:      id,
+		conn:    conn,
+		table:   &routingTable{self: id},
+		pending: make(map[string]pendingCall),
+		peers:   make(map[NodeID]map[string]time.Time),
+		closed:  make(chan struct{}),
+	}
+	go n.readLoop()
+	return n, nil
+}
+
+// Addr - Returns the local address of our node
+func (n *Node) Addr() string {
+	return n.conn.LocalAddr().String()
+}
+
+// Size - Returns the number of contacts in our routing table
+func (n *Node) Size() int {
+	return n.table.size()
+}
+
+// Close - Stops our node from listening
+func (n *Node) Close() error {
+	select {
+	case <-n.closed:
+		return nil
+	default:
+		close(n.closed)
+	}
+	return n.conn.Close()
+}
+
+// Bootstrap - Joins the DHT by pinging the passed in nodes and then looking up our own id so the
+// nodes closest to us learn about us.
+// @param []string addrs - The addresses of nodes we already know about
+// @return error - An error is produced if none of the nodes replied
+func (n *Node) Bootstrap(addrs []string) error {
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			n.call(addr, "Ping", "")
+		}(addr)
+	}
+	wg.Wait()
+
+	if n.table.size() == 0 {
+		return errors.New("Could Not Reach Any DHT Nodes")
+	}
+	n.lookup(n.ID, false)
+	return nil
+}
+
+// Announce - Tells the nodes closest to infohash that we serve the Lynk on the given port
+// @param NodeID infohash - The infohash of the Lynk
+// @param string port - The port our Lynx server listens on
+// @return error - An error is produced if no node accepted the announcement
+func (n *Node) Announce(infohash NodeID, port string) error {
+	contacts, _ := n.lookup(infohash, false)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for _, c := range contacts {
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+			if _, err := n.call(c.Addr, "Announce", infohash.String()+" "+port); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if accepted == 0 {
+		return errors.New("No DHT Nodes Accepted The Announcement")
+	}
+	return nil
+}
+
+// GetPeers - Looks up the peers that have announced the given infohash
+// @param NodeID infohash - The infohash of the Lynk
+// @return []string - The host:port addresses of the peers found
+// @return error - An error is produced if no peers could be found
+func (n *Node) GetPeers(infohash NodeID) ([]string, error) {
+	_, found := n.lookup(infohash, true)
+
+	// Include anything that was announced directly to us
+	seen := make(map[string]bool)
+	var peers []string
+	for _, p := range append(n.storedPeers(infohash), found...) {
+		if !seen[p] {
+			seen[p] = true
+			peers = append(peers, p)
+		}
+	}
+
+	if len(peers) == 0 {
+		return nil, errors.New("No Peers Found In DHT")
+	}
+	return peers, nil
+}
+
+// Performs an iterative lookup of target. When wantPeers is true Get_Peers requests are sent and
+// any peers returned are collected, otherwise Find_Node requests are sent.
+// @param NodeID target - The id we are looking for
+// @param bool wantPeers - Whether or not we are looking for peers of an infohash
+// @return []Contact - The K closest contacts that answered
+// @return []string - The peers found for target
+func (n *Node) lookup(target NodeID, wantPeers bool) ([]Contact, []string) {
+	kind := "Find_Node"
+	if wantPeers {
+		kind = "Get_Peers"
+	}
+
+	shortlist := n.table.closest(target, K)
+	queried := make(map[string]bool)
+	answered := make(map[string]bool)
+	seenPeers := make(map[string]bool)
+	var peers []string
+	var mu sync.Mutex
+
+	for {
+		// Picks the closest nodes we have not asked yet
+		var batch []Contact
+		for _, c := range shortlist {
+			if len(batch) == alpha {
+				break
+			}
+			if !queried[c.Addr] {
+				queried[c.Addr] = true
+				batch = append(batch, c)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Add(1)
+			go func(c Contact) {
+				defer wg.Done()
+				reply, err := n.call(c.Addr, kind, target.String())
+				if err != nil {
+					return
+				}
+				replyPeers, contacts := parseResults(reply.args)
+
+				mu.Lock()
+				defer mu.Unlock()
+				answered[c.Addr] = true
+				for _, p := range replyPeers {
+					if !seenPeers[p] {
+						seenPeers[p] = true
+						peers = append(peers, p)
+					}
+				}
+				for _, nc := range contacts {
+					if nc.ID == n.ID || containsContact(shortlist, nc) {
+						continue
+					}
+					shortlist = append(shortlist, nc)
+				}
+			}(c)
+		}
+		wg.Wait()
+
+		sortByDistance(shortlist, target)
+		if len(shortlist) > K*2 {
+			shortlist = shortlist[:K*2]
+		}
+	}
+
+	var result []Contact
+	for _, c := range shortlist {
+		if answered[c.Addr] && len(result) < K {
+			result = append(result, c)
+		}
+	}
+	return result, peers
+}
+
+// Sends a request to addr and waits for the reply
+// @param string addr - The address of the node
+// @param string kind - The type of request
+// @param string args - The arguments of the request
+// @return message - The reply
+// @return error - An error is produced if the node did not reply in time
+func (n *Node) call(addr, kind, args string) (message, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return message{}, err
+	}
+
+	txid := newTxID()
+	replyChan := make(chan message, 1)
+	n.mu.Lock()
+	n.pending[txid] = pendingCall{addr: udpAddr, reply: replyChan}
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, txid)
+		n.mu.Unlock()
+	}()
+
+	if err = n.send(udpAddr, kind, txid, args); err != nil {
+		return message{}, err
+	}
+
+	select {
+	case reply := <-replyChan:
+		return reply, nil
+	case <-time.After(rpcTimeout):
+		n.table.remove(addr)
+		return message{}, errors.New("DHT Node " + addr + " Did Not Reply")
+	case <-n.closed:
+		return message{}, errors.New("DHT Node Closed")
+	}
+}
+
+// Writes a single message to addr
+func (n *Node) send(addr *net.UDPAddr, kind, txid, args string) error {
+	_, err := n.conn.WriteToUDP([]byte(kind+":"+txid+":"+n.ID.String()+":"+args+"\n"), addr)
+	return err
+}
+
+// Reads packets until the node is closed, handing replies to their waiting calls and answering
+// requests.
+func (n *Node) readLoop() {
+	buf := make([]byte, maxPacket)
+	for {
+		length, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.closed:
+				return
+			default:
+				continue
+			}
+		}
+
+		msg, err := parseMessage(string(buf[:length]))
+		if err != nil {
+			continue // Ignore anything we cannot understand
+		}
+		msg.from = from
+		n.table.update(Contact{ID: msg.sender, Addr: from.String()})
+
+		switch msg.kind {
+		case "Pong", "Nodes", "Peers", "Announced":
+			n.mu.Lock()
+			pending, ok := n.pending[msg.txid]
+			n.mu.Unlock()
+			if !ok || !pending.addr.IP.Equal(from.IP) || pending.addr.Port != from.Port {
+				continue // A reply to nothing we asked, or from a node we did not ask
+			}
+			select {
+			case pending.reply <- msg:
+			default: // Already answered - a duplicate must not block the only reader
+			}
+		default:
+			n.handleRequest(msg)
+		}
+	}
+}
+
+// Answers a request sent by another node
+// @param message msg - The request
+func (n *Node) handleRequest(msg message) {
+	switch msg.kind {
+	case "Ping":
+		n.send(msg.from, "Pong", msg.txid, "")
+	case "Find_Node":
+		target, err := ParseNodeID(msg.args)
+		if err != nil {
+			return
+		}
+		n.send(msg.from, "Nodes", msg.txid, " "+formatContacts(n.table.closest(target, K)))
+	case "Get_Peers":
+		target, err := ParseNodeID(msg.args)
+		if err != nil {
+			return
+		}
+		n.send(msg.from, "Peers", msg.txid, strings.Join(n.storedPeers(target), ",")+" "+
+			formatContacts(n.table.closest(target, K)))
+	case "Announce":
+		// Args syntax is "<infohash> <port>" - we use the address the packet came from so a
+		// node can only announce itself
+		split := strings.Split(msg.args, " ")
+		if len(split) != 2 {
+			return
+		}
+		infohash, err := ParseNodeID(split[0])
+		if err != nil {
+			return
+		}
+		port, err := strconv.Atoi(split[1])
+		if err != nil || port < 1 || port > 65535 {
+			return
+		}
+		peer := net.JoinHostPort(msg.from.IP.String(), strconv.Itoa(port))
+		if n.storePeer(infohash, peer) {
+			n.send(msg.from, "Announced", msg.txid, "")
+		}
+	}
+}
+
+// Remembers that peer has announced infohash. A full infohash forgets its oldest peer, but once
+// we hold maxStoredPeers in total new announcements are refused until some expire.
+// @param NodeID infohash - The infohash that was announced
+// @param string peer - The host:port of the announcing peer
+// @return bool - Whether or not the peer was stored
+func (n *Node) storePeer(infohash NodeID, peer string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	stored := n.peers[infohash]
+	if _, ok := stored[peer]; ok {
+		stored[peer] = time.Now()
+		return true
+	}
+	if n.stored >= maxStoredPeers {
+		n.expirePeers()
+		if n.stored >= maxStoredPeers {
+			return false
+		}
+	}
+	if stored == nil {
+		stored = make(map[string]time.Time)
+		n.peers[infohash] = stored
+	}
+	if len(stored) >= maxPeersPerHash {
+		oldest := ""
+		for p, announced := range stored {
+			if oldest == "" || announced.Before(stored[oldest]) {
+				oldest = p
+			}
+		}
+		delete(stored, oldest)
+		n.stored--
+	}
+	stored[peer] = time.Now()
+	n.stored++
+	return true
+}
+
+// Forgets every stored peer whose announcement has expired. n.mu must be held.
+func (n *Node) expirePeers() {
+	for infohash, stored := range n.peers {
+		for peer, announced := range stored {
+			if time.Since(announced) > peerTTL {
+				delete(stored, peer)
+				n.stored--
+			}
+		}
+		if len(stored) == 0 {
+			delete(n.peers, infohash)
+		}
+	}
+}
+
+// Returns the peers that have announced infohash to us, dropping any that have expired
+func (n *Node) storedPeers(infohash NodeID) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var peers []string
+	for peer, announced := range n.peers[infohash] {
+		if time.Since(announced) > peerTTL {
+			delete(n.peers[infohash], peer)
+			n.stored--
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// Parses a raw packet into a message
+func parseMessage(raw string) (message, error) {
+	// tmpArr[0] - Kind | tmpArr[1] - TxID | tmpArr[2] - SenderID | tmpArr[3] - Args
+	tmpArr := strings.SplitN(strings.TrimSpace(raw), ":", 4)
+	if len(tmpArr) != 4 {
+		return message{}, errors.New("Invalid Message Syntax")
+	}
+	sender, err := ParseNodeID(tmpArr[2])
+	if err != nil {
+		return message{}, err
+	}
+	return message{kind: tmpArr[0], txid: tmpArr[1], sender: sender, args: tmpArr[3]}, nil
+}
+
+// Formats contacts as "<id>@<addr>,<id>@<addr>"
+func formatContacts(contacts []Contact) string {
+	var entries []string
+	for _, c := range contacts {
+		entries = append(entries, c.ID.String()+"@"+c.Addr)
+	}
+	return strings.Join(entries, ",")
+}
+
+// Parses the arguments of a Nodes or Peers reply - "<peer>,<peer> <id>@<addr>,<id>@<addr>"
+func parseResults(args string) ([]string, []Contact) {
+	var peers []string
+	var contacts []Contact
+
+	split := strings.SplitN(args, " ", 2)
+	for _, p := range strings.Split(split[0], ",") {
+		if _, _, err := net.SplitHostPort(p); err == nil {
+			peers = append(peers, p)
+		}
+	}
+	if len(split) == 2 {
+		for _, entry := range strings.Split(split[1], ",") {
+			pair := strings.SplitN(entry, "@", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			id, err := ParseNodeID(pair[0])
+			if err != nil {
+				continue
+			}
+			contacts = append(contacts, Contact{ID: id, Addr: pair[1]})
+		}
+	}
+	return peers, contacts
+}
+
+// Simple helper method that checks a contacts array for a specific contact.
+func containsContact(contacts []Contact, c Contact) bool {
+	for _, a := range contacts {
+		if a.ID == c.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Generates a random transaction id used to match replies to requests
+func newTxID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,198 @@
+// The unit tests for our dht
+package dht
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 10
+
+// The number of in-process nodes we create on loopback
+const numNodes = 30
+
+// Unit tests for bootstrapping, announcing and getting peers across many nodes on loopback.
+// @param *testing.T t - The wrapper for the test
+func TestAnnounceGetPeers(t *testing.T) {
+	fmt.Println("\n----------------TestBootstrap----------------")
+
+	var nodes []*Node
+	for i := 0; i < numNodes; i++ {
+		n, err := ListenAddr("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Close()
+
+		// Every node bootstraps off of the first node
+		if i > 0 {
+			if err = n.Bootstrap([]string{nodes[0].Addr()}); err != nil {
+				t.Error("Test failed, expected no errors. Got ", err)
+			}
+		}
+		nodes = append(nodes, n)
+	}
+
+	if nodes[numNodes-1].Size() == 0 {
+		t.Error("Test failed, expected last node to know other nodes")
+	} else {
+		fmt.Println("Successfully Bootstrapped", numNodes, "Nodes")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestAnnounce----------------")
+
+	infohash := InfoHash("Tests", "Test Owner")
+	err := nodes[5].Announce(infohash, "8080")
+
+	if err != nil {
+		t.Error("Test failed, expected no errors. Got ", err)
+	} else {
+		fmt.Println("Successfully Announced Lynk")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestGetPeers----------------")
+
+	peers, err := nodes[numNodes-1].GetPeers(infohash)
+
+	if err != nil || len(peers) != 1 || peers[0] != "127.0.0.1:8080" {
+		t.Error("Test failed, expected to get peer 127.0.0.1:8080. Got ", peers, err)
+	} else {
+		fmt.Println("Successfully Got Announced Peer")
+		successful++
+	}
+
+	_, err = nodes[numNodes-1].GetPeers(InfoHash("NotALynk", "Nobody"))
+
+	if err == nil {
+		t.Error("Test failed, expected no peers for an unknown Lynk.")
+	} else {
+		fmt.Println("Successfully Produced No Peers Error")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestBootstrapUnreachable----------------")
+
+	lonely, _ := ListenAddr("127.0.0.1:0")
+	defer lonely.Close()
+	err = lonely.Bootstrap([]string{"127.0.0.1:1"})
+
+	if err == nil {
+		t.Error("Test failed, expected failure bootstrapping off of a dead node.")
+	} else {
+		fmt.Println("Successfully Produced Unreachable Error")
+		successful++
+	}
+}
+
+// Unit tests for replies - spoofed replies are ignored and duplicates must not stall the node.
+// @param *testing.T t - The wrapper for the test
+func TestReplies(t *testing.T) {
+	fmt.Println("\n----------------TestReplies----------------")
+
+	n, _ := ListenAddr("127.0.0.1:0")
+	defer n.Close()
+	queried, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer queried.Close()
+	spoofer, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer spoofer.Close()
+
+	replies := make(chan message, 1)
+	go func() {
+		reply, _ := n.call(queried.LocalAddr().String(), "Ping", "")
+		replies <- reply
+	}()
+
+	// Answer the Ping from the wrong address first, then three times from the right one
+	queried.SetReadDeadline(time.Now().Add(rpcTimeout))
+	request, err := bufio.NewReader(queried).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid := strings.Split(request, ":")[1]
+	to, _ := net.ResolveUDPAddr("udp", n.Addr())
+	pong := []byte("Pong:" + txid + ":" + NewNodeID().String() + ":\n")
+	spoofer.WriteToUDP(pong, to)
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		queried.WriteToUDP(pong, to)
+	}
+
+	reply := <-replies
+	if reply.from == nil || reply.from.Port != queried.LocalAddr().(*net.UDPAddr).Port {
+		t.Error("Test failed, expected the reply of the queried node. Got ", reply.from)
+	} else {
+		fmt.Println("Successfully Ignored Spoofed Reply")
+		successful++
+	}
+
+	// The node must still be reading - it answers a new Ping
+	other, _ := ListenAddr("127.0.0.1:0")
+	defer other.Close()
+	_, err = other.call(n.Addr(), "Ping", "")
+
+	if err != nil {
+		t.Error("Test failed, expected the node to still answer after duplicate replies. Got ", err)
+	} else {
+		fmt.Println("Successfully Survived Duplicate Replies")
+		successful++
+	}
+}
+
+// Unit tests for the limits on the peers a node stores for other nodes.
+// @param *testing.T t - The wrapper for the test
+func TestStoredPeers(t *testing.T) {
+	fmt.Println("\n----------------TestStoredPeers----------------")
+
+	n, _ := ListenAddr("127.0.0.1:0")
+	defer n.Close()
+
+	infohash := InfoHash("Tests", "Test Owner")
+	for i := 0; i < maxPeersPerHash+10; i++ {
+		n.storePeer(infohash, "10.0.0."+strconv.Itoa(i%250)+":"+strconv.Itoa(1000+i))
+	}
+
+	if len(n.storedPeers(infohash)) != maxPeersPerHash {
+		t.Error("Test failed, expected", maxPeersPerHash, "peers. Got ", len(n.storedPeers(infohash)))
+	} else {
+		fmt.Println("Successfully Capped Peers Of One Infohash")
+		successful++
+	}
+
+	for i := 0; n.stored < maxStoredPeers; i++ {
+		n.storePeer(HashKey(strconv.Itoa(i)), "10.0.0.1:8080")
+	}
+
+	if n.storePeer(HashKey("One Too Many"), "10.0.0.1:8080") {
+		t.Error("Test failed, expected a full node to refuse the announcement.")
+	} else {
+		fmt.Println("Successfully Refused Announcement To A Full Node")
+		successful++
+	}
+
+	fresh, _ := ListenAddr("127.0.0.1:0")
+	defer fresh.Close()
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	fresh.handleRequest(message{kind: "Announce", args: infohash.String() + " 70000", from: from})
+	fresh.handleRequest(message{kind: "Announce", args: infohash.String() + " http", from: from})
+	fresh.handleRequest(message{kind: "Announce", args: infohash.String() + " 8080", from: from})
+
+	if peers := fresh.storedPeers(infohash); len(peers) != 1 || peers[0] != "127.0.0.1:8080" {
+		t.Error("Test failed, expected only the valid port to be stored. Got ", peers)
+	} else {
+		fmt.Println("Successfully Refused Invalid Ports")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
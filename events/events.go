@@ -0,0 +1,327 @@
+// Package events - This package is the event bus the client and server publish transfer progress
+// to. Anyone can subscribe to the bus - the GUI's uploads and downloads pages do so through the
+// Server-Sent Events stream served by ServeSSE.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The kinds of event a transfer publishes
+const (
+	Started   = "started"
+	Progress  = "progress"
+	Finished  = "finished"
+	Failed    = "failed"
+	Paused    = "paused"
+	Cancelled = "cancelled"
+)
+
+// The directions of a transfer
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// The least time between two progress events of the same transfer
+const progressInterval = 250 * time.Millisecond
+
+// How many events a subscriber can fall behind before progress events are dropped for it, or it
+// is cut off if any other event would be lost
+const subscriberBuffer = 64
+
+// How often an idle event stream sends a comment to keep the connection open
+const heartbeatInterval = 15 * time.Second
+
+// Event - A single update about a transfer
+type Event struct {
+	Kind      string    `json:"kind"`
+	Direction string    `json:"direction"`
+	ID        string    `json:"id"`
+	Lynk      string    `json:"lynk"`
+	File      string    `json:"file"`
+	Peer      string    `json:"peer"`
+	Bytes     int64     `json:"bytes"`
+	Total     int64     `json:"total"` // -1 if the size of the transfer is not known
+	Rate      float64   `json:"rate"`  // Bytes per second since the transfer started
+	ETA       float64   `json:"eta"`   // Seconds left, -1 if it cannot be estimated
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Transfer - A transfer in progress. Its methods publish its events to the bus.
+type Transfer struct {
+	mu          sync.Mutex
+	event       Event
+	started     time.Time
+	lastPublish time.Time
+	done        bool
+}
+
+// subscriber - A channel subscribed to the bus, which is closed once
+type subscriber struct {
+	ch   chan Event
+	once sync.Once
+}
+
+// Guards everything below
+var mu sync.Mutex
+
+// Every subscriber to the bus, by its channel
+var subscribers = make(map[chan Event]*subscriber)
+
+// Every transfer which has not finished or failed, by ID
+var active = make(map[string]*Transfer)
+
+// Subscribe - Subscribes to every event published from now on. A subscriber that falls more than
+// subscriberBuffer events behind misses progress events rather than slowing transfers down. If it
+// would miss any other event its channel is closed instead, so it never believes a transfer is
+// still running - it can subscribe again and start over from Active.
+// @return <-chan Event - The events
+// @return func() - Unsubscribes and closes the channel
+func Subscribe() (<-chan Event, func()) {
+	s := &subscriber{ch: make(chan Event, subscriberBuffer)}
+	mu.Lock()
+	subscribers[s.ch] = s
+	mu.Unlock()
+
+	return s.ch, func() {
+		mu.Lock()
+		delete(subscribers, s.ch)
+		mu.Unlock()
+		s.close()
+	}
+}
+
+// Publish - Sends an event to every subscriber, cutting off those too far behind to take an event
+// other than progress
+// @param Event e - The event to send
+func Publish(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	for ch, s := range subscribers {
+		select {
+		case ch <- e:
+		default: // The subscriber is too far behind
+			if e.Kind != Progress {
+				delete(subscribers, ch)
+				s.close()
+			}
+		}
+	}
+}
+
+// Helper function which closes a subscriber's channel, once however often it is called
+func (s *subscriber) close() {
+	s.once.Do(func() { close(s.ch) })
+}
+
+// Active - Returns the latest state of every transfer still in progress
+// @return []Event - One event per transfer
+func Active() []Event {
+	mu.Lock()
+	transfers := make([]*Transfer, 0, len(active))
+	for _, t := range active {
+		transfers = append(transfers, t)
+	}
+	mu.Unlock()
+
+	var states []Event
+	for _, t := range transfers {
+		t.mu.Lock()
+		states = append(states, t.event)
+		t.mu.Unlock()
+	}
+	return states
+}
+
+// Start - Starts tracking a transfer and publishes its started event. A paused job that is resumed
+// starts a new transfer with the same ID, so the transfer carries on where it left off in the GUI.
+// @param string id - The ID of the job the transfer belongs to
+// @param string direction - Download or Upload
+// @param string lynk - The name of the Lynk the file belongs to
+// @param string file - The name of the file
+// @param string peer - The address of the other side
+// @param int64 total - The number of bytes to transfer, or -1 if not known
+// @return *Transfer - The transfer
+func Start(id, direction, lynk, file, peer string, total int64) *Transfer {
+	mu.Lock()
+	t := &Transfer{
+		event: Event{Direction: direction, ID: id, Lynk: lynk, File: file, Peer: peer,
+			Total: total, ETA: -1},
+		started: time.Now(),
+	}
+	active[id] = t
+	mu.Unlock()
+
+	t.publish(Started)
+	return t
+}
+
+// Add - Records n more bytes transferred. A progress event is published at most every
+// progressInterval.
+// @param int n - The number of bytes
+func (t *Transfer) Add(n int) {
+	t.mu.Lock()
+	t.event.Bytes += int64(n)
+	due := time.Since(t.lastPublish) >= progressInterval
+	t.mu.Unlock()
+
+	if due {
+		t.publish(Progress)
+	}
+}
+
+// Finish - Publishes the transfer's finished event and stops tracking it
+func (t *Transfer) Finish() {
+	t.end(Finished, "")
+}
+
+// Fail - Publishes the transfer's failed event and stops tracking it
+// @param error err - Why the transfer failed
+func (t *Transfer) Fail(err error) {
+	msg := "Transfer Failed"
+	if err != nil {
+		msg = err.Error()
+	}
+	t.end(Failed, msg)
+}
+
+// Pause - Publishes the transfer's paused event. The transfer is still tracked and carries on
+// publishing progress once its job is resumed.
+func (t *Transfer) Pause() {
+	t.publish(Paused)
+}
+
+// End - Publishes an event of kind - Paused or Cancelled - and stops tracking the transfer. Used
+// when a transfer is stopped on purpose rather than failing.
+// @param string kind - The kind of event to publish
+func (t *Transfer) End(kind string) {
+	t.end(kind, "")
+}
+
+// Reader - Wraps r so every byte read from it counts towards the transfer
+func (t *Transfer) Reader(r io.Reader) io.Reader {
+	return &counter{t: t, r: r}
+}
+
+// Writer - Wraps w so every byte written to it counts towards the transfer
+func (t *Transfer) Writer(w io.Writer) io.Writer {
+	return &counter{t: t, w: w}
+}
+
+// Helper function which ends a transfer once
+func (t *Transfer) end(kind, msg string) {
+	t.mu.Lock()
+	if t.done {
+		t.mu.Unlock()
+		return
+	}
+	t.done = true
+	t.event.Error = msg
+	t.mu.Unlock()
+
+	mu.Lock()
+	delete(active, t.event.ID)
+	mu.Unlock()
+	t.publish(kind)
+}
+
+// Helper function which works out the rate and ETA of a transfer and publishes an event of kind
+func (t *Transfer) publish(kind string) {
+	t.mu.Lock()
+	now := time.Now()
+	t.lastPublish = now
+	e := t.event
+	t.mu.Unlock()
+
+	e.Kind = kind
+	e.Time = now
+	if elapsed := now.Sub(t.started).Seconds(); elapsed > 0 {
+		e.Rate = float64(e.Bytes) / elapsed
+	}
+	if kind == Finished {
+		e.ETA = 0
+	} else if e.Total >= 0 && e.Rate > 0 {
+		e.ETA = float64(e.Total-e.Bytes) / e.Rate
+	}
+
+	t.mu.Lock()
+	t.event.Kind, t.event.Rate, t.event.ETA = kind, e.Rate, e.ETA
+	t.mu.Unlock()
+	Publish(e)
+}
+
+// counter - An io.Reader or io.Writer which counts the bytes passing through it
+type counter struct {
+	t *Transfer
+	r io.Reader
+	w io.Writer
+}
+
+// Read - Reads from the wrapped reader and counts the bytes read
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.t.Add(n)
+	return n, err
+}
+
+// Write - Writes to the wrapped writer and counts the bytes written
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.t.Add(n)
+	return n, err
+}
+
+// ServeSSE - Streams events as Server-Sent Events. The state of every active transfer is sent
+// first so a page that has just loaded shows transfers that were already running.
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+func ServeSSE(rw http.ResponseWriter, req *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "Streaming Not Supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := Subscribe()
+	defer unsubscribe()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	for _, e := range Active() {
+		writeSSE(rw, e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return // We fell behind - the page reconnects and starts over from Active
+			}
+			writeSSE(rw, e)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(rw, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// Helper function which writes a single event in the Server-Sent Events format
+func writeSSE(w io.Writer, e Event) {
+	data, _ := json.Marshal(e)
+	fmt.Fprint(w, "event: "+e.Kind+"\ndata: "+string(data)+"\n\n")
+}
@@ -0,0 +1,97 @@
+// The unit tests for our event bus
+package events
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 5
+
+// Helper function which reads the next event or gives up after a second
+func next(ch <-chan Event) Event {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		return Event{}
+	}
+}
+
+// Unit tests for publishing a transfer's events to subscribers.
+// @param *testing.T t - The wrapper for the test
+func TestTransfer(t *testing.T) {
+	fmt.Println("\n----------------TestTransfer----------------")
+
+	ch, unsubscribe := Subscribe()
+	defer unsubscribe()
+
+	transfer := Start("1", Download, "Tests", "test.txt", "127.0.0.1:8080", 100)
+	e := next(ch)
+	if e.Kind != Started || e.Total != 100 || len(Active()) != 1 {
+		t.Error("Test failed, expected a started event. Got ", e)
+	} else {
+		fmt.Println("Successfully Published Started Event")
+		successful++
+	}
+
+	var buf bytes.Buffer
+	time.Sleep(progressInterval)
+	transfer.Writer(&buf).Write(make([]byte, 40))
+	e = next(ch)
+	if e.Kind != Progress || e.Bytes != 40 || e.Rate <= 0 || e.ETA <= 0 {
+		t.Error("Test failed, expected progress with a rate and ETA. Got ", e)
+	} else {
+		fmt.Println("Successfully Published Progress Event")
+		successful++
+	}
+
+	transfer.Fail(errors.New("Peer Went Away"))
+	transfer.Finish() // Ignored - a transfer only ends once
+	e = next(ch)
+	if e.Kind != Failed || e.Error != "Peer Went Away" || len(Active()) != 0 || next(ch).Kind != "" {
+		t.Error("Test failed, expected a single failed event. Got ", e)
+	} else {
+		fmt.Println("Successfully Published Failed Event")
+		successful++
+	}
+
+	// A subscriber which does not keep up only misses progress
+	slow, unsubscribeSlow := Subscribe()
+	defer unsubscribeSlow()
+	for i := 0; i < subscriberBuffer+10; i++ {
+		Publish(Event{Kind: Progress, ID: "2"})
+	}
+	mu.Lock()
+	subscribed := len(subscribers)
+	mu.Unlock()
+	if subscribed != 2 || len(slow) != subscriberBuffer {
+		t.Error("Test failed, expected progress to be dropped for a slow subscriber. Got ",
+			subscribed, len(slow))
+	} else {
+		fmt.Println("Successfully Dropped Progress For Slow Subscriber")
+		successful++
+	}
+
+	// but is cut off rather than miss the end of a transfer
+	Publish(Event{Kind: Finished, ID: "2"})
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Error("Test failed, expected the slow subscriber to be cut off. Got ", received)
+	} else {
+		fmt.Println("Successfully Cut Off Slow Subscriber")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -7,39 +7,47 @@
 package main
 
 import (
-	"bufio"
+	"../api"
 	"../client"
+	"../dht"
+	"../logs"
 	"../lynxutil"
+	"../metrics"
+	"../ratelimit"
 	"../server"
 	"../tracker"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jasonlvhit/gocron"
 	"github.com/skratchdot/open-golang/open"
 )
 
+// Writes the GUI's log entries
+var logger = logs.New(logs.GUI)
+
 // Holds our uploads html page
 var uploads []byte
 
 // Holds our downloads html page
 var downloads []byte
 
+// Holds our logs html page
+var logsPage []byte
+
 // current form data that was submitted
 var form url.Values
 
-// Holds the currentLynk being checked for changes
-var currentLynk lynxutil.Lynk
-
-// Tells us whether or not our lynk's files have changed
-var changed = true
+// The settings of our node, loaded from the config file, environment and flags
+var config = lynxutil.DefaultConfig()
 
 // UserInput - A struct that we combine with our Go template to produce desired HTML
 type UserInput struct {
@@ -60,8 +68,22 @@ type MyTemplate struct {
 	JSCode     template.JS
 }
 
-// Main simply calls our launch method which inits our web server
+// Main loads our config and then calls our launch method which inits our web server
 func main() {
+	cfg, err := lynxutil.LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	config = cfg
+	if err := logs.Configure(config); err != nil {
+		fmt.Println(err)
+	}
+	client.Configure(config)
+	server.Configure(config)
+	tracker.Configure(config)
+	ratelimit.Configure(config)
+
 	launch()
 }
 
@@ -69,12 +91,7 @@ func main() {
 func launch() {
 
 
-	// Checks to see if lynks.txt exists - if it doesn't it is created.
-	if _, err := os.Stat(lynxutil.HomePath + "/lynks.txt"); os.IsNotExist(err) {
-		os.Create(lynxutil.HomePath + "lynks.txt")
-	}
-
-	fmt.Println("Starting server on http://localhost:" + lynxutil.GUIPort)
+	logger.Info("Starting server on http://localhost:" + config.GUIPort)
 
 	fs := HTMLFiles{http.Dir("js/")}
 	http.Handle("/js/", http.StripPrefix("/js/", http.FileServer(fs)))
@@ -92,16 +109,28 @@ func launch() {
 	http.HandleFunc("/settings", SettingsHandler)
 	http.HandleFunc("/uploads", UploadHandler)
 	http.HandleFunc("/downloads", DownloadHandler)
+	http.HandleFunc("/logs", LogsHandler)
 	http.HandleFunc("/", SplashHandler)
 	http.HandleFunc("/files", FileHandler)
 	http.HandleFunc("/removefile", RemoveFileHandler)
+	http.HandleFunc("/fetchfile", FetchFileHandler)
+	http.HandleFunc("/selectfiles", SelectHandler)
+	http.HandleFunc("/setmode", SetModeHandler)
+	http.HandleFunc("/invite", InviteHandler)
+	http.HandleFunc("/rename", RenameHandler)
+
+	// The JSON API for scripts and other front ends
+	api.Register(http.DefaultServeMux, config)
+
+	// Our counters and gauges for Prometheus to scrape
+	http.HandleFunc("/metrics", metrics.Handler)
 
 	// Do jobs with params
-	//gocron.Every(30).Second().Do(checkLynks)
+	//gocron.Every(30).Second().Do(server.SyncLocalChanges)
 	//<-gocron.Start()
 
 	// MK - open UI automatically on start of Lynx
-	open.Run("http://localhost:" + lynxutil.GUIPort)
+	open.Run("http://localhost:" + config.GUIPort)
 
 	go cronWrapper()
 
@@ -109,7 +138,33 @@ func launch() {
 
 	go tracker.Listen()
 
-	http.ListenAndServe(":"+lynxutil.GUIPort, nil)
+	// Finds peers on our local network without needing the tracker
+	go server.AnnounceLAN()
+	go client.ListenLAN()
+
+	// Lets peers behind NATs reach us through our trackers
+	go server.ListenTraversal()
+
+	if config.DHT {
+		startDHT()
+	}
+
+	http.ListenAndServe(net.JoinHostPort(config.GUIHost, config.GUIPort),
+		localPages(http.DefaultServeMux))
+}
+
+// Helper function which only serves the GUI's pages to callers on this machine. The API decides
+// for itself, as it also lets in callers with our APIToken.
+// @param http.Handler mux - The handler of every page
+// @return http.Handler - mux behind the check
+func localPages(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, api.Prefix) && !api.LocalCaller(req) {
+			http.Error(rw, "Forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(rw, req)
+	})
 }
 
 // Open - Method which is called when a new HTMLFiles struct is created it simply opens the
@@ -132,11 +187,11 @@ func IndexHandler(rw http.ResponseWriter, req *http.Request) {
 	t := template.New("cool template")
 	t, err := t.ParseFiles("index.html")
 	if err != nil {
-		fmt.Println(err)
+		logger.Error("Could Not Load index.html: " + err.Error())
 	}
 	//t,_ = t.ParseFiles("index.html")
 	// get the table of lynks
-	tableEntries := TablePopulate(lynxutil.HomePath + "/lynks.txt")
+	tableEntries := TablePopulate()
 	//fmt.Println(client.GetFileTableIndex())
 	// generate the js code for the lynks table
 	jsCode := JSLynkGenerate()
@@ -169,10 +224,16 @@ func IndexHandler(rw http.ResponseWriter, req *http.Request) {
 func CreateHandler(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 	form = req.Form
-	name := form["Name"]
+	name := form.Get("Name")
 
-	client.CreateMeta(name[0])
-	tracker.CreateSwarm(name[0])
+	// encrypted lynks can be seeded by storage peers which cannot read them, and a lynk can be
+	// created from a folder outside the Lynx directory
+	err := client.CreateMetaAt(name, form.Get("Folder"), form.Get("Encrypted") == "true")
+	if err != nil {
+		logger.Warn("Could Not Create Lynk " + name + ": " + err.Error())
+	} else {
+		tracker.CreateSwarm(name, client.MetaPath(name))
+	}
 
 	IndexHandler(rw, req)
 }
@@ -185,9 +246,9 @@ func JoinHandler(rw http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 	form = req.Form
 	metapath := form["MetaPath"]
-	err := client.JoinLynk(metapath[0])
+	err := client.JoinLynkAt(metapath[0], form.Get("Folder"))
 	if err != nil {
-		fmt.Println(err.Error())
+		logger.Warn("Could Not Join Lynk From " + metapath[0] + ": " + err.Error())
 	}
 
 	IndexHandler(rw, req)
@@ -208,7 +269,7 @@ func RemoveHandler(rw http.ResponseWriter, req *http.Request) {
 		client.DeleteLynk(client.GetLynkNameFromIndex(index), false)
 		// make sure we dont try and load a just deleted lynk
 		client.SetFileTableIndex(-1)
-		TablePopulate(lynxutil.HomePath + "/lynks.txt")
+		TablePopulate()
 	}
 	IndexHandler(rw, req)
 }
@@ -240,6 +301,14 @@ func DownloadHandler(rw http.ResponseWriter, req *http.Request) {
 	rw.Write(downloads)
 }
 
+// LogsHandler - Function that handles requests on the logs page: "/logs".
+// @param http.ResponseWriter rw - This is what we use to write our html back to
+// the web page.
+// @param *http.Request req - This is the http request sent to the server.
+func LogsHandler(rw http.ResponseWriter, req *http.Request) {
+	rw.Write(logsPage)
+}
+
 // SplashHandler - Function which loads our splash screen that is displayed on lynx startup
 // @param http.ResponseWriter rw - This is what we use to write our html back to
 // the web page.
@@ -248,7 +317,7 @@ func SplashHandler(rw http.ResponseWriter, req *http.Request) {
 	t := template.New("cool template")
 	t, err := t.ParseFiles("splash.html")
 	if err != nil {
-		fmt.Println(err)
+		logger.Error("Could Not Load splash.html: " + err.Error())
 	}
 
 	t.ExecuteTemplate(rw, "splash.html", "")
@@ -257,30 +326,23 @@ func SplashHandler(rw http.ResponseWriter, req *http.Request) {
 
 // TablePopulate - Function which will replace an element in the table in order to popluate it
 // within the html file
-// @param pathToTable - the location of the lynks table .txt file
 // @returns the string which cotains the correct html table tags to be added to the html file
-func TablePopulate(pathToTable string) string {
+func TablePopulate() string {
 	var tableEntries = ""
-	lynksFile, err := os.Open(pathToTable)
-	if err != nil {
-		fmt.Println(err)
-	}
-	scanner := bufio.NewScanner(lynksFile)
 	i := 0
-	// Scan each line
-	for scanner.Scan() {
+	// One row for each of our lynks
+	for _, lynk := range client.GetLynks() {
 
 		rowStringNum := strconv.Itoa(i)
-		line := strings.TrimSpace(scanner.Text()) // Trim helps with errors in \n
-		split := strings.Split(line, ":::")
 		// the id of our table row
 		tableEntries += "<tr id= row" + rowStringNum + " > \n"
 		// change the color of the Lynk name when it is selected
 		if i == client.GetFileTableIndex() {
-			tableEntries += "<td><b style= \"color:blue;\">" + split[0] + "</b></td>\n"
+			tableEntries += "<td><b style= \"color:blue;\">" + lynk.Name + "</b></td>\n"
 		} else {
-			tableEntries += "<td>" + split[0] + "</td>\n"
+			tableEntries += "<td>" + lynk.Name + "</td>\n"
 		}
+		tableEntries += statusCell(lynk)
 		// the html code which allows a table row click to show the files and also has the Delete
 		// icon for deleting each lynk
 		tableEntries += "<td><form id=\"remove\" method=\"POST\" action=\"/removelynx\"><button " +
@@ -294,29 +356,38 @@ func TablePopulate(pathToTable string) string {
 		tableEntries += "</tr>\n"
 		i++
 	}
-	//client.ParseLynks(pathToTable)
 	return tableEntries
 }
 
+// Helper function which creates the table cell showing a lynk's sync state - hovering over a lynk
+// in the Error state shows why its first failed file failed
+// @param lynxutil.Lynk lynk - The lynk
+// @returns - a string containing the cell
+func statusCell(lynk lynxutil.Lynk) string {
+	colors := map[string]string{lynxutil.Synced: "green", lynxutil.Syncing: "blue",
+		lynxutil.OutOfDate: "darkorange", lynxutil.SyncError: "red"}
+	state := lynk.Synced
+	if lynk.Paused {
+		state = "Paused"
+	}
+
+	reason := ""
+	for _, file := range lynk.Files {
+		if file.State == lynxutil.SyncError && reason == "" {
+			reason = file.Name + ": " + file.Error
+		}
+	}
+	return "<td><span style=\"color:" + colors[state] + ";\" title=\"" +
+		template.HTMLEscapeString(reason) + "\">" + template.HTMLEscapeString(state) + "</span></td>\n"
+}
+
 // RemoveListPopulate - Function which creates string that contains the html for populating the
 // dropdown list in the remove button
-// @param pathToTable - the path that the lynks table lives in
 // @returns - a string that can be used with the html file to populate the dropdown list
-func RemoveListPopulate(pathToTable string) string {
+func RemoveListPopulate() string {
 	var tableEntries = ""
-	lynksFile, err := os.Open(pathToTable)
-	if err != nil {
-		fmt.Println(err)
-	}
-	scanner := bufio.NewScanner(lynksFile)
-
-	// Scan each line
-	for scanner.Scan() {
-
-		line := strings.TrimSpace(scanner.Text()) // Trim helps with errors in \n
-		split := strings.Split(line, ":::")
-		tableEntries += "<option value=\"" + split[0] + "\">" + split[0] + "</option>"
-
+	for _, lynk := range client.GetLynks() {
+		tableEntries += "<option value=\"" + lynk.Name + "\">" + lynk.Name + "</option>"
 	}
 	return tableEntries
 }
@@ -341,8 +412,10 @@ func FilePopulate(index int) string {
 		for i < len(fileNames) {
 			// the file name and size on one row and the its delete icon on the last one
 			fileEntries += "<tr> \n"
-			fileEntries += "<td>" + fileNames[i].Name + "</td>\n"
+			fileEntries += "<td>" + template.HTMLEscapeString(fileNames[i].LocalName()) + "</td>\n"
 			fileEntries += "<td>" + strconv.Itoa(fileNames[i].Length/1000) +" KB" + "</td>\n"
+			fileEntries += "<td>" + template.HTMLEscapeString(fileNames[i].State) + "</td>\n"
+			fileEntries += fetchCell(fileNames[i], i)
 			/*fileEntries += "<td><form id=\"remove\" method=\"POST\" action=\"removefile\"> \n" +
 			"<button type=\"submit\" class=\"transparent\" data-toggle=\"tooltip\"" +
 			" data-placement=\"bottom\" \n" +
@@ -355,7 +428,7 @@ func FilePopulate(index int) string {
 				"class=\"transparent\" data-toggle=\"tooltip\"" +
 				"data-placement=\"bottom\" title=\"Delete this file\" ><img " +
 				"src=\"images/file-ex-red.png\"></button><div id=\"remover" + strconv.Itoa(i) + "\">Are you " +
-				"sure you want to delete " + fileNames[i].Name + " ? <input type=\"hidden\"" +
+				"sure you want to delete " + template.HTMLEscapeString(fileNames[i].LocalName()) + " ? <input type=\"hidden\"" +
 				"name=\"index\" value=\"" + strconv.Itoa(i) + "\"> <br><br><button type=\"button\" " +
 				"id=\"close" + strconv.Itoa(i) + "\" name=\"Cancel\"" +
 				" class=\"btn btn-info\">Cancel</button><button type=\"submit\" class=\"btn btn-danger\"" +
@@ -384,13 +457,13 @@ func FileHandler(rw http.ResponseWriter, req *http.Request) {
 	t := template.New("cool template")
 	t, err := t.ParseFiles("index.html")
 	if err != nil {
-		fmt.Println(err)
+		logger.Error("Could Not Load index.html: " + err.Error())
 	}
 
 	indexInt, _ := strconv.Atoi(index[0])
 	// get the files for that lynk in our list of lynks
 	fileEntry := FilePopulate(indexInt)
-	tableEntries := TablePopulate(lynxutil.HomePath + "/lynks.txt")
+	tableEntries := TablePopulate()
 	// create the header for the file table
 	fileHeader := FileHeader(client.GetFileTableIndex())
 	// generate our javascript code
@@ -405,6 +478,116 @@ func FileHandler(rw http.ResponseWriter, req *http.Request) {
 	t.ExecuteTemplate(rw, "index.html", myTemp)
 }
 
+// Helper function which creates the table cell with a button to download a file we do not have,
+// such as one left out of the lynk's selection
+// @param lynxutil.File file - The file
+// @param int index - The index of the file in the lynk
+// @returns - a string containing the cell
+func fetchCell(file lynxutil.File, index int) string {
+	if file.State == lynxutil.Synced || file.State == lynxutil.Syncing {
+		return "<td></td>\n"
+	}
+	return "<td><form method=\"POST\" action=\"/fetchfile\"><input type=\"hidden\" " +
+		"name=\"index\" value=\"" + strconv.Itoa(index) + "\"><button type=\"submit\" " +
+		"class=\"btn btn-default btn-xs\" title=\"Download this file now\">Download</button>" +
+		"</form></td>\n"
+}
+
+// FetchFileHandler - function which downloads a single file of the selected lynk, whether or not
+// it is part of the lynk's selection
+// @param: rw - a response to our html if needed
+// @param: req - the form data from our html
+func FetchFileHandler(rw http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	if index, err := strconv.Atoi(req.Form.Get("index")); err == nil {
+		lynkName := client.GetLynkNameFromIndex(client.GetFileTableIndex())
+		lynk, _ := client.GetLynk(lynkName)
+		if index >= 0 && index < len(lynk.Files) {
+			if err := client.FetchFile(lynkName, lynk.Files[index].Name); err != nil {
+				logger.Lynk(lynkName).Warn("Could Not Fetch " + lynk.Files[index].Name + ": " +
+					err.Error())
+			}
+		}
+	}
+	// back to home page
+	IndexHandler(rw, req)
+}
+
+// SelectHandler - function which sets which files of the selected lynk are downloaded from the
+// comma separated include and exclude patterns of the form in the file header
+// @param: rw - a response to our html if needed
+// @param: req - the form data from our html
+func SelectHandler(rw http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	lynkName := client.GetLynkNameFromIndex(client.GetFileTableIndex())
+	selection := lynxutil.Selection{Include: splitPatterns(req.Form.Get("include")),
+		Exclude: splitPatterns(req.Form.Get("exclude"))}
+	if err := client.SetSelection(lynkName, selection); err != nil {
+		logger.Lynk(lynkName).Warn("Could Not Set Selection: " + err.Error())
+	}
+	// back to home page
+	IndexHandler(rw, req)
+}
+
+// SetModeHandler - function which sets whether the selected lynk is synced both ways, only
+// receives changes or only sends them from the form in the file header
+// @param: rw - a response to our html if needed
+// @param: req - the form data from our html
+func SetModeHandler(rw http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	lynkName := client.GetLynkNameFromIndex(client.GetFileTableIndex())
+	if err := client.SetMode(lynkName, req.Form.Get("mode")); err != nil {
+		logger.Lynk(lynkName).Warn("Could Not Set Mode: " + err.Error())
+	}
+	// back to home page
+	IndexHandler(rw, req)
+}
+
+// InviteHandler - function which creates an invite to the selected lynk and shows it so it can be
+// copied and handed to the peer being invited
+// @param: rw - where the invite is written
+// @param: req - the form data from our html
+func InviteHandler(rw http.ResponseWriter, req *http.Request) {
+	lynkName := client.GetLynkNameFromIndex(client.GetFileTableIndex())
+	invite, err := client.CreateInvite(lynkName, client.DefaultInviteLife)
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		logger.Lynk(lynkName).Warn("Could Not Create Invite: " + err.Error())
+		rw.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(rw, "Could Not Create Invite: "+err.Error())
+		return
+	}
+	parsed, _ := lynxutil.ParseInvite(invite)
+	fmt.Fprintln(rw, "Paste this invite into Join Lynk - it works until "+
+		time.Unix(parsed.Expires, 0).Format(time.RFC1123)+":\n\n"+invite+
+		"\n\nThe invite is signed with the key with fingerprint "+parsed.Fingerprint)
+}
+
+// RenameHandler - function which renames our folder for the selected lynk from the form in the
+// file header. Its ID stays the same, so our peers are not affected.
+// @param: rw - a response to our html if needed
+// @param: req - the form data from our html
+func RenameHandler(rw http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	lynkName := client.GetLynkNameFromIndex(client.GetFileTableIndex())
+	if err := client.RenameLynk(lynkName, strings.TrimSpace(req.Form.Get("name"))); err != nil {
+		logger.Lynk(lynkName).Warn("Could Not Rename Lynk: " + err.Error())
+	}
+	// back to home page
+	IndexHandler(rw, req)
+}
+
+// Helper function which splits a comma separated list of selection patterns
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
 // RemoveFileHandler - function which handles the removal of a file from a specific lynk,
 // will actually delete the file locally
 // @param: rw - a response to our html if needed
@@ -423,9 +606,9 @@ func RemoveFileHandler(rw http.ResponseWriter, req *http.Request) {
 		//client.DeleteLynk(client.GetLynkNameFromIndex(client.GetFileTableIndex()))
 		// create a new meta.info file and push it to reflect the changes
 		client.CreateMeta(lynk)
-		server.PushMeta(lynxutil.HomePath + lynk + "/meta.info")
+		server.PushMeta(client.MetaPath(lynk))
 		//tracker.CreateSwarm(lynk)
-		//TablePopulate(lynxutil.HomePath + "/lynks.txt")
+		//TablePopulate()
 	}
 	// back to home page
 	IndexHandler(rw, req)
@@ -437,49 +620,7 @@ func RemoveFileHandler(rw http.ResponseWriter, req *http.Request) {
 func init() {
 	uploads, _ = ioutil.ReadFile("uploads.html")
 	downloads, _ = ioutil.ReadFile("downloads.html")
-}
-
-// Function which checks the files in a directory to see if any have been added / changed
-// @param path string - the path where the root directory is located
-// @param file os.FileInfo - each file within the root or inner directories
-// @param err error - any error we way encounter along the way
-// @return error - An error can produced if we encounter an invalid file.
-func checkFiles(path string, file os.FileInfo, err error) error {
-
-	inMeta := false
-	for _, f := range currentLynk.Files {
-		// Checks that the file is in the meta.info
-
-		if f.Name == file.Name() {
-			//fmt.Println("same file name: " + file.Name())
-			inMeta = true
-		}
-	}
-
-	// Don't add directories, trackers, or a meta.info file to the new meta.info
-	if !file.IsDir() && !strings.Contains(path, "_Tracker") && file.Name() != "meta.info" && !inMeta {
-		fmt.Println("File: " + file.Name() + " has been added or changed")
-		changed = true
-	}
-
-	return nil
-}
-
-// Helper function that we use to check to see if our Lynk has changed
-func checkLynks() {
-	// Loops through every Lynk to check to see if their files have changed
-	for _, lynk := range client.GetLynks() {
-		//fmt.Println("Checking..." + lynk.Name)
-		changed = false
-		// Sets currentLynk so it can be used in checkFiles
-		currentLynk = lynk
-		// Sets changed to true if any files have been changed
-		filepath.Walk(lynxutil.HomePath+lynk.Name, checkFiles)
-		if changed {
-			client.CreateMeta(lynk.Name)
-			server.PushMeta(lynxutil.HomePath + lynk.Name + "/meta.info")
-		}
-	}
+	logsPage, _ = ioutil.ReadFile("logs.html")
 }
 
 // JSLynkGenerate - function which generate java script code for our table of lynks.
@@ -517,6 +658,47 @@ func FileHeader(index int) string {
 	lynkOwner := tempLynk.Owner
 
 	htmlString = "<h3>Lynk:" + lynkName + " | Owner:" + lynkOwner + "</h3>"
+	htmlString += "<p class=\"text-muted\">ID: " + tempLynk.ID + "</p>"
+	if tempLynk.Encrypted && tempLynk.Key == nil {
+		htmlString += "<p>Encrypted - we are a storage peer and cannot read its files</p>"
+	} else if tempLynk.Encrypted {
+		htmlString += "<p>Encrypted - storage peers cannot read its files</p>"
+	}
+
+	// the patterns choosing which of the lynk's files we download
+	selection := tempLynk.Selection
+	htmlString += "<form class=\"form-inline\" method=\"POST\" action=\"/selectfiles\">" +
+		"<input type=\"text\" class=\"form-control\" name=\"include\" placeholder=\"Sync only " +
+		"e.g. Photos/, *.txt\" value=\"" + template.HTMLEscapeString(strings.Join(selection.Include, ", ")) +
+		"\"> <input type=\"text\" class=\"form-control\" name=\"exclude\" placeholder=\"Never " +
+		"sync e.g. *.iso\" value=\"" + template.HTMLEscapeString(strings.Join(selection.Exclude, ", ")) +
+		"\"> <button type=\"submit\" class=\"btn btn-default\">Save Selection</button></form>"
+
+	// whether the lynk is synced both ways, only receives or only sends
+	htmlString += "<form class=\"form-inline\" method=\"POST\" action=\"/setmode\">" +
+		"<select class=\"form-control\" name=\"mode\">"
+	for _, mode := range []string{lynxutil.TwoWay, lynxutil.ReceiveOnly, lynxutil.SendOnly} {
+		selected := ""
+		if mode == tempLynk.Mode {
+			selected = " selected"
+		}
+		htmlString += "<option value=\"" + mode + "\"" + selected + ">" + mode + "</option>"
+	}
+	htmlString += "</select> <button type=\"submit\" class=\"btn btn-default\">Set Mode</button></form>"
+
+	// an invite the owner can hand out instead of meta.info
+	htmlString += "<form class=\"form-inline\" method=\"POST\" action=\"/invite\" target=\"_blank\">" +
+		"<button type=\"submit\" class=\"btn btn-default\">Create Invite</button></form>"
+
+	// our name for the lynk, which is the name of its folder - peers know it by its ID
+	htmlString += "<form class=\"form-inline\" method=\"POST\" action=\"/rename\">" +
+		"<input type=\"text\" class=\"form-control\" name=\"name\" value=\"" +
+		template.HTMLEscapeString(lynkName) + "\"> <button type=\"submit\" " +
+		"class=\"btn btn-default\">Rename</button></form>"
+	if len(tempLynk.LocalChanges) > 0 {
+		htmlString += "<p class=\"text-warning\">Not pushed - this Lynk is receive-only: " +
+			template.HTMLEscapeString(strings.Join(tempLynk.LocalChanges, ", ")) + "</p>"
+	}
 
 	return htmlString
 
@@ -525,6 +707,22 @@ func FileHeader(index int) string {
 // Helper function that wraps around our cron call so we can call it in a goroutine
 func cronWrapper() {
 	s := gocron.NewScheduler()
-	s.Every(10).Seconds().Do(checkLynks)
+	s.Every(10).Seconds().Do(server.SyncLocalChanges)
+	if config.DHT {
+		s.Every(5).Minutes().Do(client.AnnounceLynks)
+	}
 	<-s.Start()
 }
+
+// Helper function that starts our DHT node and hands it to the client so lynks can be announced
+// and peers found when a tracker is unreachable
+func startDHT() {
+	node, err := dht.Listen(config.DHTPort)
+	if err != nil {
+		logger.Error("Could Not Start DHT: " + err.Error())
+		return
+	}
+
+	client.SetDHT(node)
+	go client.AnnounceLynks()
+}
@@ -32,6 +32,11 @@ var imgPath = cU.HomeDir + "/funny.jpg"
 // Path to the joining meta.info file
 var joinPath = cU.HomeDir + "/meta.info"
 
+// Loads our lynks from the database in the default home directory before any test runs
+func init() {
+	client.Configure(lynxutil.DefaultConfig())
+}
+
 // Count of the # of successful tests.
 var successful = 0
 
@@ -117,7 +122,7 @@ func testStartUp() error {
 func testCreate() error {
 	//fmt.Println("----------------TestCreate----------------")
 	err := client.CreateMeta("SysTests")
-	tracker.CreateSwarm("SysTests")
+	tracker.CreateSwarm("SysTests", client.MetaPath("SysTests"))
 	if err != nil {
 		fmt.Println("Test failed, expected no errors. Got " + err.Error())
 	} else {
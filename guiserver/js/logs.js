@@ -0,0 +1,55 @@
+/*
+    Shows the most recent log entries from /api/v1/logs in a table, filtered by the level,
+    component, Lynk and text chosen on the logs page, and refreshes them every few seconds.
+
+    @author Max Kernchen
+    @version 10/19/2026
+ */
+
+// Builds the query string for the filters chosen on the page
+function logsQuery() {
+    var query = {level: $("#level").val(), limit: 200};
+    $.each(["component", "lynk", "peer", "text"], function (i, field) {
+        var value = $("#" + field).val();
+        if (value) {
+            query[field] = value;
+        }
+    });
+    return $.param(query);
+}
+
+// Replaces the rows of the table with the entries matching the filters, newest first
+// @param tableBody - the id of the tbody to render entries into
+function showLogs(tableBody) {
+    $.getJSON("/api/v1/logs?" + logsQuery(), function (entries) {
+        var body = $("#" + tableBody).empty();
+        if (entries.length === 0) {
+            body.append($("<tr>").append($("<td colspan='6'>").text("No log entries")));
+            return;
+        }
+        $.each(entries.reverse(), function (i, e) {
+            var row = $("<tr>");
+            if (e.level === "error") {
+                row.addClass("danger");
+            } else if (e.level === "warn") {
+                row.addClass("warning");
+            }
+            $.each([new Date(e.time).toLocaleString(), e.level, e.component, e.lynk || "",
+                e.peer || "", e.message], function (j, text) {
+                row.append($("<td>").text(text));
+            });
+            body.append(row);
+        });
+    });
+}
+
+// Shows the log entries now, whenever a filter changes and every few seconds after that
+// @param tableBody - the id of the tbody to render entries into
+function followLogs(tableBody) {
+    var refresh = function () {
+        showLogs(tableBody);
+    };
+    $("#filters").on("change keyup", "input, select", refresh);
+    refresh();
+    setInterval(refresh, 3000);
+}
@@ -0,0 +1,142 @@
+/*
+    Renders live transfer progress from the /api/v1/events Server-Sent Events stream into a table.
+    Used by both the uploads and downloads pages, which can also pause, resume and cancel each
+    transfer through /api/v1/jobs.
+
+    @author Max Kernchen
+    @version 10/19/2026
+ */
+
+// Formats a number of bytes as a human readable size
+function formatBytes(bytes) {
+    var units = ["B", "KB", "MB", "GB", "TB"];
+    var i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return bytes.toFixed(i === 0 ? 0 : 1) + " " + units[i];
+}
+
+// Formats a number of seconds as minutes and seconds
+function formatETA(seconds) {
+    if (seconds < 0) {
+        return "Unknown";
+    }
+    seconds = Math.ceil(seconds);
+    var minutes = Math.floor(seconds / 60);
+    if (minutes === 0) {
+        return seconds + " seconds";
+    }
+    return minutes + " minute" + (minutes === 1 ? " " : "s ") + (seconds % 60) + " seconds";
+}
+
+// Builds the text shown in the progress column for an event
+function formatProgress(e) {
+    if (e.total < 0) {
+        return formatBytes(e.bytes);
+    }
+    var percent = e.total === 0 ? 100 : Math.floor(e.bytes * 100 / e.total);
+    return percent + "% of " + formatBytes(e.total);
+}
+
+// Builds the text shown in the status column for an event
+function formatStatus(e) {
+    if (e.kind === "finished") {
+        return "Done";
+    } else if (e.kind === "failed") {
+        return "Failed: " + e.error;
+    } else if (e.kind === "paused") {
+        return "Paused";
+    } else if (e.kind === "cancelled") {
+        return "Cancelled";
+    }
+    return formatETA(e.eta);
+}
+
+// Formats a limit in kilobytes per second, where 0 means unlimited
+function formatLimit(kb) {
+    return kb === 0 ? "Unlimited" : formatBytes(kb * 1024) + "/s";
+}
+
+// Shows the bandwidth limit of the given direction in force right now, and refreshes it every
+// minute so a schedule starting or ending is picked up
+// @param direction - "download" or "upload"
+// @param element - the id of the element to show the limit in
+function showLimits(direction, element) {
+    var refresh = function () {
+        $.getJSON("/api/v1/limits", function (status) {
+            var text = "Limit: " + formatLimit(status.limits[direction]);
+            if (status.schedule) {
+                text += " (scheduled " + status.schedule.from + " - " + status.schedule.to + ")";
+            }
+            $.each(status.lynkLimits, function (lynk, limits) {
+                if (limits[direction] > 0) {
+                    text += " | " + lynk + ": " + formatLimit(limits[direction]);
+                }
+            });
+            $("#" + element).text(text);
+        });
+    };
+    refresh();
+    setInterval(refresh, 60000);
+}
+
+// Asks the API to pause, resume or cancel the transfer with the given id
+function controlJob(id, action) {
+    $.post("/api/v1/jobs/" + id + "/" + action);
+}
+
+// Builds the pause or resume button and the cancel button for a transfer that has not ended
+function jobButtons(e) {
+    if (e.kind === "finished" || e.kind === "failed" || e.kind === "cancelled") {
+        return [];
+    }
+    var toggle = e.kind === "paused" ? "resume" : "pause";
+    return [
+        $("<button>").addClass("btn btn-default btn-xs").text(toggle === "pause" ? "Pause" : "Resume")
+            .click(function () { controlJob(e.id, toggle); }),
+        $("<button>").addClass("btn btn-danger btn-xs").text("Cancel")
+            .click(function () { controlJob(e.id, "cancel"); })
+    ];
+}
+
+// Follows every transfer in the given direction and keeps the table body up to date
+// @param direction - "download" or "upload"
+// @param tableBody - the id of the tbody element to render rows into
+function followTransfers(direction, tableBody) {
+    var source = new EventSource("/api/v1/events");
+
+    var render = function (msg) {
+        var e = JSON.parse(msg.data);
+        if (e.direction !== direction) {
+            return;
+        }
+
+        var row = $("#transfer" + e.id);
+        if (row.length === 0) {
+            row = $("<tr>").attr("id", "transfer" + e.id);
+            for (var i = 0; i < 7; i++) {
+                row.append($("<td>"));
+            }
+            $("#" + tableBody).prepend(row);
+            $("#" + tableBody + "empty").remove();
+        }
+
+        var cells = row.children("td");
+        cells.eq(0).text(e.file);
+        cells.eq(1).text(e.lynk);
+        cells.eq(2).text(e.peer);
+        cells.eq(3).text(formatProgress(e));
+        cells.eq(4).text(formatBytes(e.rate) + "/s");
+        cells.eq(5).text(formatStatus(e));
+        cells.eq(6).empty().append(jobButtons(e));
+        row.toggleClass("danger", e.kind === "failed" || e.kind === "cancelled");
+        row.toggleClass("warning", e.kind === "paused");
+        row.toggleClass("success", e.kind === "finished");
+    };
+
+    ["started", "progress", "finished", "failed", "paused", "cancelled"].forEach(function (kind) {
+        source.addEventListener(kind, render);
+    });
+}
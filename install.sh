@@ -3,6 +3,7 @@
 go get github.com/jasonlvhit/gocron
 go get github.com/skratchdot/open-golang/open
 go get golang.org/x/crypto/openpgp
+go get go.etcd.io/bbolt
 echo Downloaded Required Packages
 
 cd client
@@ -50,6 +51,66 @@ go install
 echo Mypgp Installed
 cd ..
 
+cd dht
+go install
+echo DHT Installed
+cd ..
+
+cd events
+go install
+echo Events Installed
+cd ..
+
+cd jobs
+go install
+echo Jobs Installed
+cd ..
+
+cd ratelimit
+go install
+echo Ratelimit Installed
+cd ..
+
+cd logs
+go install
+echo Logs Installed
+cd ..
+
+cd metrics
+go install
+echo Metrics Installed
+cd ..
+
+cd store
+go install
+echo Store Installed
+cd ..
+
+cd transport
+go install
+echo Transport Installed
+cd ..
+
+cd api
+go install
+echo API Installed
+cd ..
+
+cd daemon
+go install
+echo Daemon Installed
+cd ..
+
+cd lynxd
+go install
+echo lynxd Installed
+cd ..
+
+cd lynx
+go install
+echo lynx Installed
+cd ..
+
 cd guiserver
 echo Starting Lynx...
 go run guiserver.go
@@ -0,0 +1,296 @@
+// Package jobs - This package turns every download and upload into a job which can be paused,
+// resumed and cancelled. Each job carries a context which is cancelled when the job is paused or
+// cancelled so whatever is moving its bytes can stop straight away.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// The states a job can be in
+const (
+	Running   = "running"
+	Paused    = "paused"
+	Cancelled = "cancelled"
+	Done      = "done"
+	Failed    = "failed"
+)
+
+// ErrCancelled - Returned once a job has been cancelled
+var ErrCancelled = errors.New("Transfer Cancelled")
+
+// ErrPaused - Returned by a transfer which was stopped because its job was paused
+var ErrPaused = errors.New("Transfer Paused")
+
+// Job - A single download or upload of a file
+type Job struct {
+	ID        string
+	Direction string
+	Lynk      string
+	File      string
+	mu        sync.Mutex
+	state     string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	resumed   chan struct{} // Closed when a paused job is resumed or cancelled
+}
+
+// Info - A job as it is reported to the API
+type Info struct {
+	ID        string `json:"id"`
+	Direction string `json:"direction"`
+	Lynk      string `json:"lynk"`
+	File      string `json:"file"`
+	State     string `json:"state"`
+}
+
+// Guards jobs and nextID
+var mu sync.Mutex
+
+// Every job which has not finished, by ID
+var jobs = make(map[string]*Job)
+
+// The ID given to the next job
+var nextID = 1
+
+// New - Starts a new running job
+// @param string direction - events.Download or events.Upload
+// @param string lynk - The name of the Lynk the file belongs to
+// @param string file - The name of the file
+// @return *Job - The job
+func New(direction, lynk, file string) *Job {
+	mu.Lock()
+	defer mu.Unlock()
+	return newJob(direction, lynk, file)
+}
+
+// Get - Returns the unfinished job moving the given file, or starts a new one. Downloads use this
+// so retrying a file carries on with the same job - and stays paused if it was paused.
+// @param string direction - events.Download or events.Upload
+// @param string lynk - The name of the Lynk the file belongs to
+// @param string file - The name of the file
+// @return *Job - The job
+func Get(direction, lynk, file string) *Job {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, j := range jobs {
+		if j.Direction == direction && j.Lynk == lynk && j.File == file {
+			return j
+		}
+	}
+	return newJob(direction, lynk, file)
+}
+
+// Helper function which creates and registers a job - mu must be held
+func newJob(direction, lynk, file string) *Job {
+	j := &Job{
+		ID:        strconv.Itoa(nextID),
+		Direction: direction,
+		Lynk:      lynk,
+		File:      file,
+		state:     Running,
+	}
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	nextID++
+	jobs[j.ID] = j
+	return j
+}
+
+// Find - Returns the unfinished job with the given ID
+// @param string id - The ID of the job
+// @return *Job - The job, or nil if there is no such job
+func Find(id string) *Job {
+	mu.Lock()
+	defer mu.Unlock()
+	return jobs[id]
+}
+
+// ForLynk - Returns every unfinished job of a Lynk
+// @param string lynk - The name of the Lynk
+// @return []*Job - The jobs
+func ForLynk(lynk string) []*Job {
+	mu.Lock()
+	defer mu.Unlock()
+	var found []*Job
+	for _, j := range jobs {
+		if j.Lynk == lynk {
+			found = append(found, j)
+		}
+	}
+	return found
+}
+
+// List - Describes every unfinished job
+// @return []Info - The jobs
+func List() []Info {
+	mu.Lock()
+	all := make([]*Job, 0, len(jobs))
+	for _, j := range jobs {
+		all = append(all, j)
+	}
+	mu.Unlock()
+
+	infos := []Info{}
+	for _, j := range all {
+		infos = append(infos, j.Info())
+	}
+	return infos
+}
+
+// Info - Describes the job
+func (j *Job) Info() Info {
+	return Info{j.ID, j.Direction, j.Lynk, j.File, j.State()}
+}
+
+// State - Returns the state the job is in
+func (j *Job) State() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// Context - Returns the context of the job's current run. It is cancelled when the job is paused
+// or cancelled, and a fresh one is made when the job is resumed.
+func (j *Job) Context() context.Context {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.ctx
+}
+
+// Err - Returns ErrPaused or ErrCancelled if the job has been stopped, otherwise nil
+func (j *Job) Err() error {
+	switch j.State() {
+	case Paused:
+		return ErrPaused
+	case Cancelled:
+		return ErrCancelled
+	}
+	return nil
+}
+
+// Pause - Pauses a running job
+// @return error - An error is produced if the job is not running
+func (j *Job) Pause() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state != Running {
+		return errors.New("Only A Running Transfer Can Be Paused")
+	}
+	j.state = Paused
+	j.resumed = make(chan struct{})
+	j.cancel()
+	return nil
+}
+
+// Resume - Resumes a paused job with a fresh context
+// @return error - An error is produced if the job is not paused
+func (j *Job) Resume() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state != Paused {
+		return errors.New("Only A Paused Transfer Can Be Resumed")
+	}
+	j.state = Running
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	close(j.resumed)
+	return nil
+}
+
+// Cancel - Cancels a job that has not finished
+// @return error - An error is produced if the job has already finished
+func (j *Job) Cancel() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state != Running && j.state != Paused {
+		return errors.New("Transfer Has Already Finished")
+	}
+	if j.state == Paused {
+		close(j.resumed)
+	}
+	j.state = Cancelled
+	j.cancel()
+	return nil
+}
+
+// Wait - Blocks while the job is paused
+// @return error - ErrCancelled if the job was cancelled, otherwise nil
+func (j *Job) Wait() error {
+	j.mu.Lock()
+	resumed := j.resumed
+	state := j.state
+	j.mu.Unlock()
+
+	if state == Paused {
+		<-resumed
+		state = j.State()
+	}
+	if state == Cancelled {
+		return ErrCancelled
+	}
+	return nil
+}
+
+// Finish - Marks the job done, or failed if err is not nil, and forgets it. A cancelled job stays
+// cancelled.
+// @param error err - Why the job failed, or nil if it succeeded
+func (j *Job) Finish(err error) {
+	j.mu.Lock()
+	if j.state == Running || j.state == Paused {
+		j.state = Done
+		if err != nil {
+			j.state = Failed
+		}
+	}
+	j.cancel()
+	j.mu.Unlock()
+
+	mu.Lock()
+	delete(jobs, j.ID)
+	mu.Unlock()
+}
+
+// PauseLynk - Pauses every running job of a Lynk
+// @param string lynk - The name of the Lynk
+func PauseLynk(lynk string) {
+	for _, j := range ForLynk(lynk) {
+		j.Pause()
+	}
+}
+
+// ResumeLynk - Resumes every paused job of a Lynk
+// @param string lynk - The name of the Lynk
+func ResumeLynk(lynk string) {
+	for _, j := range ForLynk(lynk) {
+		j.Resume()
+	}
+}
+
+// CancelLynk - Cancels every job of a Lynk
+// @param string lynk - The name of the Lynk
+func CancelLynk(lynk string) {
+	for _, j := range ForLynk(lynk) {
+		j.Cancel()
+	}
+}
+
+// Watch - Closes c as soon as ctx is done, which interrupts any read or write blocked on it
+// @param context.Context ctx - The context to watch
+// @param io.Closer c - What to close - usually a net.Conn
+// @return func() - Stops watching - call it once the transfer is over
+func Watch(ctx context.Context, c io.Closer) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }
+}
@@ -0,0 +1,97 @@
+// The unit tests for our transfer jobs
+package jobs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 5
+
+// closer - An io.Closer which records that it was closed
+type closer chan bool
+
+// Close - Records the close
+func (c closer) Close() error {
+	c <- true
+	return nil
+}
+
+// Unit tests for pausing, resuming and cancelling a job.
+// @param *testing.T t - The wrapper for the test
+func TestJob(t *testing.T) {
+	fmt.Println("\n----------------TestJob----------------")
+
+	job := Get("download", "Tests", "test.txt")
+	if Get("download", "Tests", "test.txt") != job || Find(job.ID) != job {
+		t.Error("Test failed, expected the same job for the same file")
+	} else {
+		fmt.Println("Successfully Reused Job")
+		successful++
+	}
+
+	first := job.Context()
+	job.Pause()
+	waited := make(chan error)
+	go func() { waited <- job.Wait() }()
+	select {
+	case <-waited:
+		t.Error("Test failed, expected Wait to block while paused")
+	case <-time.After(50 * time.Millisecond):
+		if first.Err() == nil || job.Err() != ErrPaused {
+			t.Error("Test failed, expected pausing to cancel the context")
+		} else {
+			fmt.Println("Successfully Paused Job")
+			successful++
+		}
+	}
+
+	job.Resume()
+	if err := <-waited; err != nil || job.Context().Err() != nil {
+		t.Error("Test failed, expected a resumed job with a fresh context. Got ", err)
+	} else {
+		fmt.Println("Successfully Resumed Job")
+		successful++
+	}
+
+	job.Pause()
+	go func() { waited <- job.Wait() }()
+	job.Cancel()
+	job.Finish(nil)
+	if err := <-waited; err != ErrCancelled || job.State() != Cancelled || Find(job.ID) != nil {
+		t.Error("Test failed, expected a cancelled and forgotten job. Got ", err, job.State())
+	} else {
+		fmt.Println("Successfully Cancelled Paused Job")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
+
+// Unit tests for Watch closing a connection once its job is stopped.
+// @param *testing.T t - The wrapper for the test
+func TestWatch(t *testing.T) {
+	fmt.Println("\n----------------TestWatch----------------")
+
+	job := New("upload", "Tests", "test.txt")
+	c := make(closer, 1)
+	stop := Watch(job.Context(), c)
+	defer stop()
+
+	CancelLynk("Tests")
+	select {
+	case <-c:
+		fmt.Println("Successfully Closed On Cancel")
+		successful++
+	case <-time.After(time.Second):
+		t.Error("Test failed, expected the connection to be closed")
+	}
+	job.Finish(nil)
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -0,0 +1,285 @@
+// Package logs - This package is the structured logger of a Lynx node. Every entry has a level, the
+// component which wrote it and optionally the Lynk and peer it is about. Entries are printed to the
+// console, appended as JSON lines to a log file in the home directory which is rotated once it
+// grows too large, and kept in memory so the API can show the most recent ones.
+package logs
+
+import (
+	"../lynxutil"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level - How important a log entry is
+type Level int
+
+// The levels of log entries from lowest to highest - in the order of lynxutil.LogLevels
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+// The components which write log entries
+const (
+	Client  = "client"
+	Server  = "server"
+	Tracker = "tracker"
+	GUI     = "gui"
+	Daemon  = "daemon"
+)
+
+// Dir - The directory in the home directory log files are kept in
+const Dir = "logs/"
+
+// FileName - The name of the current log file - rotated ones end in .1, .2 and so on
+const FileName = "lynx.log"
+
+// The size at which the log file is rotated - a variable so tests can rotate small files
+var maxFileSize int64 = 5 << 20
+
+// The number of rotated log files kept
+const maxBackups = 3
+
+// The number of recent entries kept in memory for Recent
+const maxRecent = 1000
+
+// Entry - A single log entry
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Component string    `json:"component"`
+	Message   string    `json:"message"`
+	Lynk      string    `json:"lynk,omitempty"`
+	Peer      string    `json:"peer,omitempty"`
+}
+
+// Filter - Which recent entries to return - empty fields match everything
+type Filter struct {
+	Level     Level  // The lowest level returned
+	Component string // Only entries of this component
+	Lynk      string // Only entries about this Lynk
+	Peer      string // Only entries about this peer
+	Text      string // Only entries whose message contains this text
+	Limit     int    // At most this many of the newest entries - 0 for 100
+}
+
+// Logger - Writes entries for a component, and for a Lynk and peer once they have been added with
+// Lynk and Peer. A Logger is a value, so adding fields never changes the logger it came from.
+type Logger struct {
+	component string
+	lynk      string
+	peer      string
+}
+
+// Guards everything below
+var mu sync.Mutex
+
+// The lowest level which is logged
+var minLevel = Info
+
+// Where entries are printed for whoever is watching the console
+var console io.Writer = os.Stdout
+
+// The open log file, nil until Configure has opened one
+var file *os.File
+
+// The path and current size of the log file
+var filePath string
+var fileSize int64
+
+// The most recent entries, oldest first
+var recent []Entry
+
+// Configure - Sets the lowest level logged and opens the log file in the home directory. Until it
+// is called entries only go to the console and memory.
+// @param lynxutil.Config cfg - The config loaded by our main package
+// @return error - An error is produced if the level is invalid or the log file cannot be opened
+func Configure(cfg lynxutil.Config) error {
+	level, err := ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	minLevel = level
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+
+	if err := os.MkdirAll(cfg.HomePath+Dir, 0755); err != nil {
+		return err
+	}
+	filePath = cfg.HomePath + Dir + FileName
+	return openFile()
+}
+
+// ParseLevel - Converts a level's name to the level
+// @param string name - One of debug, info, warn or error
+// @return Level - The level
+// @return error - An error is produced if the name is not a level
+func ParseLevel(name string) (Level, error) {
+	for i, level := range lynxutil.LogLevels {
+		if strings.EqualFold(name, level) {
+			return Level(i), nil
+		}
+	}
+	return Info, errors.New("Invalid Log Level: " + name)
+}
+
+// String - Returns the name of the level
+func (level Level) String() string {
+	if level < Debug || level > Error {
+		return "level" + strconv.Itoa(int(level))
+	}
+	return lynxutil.LogLevels[level]
+}
+
+// New - Returns a logger for a component
+// @param string component - One of Client, Server, Tracker, GUI or Daemon
+// @return Logger - The logger
+func New(component string) Logger {
+	return Logger{component: component}
+}
+
+// Lynk - Returns a logger whose entries are about the given Lynk
+// @param string name - The name of the Lynk
+func (l Logger) Lynk(name string) Logger {
+	l.lynk = name
+	return l
+}
+
+// Peer - Returns a logger whose entries are about the given peer
+// @param string addr - The host:port of the peer
+func (l Logger) Peer(addr string) Logger {
+	l.peer = addr
+	return l
+}
+
+// Debug - Logs details that are only useful when tracking down a problem
+func (l Logger) Debug(msg string) { l.log(Debug, msg) }
+
+// Info - Logs what the node is doing
+func (l Logger) Info(msg string) { l.log(Info, msg) }
+
+// Warn - Logs something that went wrong but which the node recovers from
+func (l Logger) Warn(msg string) { l.log(Warn, msg) }
+
+// Error - Logs something that failed
+func (l Logger) Error(msg string) { l.log(Error, msg) }
+
+// Recent - Returns the newest entries in memory which match a filter, oldest first
+// @param Filter filter - Which entries to return
+// @return []Entry - The entries
+func Recent(filter Filter) []Entry {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	matched := []Entry{}
+	for i := len(recent) - 1; i >= 0 && len(matched) < limit; i-- {
+		if filter.matches(recent[i]) {
+			matched = append(matched, recent[i])
+		}
+	}
+
+	// The entries were gathered newest first
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched
+}
+
+// Helper function which returns whether or not an entry matches a filter
+func (filter Filter) matches(entry Entry) bool {
+	level, _ := ParseLevel(entry.Level)
+	return level >= filter.Level &&
+		(filter.Component == "" || entry.Component == filter.Component) &&
+		(filter.Lynk == "" || entry.Lynk == filter.Lynk) &&
+		(filter.Peer == "" || entry.Peer == filter.Peer) &&
+		(filter.Text == "" || strings.Contains(entry.Message, filter.Text))
+}
+
+// Helper function which writes an entry to the console, the log file and memory
+func (l Logger) log(level Level, msg string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if level < minLevel {
+		return
+	}
+
+	entry := Entry{Time: time.Now(), Level: level.String(), Component: l.component,
+		Message: msg, Lynk: l.lynk, Peer: l.peer}
+
+	recent = append(recent, entry)
+	if len(recent) > maxRecent {
+		recent = append([]Entry(nil), recent[len(recent)-maxRecent:]...)
+	}
+
+	fmt.Fprintln(console, entry.String())
+
+	if file != nil {
+		line, _ := json.Marshal(entry)
+		n, _ := file.Write(append(line, '\n'))
+		fileSize += int64(n)
+		if fileSize >= maxFileSize {
+			rotate()
+		}
+	}
+}
+
+// String - Formats the entry as a single line, the way it is printed to the console
+func (entry Entry) String() string {
+	line := entry.Time.Format("2006-01-02 15:04:05") + " " +
+		fmt.Sprintf("%-5s", strings.ToUpper(entry.Level)) + " " + entry.Component
+	if entry.Lynk != "" {
+		line += " lynk=" + entry.Lynk
+	}
+	if entry.Peer != "" {
+		line += " peer=" + entry.Peer
+	}
+	return line + ": " + entry.Message
+}
+
+// Helper function which opens the log file for appending - mu must be held
+func openFile() error {
+	opened, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := opened.Stat()
+	if err != nil {
+		opened.Close()
+		return err
+	}
+	file, fileSize = opened, info.Size()
+	return nil
+}
+
+// Helper function which moves the log file to .1, .1 to .2 and so on, dropping the oldest, and
+// starts a new log file - mu must be held
+func rotate() {
+	file.Close()
+	file = nil
+	for i := maxBackups - 1; i > 0; i-- {
+		os.Rename(filePath+"."+strconv.Itoa(i), filePath+"."+strconv.Itoa(i+1))
+	}
+	os.Rename(filePath, filePath+".1")
+
+	if err := openFile(); err != nil {
+		fmt.Fprintln(console, "Could Not Open Log File: "+err.Error())
+	}
+}
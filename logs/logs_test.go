@@ -0,0 +1,104 @@
+// The unit tests for our logger
+package logs
+
+import (
+	"capstone/lynxutil"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 5
+
+// Unit tests for levels and filtering recent entries
+// @param *testing.T t - The wrapper for the test
+func TestRecent(t *testing.T) {
+	fmt.Println("\n----------------TestRecent----------------")
+	dir, _ := ioutil.TempDir("", "logs")
+	defer os.RemoveAll(dir)
+
+	cfg := lynxutil.DefaultConfig()
+	cfg.HomePath = dir + "/"
+	cfg.LogLevel = "info"
+	if err := Configure(cfg); err != nil {
+		t.Fatal("Test failed, expected to open the log file. Got ", err)
+	}
+
+	logger := New(Client).Lynk("Tests")
+	logger.Debug("Hidden Entry")
+	logger.Info("First Entry")
+	logger.Peer("1.2.3.4:8080").Warn("Second Entry")
+	New(Server).Error("Third Entry")
+
+	entries := Recent(Filter{Text: "Entry"})
+	if len(entries) != 3 || entries[0].Message != "First Entry" || entries[2].Message != "Third Entry" {
+		t.Error("Test failed, expected 3 entries oldest first without debug. Got ", entries)
+	} else {
+		fmt.Println("Successfully Skipped Entries Below Level")
+		successful++
+	}
+
+	byLynk := Recent(Filter{Lynk: "Tests", Level: Warn})
+	byPeer := Recent(Filter{Peer: "1.2.3.4:8080"})
+	byComponent := Recent(Filter{Component: Server, Text: "Entry"})
+	limited := Recent(Filter{Text: "Entry", Limit: 1})
+	if len(byLynk) != 1 || len(byPeer) != 1 || len(byComponent) != 1 || len(limited) != 1 ||
+		limited[0].Message != "Third Entry" {
+		t.Error("Test failed, expected filtered entries. Got ", byLynk, byPeer, byComponent, limited)
+	} else {
+		fmt.Println("Successfully Filtered Entries")
+		successful++
+	}
+
+	contents, _ := ioutil.ReadFile(dir + "/" + Dir + FileName)
+	if !strings.Contains(string(contents), `"message":"Second Entry"`) ||
+		!strings.Contains(string(contents), `"peer":"1.2.3.4:8080"`) {
+		t.Error("Test failed, expected JSON lines in the log file. Got ", string(contents))
+	} else {
+		fmt.Println("Successfully Wrote JSON Lines")
+		successful++
+	}
+
+	cfg.LogLevel = "loud"
+	if Configure(cfg) == nil {
+		t.Error("Test failed, expected an error for an invalid level.")
+	} else {
+		fmt.Println("Successfully Rejected Invalid Level")
+		successful++
+	}
+}
+
+// Unit tests for rotating the log file
+// @param *testing.T t - The wrapper for the test
+func TestRotate(t *testing.T) {
+	fmt.Println("\n----------------TestRotate----------------")
+	dir, _ := ioutil.TempDir("", "logs")
+	defer os.RemoveAll(dir)
+
+	old := maxFileSize
+	maxFileSize = 200
+	defer func() { maxFileSize = old }()
+
+	cfg := lynxutil.DefaultConfig()
+	cfg.HomePath = dir + "/"
+	Configure(cfg)
+	for i := 0; i < 20; i++ {
+		New(Tracker).Info("Rotated Entry")
+	}
+
+	files, _ := ioutil.ReadDir(dir + "/" + Dir)
+	if len(files) != maxBackups+1 {
+		t.Error("Test failed, expected the log file and ", maxBackups, " backups. Got ", len(files))
+	} else {
+		fmt.Println("Successfully Rotated Log File")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -0,0 +1,107 @@
+// The Lynx command line client - sends commands to a running lynxd over its local socket.
+package main
+
+import (
+	"../daemon"
+	"../lynxutil"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// command - A lynx command, the daemon command it sends and the arguments it takes
+type command struct {
+	request string
+	args    []string
+	help    string
+}
+
+// Every command lynx understands
+var commands = map[string]command{
+	"create": {"Create", []string{"<lynk>"}, "Turn a folder in the Lynx directory into a Lynk"},
+	"create-encrypted": {"Create_Enc", []string{"<lynk>"},
+		"Create a Lynk which storage peers store and serve without being able to read"},
+	"join": {"Join", []string{"<meta.info path or invite>"},
+		"Join a Lynk through its meta.info file or an invite from its owner"},
+	"create-at": {"Create_At", []string{"<lynk>", "<folder>"},
+		"Turn a folder anywhere on disk into a Lynk - the folder stays where it is"},
+	"join-at": {"Join_At", []string{"<folder>", "<meta.info path or invite>"},
+		"Join a Lynk into an empty or new folder anywhere on disk"},
+	"leave":  {"Leave", []string{"<lynk>"}, "Stop syncing a Lynk - its files are kept"},
+	"list":   {"List", nil, "List our Lynks"},
+	"status": {"Status", nil, "Show the state of the daemon"},
+	"logs":   {"Logs", nil, "Show the daemon's most recent log entries"},
+	"files":  {"Files", []string{"<lynk>"}, "List the files of a Lynk"},
+	"rm":     {"Remove_File", []string{"<lynk>", "<file>"}, "Delete a file from a Lynk everywhere"},
+	"peers":  {"Peers", []string{"<lynk>"}, "List the peers we know of for a Lynk"},
+	"pause":  {"Pause", []string{"<lynk>"}, "Stop pushing and downloading changes to a Lynk"},
+	"resume": {"Resume", []string{"<lynk>"}, "Start syncing a paused Lynk again"},
+	"select": {"Select", []string{"<lynk>", "<include>", "<exclude>"},
+		"Download only files matching the comma separated patterns - \"\" \"\" for every file"},
+	"fetch": {"Fetch", []string{"<lynk>", "<file>"}, "Download a file even if it is not selected"},
+	"mode": {"Mode", []string{"<lynk>", "<mode>"},
+		"Sync a Lynk two-way, receive-only (local changes are undone) or send-only"},
+	"rename": {"Rename", []string{"<lynk>", "<new name>"},
+		"Rename our folder for a Lynk - its ID and our peers are not affected"},
+	"invite": {"Invite", []string{"<lynk>"}, "Create an invite to a Lynk which works for a week"},
+	"export-key": {"Export_Key", []string{"<lynk>", "<public key file>", "<out file>"},
+		"Encrypt an encrypted Lynk's key for a trusted member's OpenPGP public key"},
+	"import-key": {"Import_Key", []string{"<lynk>", "<key file>", "<private key file>"},
+		"Become a trusted member of an encrypted Lynk with a key exported for us"},
+}
+
+// The order commands are listed in by usage
+var commandOrder = []string{"create", "join", "leave", "list", "status", "logs", "files", "rm",
+	"peers", "pause", "resume", "select", "fetch", "mode", "create-encrypted", "export-key",
+	"import-key", "invite", "rename", "create-at", "join-at"}
+
+// Parses our flags and command, sends the command to lynxd and prints its output
+func main() {
+	config, args, err := lynxutil.LoadConfigArgs(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok || len(args)-1 != len(cmd.args) {
+		usage()
+		os.Exit(1)
+	}
+
+	// lynxd runs in a directory of its own, so folders are sent as absolute paths
+	for i, arg := range cmd.args {
+		if abs, err := filepath.Abs(args[i+1]); err == nil && arg == "<folder>" {
+			args[i+1] = abs
+		}
+	}
+
+	output, err := daemon.Call(config.ControlSocket, cmd.request, args[1:]...)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Lines out as columns
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprint(w, output)
+	w.Flush()
+}
+
+// Prints how lynx is used
+func usage() {
+	fmt.Println("Usage: lynx [-socket <path>] [-home <path>] <command> [arguments]")
+	fmt.Println("\nCommands:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, name := range commandOrder {
+		cmd := commands[name]
+		fmt.Fprintln(w, "  "+strings.Join(append([]string{name}, cmd.args...), " ")+"\t"+cmd.help)
+	}
+	w.Flush()
+}
@@ -0,0 +1,24 @@
+// The Lynx daemon - runs the server, tracker and syncing without the GUI and answers commands
+// from lynx on a local socket. Takes the same flags as the GUI, e.g. -home and -server-port.
+package main
+
+import (
+	"../daemon"
+	"../lynxutil"
+	"fmt"
+	"os"
+)
+
+// Loads our config and runs the daemon until it is killed
+func main() {
+	config, err := lynxutil.LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err = daemon.Run(config); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
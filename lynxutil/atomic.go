@@ -0,0 +1,91 @@
+// Package lynxutil - This file holds the helpers we use to write files so that a crash can never
+// leave one missing or half written. A file is written to a temp file beside it, synced to disk
+// and only then renamed over the old one.
+package lynxutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempSuffix - The suffix of the temp files written by WriteFileAtomic. Anything walking a Lynk's
+// directory should skip files with it.
+const TempSuffix = ".lynxtmp"
+
+// WriteFileAtomic - Replaces the file at path with data. The data is written to a temp file in the
+// same directory, synced and renamed over path, so readers see either the old or the new file.
+// @param string path - The path of the file
+// @param []byte data - The new contents of the file
+// @param os.FileMode perm - The permissions of the file
+// @return error - An error is produced if the temp file cannot be written or renamed
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*"+TempSuffix)
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Chmod(perm)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return Commit(temp.Name(), path)
+}
+
+// Commit - Renames a complete, already synced temp file over path and syncs the directory so the
+// rename itself survives a crash
+// @param string tempPath - The path of the temp file
+// @param string path - The path it should have
+// @return error - An error is produced if the file cannot be renamed
+func Commit(tempPath, path string) error {
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	// Not every system can sync a directory, and the file is in place either way
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// IsTempFile - Returns whether or not a file name belongs to a temp file of WriteFileAtomic
+// @param string name - The name of the file
+func IsTempFile(name string) bool {
+	return strings.HasSuffix(name, TempSuffix)
+}
+
+// HashFile - Returns the SHA-256 hash of a file, which meta.info keeps for each file so downloads
+// can be verified
+// @param string path - The path of the file
+// @return string - The hash in hex
+// @return error - An error is produced if the file cannot be read
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,409 @@
+// Package lynxutil - This file holds the configuration of a Lynx node. Settings come from, in order
+// of precedence, command line flags, LYNX_* environment variables, a JSON config file and finally
+// the defaults below - which lets several nodes run on one host with their own ports and homes.
+package lynxutil
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultServerPort - The Default Port For The Lynx Server
+const DefaultServerPort = "8080"
+
+// DefaultTrackerPort - The Default Port For The Lynx Tracker
+const DefaultTrackerPort = "9000"
+
+// DefaultGUIPort - The Default Port For The Lynx GUI
+const DefaultGUIPort = "5000"
+
+// DefaultGUIHost - The Default Host The Lynx GUI Is Served On - Only This Machine Can Reach It
+const DefaultGUIHost = "127.0.0.1"
+
+// DefaultDHTPort - The Default UDP Port For The Lynx DHT
+const DefaultDHTPort = "9500"
+
+// DefaultChunkLength - Represents Default Chunk Length
+const DefaultChunkLength = 32
+
+// DefaultReconnAttempts - Represents The Default Number Of Reconnection Attempts Lynx Will Make
+const DefaultReconnAttempts = 3
+
+// DefaultLogLevel - The Lowest Level Of Log Entries Written By Default
+const DefaultLogLevel = "info"
+
+// LogLevels - The levels of log entries from lowest to highest
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// The name of the config file looked for in the home directory when none is given
+const configFileName = "config.json"
+
+// Config - A struct which holds every setting of a Lynx node. It is loaded once by a main package
+// and handed to the client, server and tracker through their Configure functions.
+type Config struct {
+	ServerPort     string
+	TrackerPort    string
+	GUIPort        string
+	DHTPort        string
+	ChunkLength    int
+	ReconnAttempts int
+	HomePath       string          // Always absolute and always ends in "/"
+	TrackerPath    string          // Where trackers keep their state - see TrackerDir
+	DHT            bool            // Whether Lynks are also announced and looked up in the DHT
+	ControlSocket  string          // The local socket lynxd listens on for lynx commands
+	Limits         Limits          // Bandwidth limits of the whole node
+	Schedule       []LimitSchedule // Times of day at which other limits replace Limits
+	LogLevel       string          // The lowest level written to the log - one of LogLevels
+	MetricsAddr    string          // The host:port lynxd serves /metrics on - empty for none
+	TrackerRate    int             // Requests a second per client the tracker answers - 0 for any
+	AdminAddr      string          // The host:port of trackDriver's admin API - empty for none
+	AdminToken     string          // The bearer token the admin API requires - never a flag
+	GUIHost        string          // The host the GUI and API listen on - loopback by default
+	APIToken       string          // The bearer token API callers on other hosts send - no flag
+}
+
+// Limits - Bandwidth limits in kilobytes per second - 0 means unlimited
+type Limits struct {
+	Upload   int `json:"upload"`
+	Download int `json:"download"`
+}
+
+// LimitSchedule - Limits which replace the node's limits between two times of day, e.g. from
+// "22:00" to "06:00" with no limits to sync at full speed overnight
+type LimitSchedule struct {
+	From   string `json:"from"` // "HH:MM" in local time
+	To     string `json:"to"`   // "HH:MM" - earlier than From if the schedule spans midnight
+	Limits `json:"limits"`
+}
+
+// Active - Returns whether or not a schedule applies at the given time
+// @param time.Time t - The time to check
+// @return bool - True if t is between From (inclusive) and To (exclusive)
+func (schedule LimitSchedule) Active(t time.Time) bool {
+	from, errFrom := parseClock(schedule.From)
+	to, errTo := parseClock(schedule.To)
+	if errFrom != nil || errTo != nil {
+		return false
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to // Spans midnight
+}
+
+// DefaultConfig - Returns the settings used when nothing is configured, with the home directory
+// at ~/Lynx/
+// @return Config - The default config
+func DefaultConfig() Config {
+	return Config{
+		ServerPort:     DefaultServerPort,
+		TrackerPort:    DefaultTrackerPort,
+		GUIPort:        DefaultGUIPort,
+		GUIHost:        DefaultGUIHost,
+		DHTPort:        DefaultDHTPort,
+		ChunkLength:    DefaultChunkLength,
+		ReconnAttempts: DefaultReconnAttempts,
+		HomePath:       defaultHomePath(),
+		LogLevel:       DefaultLogLevel,
+	}
+}
+
+// LoadConfig - Builds a config from the defaults, a config file, the environment and flags. The
+// config file is given by -config or LYNX_CONFIG and defaults to config.json in the home
+// directory - a missing default file is not an error.
+// @param []string args - The command line arguments, without the program name
+// @return Config - The loaded config
+// @return error - An error is produced if a flag, variable or the config file is invalid
+func LoadConfig(args []string) (Config, error) {
+	config, _, err := LoadConfigArgs(args)
+	return config, err
+}
+
+// LoadConfigArgs - Works like LoadConfig but also returns the arguments left after the flags, for
+// programs which take a command after their flags
+// @param []string args - The command line arguments, without the program name
+// @return Config - The loaded config
+// @return []string - The arguments which were not flags
+// @return error - An error is produced if a flag, variable or the config file is invalid
+func LoadConfigArgs(args []string) (Config, []string, error) {
+	config := DefaultConfig()
+
+	flags := flag.NewFlagSet("lynx", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("LYNX_CONFIG"), "Path of the JSON config file")
+	serverPort := flags.String("server-port", "", "Port the server listens on")
+	trackerPort := flags.String("tracker-port", "", "Port the tracker listens on")
+	guiPort := flags.String("gui-port", "", "Port the GUI is served on")
+	guiHost := flags.String("gui-host", "", "Host the GUI is served on, 0.0.0.0 for every host")
+	dhtPort := flags.String("dht-port", "", "UDP port of the DHT node")
+	chunkLength := flags.Int("chunk-length", 0, "Chunk length used when downloading")
+	reconnAttempts := flags.Int("reconn-attempts", 0, "Times a failed download is retried")
+	homePath := flags.String("home", "", "Directory Lynks and lynks.txt are kept in")
+	trackerPath := flags.String("tracker-dir", "", "Directory the tracker keeps its state in")
+	useDHT := flags.Bool("dht", false, "Announce and look up Lynks in the DHT as well")
+	socket := flags.String("socket", "", "Local socket lynxd listens on for commands")
+	uploadLimit := flags.Int("upload-limit", 0, "Upload limit in KB/s, 0 for unlimited")
+	downloadLimit := flags.Int("download-limit", 0, "Download limit in KB/s, 0 for unlimited")
+	logLevel := flags.String("log-level", "", "Lowest level logged: debug, info, warn or error")
+	metricsAddr := flags.String("metrics-addr", "", "Address lynxd serves /metrics on, e.g. :9100")
+	trackerRate := flags.Int("tracker-rate", 0, "Requests a second per client, 0 for unlimited")
+	adminAddr := flags.String("admin-addr", "", "Address trackDriver serves its admin API on")
+	if err := flags.Parse(args); err != nil {
+		return config, nil, err
+	}
+
+	// A home given by flag or environment decides where the default config file is looked for
+	if home := os.Getenv("LYNX_HOME"); home != "" {
+		config.HomePath = home
+	}
+	if *homePath != "" {
+		config.HomePath = *homePath
+	}
+
+	path := *configPath
+	if path == "" {
+		path = filepath.Join(config.HomePath, configFileName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			path = ""
+		}
+	}
+	if path != "" {
+		if err := readConfigFile(path, &config); err != nil {
+			return config, nil, err
+		}
+	}
+
+	if err := applyEnv(&config); err != nil {
+		return config, nil, err
+	}
+
+	// Only flags which were actually given override the file and environment
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server-port":
+			config.ServerPort = *serverPort
+		case "tracker-port":
+			config.TrackerPort = *trackerPort
+		case "gui-port":
+			config.GUIPort = *guiPort
+		case "gui-host":
+			config.GUIHost = *guiHost
+		case "dht-port":
+			config.DHTPort = *dhtPort
+		case "chunk-length":
+			config.ChunkLength = *chunkLength
+		case "reconn-attempts":
+			config.ReconnAttempts = *reconnAttempts
+		case "home":
+			config.HomePath = *homePath
+		case "tracker-dir":
+			config.TrackerPath = *trackerPath
+		case "dht":
+			config.DHT = *useDHT
+		case "socket":
+			config.ControlSocket = *socket
+		case "upload-limit":
+			config.Limits.Upload = *uploadLimit
+		case "download-limit":
+			config.Limits.Download = *downloadLimit
+		case "log-level":
+			config.LogLevel = *logLevel
+		case "metrics-addr":
+			config.MetricsAddr = *metricsAddr
+		case "tracker-rate":
+			config.TrackerRate = *trackerRate
+		case "admin-addr":
+			config.AdminAddr = *adminAddr
+		}
+	})
+
+	return config, flags.Args(), config.validate()
+}
+
+// Helper function which reads a JSON config file over the top of config. Any field left out of
+// the file keeps its current value.
+// @param string path - The path of the config file
+// @param *Config config - The config to fill in
+// @return error - An error is produced if the file cannot be read or is not valid JSON
+func readConfigFile(path string, config *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return errors.New("Invalid Config File " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+// Helper function which applies the LYNX_* environment variables to config
+// @param *Config config - The config to fill in
+// @return error - An error is produced if a numeric or boolean variable cannot be parsed
+func applyEnv(config *Config) error {
+	strs := map[string]*string{
+		"LYNX_SERVER_PORT":  &config.ServerPort,
+		"LYNX_TRACKER_PORT": &config.TrackerPort,
+		"LYNX_GUI_PORT":     &config.GUIPort,
+		"LYNX_GUI_HOST":     &config.GUIHost,
+		"LYNX_DHT_PORT":     &config.DHTPort,
+		"LYNX_HOME":         &config.HomePath,
+		"LYNX_TRACKER_DIR":  &config.TrackerPath,
+		"LYNX_SOCKET":       &config.ControlSocket,
+		"LYNX_LOG_LEVEL":    &config.LogLevel,
+		"LYNX_METRICS_ADDR": &config.MetricsAddr,
+		"LYNX_ADMIN_ADDR":   &config.AdminAddr,
+		"LYNX_ADMIN_TOKEN":  &config.AdminToken,
+		"LYNX_API_TOKEN":    &config.APIToken,
+	}
+	for name, field := range strs {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	ints := map[string]*int{
+		"LYNX_CHUNK_LENGTH":    &config.ChunkLength,
+		"LYNX_RECONN_ATTEMPTS": &config.ReconnAttempts,
+		"LYNX_UPLOAD_LIMIT":    &config.Limits.Upload,
+		"LYNX_DOWNLOAD_LIMIT":  &config.Limits.Download,
+		"LYNX_TRACKER_RATE":    &config.TrackerRate,
+	}
+	for name, field := range ints {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("Invalid " + name + ": " + value)
+			}
+			*field = n
+		}
+	}
+
+	if value := os.Getenv("LYNX_DHT"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("Invalid LYNX_DHT: " + value)
+		}
+		config.DHT = b
+	}
+	return nil
+}
+
+// Helper function which checks a loaded config and tidies up its home path
+// @return error - An error is produced if a port or count is invalid
+func (config *Config) validate() error {
+	ports := map[string]string{
+		"ServerPort":  config.ServerPort,
+		"TrackerPort": config.TrackerPort,
+		"GUIPort":     config.GUIPort,
+		"DHTPort":     config.DHTPort,
+	}
+	for name, port := range ports {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return errors.New("Invalid " + name + ": " + port)
+		}
+	}
+	if config.ChunkLength <= 0 {
+		return errors.New("ChunkLength Must Be Positive")
+	}
+	if config.ReconnAttempts < 0 {
+		return errors.New("ReconnAttempts Cannot Be Negative")
+	}
+	if config.TrackerRate < 0 {
+		return errors.New("TrackerRate Cannot Be Negative")
+	}
+	if ip := net.ParseIP(config.GUIHost); config.GUIHost != "localhost" &&
+		(ip == nil || !ip.IsLoopback()) && config.APIToken == "" {
+		return errors.New("APIToken Must Be Set To Serve The GUI On " + config.GUIHost)
+	}
+	if err := config.Limits.Validate(); err != nil {
+		return err
+	}
+	for _, schedule := range config.Schedule {
+		if _, err := parseClock(schedule.From); err != nil {
+			return err
+		}
+		if _, err := parseClock(schedule.To); err != nil {
+			return err
+		}
+		if err := schedule.Limits.Validate(); err != nil {
+			return err
+		}
+	}
+	config.LogLevel = strings.ToLower(config.LogLevel)
+	validLevel := false
+	for _, level := range LogLevels {
+		validLevel = validLevel || config.LogLevel == level
+	}
+	if !validLevel {
+		return errors.New("Invalid LogLevel: " + config.LogLevel)
+	}
+
+	home, err := filepath.Abs(config.HomePath)
+	if err != nil {
+		return err
+	}
+	config.HomePath = normalizeHome(home)
+	if config.TrackerPath != "" {
+		trackerPath, err := filepath.Abs(config.TrackerPath)
+		if err != nil {
+			return err
+		}
+		config.TrackerPath = normalizeHome(trackerPath)
+	} else {
+		config.TrackerPath = config.HomePath + defaultTrackerDir
+	}
+	if config.ControlSocket == "" {
+		config.ControlSocket = config.HomePath + "lynxd.sock"
+	}
+	return nil
+}
+
+// Validate - Checks that no limit is negative
+// @return error - An error is produced if a limit is negative
+func (limits Limits) Validate() error {
+	if limits.Upload < 0 || limits.Download < 0 {
+		return errors.New("Bandwidth Limits Cannot Be Negative")
+	}
+	return nil
+}
+
+// Helper function which parses a time of day such as "22:30" into minutes after midnight
+// @param string clock - The time of day
+// @return int - The minutes after midnight
+// @return error - An error is produced if clock is not a valid "HH:MM" time
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, errors.New("Invalid Time Of Day: " + clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Helper function which returns ~/Lynx/
+func defaultHomePath() string {
+	currentusr, err := user.Current()
+	if err != nil {
+		return normalizeHome(filepath.Join(os.TempDir(), "Lynx"))
+	}
+	return normalizeHome(filepath.Join(currentusr.HomeDir, "Lynx"))
+}
+
+// Helper function which replaces Windows "\" with Unix "/" and makes sure the path ends in "/"
+func normalizeHome(path string) string {
+	path = strings.Replace(path, "\\", "/", -1)
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
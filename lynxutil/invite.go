@@ -0,0 +1,165 @@
+// Package lynxutil - This file holds the invites to a Lynk and the identity key they are signed
+// with. An invite is a short string an owner hands out instead of their meta.info. It names the
+// Lynk and its trackers, expires, and is signed with the owner's key, whose fingerprint it
+// carries so the owner can be checked out of band. The owner's public key is kept in meta.info
+// as well, so a tracker can tell the owner's invites from anyone else's.
+package lynxutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// IdentityFile - The name of the file in the home directory our identity key is kept in
+const IdentityFile = "identity.key"
+
+// InvitePrefix - What every invite starts with, so it can be told apart from a meta.info path
+const InvitePrefix = "lynx:"
+
+// Invite - An invitation to join a Lynk
+type Invite struct {
+	LynkID      string   `json:"id"`       // The ID of the Lynk from its meta.info
+	Name        string   `json:"name"`     // The name the owner gave the Lynk
+	Owner       string   `json:"owner"`    // The owner of the Lynk
+	Trackers    []string `json:"trackers"` // The host:port of each tracker meta.info is fetched from
+	Revision    int      `json:"rev"`      // The Lynk's revision when the invite was made
+	OwnerKey    []byte   `json:"key"`      // The public key the invite is signed with
+	Fingerprint string   `json:"fp"`       // The fingerprint of OwnerKey
+	Expires     int64    `json:"exp"`      // When the invite stops working, in Unix seconds
+	Token       string   `json:"token"`    // Random, so no two invites are the same
+}
+
+// LoadIdentity - Loads our identity key, creating it the first time
+// @param string path - The path of the key file
+// @return ed25519.PrivateKey - Our private key - its public half is key.Public()
+// @return error - An error is produced if the key cannot be read or created
+func LoadIdentity(path string) (ed25519.PrivateKey, error) {
+	if seed, err := ioutil.ReadFile(path); err == nil {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(seed)))
+		if err != nil || len(decoded) != ed25519.SeedSize {
+			return nil, errors.New("Invalid Identity Key In " + path)
+		}
+		return ed25519.NewKeyFromSeed(decoded), nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	seed := base64.StdEncoding.EncodeToString(key.Seed())
+	if err = WriteFileAtomic(path, []byte(seed+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Fingerprint - Returns the fingerprint of a public key as it is shown to people - the first 16
+// bytes of its SHA-256 hash in hex, in groups of four
+// @param []byte publicKey - The public key
+// @return string - The fingerprint, e.g. "1a2b 3c4d ..."
+func Fingerprint(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	hexSum := hex.EncodeToString(sum[:16])
+	var groups []string
+	for i := 0; i < len(hexSum); i += 4 {
+		groups = append(groups, hexSum[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// NewInvite - Creates and signs an invite
+// @param Invite invite - The Lynk's ID, name, owner and trackers - the rest is filled in
+// @param ed25519.PrivateKey key - The owner's identity key
+// @param time.Duration validFor - How long the invite works for
+// @return string - The invite, starting with InvitePrefix
+// @return error - An error is produced if no random token could be made
+func NewInvite(invite Invite, key ed25519.PrivateKey, validFor time.Duration) (string, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	invite.Token = hex.EncodeToString(token)
+	invite.OwnerKey = key.Public().(ed25519.PublicKey)
+	invite.Fingerprint = Fingerprint(invite.OwnerKey)
+	invite.Expires = time.Now().Add(validFor).Unix()
+
+	payload, _ := json.Marshal(invite)
+	signature := ed25519.Sign(key, payload)
+	return InvitePrefix + base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// EncodeOwnerKey - Encodes a public identity key the way meta.info keeps it
+// @param []byte publicKey - The public key
+// @return string - The key in base64
+func EncodeOwnerKey(publicKey []byte) string {
+	return base64.StdEncoding.EncodeToString(publicKey)
+}
+
+// CheckInvite - Checks that an invite ParseInvite accepted is for the Lynk of a meta.info - it
+// must name the Lynk's ID and owner and be signed by the owner key the meta.info keeps. As the
+// invite is signed over the ID, owner and revision, a meta.info older than the invite is refused
+// too.
+// @param Invite invite - The invite
+// @param MetaHeader header - The header of the Lynk's meta.info
+// @return error - An error is produced if the Lynk has no owner key or the invite is not for it
+func CheckInvite(invite Invite, header MetaHeader) error {
+	if header.OwnerKey == "" {
+		return errors.New("Lynk Has No Owner Key")
+	} else if invite.LynkID != header.ID || invite.Owner != header.Owner ||
+		EncodeOwnerKey(invite.OwnerKey) != header.OwnerKey {
+		return errors.New("Invite Is Not For This Lynk")
+	} else if header.Revision < invite.Revision {
+		return errors.New("meta.info Is Older Than The Invite")
+	}
+	return nil
+}
+
+// IsInvite - Returns whether or not a string is an invite rather than a meta.info path
+// @param string s - The string
+func IsInvite(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), InvitePrefix)
+}
+
+// ParseInvite - Decodes an invite and checks that it is signed by the key whose fingerprint it
+// carries and has not expired
+// @param string s - The invite
+// @return Invite - The invite
+// @return error - An error is produced if the invite is malformed, forged or expired
+func ParseInvite(s string) (Invite, error) {
+	var invite Invite
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), InvitePrefix), ".")
+	if len(parts) != 2 {
+		return invite, errors.New("Invalid Invite")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return invite, errors.New("Invalid Invite")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &invite) != nil {
+		return invite, errors.New("Invalid Invite")
+	}
+
+	if len(invite.OwnerKey) != ed25519.PublicKeySize ||
+		invite.Fingerprint != Fingerprint(invite.OwnerKey) ||
+		!ed25519.Verify(ed25519.PublicKey(invite.OwnerKey), payload, signature) {
+		return invite, errors.New("Invite Signature Is Invalid")
+	} else if time.Now().Unix() > invite.Expires {
+		return invite, errors.New("Invite Has Expired")
+	} else if invite.Name == "" || len(invite.Trackers) == 0 {
+		return invite, errors.New("Invalid Invite")
+	}
+	return invite, nil
+}
@@ -0,0 +1,149 @@
+// Package lynxutil - This file holds the IDs of Lynks. A Lynk's ID is kept in its meta.info and
+// names it in every message between peers and trackers, so two Lynks with the same name never
+// collide. The name is only what the owner called the Lynk - each peer keeps the Lynk in a folder
+// of its own choosing.
+package lynxutil
+
+import (
+	"../dht"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// The longest Lynk ID we accept - IDs we create are 40 characters
+const maxLynkIDLength = 64
+
+// ErrInvalidLynkID - The error produced for a meta.info whose ID cannot name a Lynk
+var ErrInvalidLynkID = errors.New("Invalid Lynk ID")
+
+// MetaHeader - The values at the top of a meta.info which describe the Lynk rather than its files
+type MetaHeader struct {
+	ID        string // The Lynk's ID - worked out by LegacyLynkID for meta.info files without one
+	Name      string // The name the owner gave the Lynk
+	Owner     string
+	OwnerKey  string // The owner's public identity key - EncodeOwnerKey - empty in older files
+	Tracker   string // The announce value - the host:port of the tracker, or "dht"
+	Encrypted bool
+	Revision  int // Counts up each time the Lynk's meta.info is written - 0 in older files
+}
+
+// NewLynkID - Creates the ID of a new Lynk. IDs are random and as long as a DHT infohash.
+// @return string - The ID in hex
+// @return error - An error is produced if no random bytes could be read
+func NewLynkID() (string, error) {
+	id := make([]byte, len(dht.NodeID{}))
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// LegacyLynkID - Returns the ID of a Lynk whose meta.info was written before Lynks had IDs. Every
+// peer works out the same one - the infohash older versions announced the Lynk under.
+// @param string name - The lynkName value from meta.info
+// @param string owner - The owner value from meta.info
+// @return string - The ID in hex
+func LegacyLynkID(name, owner string) string {
+	return dht.InfoHash(name, owner).String()
+}
+
+// ValidLynkID - Returns whether or not an ID can name a Lynk. An ID is sent in every message
+// between peers and trackers and names the Lynk's directory on a tracker, so it may only hold
+// letters, digits, '-' and '_'.
+// @param string id - The ID
+// @return bool - Whether or not the ID is valid
+func ValidLynkID(id string) bool {
+	if id == "" || len(id) > maxLynkIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' ||
+			c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// LynkInfoHash - Returns the infohash a Lynk is announced under in the DHT and on the local
+// network. It is a hash of the Lynk's ID rather than the ID itself, since the ID is all it takes to
+// ask a tracker for the Lynk's meta.info.
+// @param string id - The Lynk's ID
+// @return dht.NodeID - The infohash
+func LynkInfoHash(id string) dht.NodeID {
+	return dht.HashKey("lynk:::" + id)
+}
+
+// ParseMetaHeader - Reads the header of a meta.info
+// @param []byte meta - The meta.info
+// @return MetaHeader - The header
+// @return error - ErrInvalidLynkID is produced if the meta.info has an ID ValidLynkID refuses
+func ParseMetaHeader(meta []byte) (MetaHeader, error) {
+	var header MetaHeader
+	scanner := bufio.NewScanner(bytes.NewReader(meta))
+	for scanner.Scan() {
+		split := strings.Split(strings.TrimSpace(scanner.Text()), ":::")
+		if len(split) < 2 {
+			continue
+		}
+		switch split[0] {
+		case "id":
+			header.ID = split[1]
+		case "lynkName":
+			header.Name = split[1]
+		case "owner":
+			header.Owner = split[1]
+		case "ownerKey":
+			header.OwnerKey = split[1]
+		case "announce":
+			header.Tracker = split[1]
+		case "encrypted":
+			header.Encrypted = split[1] == "true"
+		case "revision":
+			header.Revision, _ = strconv.Atoi(split[1])
+		}
+	}
+
+	if header.ID == "" {
+		header.ID = LegacyLynkID(header.Name, header.Owner)
+	} else if !ValidLynkID(header.ID) {
+		return header, ErrInvalidLynkID
+	}
+	return header, nil
+}
+
+// CheckPush - Checks that a pushed meta.info may replace the one we have. It must keep the Lynk's
+// ID, owner and owner key - a meta.info written before there were owner keys may gain one - and
+// must not be older than ours.
+// @param MetaHeader pushed - The header of the pushed meta.info
+// @param MetaHeader current - The header of our meta.info
+// @return error - An error is produced if the pushed meta.info may not replace ours
+func CheckPush(pushed, current MetaHeader) error {
+	if pushed.ID != current.ID {
+		return errors.New("meta.info Is For Another Lynk")
+	} else if pushed.Owner != current.Owner ||
+		(current.OwnerKey != "" && pushed.OwnerKey != current.OwnerKey) {
+		return errors.New("meta.info Changes The Lynk's Owner")
+	} else if pushed.Revision < current.Revision {
+		return errors.New("meta.info Is Out Of Date")
+	}
+	return nil
+}
+
+// ReadMetaHeader - Reads the header of a meta.info file
+// @param string metaPath - The path of the meta.info
+// @return MetaHeader - The header
+// @return error - An error is produced if the file cannot be read or its ID is invalid
+func ReadMetaHeader(metaPath string) (MetaHeader, error) {
+	meta, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return MetaHeader{}, err
+	}
+	return ParseMetaHeader(meta)
+}
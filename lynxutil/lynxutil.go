@@ -6,35 +6,32 @@ package lynxutil
 
 import (
 	"../mypgp"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/user"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
-// ServerPort - The Default Port For The Lynx Server
-const ServerPort = "8080"
+// LANGroup - The Multicast Group Lynx Servers Announce Their Lynks To On The Local Network
+const LANGroup = "239.192.76.88:9600"
 
-// TrackerPort - The Default Port For The Lynx Tracker
-const TrackerPort = "9000"
+// PEXTimeout - How Long Either Side Of A Peer Exchange Waits On The Other Before Giving Up
+const PEXTimeout = 10 * time.Second
 
-// GUIPort - The Default Port For The Lynx GUI
-const GUIPort = "5000"
+// MaxMetaSize - The Largest meta.info We Read, Both As Sent And Once It Is Decompressed
+const MaxMetaSize = 16 << 20
 
 // SockErr - Represents A Welcome Socket Error
 const SockErr = -1
 
-// ChunkLength - Represents Default Chunk Length
-const ChunkLength = 32
-
-// ReconnAttempts - Represents The Maximum Numbers Of Reconnection Attempts Lynx Will Make
-const ReconnAttempts = 3
-
-// HomePath - The absolute path of the user's Lynx directory
-var HomePath string
+// How long we wait on a single address of a peer before trying the next one
+const dialTimeout = 5 * time.Second
 
 // PrivateKey - This is the armored string that represents our private OpenPGP Key.
 var PrivateKey string
@@ -44,22 +41,63 @@ var PublicKey string
 
 // Peer - A struct which represents a Peer of the client
 type Peer struct {
-	IP   string
-	Port string
-	Key  string
+	IP    string
+	Port  string
+	Key   string
+	LAN   bool     // Whether or not the peer was discovered on our local network
+	PEX   bool     // Whether or not the peer was only learned through peer exchange
+	Addrs []string // Every host:port the peer can be reached on - both IPv4 and IPv6
 }
 
+// The sync states of a Lynk and of each of its files
+const (
+	Synced    = "Synced"      // The file is what meta.info describes
+	Syncing   = "Syncing"     // The file is being downloaded
+	OutOfDate = "Out of date" // The file is missing or differs from meta.info
+	SyncError = "Error"       // The last attempt to download the file failed
+	Skipped   = "Not synced"  // We do not have the file and it is not selected for download
+)
+
 // Lynk - A struct which holds all the information about a specific Lynk.
 type Lynk struct {
-	Name      string
+	Name      string // Our name for the Lynk - the folder it is kept in, which may differ per peer
+	ID        string // The ID from meta.info, which names the Lynk to peers and trackers
+	Root      string // The absolute path of the folder when it is not HomePath + Name
 	Owner     string
-	Synced    string
+	Synced    string // The sync state of the Lynk as a whole - worked out by SyncState
 	Tracker   string
 	Files     []File
 	Peers     []Peer
 	FileNames []string
 	FileSize  []int
 	DLing     bool
+	Paused    bool      // Whether or not syncing has been paused - nothing is pushed or downloaded
+	Selection Selection // Which of the files we download - every file unless it is set
+	Mode      string    // TwoWay, ReceiveOnly or SendOnly
+	Limits    Limits    // Bandwidth limits of the Lynk alone - the node's limits apply as well
+
+	LocalChanges []string // Files added locally to a ReceiveOnly Lynk, which are never pushed
+
+	Encrypted bool   // Whether or not meta.info holds only sealed file names, lengths and hashes
+	Key       []byte // The key of an Encrypted Lynk - nil on storage peers, never in meta.info
+	Revision  int    // The revision of the meta.info we last parsed - see MetaHeader
+}
+
+// The modes a Lynk can be synced in
+const (
+	TwoWay      = "two-way"      // Local changes are pushed and remote changes are downloaded
+	ReceiveOnly = "receive-only" // Remote changes are downloaded and local changes are undone
+	SendOnly    = "send-only"    // Local changes are pushed and remote changes are ignored
+)
+
+// ValidMode - Checks that a mode is one a Lynk can be synced in
+// @param string mode - The mode
+// @return error - An error is produced if the mode is not TwoWay, ReceiveOnly or SendOnly
+func ValidMode(mode string) error {
+	if mode != TwoWay && mode != ReceiveOnly && mode != SendOnly {
+		return errors.New("Invalid Mode: " + mode)
+	}
+	return nil
 }
 
 // File - A struct based which represents a File in a Lynk's directory. It is based
@@ -70,9 +108,44 @@ type File struct {
 	Name        string
 	Chunks      string
 	ChunkLength int
+	Hash        string // SHA-256 of the file in hex - empty in meta.info files from older versions
+	State       string // The sync state of our copy - not part of meta.info
+	Error       string // Why the last download failed when State is SyncError
+	PlainName   string // The name Name was sealed from - set only when we have the Lynk's key
+	ModTime     int64  // The mtime our copy had when last found in sync - not part of meta.info
+}
+
+// LocalName - Returns the name our copy of the file has on disk. Trusted members of an
+// encrypted Lynk keep their files under their plain names, everyone else under Name.
+func (file File) LocalName() string {
+	if file.PlainName != "" {
+		return file.PlainName
+	}
+	return file.Name
 }
 
-// FileCopy - Copies a file from src to dst
+// SyncState - Works out the sync state of a Lynk from the states of its files. A failed file
+// outweighs one being downloaded, which outweighs one which is out of date. Files which are not
+// selected for download do not count.
+// @param []File files - The files of the Lynk
+// @return string - The state of the Lynk
+func SyncState(files []File) string {
+	state := Synced
+	for _, file := range files {
+		switch {
+		case file.State == SyncError:
+			return SyncError
+		case file.State == Syncing:
+			state = Syncing
+		case file.State != Synced && file.State != Skipped && state == Synced:
+			state = OutOfDate
+		}
+	}
+	return state
+}
+
+// FileCopy - Copies a file from src to dst. The copy is written to a temp file beside dst and
+// renamed over it once complete, so dst is never left half written.
 // @param string src - the file that will be copied
 // @param string dst - the destination of the file to be copied
 // @return error - An error can be produced when issues arise from trying to access,
@@ -84,48 +157,168 @@ func FileCopy(src, dst string) error {
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst) // Opens output
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".*"+TempSuffix)
 	if err != nil {
 		return err
 	}
 
 	_, err = io.Copy(out, in) // Copies the file contents
+	if err == nil {
+		err = out.Chmod(0644)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr // Checks for close error
+	}
 	if err != nil {
+		os.Remove(out.Name())
 		return err
 	}
 
-	return out.Close() // Checks for close error
+	return Commit(out.Name(), dst)
 }
 
-// GetIP - Finds the ip of the current pc
+// GetIP - Finds the ip of the current pc. IPv4 addresses are preferred, but if the pc only has
+// IPv6 addresses the first of those is returned instead.
 // @return error - The single string ip
 func GetIP() string {
-	var onlyfirstip = false //only need first ip address
-	var ipstring = ""
+	ips := GetIPs()
+	for _, ip := range ips {
+		if net.ParseIP(ip).To4() != nil {
+			return ip
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0]
+	}
+	return ""
+}
+
+// GetIPs - Finds every IPv4 and IPv6 address of the current pc which other peers could reach us
+// on. Loopback and link-local addresses are skipped since they are useless to other machines.
+// @return []string - The ips of the current pc
+func GetIPs() []string {
+	var ips []string
 	ifaces, err := net.Interfaces()
+	if err != nil {
+		fmt.Println(err)
+	}
 	for _, i := range ifaces {
 		addrs, errI := i.Addrs()
 		if errI != nil {
-			fmt.Println(err)
+			fmt.Println(errI)
 		}
 		for _, addrs := range addrs {
-			if ipnet, ok := addrs.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-				if ipnet.IP.To4() != nil {
-					if !onlyfirstip {
-						onlyfirstip = true
-						ipstring = ipnet.IP.String()
-					}
-
-				}
+			if ipnet, ok := addrs.(*net.IPNet); ok && !ipnet.IP.IsLoopback() &&
+				!ipnet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipnet.IP.String())
 			}
+		}
+	}
+	return ips
+}
+
+// GetAddrs - Returns every host:port address of the current pc for the given port.
+// @param string port - The port to pair with each of our ips
+// @return []string - The addresses, suitable for Peer.Addrs
+func GetAddrs(port string) []string {
+	var addrs []string
+	for _, ip := range GetIPs() {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	return addrs
+}
 
+// Addresses - Returns every host:port address a peer can be reached on. Peers built from only an
+// IP and Port have a single address.
+// @return []string - The peer's addresses
+func (p Peer) Addresses() []string {
+	if len(p.Addrs) > 0 {
+		return p.Addrs
+	}
+	if p.IP == "" {
+		return nil
+	}
+	return []string{net.JoinHostPort(p.IP, p.Port)}
+}
+
+// Equal - Checks whether two peers are the same peer - which is the case when they share any
+// address.
+// @param Peer other - The peer to compare against
+// @return bool - True if the peers share an address
+func (p Peer) Equal(other Peer) bool {
+	for _, a := range p.Addresses() {
+		for _, b := range other.Addresses() {
+			if a == b {
+				return true
+			}
 		}
+	}
+	return false
+}
 
+// NewPeer - Creates a peer from a list of host:port addresses. IP and Port are set from the first
+// valid address, preferring IPv4.
+// @param []string addrs - The peer's addresses
+// @return Peer - The new peer
+// @return error - An error is produced if none of the addresses are valid
+func NewPeer(addrs []string) (Peer, error) {
+	p := Peer{}
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil {
+			continue
+		}
+		p.Addrs = append(p.Addrs, net.JoinHostPort(ip.String(), port))
+		if p.IP == "" || (net.ParseIP(p.IP).To4() == nil && ip.To4() != nil) {
+			p.IP = ip.String()
+			p.Port = port
+		}
 	}
-	if err != nil {
-		fmt.Println(err)
+	if len(p.Addrs) == 0 {
+		return p, errors.New("Peer Has No Valid Addresses")
+	}
+	return p, nil
+}
+
+// FormatPeer - Formats a peer as a single line for swarm.info and other peer lists. Each of the
+// peer's addresses is separated by a space - e.g. "10.0.0.5:8080 [2001:db8::5]:8080"
+// @param Peer p - The peer to format
+// @return string - The formatted peer
+func FormatPeer(p Peer) string {
+	return strings.Join(p.Addresses(), " ")
+}
+
+// ParsePeer - Parses a line written by FormatPeer. The old "IP:::Port" format is also accepted so
+// existing swarm.info files keep working.
+// @param string line - The line to parse
+// @return Peer - The parsed peer
+// @return error - An error is produced if the line holds no valid addresses
+func ParsePeer(line string) (Peer, error) {
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndex(line, ":::"); i > 0 && !strings.Contains(line, "[") {
+		return NewPeer([]string{net.JoinHostPort(line[:i], line[i+3:])})
+	}
+	return NewPeer(strings.Fields(line))
+}
+
+// DialPeer - Connects to a peer by trying each of its addresses in turn.
+// @param Peer p - The peer to connect to
+// @return net.Conn - The connection to the first address that answered
+// @return error - An error is produced if none of the peer's addresses answered
+func DialPeer(p Peer) (net.Conn, error) {
+	err := errors.New("Peer Has No Addresses")
+	for _, addr := range p.Addresses() {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+		if err == nil {
+			return conn, nil
+		}
 	}
-	return ipstring
+	return nil, err
 }
 
 // GetLynk - Simple helper method that checks a lynks array for specific lynk.
@@ -168,19 +361,17 @@ func Listen(handler func(net.Conn) error, port string) {
 }
 // Function which  creates the root Lynx directory if it is not already defined
 // not currently in use still need to do extra testing - MK
-func CheckAndCreateLynxDir(){
-	if _, err := os.Stat(HomePath); os.IsNotExist(err) {
-		os.Mkdir(HomePath, 0644)
+func CheckAndCreateLynxDir(homePath string){
+	if _, err := os.Stat(homePath); os.IsNotExist(err) {
+		os.Mkdir(homePath, 0644)
 	}
 }
 
-// Function init runs as soon as this class is imported and allows us to setup our HomePath
+// Function init runs as soon as this class is imported and allows us to setup our keys
 func init() {
 	currentusr, _ := user.Current()
-	HomePath = currentusr.HomeDir + "/Lynx/"
 	//check and create the Lynx directory if it is not there - not currently in use still need to do extra testing.
-	//CheckAndCreateLynxDir()
-	HomePath = strings.Replace(HomePath, "\\", "/", -1) // Replaces Windows "\" With Unix "/" in path
+	//CheckAndCreateLynxDir(DefaultConfig().HomePath)
 	config := mypgp.Config{Expiry: 365 * 24 * time.Hour}
 	key, _ := mypgp.CreateKey(currentusr.Name, "openpgp:lynxkeys", currentusr.Name+"@lynx.com", &config)
 	PublicKey = key.Public
@@ -5,16 +5,24 @@
 package lynxutil
 
 import (
+	"crypto/ed25519"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Count of the # of successful tests.
 var successful = 0
 
 // Total # of the tests.
-const total = 7
+const total = 30
 
 // Gets user's home directory
 var cU, _ = user.Current()
@@ -83,6 +91,42 @@ func TestGetIP(t *testing.T) {
 	}
 }
 
+// Unit tests for our WriteFileAtomic and HashFile functions.
+// @param *testing.T t - The wrapper for the test
+func TestWriteFileAtomic(t *testing.T) {
+	fmt.Println("\n----------------TestWriteFileAtomic----------------")
+	dir, _ := ioutil.TempDir("", "lynxutil")
+	defer os.RemoveAll(dir)
+	path := dir + "/meta.info"
+
+	WriteFileAtomic(path, []byte("old"), 0644)
+	err := WriteFileAtomic(path, []byte("abc"), 0644)
+	data, _ := ioutil.ReadFile(path)
+	if err != nil || string(data) != "abc" {
+		t.Error("Test failed, expected the file to be replaced. Got ", string(data), err)
+	} else {
+		fmt.Println("Successfully Replaced File")
+		successful++
+	}
+
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Error("Test failed, expected no temp files to be left. Got ", len(entries))
+	} else {
+		fmt.Println("Successfully Cleaned Up Temp Files")
+		successful++
+	}
+
+	hash, err := HashFile(path)
+	if err != nil ||
+		hash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Error("Test failed, expected the SHA-256 of abc. Got ", hash, err)
+	} else {
+		fmt.Println("Successfully Hashed File")
+		successful++
+	}
+}
+
 // Unit tests for our GetLynk function.
 // @param *testing.T t - The wrapper for the test
 func TestGetLynk(t *testing.T) {
@@ -105,6 +149,328 @@ func TestGetLynk(t *testing.T) {
 	} else {
 		t.Error("Test failed, expected to get cool lynk. Got ", lynk)
 	}
+}
+
+// Unit tests for our Registry - run with -race to check the locking.
+// @param *testing.T t - The wrapper for the test
+func TestRegistry(t *testing.T) {
+	fmt.Println("\n----------------TestRegistry----------------")
+	r := NewRegistry()
+	r.Add(Lynk{Name: "shared"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "lynk" + strconv.Itoa(i)
+			for j := 0; j < 50; j++ {
+				r.Add(Lynk{Name: name})
+				r.Update("shared", func(lynk *Lynk) {
+					lynk.Peers = append(lynk.Peers, Peer{IP: "127.0.0.1", Port: strconv.Itoa(j)})
+				})
+				r.UpdateAll(func(lynk *Lynk) { lynk.DLing = !lynk.DLing })
+				if shared, ok := r.Get("shared"); ok {
+					shared.Peers = nil // Only the copy changes
+				}
+				r.List()
+				r.Remove(name)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	shared, _ := r.Get("shared")
+	if r.Len() != 1 || len(shared.Peers) != 8*50 {
+		t.Error("Test failed, expected 1 Lynk with 400 peers. Got ", r.Len(), len(shared.Peers))
+	} else {
+		fmt.Println("Successfully Updated Registry Concurrently")
+		successful++
+	}
+
+	if r.Add(Lynk{Name: "shared"}) {
+		t.Error("Test failed, expected a duplicate Lynk not to be added.")
+	} else {
+		fmt.Println("Successfully Avoided Duplicate Lynk")
+		successful++
+	}
+}
+
+// Unit tests for our SyncState function.
+// @param *testing.T t - The wrapper for the test
+func TestSyncState(t *testing.T) {
+	fmt.Println("\n----------------TestSyncState----------------")
+	synced, behind := File{State: Synced}, File{State: OutOfDate}
+	syncing, failed := File{State: Syncing}, File{State: SyncError}
+
+	states := []string{SyncState(nil), SyncState([]File{synced, behind}),
+		SyncState([]File{behind, syncing, synced}), SyncState([]File{syncing, failed, behind})}
+	if states[0] != Synced || states[1] != OutOfDate || states[2] != Syncing || states[3] != SyncError {
+		t.Error("Test failed, expected Synced, Out of date, Syncing and Error. Got ", states)
+	} else {
+		fmt.Println("Successfully Worked Out Sync States")
+		successful++
+	}
+}
+
+// Unit tests for choosing the files of a Lynk we download.
+// @param *testing.T t - The wrapper for the test
+func TestSelection(t *testing.T) {
+	fmt.Println("\n----------------TestSelection----------------")
+	beach := File{Name: "beach.jpg", Path: "C:\\Users\\Max\\Lynx\\Photos\\2016\\beach.jpg"}
+	notes := File{Name: "notes.txt", Path: "/home/max/Lynx/Photos/notes.txt"}
+	iso := File{Name: "disk.iso"}
+
+	selection := Selection{Include: []string{"2016/", "*.iso"}, Exclude: []string{"disk.*"}}
+	if beach.RelPath("Photos") != "2016/beach.jpg" || !selection.Wants("Photos", beach) ||
+		selection.Wants("Photos", notes) || selection.Wants("Photos", iso) ||
+		!(Selection{}).Wants("Photos", notes) {
+		t.Error("Test failed, expected only beach.jpg to be selected. Got ", beach.RelPath("Photos"))
+	} else {
+		fmt.Println("Successfully Selected Files")
+		successful++
+	}
+
+	if (Selection{Include: []string{"[a-"}}).Validate() == nil ||
+		(Selection{Exclude: []string{" "}}).Validate() == nil || selection.Validate() != nil {
+		t.Error("Test failed, expected only the invalid patterns to be rejected.")
+	} else {
+		fmt.Println("Successfully Validated Patterns")
+		successful++
+	}
+
+	if ValidMode(ReceiveOnly) != nil || ValidMode(SendOnly) != nil || ValidMode("both") == nil {
+		t.Error("Test failed, expected only invalid modes to be rejected.")
+	} else {
+		fmt.Println("Successfully Validated Modes")
+		successful++
+	}
+}
+
+// Unit tests for our FormatPeer and ParsePeer functions.
+// @param *testing.T t - The wrapper for the test
+func TestParsePeer(t *testing.T) {
+	fmt.Println("\n----------------TestParsePeer----------------")
+
+	peer, err := ParsePeer("10.0.0.5:::8080")
+	if err != nil || peer.IP != "10.0.0.5" || peer.Port != "8080" {
+		t.Error("Test failed, expected to parse old swarm.info line. Got ", peer, err)
+	} else {
+		fmt.Println("Successfully Parsed Old Format Peer")
+		successful++
+	}
+
+	peer, err = ParsePeer("[2001:db8::5]:8080 10.0.0.5:8080")
+	if err != nil || len(peer.Addrs) != 2 || peer.IP != "10.0.0.5" ||
+		FormatPeer(peer) != "[2001:db8::5]:8080 10.0.0.5:8080" {
+		t.Error("Test failed, expected to parse IPv6 and IPv4 addresses. Got ", peer, err)
+	} else {
+		fmt.Println("Successfully Parsed Multi-Address Peer")
+		successful++
+	}
+
+	other, _ := ParsePeer("[2001:db8::5]:8080")
+	if !peer.Equal(other) {
+		t.Error("Test failed, expected peers sharing an address to be equal.")
+	} else {
+		fmt.Println("Successfully Matched Peers Sharing An Address")
+		successful++
+	}
+
+}
+
+// Unit tests for loading a config from a file, the environment and flags.
+// @param *testing.T t - The wrapper for the test
+func TestLoadConfig(t *testing.T) {
+	fmt.Println("\n----------------TestLoadConfig----------------")
+
+	dir, _ := ioutil.TempDir("", "lynxconfig")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"ServerPort": "8181", "TrackerPort": "9191", "ChunkLength": 64}`),
+		0644)
+
+	config, err := LoadConfig([]string{"-config", path, "-home", dir})
+	if err != nil || config.ServerPort != "8181" || config.ChunkLength != 64 ||
+		config.GUIPort != DefaultGUIPort || config.HomePath != filepath.ToSlash(dir)+"/" {
+		t.Error("Test failed, expected settings from the config file. Got ", config, err)
+	} else {
+		fmt.Println("Successfully Loaded Config File")
+		successful++
+	}
+
+	os.Setenv("LYNX_TRACKER_PORT", "9292")
+	os.Setenv("LYNX_SERVER_PORT", "8282")
+	config, err = LoadConfig([]string{"-config", path, "-server-port", "8383"})
+	os.Unsetenv("LYNX_TRACKER_PORT")
+	os.Unsetenv("LYNX_SERVER_PORT")
+	if err != nil || config.TrackerPort != "9292" || config.ServerPort != "8383" {
+		t.Error("Test failed, expected environment over file and flags over both. Got ", config, err)
+	} else {
+		fmt.Println("Successfully Overrode Config With Environment And Flags")
+		successful++
+	}
+
+	_, err = LoadConfig([]string{"-config", path, "-gui-port", "http"})
+	if err == nil {
+		t.Error("Test failed, expected an error for an invalid port.")
+	} else {
+		fmt.Println("Successfully Rejected Invalid Port")
+		successful++
+	}
+
+	// The GUI and API may only be served beyond this machine when callers need a token
+	_, err = LoadConfig([]string{"-config", path, "-gui-host", "0.0.0.0"})
+	os.Setenv("LYNX_API_TOKEN", "secret")
+	config, errToken := LoadConfig([]string{"-config", path, "-gui-host", "0.0.0.0"})
+	os.Unsetenv("LYNX_API_TOKEN")
+	if err == nil || errToken != nil || config.GUIHost != "0.0.0.0" {
+		t.Error("Test failed, expected a token to be needed beyond this host. Got ", err, errToken)
+	} else {
+		fmt.Println("Successfully Required API Token Beyond This Host")
+		successful++
+	}
+}
+
+// Unit tests for the IDs of Lynks and finding a Lynk by its ID.
+// @param *testing.T t - The wrapper for the test
+func TestLynkID(t *testing.T) {
+	fmt.Println("\n----------------TestLynkID----------------")
+
+	id, err := NewLynkID()
+	other, _ := NewLynkID()
+	header, _ := ParseMetaHeader([]byte("announce:::127.0.0.1:9000\nid:::" + id +
+		"\nlynkName:::Photos\nowner:::Max\nencrypted:::true\n"))
+	legacy, _ := ParseMetaHeader([]byte("announce:::dht\nlynkName:::Photos\nowner:::Max\n"))
+	if err != nil || len(id) != 40 || id == other || header.ID != id || header.Name != "Photos" ||
+		!header.Encrypted || legacy.ID != LegacyLynkID("Photos", "Max") || len(legacy.ID) != 40 ||
+		legacy.ID == LegacyLynkID("Photos", "Someone Else") || legacy.Tracker != "dht" {
+		t.Error("Test failed, expected random IDs and legacy IDs from name and owner. Got ", id,
+			header, legacy)
+	} else {
+		fmt.Println("Successfully Read Lynk IDs")
+		successful++
+	}
+
+	refused := 0
+	for _, bad := range []string{"a:b", "../etc", "a/b", "a b", strings.Repeat("a", 65)} {
+		_, err := ParseMetaHeader([]byte("id:::" + bad + "\nlynkName:::Photos\nowner:::Max\n"))
+		if err == ErrInvalidLynkID && !ValidLynkID(bad) {
+			refused++
+		}
+	}
+	if refused != 5 || !ValidLynkID(id) || !ValidLynkID("photos_2-backup") {
+		t.Error("Test failed, expected IDs which break messages or paths to be refused. Got ",
+			refused)
+	} else {
+		fmt.Println("Successfully Refused Invalid Lynk IDs")
+		successful++
+	}
+
+	registry := NewRegistry()
+	registry.Add(Lynk{Name: "Photos", ID: id})
+	registry.Add(Lynk{Name: "Photos-2", ID: other, Root: "/srv/photos"})
+	byID, ok := registry.Resolve(other)
+	byName, nameOk := registry.Resolve("Photos")
+	_, missing := registry.Resolve("Nope")
+	byRoot, rootOk := registry.FindRoot("/srv/photos")
+	_, noRoot := registry.FindRoot("")
+	if !ok || byID.Name != "Photos-2" || !nameOk || byName.ID != id || missing || !rootOk ||
+		byRoot.ID != other || noRoot {
+		t.Error("Test failed, expected to resolve Lynks by ID and then name. Got ", byID, byName)
+	} else {
+		fmt.Println("Successfully Resolved Lynks")
+		successful++
+	}
+}
+
+// Unit tests for moving the <Lynk>_Tracker folders of older versions into the tracker directory.
+// @param *testing.T t - The wrapper for the test
+func TestMigrateTrackers(t *testing.T) {
+	fmt.Println("\n----------------TestMigrateTrackers----------------")
+
+	home, _ := ioutil.TempDir("", "lynxtrackers")
+	defer os.RemoveAll(home)
+	cfg := Config{HomePath: home + "/", TrackerPath: home + "/trackers/"}
+	id, _ := NewLynkID()
+	os.MkdirAll(home+"/Photos/Photos_Tracker", 0755)
+	ioutil.WriteFile(home+"/Photos/Photos_Tracker/meta.info",
+		[]byte("announce:::127.0.0.1:9000\nid:::"+id+"\nlynkName:::Photos\nowner:::Max\n"), 0644)
+	ioutil.WriteFile(home+"/Photos/Photos_Tracker/swarm.info", []byte("127.0.0.1:::8080\n"), 0644)
+	os.MkdirAll(home+"/Music", 0755) // A Lynk without a tracker is left alone
+
+	moved, err := MigrateTrackers(cfg)
+	again, _ := MigrateTrackers(cfg)
+	swarm, _ := ioutil.ReadFile(cfg.TrackerDir(id) + "swarm.info")
+	_, oldErr := os.Stat(home + "/Photos")
+	_, musicErr := os.Stat(home + "/Music")
+	if err != nil || moved != 1 || again != 0 || string(swarm) != "127.0.0.1:::8080\n" ||
+		!os.IsNotExist(oldErr) || musicErr != nil {
+		t.Error("Test failed, expected the tracker to be moved once. Got ", moved, again, err)
+	} else {
+		fmt.Println("Successfully Migrated Trackers")
+		successful++
+	}
+}
+
+// Unit tests for creating, signing and parsing invites.
+// @param *testing.T t - The wrapper for the test
+func TestInvite(t *testing.T) {
+	fmt.Println("\n----------------TestInvite----------------")
+
+	dir, _ := ioutil.TempDir("", "lynxinvite")
+	defer os.RemoveAll(dir)
+	key, err := LoadIdentity(filepath.Join(dir, IdentityFile))
+	again, _ := LoadIdentity(filepath.Join(dir, IdentityFile))
+
+	s, _ := NewInvite(Invite{LynkID: "abc", Name: "Tests", Owner: "Max",
+		Trackers: []string{"127.0.0.1:9000"}}, key, time.Hour)
+	invite, parseErr := ParseInvite(s)
+	if err != nil || !key.Equal(again) || !IsInvite(s) || IsInvite("/home/max/meta.info") ||
+		parseErr != nil || invite.Name != "Tests" || invite.Trackers[0] != "127.0.0.1:9000" ||
+		invite.Fingerprint != Fingerprint(key.Public().(ed25519.PublicKey)) {
+		t.Error("Test failed, expected a valid invite signed by our identity. Got ", invite, err,
+			parseErr)
+	} else {
+		fmt.Println("Successfully Created And Parsed Invite")
+		successful++
+	}
+
+	// Another key re-signing the payload is caught by the fingerprint and signature checks
+	other, _ := LoadIdentity(filepath.Join(dir, "other.key"))
+	forged, _ := NewInvite(invite, other, time.Hour)
+	tampered := s[:len(InvitePrefix)] + forged[len(InvitePrefix):strings.Index(forged, ".")] +
+		s[strings.Index(s, "."):]
+	expired, _ := NewInvite(invite, key, -time.Minute)
+	_, tamperedErr := ParseInvite(tampered)
+	_, expiredErr := ParseInvite(expired)
+	_, garbageErr := ParseInvite("lynx:not-an-invite")
+	if tamperedErr == nil || expiredErr == nil || garbageErr == nil {
+		t.Error("Test failed, expected tampered, expired and malformed invites to be rejected.")
+	} else {
+		fmt.Println("Successfully Rejected Bad Invites")
+		successful++
+	}
+
+	// An invite is for the Lynk whose meta.info holds its ID, owner and key, from its revision on
+	signed, _ := NewInvite(Invite{LynkID: "abc", Name: "Tests", Owner: "Max", Revision: 2,
+		Trackers: []string{"127.0.0.1:9000"}}, key, time.Hour)
+	invite, _ = ParseInvite(signed)
+	header := MetaHeader{ID: "abc", Owner: "Max", Revision: 3,
+		OwnerKey: EncodeOwnerKey(key.Public().(ed25519.PublicKey))}
+	older, otherLynk, otherKey, noKey := header, header, header, header
+	older.Revision = 1
+	otherLynk.ID = "def"
+	otherKey.OwnerKey = EncodeOwnerKey(other.Public().(ed25519.PublicKey))
+	noKey.OwnerKey = ""
+	if CheckInvite(invite, header) != nil || CheckInvite(invite, older) == nil ||
+		CheckInvite(invite, otherLynk) == nil || CheckInvite(invite, otherKey) == nil ||
+		CheckInvite(invite, noKey) == nil {
+		t.Error("Test failed, expected only the owner's invite to the Lynk to be let in.")
+	} else {
+		fmt.Println("Successfully Checked Invite Against meta.info")
+		successful++
+	}
 
 	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
 }
@@ -0,0 +1,194 @@
+// Package lynxutil - This file holds the registry our Lynks are kept in. The client, server,
+// tracker, cron job and HTTP handlers all reach the Lynks from their own goroutines, so the
+// registry guards them with a lock and only ever hands out copies.
+package lynxutil
+
+import (
+	"sync"
+)
+
+// Registry - A concurrency-safe, ordered set of Lynks keyed by name. Lynks are read as copies
+// and changed only through Update, so nobody holds a pointer into the registry once its lock has
+// been released.
+type Registry struct {
+	mu    sync.RWMutex
+	lynks []*Lynk
+}
+
+// NewRegistry - Creates an empty registry
+// @return *Registry - The registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Get - Returns a copy of the Lynk with the given name
+// @param string name - The name of the Lynk
+// @return Lynk - The Lynk
+// @return bool - Whether or not the Lynk was found
+func (r *Registry) Get(name string) (Lynk, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if lynk := r.find(name); lynk != nil {
+		return lynk.clone(), true
+	}
+	return Lynk{}, false
+}
+
+// At - Returns a copy of the Lynk at the given position - the order Lynks were added in
+// @param int index - The position of the Lynk
+// @return Lynk - The Lynk
+// @return bool - Whether or not there is a Lynk at that position
+func (r *Registry) At(index int) (Lynk, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if index < 0 || index >= len(r.lynks) {
+		return Lynk{}, false
+	}
+	return r.lynks[index].clone(), true
+}
+
+// List - Returns a copy of every Lynk in the order they were added
+// @return []Lynk - The Lynks
+func (r *Registry) List() []Lynk {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	lynks := make([]Lynk, 0, len(r.lynks))
+	for _, lynk := range r.lynks {
+		lynks = append(lynks, lynk.clone())
+	}
+	return lynks
+}
+
+// Resolve - Returns a copy of the Lynk with the given ID. Peers running older versions name a
+// Lynk by its name instead, so a Lynk with that name is returned if no Lynk has the ID.
+// @param string idOrName - The ID or name of the Lynk
+// @return Lynk - The Lynk
+// @return bool - Whether or not the Lynk was found
+func (r *Registry) Resolve(idOrName string) (Lynk, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, lynk := range r.lynks {
+		if lynk.ID != "" && lynk.ID == idOrName {
+			return lynk.clone(), true
+		}
+	}
+	if lynk := r.find(idOrName); lynk != nil {
+		return lynk.clone(), true
+	}
+	return Lynk{}, false
+}
+
+// FindRoot - Returns a copy of the Lynk kept in the given folder. Lynks kept in the home
+// directory have no Root and are never found.
+// @param string root - The absolute path of the folder
+// @return Lynk - The Lynk
+// @return bool - Whether or not the Lynk was found
+func (r *Registry) FindRoot(root string) (Lynk, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, lynk := range r.lynks {
+		if lynk.Root != "" && lynk.Root == root {
+			return lynk.clone(), true
+		}
+	}
+	return Lynk{}, false
+}
+
+// Len - Returns the number of Lynks
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.lynks)
+}
+
+// Add - Adds a Lynk unless one with the same name is already there
+// @param Lynk lynk - The Lynk to add
+// @return bool - Whether or not the Lynk was added
+func (r *Registry) Add(lynk Lynk) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.find(lynk.Name) != nil {
+		return false
+	}
+	added := lynk.clone()
+	r.lynks = append(r.lynks, &added)
+	return true
+}
+
+// Remove - Removes the Lynk with the given name
+// @param string name - The name of the Lynk
+// @return bool - Whether or not the Lynk was there
+func (r *Registry) Remove(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, lynk := range r.lynks {
+		if lynk.Name == name {
+			r.lynks = append(r.lynks[:i], r.lynks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Reset - Replaces every Lynk with the given ones
+// @param []Lynk lynks - The new Lynks
+func (r *Registry) Reset(lynks []Lynk) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lynks = nil
+	for _, lynk := range lynks {
+		added := lynk.clone()
+		r.lynks = append(r.lynks, &added)
+	}
+}
+
+// Update - Changes the Lynk with the given name while holding the registry's lock. fn must not
+// keep the pointer it is given or call back into the registry.
+// @param string name - The name of the Lynk
+// @param func(*Lynk) fn - Makes the change
+// @return bool - Whether or not the Lynk was found
+func (r *Registry) Update(name string, fn func(*Lynk)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lynk := r.find(name)
+	if lynk == nil {
+		return false
+	}
+	fn(lynk)
+	return true
+}
+
+// UpdateAll - Calls fn for every Lynk while holding the registry's lock, with the same rules as
+// Update
+// @param func(*Lynk) fn - Makes the change
+func (r *Registry) UpdateAll(fn func(*Lynk)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, lynk := range r.lynks {
+		fn(lynk)
+	}
+}
+
+// Helper function which finds a Lynk by name - the lock must be held
+func (r *Registry) find(name string) *Lynk {
+	for _, lynk := range r.lynks {
+		if lynk.Name == name {
+			return lynk
+		}
+	}
+	return nil
+}
+
+// Helper function which copies a Lynk along with its slices, so the copy shares nothing with the
+// registry
+func (lynk *Lynk) clone() Lynk {
+	c := *lynk
+	c.Files = append([]File(nil), lynk.Files...)
+	c.Peers = append([]Peer(nil), lynk.Peers...)
+	for i := range c.Peers {
+		c.Peers[i].Addrs = append([]string(nil), c.Peers[i].Addrs...)
+	}
+	c.FileNames = append([]string(nil), lynk.FileNames...)
+	c.FileSize = append([]int(nil), lynk.FileSize...)
+	return c
+}
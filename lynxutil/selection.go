@@ -0,0 +1,73 @@
+// Package lynxutil - This file holds the selective sync of a Lynk. A peer can choose which of a
+// Lynk's files it downloads with include and exclude patterns, while still seeing every file in
+// the Lynk's meta.info.
+package lynxutil
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// Selection - The files of a Lynk we download. Patterns are globs as understood by path.Match,
+// such as "*.jpg" or "2016/*", matched against a file's path within the Lynk and against its
+// name, or folders such as "2016/" which match everything below them.
+type Selection struct {
+	Include []string `json:"include"` // Only matching files are downloaded - empty for every file
+	Exclude []string `json:"exclude"` // Matching files are never downloaded, even if included
+}
+
+// Validate - Checks that every pattern of the selection is a valid glob
+// @return error - An error is produced naming the first invalid pattern
+func (selection Selection) Validate() error {
+	for _, pattern := range append(append([]string{}, selection.Include...), selection.Exclude...) {
+		if strings.TrimSpace(pattern) == "" {
+			return errors.New("Empty Selection Pattern")
+		} else if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New("Invalid Selection Pattern: " + pattern)
+		}
+	}
+	return nil
+}
+
+// Wants - Returns whether or not a file of the Lynk is selected for download
+// @param string lynkName - The name of the Lynk the file is in
+// @param File file - The file
+// @return bool - True if the file is included and not excluded
+func (selection Selection) Wants(lynkName string, file File) bool {
+	rel := file.RelPath(lynkName)
+	included := len(selection.Include) == 0
+	for _, pattern := range selection.Include {
+		included = included || matches(pattern, rel, file.LocalName())
+	}
+	for _, pattern := range selection.Exclude {
+		if matches(pattern, rel, file.LocalName()) {
+			return false
+		}
+	}
+	return included
+}
+
+// RelPath - Returns the path of a file within its Lynk, e.g. "2016/beach.jpg", worked out from
+// the path meta.info has for it on the owner's machine - or just its name if that cannot be done
+// @param string lynkName - The name of the Lynk the file is in
+// @return string - The path of the file within the Lynk
+func (file File) RelPath(lynkName string) string {
+	full := strings.Replace(file.Path, "\\", "/", -1)
+	if i := strings.Index(full, "/"+lynkName+"/"); i >= 0 {
+		return full[i+len(lynkName)+2:]
+	}
+	return file.LocalName()
+}
+
+// Helper function which returns whether or not a pattern matches a file's path or name, or is a
+// folder the file is in
+func matches(pattern, rel, name string) bool {
+	folder := strings.TrimSuffix(pattern, "/")
+	if strings.HasPrefix(rel, folder+"/") {
+		return true
+	}
+	matchedRel, _ := path.Match(pattern, rel)
+	matchedName, _ := path.Match(pattern, name)
+	return matchedRel || matchedName
+}
@@ -0,0 +1,98 @@
+// Package lynxutil - This file holds where a tracker keeps its state. Every Lynk we track has a
+// directory named after its ID in the tracker directory, holding swarm.info and the tracker's copy
+// of meta.info, so nothing of the tracker is ever synced with the Lynk. Older versions kept both
+// files in a <Lynk>_Tracker folder inside the Lynk's folder, which MigrateTrackers moves out.
+package lynxutil
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The tracker directory in the home directory when none is configured - a name no Lynk can have
+const defaultTrackerDir = ".lynxtracker/"
+
+// TrackerDir - Returns the directory a tracker keeps the state of a Lynk in
+// @param string lynkID - The ID of the Lynk
+// @return string - The directory, ending in "/"
+func (config Config) TrackerDir(lynkID string) string {
+	trackerPath := config.TrackerPath
+	if trackerPath == "" {
+		trackerPath = config.HomePath + defaultTrackerDir
+	}
+	return trackerPath + lynkID + "/"
+}
+
+// MigrateTrackers - Moves the <Lynk>/<Lynk>_Tracker folders of older versions out of the home
+// directory into the tracker directory. A Lynk whose state has already been moved keeps it, and
+// calling this again does nothing once every folder is gone.
+// @param Config config - The config of our node
+// @return int - The number of folders moved
+// @return error - An error is produced if a folder could not be moved - the rest still are
+func MigrateTrackers(config Config) (int, error) {
+	entries, err := ioutil.ReadDir(config.HomePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	var firstErr error
+	for _, entry := range entries {
+		oldDir := config.HomePath + entry.Name() + "/" + entry.Name() + "_Tracker"
+		if info, err := os.Stat(oldDir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		header, err := ReadMetaHeader(oldDir + "/meta.info")
+		if err == nil {
+			err = moveTracker(oldDir, config.TrackerDir(header.ID))
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.New("Could Not Move " + oldDir + ": " + err.Error())
+			}
+			continue
+		}
+		// A folder which only held the tracker of a Lynk kept elsewhere is left empty
+		os.Remove(config.HomePath + entry.Name())
+		moved++
+	}
+	return moved, firstErr
+}
+
+// Helper function which moves the state of one tracked Lynk, copying it if it cannot simply be
+// renamed - for example because the tracker directory is on another disk
+// @param string oldDir - The <Lynk>_Tracker folder
+// @param string newDir - The Lynk's directory in the tracker directory
+// @return error - An error is produced if the files could not be copied
+func moveTracker(oldDir, newDir string) error {
+	if _, err := os.Stat(newDir); err == nil {
+		return os.RemoveAll(oldDir) // The state we already moved is newer
+	}
+	if err := os.MkdirAll(filepath.Dir(filepath.Clean(newDir)), 0755); err != nil {
+		return err
+	}
+	if os.Rename(oldDir, filepath.Clean(newDir)) == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return err
+	}
+	for _, name := range []string{"swarm.info", "meta.info"} {
+		data, err := ioutil.ReadFile(oldDir + "/" + name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err = WriteFileAtomic(newDir+name, data, 0644); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(oldDir)
+}
@@ -0,0 +1,290 @@
+// Package metrics - This package keeps the counters and gauges of a Lynx node and serves them on
+// /metrics in the Prometheus text format, so nodes on several machines can be scraped and graphed.
+// The metrics every node has are declared below - the client, server and tracker add to them as
+// they work, and gauges which are cheaper to work out when scraped are registered with
+// NewGaugeFunc.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The kinds of metric, as named in the exposition format
+const (
+	counterKind = "counter"
+	gaugeKind   = "gauge"
+)
+
+// The metrics of a Lynx node
+var (
+	BytesSent = NewCounter("lynx_bytes_sent_total",
+		"Bytes of files sent to peers.", "lynk")
+	BytesReceived = NewCounter("lynx_bytes_received_total",
+		"Bytes of files received from peers.", "lynk")
+	ActiveConnections = NewGauge("lynx_active_connections",
+		"Connections from peers being handled right now.", "component")
+	Requests = NewCounter("lynx_requests_total",
+		"Requests received from peers by message type.", "component", "type")
+	Downloads = NewCounter("lynx_downloads_total",
+		"Files asked for from peers by result.", "lynk", "result")
+	DecryptFailures = NewCounter("lynx_decrypt_failures_total",
+		"Files and meta.info files received which could not be decrypted.", "component")
+	RateLimited = NewCounter("lynx_rate_limited_total",
+		"Connections turned away for sending too many requests.", "component")
+)
+
+// Counter - A value which only goes up, kept for each combination of its labels' values
+type Counter struct {
+	family *family
+}
+
+// Gauge - A value which goes up and down, kept for each combination of its labels' values
+type Gauge struct {
+	family *family
+}
+
+// A named metric and its values
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	fn     func() map[string]float64 // Set for a gauge worked out when scraped
+
+	mu     sync.Mutex
+	values map[string]*series // By the label values joined with seriesSep
+}
+
+// A single value of a family
+type series struct {
+	labels []string
+	value  float64
+}
+
+// Separates the label values in the keys of family.values - it cannot appear in a valid label
+const seriesSep = "\xff"
+
+// Guards registry
+var registryMu sync.Mutex
+
+// Every registered family, by name
+var registry = make(map[string]*family)
+
+// NewCounter - Registers a counter
+// @param string name - The name of the metric, which should end in _total
+// @param string help - What the metric counts
+// @param ...string labels - The names of the labels each value is kept by
+// @return *Counter - The counter
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register(&family{name: name, help: help, kind: counterKind, labels: labels})}
+}
+
+// NewGauge - Registers a gauge
+// @param string name - The name of the metric
+// @param string help - What the metric measures
+// @param ...string labels - The names of the labels each value is kept by
+// @return *Gauge - The gauge
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register(&family{name: name, help: help, kind: gaugeKind, labels: labels})}
+}
+
+// NewGaugeFunc - Registers a gauge whose values are worked out by fn each time it is scraped
+// @param string name - The name of the metric
+// @param string help - What the metric measures
+// @param string label - The name of the label the values are kept by
+// @param func() map[string]float64 fn - Returns the value for each value of the label
+func NewGaugeFunc(name, help, label string, fn func() map[string]float64) {
+	register(&family{name: name, help: help, kind: gaugeKind, labels: []string{label}, fn: fn})
+}
+
+// Inc - Adds one to the counter
+// @param ...string values - The value of each of the counter's labels
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add - Adds to the counter - a negative amount is ignored since counters only go up
+// @param float64 v - The amount to add
+// @param ...string values - The value of each of the counter's labels
+func (c *Counter) Add(v float64, values ...string) {
+	if v > 0 {
+		c.family.update(values, func(s *series) { s.value += v })
+	}
+}
+
+// Reader - Returns a reader which adds the bytes read through it to the counter
+// @param io.Reader r - The reader to count
+// @param ...string values - The value of each of the counter's labels
+// @return io.Reader - The counting reader
+func (c *Counter) Reader(r io.Reader, values ...string) io.Reader {
+	return &countingReader{r: r, counter: c, values: values}
+}
+
+// Set - Sets the gauge
+// @param float64 v - The new value
+// @param ...string values - The value of each of the gauge's labels
+func (g *Gauge) Set(v float64, values ...string) {
+	g.family.update(values, func(s *series) { s.value = v })
+}
+
+// Add - Adds to the gauge, which may be negative
+// @param float64 v - The amount to add
+// @param ...string values - The value of each of the gauge's labels
+func (g *Gauge) Add(v float64, values ...string) {
+	g.family.update(values, func(s *series) { s.value += v })
+}
+
+// Inc - Adds one to the gauge
+// @param ...string values - The value of each of the gauge's labels
+func (g *Gauge) Inc(values ...string) { g.Add(1, values...) }
+
+// Dec - Takes one from the gauge
+// @param ...string values - The value of each of the gauge's labels
+func (g *Gauge) Dec(values ...string) { g.Add(-1, values...) }
+
+// Delete - Drops a value of the gauge, for example once the Lynk it was about is gone
+// @param ...string values - The value of each of the gauge's labels
+func (g *Gauge) Delete(values ...string) {
+	g.family.mu.Lock()
+	defer g.family.mu.Unlock()
+	delete(g.family.values, strings.Join(values, seriesSep))
+}
+
+// Handler - Serves every metric in the Prometheus text format
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+func Handler(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Write(rw)
+}
+
+// Write - Writes every metric in the Prometheus text format, ordered by name and label values
+// @param io.Writer w - Where the metrics are written
+// @return error - An error is produced if w could not be written to
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	families := make([]*family, 0, len(registry))
+	for _, f := range registry {
+		families = append(families, f)
+	}
+	registryMu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	out := bufio.NewWriter(w)
+	for _, f := range families {
+		out.WriteString("# HELP " + f.name + " " + escape(f.help, false) + "\n")
+		out.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		for _, s := range f.snapshot() {
+			out.WriteString(f.name + formatLabels(f.labels, s.labels) + " " +
+				formatValue(s.value) + "\n")
+		}
+	}
+	return out.Flush()
+}
+
+// Helper function which adds a family to the registry - two metrics with one name is a mistake
+// in our code, so it panics as soon as the package is loaded
+func register(f *family) *family {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[f.name]; ok {
+		panic("metrics: " + f.name + " registered twice")
+	}
+	f.values = make(map[string]*series)
+	registry[f.name] = f
+	return f
+}
+
+// Helper function which changes the series of the given label values, creating it at 0 first
+func (f *family) update(values []string, fn func(*series)) {
+	if len(values) != len(f.labels) {
+		panic("metrics: " + f.name + " takes " + strconv.Itoa(len(f.labels)) + " label values")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.Join(values, seriesSep)
+	s, ok := f.values[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		f.values[key] = s
+	}
+	fn(s)
+}
+
+// Helper function which copies the series of a family, ordered by their label values
+func (f *family) snapshot() []series {
+	var all []series
+	if f.fn != nil {
+		for value, v := range f.fn() {
+			all = append(all, series{labels: []string{value}, value: v})
+		}
+	} else {
+		f.mu.Lock()
+		for _, s := range f.values {
+			all = append(all, *s)
+		}
+		f.mu.Unlock()
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labels, seriesSep) < strings.Join(all[j].labels, seriesSep)
+	})
+	return all
+}
+
+// Helper function which formats label names and values as {name="value",...}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escape(values[i], true) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Helper function which formats a value the way Prometheus parses it
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Helper function which escapes backslashes and newlines - and double quotes in label values
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+// A reader which adds what is read through it to a counter
+type countingReader struct {
+	r       io.Reader
+	counter *Counter
+	values  []string
+}
+
+// Read - Reads from the wrapped reader and counts the bytes read
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Add(float64(n), c.values...)
+	return n, err
+}
@@ -0,0 +1,104 @@
+// The unit tests for our metrics
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 6
+
+// Unit tests for counters, gauges and the exposition format
+// @param *testing.T t - The wrapper for the test
+func TestWrite(t *testing.T) {
+	fmt.Println("\n----------------TestWrite----------------")
+	counter := NewCounter("test_sent_total", "Bytes sent.", "lynk")
+	counter.Add(10, "Tests")
+	counter.Inc("Tests")
+	counter.Add(-5, "Tests") // Ignored
+	counter.Inc(`Odd "Lynk"`)
+	gauge := NewGauge("test_connections", "Open connections.\nTwo lines.", "component")
+	gauge.Inc("server")
+	gauge.Inc("server")
+	gauge.Dec("server")
+	gauge.Set(7, "tracker")
+	gauge.Delete("tracker")
+
+	var out bytes.Buffer
+	Write(&out)
+	text := out.String()
+	if !strings.Contains(text, "# TYPE test_sent_total counter\n") ||
+		!strings.Contains(text, "test_sent_total{lynk=\"Tests\"} 11\n") {
+		t.Error("Test failed, expected the counter to be 11. Got ", text)
+	} else {
+		fmt.Println("Successfully Wrote Counter")
+		successful++
+	}
+
+	if !strings.Contains(text, "test_connections{component=\"server\"} 1\n") ||
+		strings.Contains(text, "tracker") {
+		t.Error("Test failed, expected the gauge to be 1 with tracker deleted. Got ", text)
+	} else {
+		fmt.Println("Successfully Wrote Gauge")
+		successful++
+	}
+
+	if !strings.Contains(text, `test_sent_total{lynk="Odd \"Lynk\""} 1`) ||
+		!strings.Contains(text, `# HELP test_connections Open connections.\nTwo lines.`) {
+		t.Error("Test failed, expected escaped label values and help. Got ", text)
+	} else {
+		fmt.Println("Successfully Escaped Labels And Help")
+		successful++
+	}
+}
+
+// Unit tests for gauge functions, counting readers and the handler
+// @param *testing.T t - The wrapper for the test
+func TestHandler(t *testing.T) {
+	fmt.Println("\n----------------TestHandler----------------")
+	NewGaugeFunc("test_swarm_peers", "Peers per swarm.", "lynk", func() map[string]float64 {
+		return map[string]float64{"B": 2, "A": 0.5}
+	})
+	counter := NewCounter("test_received_total", "Bytes received.", "lynk")
+	ioutil.ReadAll(counter.Reader(strings.NewReader("twelve bytes"), "Tests"))
+
+	rw := httptest.NewRecorder()
+	Handler(rw, httptest.NewRequest("GET", "/metrics", nil))
+	text := rw.Body.String()
+	if !strings.Contains(text, "test_swarm_peers{lynk=\"A\"} 0.5\ntest_swarm_peers{lynk=\"B\"} 2\n") {
+		t.Error("Test failed, expected the gauge function's values in order. Got ", text)
+	} else {
+		fmt.Println("Successfully Wrote Gauge Function")
+		successful++
+	}
+
+	if !strings.Contains(text, "test_received_total{lynk=\"Tests\"} 12\n") ||
+		!strings.HasPrefix(rw.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("Test failed, expected 12 bytes counted as text. Got ", text)
+	} else {
+		fmt.Println("Successfully Counted Bytes Read")
+		successful++
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Test failed, expected a panic for a name registered twice.")
+			} else {
+				fmt.Println("Successfully Rejected Duplicate Metric")
+				successful++
+			}
+		}()
+		NewCounter("test_received_total", "Again.")
+	}()
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
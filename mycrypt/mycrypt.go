@@ -7,7 +7,10 @@ package mycrypt
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"io"
 )
@@ -69,3 +72,131 @@ func Decrypt(key, ciphertext []byte) (plaintext []byte, err error) {
 
 	return
 }
+
+// NewDecryptReader - Decrypts a stream produced by Encrypt as it is read, so a large file can be
+// written out piece by piece instead of being held in memory
+// @param []byte key - The key the stream was encrypted with
+// @param io.Reader r - The encrypted stream, starting with its initialization vector
+// @returns io.Reader - A reader of the decrypted data
+// @returns error err - An error can be produced if a cipher cannot be created from the passed
+// in key or if the initialization vector cannot be read. Otherwise it will be nil.
+func NewDecryptReader(key []byte, r io.Reader) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err = io.ReadFull(r, iv); err != nil {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return cipher.StreamReader{S: cipher.NewCFBDecrypter(block, iv), R: r}, nil
+}
+
+// Overhead - How many bytes longer than its plain text a sealed file is
+const Overhead = aes.BlockSize
+
+// The size of the keys NewKey creates - 32 bytes selects AES-256
+const keySize = 32
+
+// NewKey - Creates a random key, such as the key of an encrypted Lynk
+// @returns []byte - The key
+// @returns error err - An error can be produced if the system has no randomness to give
+func NewKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal - Encrypts data like Encrypt, except that the initialization vector is derived from the
+// key and the data. The same data always seals to the same bytes, so every peer of an encrypted
+// Lynk describes and verifies a file by the same length and hash, and Open can tell whether the
+// right key was used.
+// @param []byte key - The key to seal the data with
+// @param []byte data - The data to seal
+// @returns []byte - The sealed data
+// @returns error err - An error can be produced if a cipher cannot be created from the key
+func Seal(key, data []byte) ([]byte, error) {
+	return seal(key, "file", data)
+}
+
+// Open - Decrypts data sealed by Seal and checks that it was sealed with the same key
+// @param []byte key - The key the data was sealed with
+// @param []byte sealed - The sealed data
+// @returns []byte - The data
+// @returns error err - An error is produced if the data was not sealed with this key
+func Open(key, sealed []byte) ([]byte, error) {
+	return open(key, "file", sealed)
+}
+
+// EncryptName - Seals the name of a file so it can be kept in a meta.info and used as the name of
+// the file on peers which do not have the key
+// @param []byte key - The key to seal the name with
+// @param string name - The name of the file
+// @returns string - The sealed name, made only of characters that are safe in a file name
+// @returns error err - An error can be produced if a cipher cannot be created from the key
+func EncryptName(key []byte, name string) (string, error) {
+	sealed, err := seal(key, "name", []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptName - Opens a name sealed by EncryptName
+// @param []byte key - The key the name was sealed with
+// @param string sealedName - The sealed name
+// @returns string - The name of the file
+// @returns error err - An error is produced if the name was not sealed with this key
+func DecryptName(key []byte, sealedName string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(sealedName)
+	if err != nil {
+		return "", errors.New("Invalid Sealed Name")
+	}
+	name, err := open(key, "name", sealed)
+	return string(name), err
+}
+
+// Helper function which seals data with an initialization vector derived from the key, the kind
+// of data and the data itself
+func seal(key []byte, kind string, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, aes.BlockSize+len(data))
+	copy(sealed, deriveIV(key, kind, data))
+	cipher.NewCFBEncrypter(block, sealed[:aes.BlockSize]).XORKeyStream(sealed[aes.BlockSize:], data)
+	return sealed, nil
+}
+
+// Helper function which opens data sealed by seal - data opened with the wrong key does not
+// derive the initialization vector it was sealed with
+func open(key []byte, kind string, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	} else if len(sealed) < aes.BlockSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	data := make([]byte, len(sealed)-aes.BlockSize)
+	cipher.NewCFBDecrypter(block, sealed[:aes.BlockSize]).XORKeyStream(data, sealed[aes.BlockSize:])
+	if !hmac.Equal(deriveIV(key, kind, data), sealed[:aes.BlockSize]) {
+		return nil, errors.New("Wrong Key")
+	}
+	return data, nil
+}
+
+// Helper function which derives an initialization vector from the key, the kind of data and the
+// data itself
+func deriveIV(key []byte, kind string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(kind + ":"))
+	mac.Write(data)
+	return mac.Sum(nil)[:aes.BlockSize]
+}
@@ -5,7 +5,10 @@
 package mycrypt
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -13,7 +16,7 @@ import (
 var successful = 0
 
 // Total # of the tests.
-const total = 3
+const total = 6
 
 // Unit tests for our Encrypt and Decrypt functions.
 // @param *testing.T t - The wrapper for the test
@@ -53,3 +56,65 @@ func TestFileCopy(t *testing.T) {
 
 	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
 }
+
+// Unit test for NewDecryptReader - decrypting a stream must give the same data as Decrypt.
+// @param *testing.T t - The wrapper for the test
+func TestDecryptReader(t *testing.T) {
+	fmt.Println("\n----------------TestDecryptReader----------------")
+
+	key := []byte("openpgp:lynxkeys")
+	plaintext := bytes.Repeat([]byte("Streamed in pieces. "), 4096)
+
+	ciphertext, err := Encrypt(key, plaintext)
+	if err != nil {
+		t.Fatal("Test failed, expected no errors. Got ", err)
+	}
+
+	r, err := NewDecryptReader(key, bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatal("Test failed, expected no errors. Got ", err)
+	}
+	streamed, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(streamed, plaintext) {
+		t.Error("Test failed, streamed plain text does not match the original")
+	} else {
+		fmt.Println("Streamed Decryption Valid")
+		successful++
+	}
+}
+
+// Unit tests for sealing the files and file names of an encrypted Lynk.
+// @param *testing.T t - The wrapper for the test
+func TestSeal(t *testing.T) {
+	fmt.Println("\n----------------TestSeal----------------")
+
+	key, _ := NewKey()
+	other, _ := NewKey()
+	plaintext := []byte("Only trusted members may read this.")
+
+	first, _ := Seal(key, plaintext)
+	second, _ := Seal(key, plaintext)
+	opened, err := Open(key, first)
+	if !bytes.Equal(first, second) || len(first) != len(plaintext)+Overhead || err != nil ||
+		!bytes.Equal(opened, plaintext) {
+		t.Error("Test failed, expected the same sealed data which opens again. Got ", err)
+	} else if _, err = Open(other, first); err == nil {
+		t.Error("Test failed, expected data sealed with another key not to open.")
+	} else {
+		fmt.Println("Successfully Sealed And Opened File")
+		successful++
+	}
+
+	sealedName, _ := EncryptName(key, "beach photo.jpg")
+	name, err := DecryptName(key, sealedName)
+	if strings.ContainsAny(sealedName, "/\\ .") || err != nil || name != "beach photo.jpg" {
+		t.Error("Test failed, expected a safe sealed name which opens again. Got ", sealedName, name, err)
+	} else if _, err = DecryptName(other, sealedName); err == nil {
+		t.Error("Test failed, expected a name sealed with another key not to open.")
+	} else {
+		fmt.Println("Successfully Sealed And Opened File Name")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
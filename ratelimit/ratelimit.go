@@ -0,0 +1,303 @@
+// Package ratelimit - This package limits how fast files are uploaded and downloaded. Every
+// transfer passes through two token buckets - one for its Lynk and one for the whole node - whose
+// rates come from the node's config and its time-of-day schedule, and from the limits kept with
+// each Lynk.
+package ratelimit
+
+import (
+	"../lynxutil"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// The directions of a transfer - the same values the events package uses
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// The most bytes read or written at once, so waits stay short and even
+const maxPiece = 32 * 1024
+
+// Bucket - A token bucket. Tokens are bytes - they fill up at the bucket's rate, at most one
+// second's worth is saved up, and a transfer waits until there are enough for what it moves.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second, 0 for unlimited
+	tokens float64 // Goes below 0 while transfers are waiting on tokens they have reserved
+	last   time.Time
+}
+
+// Status - The limits in force as the API returns them, in kilobytes per second
+type Status struct {
+	Limits     lynxutil.Limits            `json:"limits"`     // Limits of the node right now
+	Schedule   *lynxutil.LimitSchedule    `json:"schedule"`   // The schedule in force, if any
+	LynkLimits map[string]lynxutil.Limits `json:"lynkLimits"` // Limits of single Lynks by ID
+}
+
+// Guards everything below
+var mu sync.Mutex
+
+// The settings of our node - replaced by Configure before any transfer is limited
+var config = lynxutil.DefaultConfig()
+
+// The limits of each Lynk by ID - only Lynks with limits are kept
+var lynkLimits = make(map[string]lynxutil.Limits)
+
+// The buckets of the whole node by direction
+var global = map[string]*Bucket{Download: new(Bucket), Upload: new(Bucket)}
+
+// The buckets of each Lynk by ID and then direction
+var lynkBuckets = make(map[string]map[string]*Bucket)
+
+// Configure - Sets the limits and schedule transfers are held to
+// @param lynxutil.Config cfg - The config loaded by our main package
+func Configure(cfg lynxutil.Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = cfg
+}
+
+// SetLynkLimits - Changes the limits of a single Lynk. The client keeps them with the Lynk and
+// sets them again each time it loads its Lynks.
+// @param string lynkID - The ID of the Lynk
+// @param lynxutil.Limits limits - The new limits - zero values remove the limits
+// @return error - An error is produced if a limit is negative
+func SetLynkLimits(lynkID string, limits lynxutil.Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if limits == (lynxutil.Limits{}) {
+		delete(lynkLimits, lynkID)
+	} else {
+		lynkLimits[lynkID] = limits
+	}
+	return nil
+}
+
+// LynkLimits - Returns the limits of a single Lynk
+// @param string lynkID - The ID of the Lynk
+// @return lynxutil.Limits - The limits, zero if the Lynk is not limited
+func LynkLimits(lynkID string) lynxutil.Limits {
+	mu.Lock()
+	defer mu.Unlock()
+	return lynkLimits[lynkID]
+}
+
+// Current - Describes the limits in force right now
+// @return Status - The limits
+func Current() Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	limits, schedule := nodeLimits(time.Now())
+	status := Status{Limits: limits, Schedule: schedule,
+		LynkLimits: make(map[string]lynxutil.Limits)}
+	for id, limits := range lynkLimits {
+		status.LynkLimits[id] = limits
+	}
+	return status
+}
+
+// Reader - Wraps r so reading from it is held to the download limits of the node and the Lynk
+// @param context.Context ctx - Waiting stops with an error once ctx is done
+// @param string lynkID - The ID of the Lynk being downloaded
+// @param io.Reader r - The reader to limit
+// @return io.Reader - The limited reader
+func Reader(ctx context.Context, lynkID string, r io.Reader) io.Reader {
+	return &limited{ctx: ctx, direction: Download, lynk: lynkID, r: r}
+}
+
+// Writer - Wraps w so writing to it is held to the upload limits of the node and the Lynk
+// @param context.Context ctx - Waiting stops with an error once ctx is done
+// @param string lynkID - The ID of the Lynk being uploaded
+// @param io.Writer w - The writer to limit
+// @return io.Writer - The limited writer
+func Writer(ctx context.Context, lynkID string, w io.Writer) io.Writer {
+	return &limited{ctx: ctx, direction: Upload, lynk: lynkID, w: w}
+}
+
+// limited - An io.Reader or io.Writer held to the limits of a direction and Lynk
+type limited struct {
+	ctx       context.Context
+	direction string
+	lynk      string // The ID of the Lynk
+	r         io.Reader
+	w         io.Writer
+}
+
+// Read - Reads at most maxPiece bytes and then waits until the limits allow them. Waiting after
+// the read leaves the rest of the data with the sender, which slows it down.
+func (l *limited) Read(p []byte) (int, error) {
+	if len(p) > maxPiece {
+		p = p[:maxPiece]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := wait(l.ctx, l.direction, l.lynk, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Write - Waits until the limits allow each piece of p and then writes it
+func (l *limited) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + maxPiece
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := wait(l.ctx, l.direction, l.lynk, end-written); err != nil {
+			return written, err
+		}
+		n, err := l.w.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Helper function which waits until both the Lynk's and the node's bucket have n bytes to spare
+// @param context.Context ctx - Waiting stops with ctx's error once it is done
+// @param string direction - Download or Upload
+// @param string lynk - The ID of the Lynk
+// @param int n - The number of bytes
+// @return error - ctx's error if it was done before the bytes were allowed
+func wait(ctx context.Context, direction, lynk string, n int) error {
+	lynkBucket, lynkRate, globalRate := buckets(direction, lynk)
+	lynkBucket.SetRate(lynkRate)
+	global[direction].SetRate(globalRate)
+
+	if err := lynkBucket.Wait(ctx, n); err != nil {
+		return err
+	}
+	return global[direction].Wait(ctx, n)
+}
+
+// Helper function which returns the bucket of a Lynk and the rates both buckets should have now
+// @return *Bucket - The Lynk's bucket
+// @return int64 - The rate of the Lynk's bucket in bytes per second
+// @return int64 - The rate of the node's bucket in bytes per second
+func buckets(direction, lynk string) (*Bucket, int64, int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if lynkBuckets[lynk] == nil {
+		lynkBuckets[lynk] = map[string]*Bucket{Download: new(Bucket), Upload: new(Bucket)}
+	}
+	limits, _ := nodeLimits(time.Now())
+	return lynkBuckets[lynk][direction], rate(lynkLimits[lynk], direction),
+		rate(limits, direction)
+}
+
+// Helper function which returns the limits of the node at the given time - those of the first
+// schedule in force, otherwise the node's own limits. mu must be held.
+func nodeLimits(t time.Time) (lynxutil.Limits, *lynxutil.LimitSchedule) {
+	for i := range config.Schedule {
+		if config.Schedule[i].Active(t) {
+			schedule := config.Schedule[i]
+			return schedule.Limits, &schedule
+		}
+	}
+	return config.Limits, nil
+}
+
+// Helper function which converts the limit of a direction to bytes per second
+func rate(limits lynxutil.Limits, direction string) int64 {
+	if direction == Upload {
+		return int64(limits.Upload) * 1024
+	}
+	return int64(limits.Download) * 1024
+}
+
+// NewBucket - Returns a bucket which starts with a second's worth of tokens, so whatever it limits
+// is not held up before it has used any
+// @param int64 rate - The rate in tokens per second, 0 for unlimited
+// @return *Bucket - The bucket
+func NewBucket(rate int64) *Bucket {
+	return &Bucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// SetRate - Changes the rate of the bucket
+// @param int64 rate - The new rate in bytes per second, 0 for unlimited
+func (b *Bucket) SetRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if float64(rate) == b.rate {
+		return
+	}
+	b.rate = float64(rate)
+	b.tokens = 0 // Nothing saved up under the old rate carries over
+	b.last = time.Now()
+}
+
+// Take - Takes n tokens from the bucket if it has them, without waiting
+// @param int n - The number of tokens
+// @return bool - Whether or not the tokens were taken - nothing is taken if they were not
+func (b *Bucket) Take(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return true // Unlimited
+	}
+	b.fill(time.Now())
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Helper function which fills in the tokens saved up since the bucket was last used. b.mu must be
+// held.
+// @param time.Time now - The time right now
+func (b *Bucket) fill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate // At most a second's worth is saved up
+	}
+	b.last = now
+}
+
+// Wait - Takes n tokens from the bucket, waiting until they have been filled in
+// @param context.Context ctx - Waiting stops with ctx's error once it is done
+// @param int n - The number of tokens
+// @return error - ctx's error if it was done before the tokens were there
+func (b *Bucket) Wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil // Unlimited
+	}
+
+	b.fill(time.Now())
+
+	// The tokens are reserved now - if there are not enough we wait until they are filled in
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.tokens += float64(n) // Hands back what we did not use
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+// The unit tests for our bandwidth limits
+package ratelimit
+
+import (
+	"../lynxutil"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 5
+
+// Unit tests for holding a transfer to its limits.
+// @param *testing.T t - The wrapper for the test
+func TestLimits(t *testing.T) {
+	fmt.Println("\n----------------TestLimits----------------")
+
+	Configure(lynxutil.DefaultConfig())
+	SetLynkLimits("Slow", lynxutil.Limits{Download: 100})
+
+	// 100KB/s with nothing saved up - 50KB takes about half a second
+	start := time.Now()
+	r := Reader(context.Background(), "Slow", bytes.NewReader(make([]byte, 50*1024)))
+	data, _ := ioutil.ReadAll(r)
+	if elapsed := time.Since(start); len(data) != 50*1024 || elapsed < 400*time.Millisecond {
+		t.Error("Test failed, expected the download to be limited. Took ", elapsed)
+	} else {
+		fmt.Println("Successfully Limited Download")
+		successful++
+	}
+
+	start = time.Now()
+	var buf bytes.Buffer
+	Writer(context.Background(), "Slow", &buf).Write(make([]byte, 1024*1024))
+	if elapsed := time.Since(start); buf.Len() != 1024*1024 || elapsed > 200*time.Millisecond {
+		t.Error("Test failed, expected uploads of the Lynk to be unlimited. Took ", elapsed)
+	} else {
+		fmt.Println("Successfully Left Upload Unlimited")
+		successful++
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := Reader(ctx, "Slow", bytes.NewReader(make([]byte, 1024*1024))).Read(make([]byte, 64*1024))
+	if err != context.DeadlineExceeded {
+		t.Error("Test failed, expected the wait to stop with the context. Got ", err)
+	} else {
+		fmt.Println("Successfully Stopped Waiting On Context")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
+
+// Unit tests for schedules replacing the node's limits.
+// @param *testing.T t - The wrapper for the test
+func TestSchedule(t *testing.T) {
+	fmt.Println("\n----------------TestSchedule----------------")
+
+	cfg := lynxutil.DefaultConfig()
+	cfg.Limits = lynxutil.Limits{Upload: 50, Download: 50}
+	cfg.Schedule = []lynxutil.LimitSchedule{{From: "22:00", To: "06:00"}}
+	Configure(cfg)
+
+	night := time.Date(2026, 10, 19, 23, 30, 0, 0, time.Local)
+	morning := time.Date(2026, 10, 20, 6, 0, 0, 0, time.Local)
+	nightLimits, schedule := nodeLimits(night)
+	dayLimits, _ := nodeLimits(morning)
+	if nightLimits.Upload != 0 || schedule == nil || dayLimits.Upload != 50 {
+		t.Error("Test failed, expected no limits overnight only. Got ", nightLimits, dayLimits)
+	} else {
+		fmt.Println("Successfully Applied Schedule Across Midnight")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
+
+// Unit tests for taking tokens from a bucket without waiting.
+// @param *testing.T t - The wrapper for the test
+func TestTake(t *testing.T) {
+	fmt.Println("\n----------------TestTake----------------")
+
+	// Starts with a second's worth - 3 tokens - and only refills one every third of a second
+	b := NewBucket(3)
+	taken := b.Take(1) && b.Take(2)
+	empty := b.Take(1)
+	b.SetRate(0)
+	if !taken || empty || !b.Take(1000) {
+		t.Error("Test failed, expected 3 tokens and then none until unlimited. Got ", taken, empty)
+	} else {
+		fmt.Println("Successfully Took Tokens")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -2,5 +2,5 @@
 
 cd guiserver
 echo Starting Lynx...
-go run guiserver.go
+go run guiserver.go "$@"
 exit
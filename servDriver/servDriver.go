@@ -4,9 +4,28 @@
 // @verison: 2/17/2016
 package main
 
-import "capstone/server"
+import (
+	"capstone/client"
+	"capstone/logs"
+	"capstone/lynxutil"
+	"capstone/ratelimit"
+	"capstone/server"
+	"fmt"
+	"os"
+)
 
 // Function used to drive and test our server's functions
 func main() {
+	config, err := lynxutil.LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := logs.Configure(config); err != nil {
+		fmt.Println(err)
+	}
+	client.Configure(config)
+	server.Configure(config)
+	ratelimit.Configure(config)
 	server.Listen()
 }
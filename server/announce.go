@@ -0,0 +1,57 @@
+// Package server - This file is responsible for announcing the Lynks we host to other peers on
+// our local network so they can find us without a tracker.
+package server
+
+import (
+	"../client"
+	"../lynxutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// How often we announce our Lynks to the local network
+const announceInterval = 30 * time.Second
+
+// AnnounceLAN - Multicasts an announcement of every Lynk we host to the local network every
+// announceInterval. Lynks are identified by their infohash rather than their name or ID so we
+// don't leak either to everyone on the subnet - the ID is all it takes to ask a tracker for a
+// Lynk. This function does not return unless the multicast group cannot be reached.
+// @return error - An error is produced if we cannot send to the multicast group
+func AnnounceLAN() error {
+	group, err := net.ResolveUDPAddr("udp4", lynxutil.LANGroup)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	conn, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		logger.Error("Could Not Join LAN Multicast Group: " + err.Error())
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		announcement := lanAnnouncement()
+		if announcement != "" {
+			conn.Write([]byte(announcement))
+		}
+		time.Sleep(announceInterval)
+	}
+}
+
+// Helper function which builds our announcement.
+// Syntax is "Lynk_Announce:<ServerPort>:<InfoHash>,<InfoHash>\n"
+// @return string - The announcement, or an empty string if we have no Lynks to announce
+func lanAnnouncement() string {
+	var hashes []string
+	for _, lynk := range client.GetLynks() {
+		hashes = append(hashes, lynxutil.LynkInfoHash(lynk.ID).String())
+	}
+
+	if len(hashes) == 0 {
+		return ""
+	}
+	return "Lynk_Announce:" + config.ServerPort + ":" + strings.Join(hashes, ",") + "\n"
+}
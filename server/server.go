@@ -8,27 +8,47 @@ import (
 	"bufio"
 	"bytes"
 	"../client"
+	"../events"
+	"../jobs"
+	"../logs"
 	"../lynxutil"
+	"../metrics"
 	"../mycrypt"
+	"../ratelimit"
 	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
-	//"path/filepath"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 	//"path/filepath"
 	//"path/filepath"
 )
 
-// Holds the currentLynk being worked on in an update
-var currentLynk *lynxutil.Lynk
+// The size of the pieces a file is written to a peer in
+const sendChunk = 32 * 1024
+
+// The settings of our node - replaced by Configure before the server is used
+var config = lynxutil.DefaultConfig()
+
+// Writes the server's log entries
+var logger = logs.New(logs.Server)
+
+// Configure - Sets the ports and home directory the server uses
+// @param lynxutil.Config cfg - The config loaded by our main package
+func Configure(cfg lynxutil.Config) {
+	config = cfg
+}
 
 // Listen - Calls lynxutil to create a welcomeSocket that listens for TCP connections - once
 // someone connects a goroutine is spawned to handle the request
 func Listen() {
-	lynxutil.Listen(handleFileRequest, lynxutil.ServerPort)
+	lynxutil.Listen(handleFileRequest, config.ServerPort)
 }
 
 // handleFileRequest - Handles a file request sent by another peer - this involves checking to see
@@ -37,13 +57,18 @@ func Listen() {
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
 func handleFileRequest(conn net.Conn) error {
-	request, err := bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
+	metrics.ActiveConnections.Inc(logs.Server)
+	defer metrics.ActiveConnections.Dec(logs.Server)
+
+	reader := bufio.NewReader(conn)
+	request, err := reader.ReadString('\n') // Waits for a String ending in newline
 	if err != nil {
 		return err
 	}
 
 	// Will handle tracker request & receiving of Meta
-	tmpArr := strings.Split(request, ":")
+	tmpArr := strings.SplitN(request, ":", 2)
+	metrics.Requests.Inc(logs.Server, messageType(tmpArr[0]))
 	if len(tmpArr) != 2 {
 		conn.Close()
 		return errors.New("Invalid Request Syntax")
@@ -51,20 +76,37 @@ func handleFileRequest(conn net.Conn) error {
 
 	if tmpArr[0] == "Meta_Push" {
 		handlePush(request, conn)
+	} else if tmpArr[0] == "Peer_Exchange" {
+		handlePeerExchange(strings.TrimSpace(tmpArr[1]), reader, conn)
 	} else {
 		fileReq := tmpArr[1] // Gets the name of requested file
 		fileReq = strings.TrimSpace(fileReq)
 
-		//fmt.Println("Asked for " + fileReq)
+		// A paused download asks "Resume_FileName:<Offset>:<LynkID>/<FileName>" to get only
+		// the part of the file it does not have yet
+		offset := int64(0)
+		if tmpArr[0] == "Resume_FileName" {
+			resumeArr := strings.SplitN(fileReq, ":", 2)
+			if len(resumeArr) == 2 {
+				offset, err = strconv.ParseInt(resumeArr[0], 10, 64)
+			}
+			if len(resumeArr) != 2 || err != nil || offset < 0 {
+				conn.Close()
+				return errors.New("Invalid Request Syntax")
+			}
+			fileReq = resumeArr[1]
+		}
+		fileReq = localPath(fileReq)
 
 		haveFile := client.HaveFile(fileReq)
-		//fmt.Println(haveFile)
+		logger.Peer(conn.RemoteAddr().String()).Debug("Asked For " + fileReq + ", Have It: " +
+			strconv.FormatBool(haveFile))
 
 		// Depending on if we have the file - we write back to our client accordingly
 		if haveFile {
-			fmt.Fprintf(conn, "YES\n")    // Reply
-			err = sendFile(fileReq, conn) // Sending The File
+			err = sendFile(fileReq, offset, conn) // Replies "YES:<Length>" and sends the file
 			if err != nil {
+				conn.Close()
 				return err
 			}
 		} else {
@@ -76,6 +118,31 @@ func handleFileRequest(conn net.Conn) error {
 	return conn.Close()
 }
 
+// Helper function which turns the "<LynkID>/<FileName>" a peer asks for into the
+// "<LynkName>/<FileName>" of our copy. Peers running older versions ask with the lynk's name.
+// @param string fileReq - The file the peer asked for
+// @return string - Our path for the file from the root of the Lynx directory
+func localPath(fileReq string) string {
+	if i := strings.Index(fileReq, "/"); i >= 0 {
+		if lynkName, ok := client.ResolveLynk(fileReq[:i]); ok {
+			return lynkName + fileReq[i:]
+		}
+	}
+	return fileReq
+}
+
+// Helper function which returns the message type a request is counted under - anything we do not
+// know is counted as "other" so peers cannot make up new series
+// @param string name - The part of the request before the first ":"
+// @return string - The message type
+func messageType(name string) string {
+	switch name {
+	case "Meta_Push", "Peer_Exchange", "Do_You_Have_FileName", "Resume_FileName":
+		return name
+	}
+	return "other"
+}
+
 // handleTrackerRequest - Handles a tracker request sent by another peer - this involves opening
 // the meta.info file and passing the requesting peer the IP address stored inside.
 // @param string request - The request the client made
@@ -89,13 +156,12 @@ func handleTrackerRequest(request string, conn net.Conn) error {
 		return errors.New("Invalid Request Syntax")
 	}
 
-	mPath := lynxutil.HomePath + tmpArr[1] + "meta.info"
-	mPath = strings.TrimSpace(mPath)
+	mPath := client.MetaPath(strings.TrimSpace(tmpArr[1]))
 
 	mFile, _ := os.Open(mPath)
 	scanner := bufio.NewScanner(mFile)
 	ip := (strings.Split(scanner.Text(), ":::"))[1] // Splits first line of metainfo and gets the IP
-	fmt.Fprintf(conn, ip+"\n")
+	fmt.Fprint(conn, ip+"\n")
 
 	return nil
 }
@@ -106,66 +172,193 @@ func handleTrackerRequest(request string, conn net.Conn) error {
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
 func handlePush(request string, conn net.Conn) error {
-	// Client syntax for push is "Meta_Push:<LynkName>\n"
-	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkName>
+	// Client syntax for push is "Meta_Push:<LynkID>\n"
+	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkID>
 	tmpArr := strings.Split(request, ":")
 	if len(tmpArr) != 2 {
 		conn.Close()
 		return errors.New("Invalid Request Syntax")
 	}
 
-	lynkName := strings.TrimSpace(tmpArr[1])
-	metaPath := lynxutil.HomePath + lynkName + "/meta.info"
-
-	bufIn, err := ioutil.ReadAll(conn)
+	lynkName, ok := client.ResolveLynk(strings.TrimSpace(tmpArr[1]))
+	if !ok {
+		conn.Close()
+		return errors.New("Lynk Not Found")
+	}
+	metaPath := client.MetaPath(lynkName)
 
-	//fmt.Println(request, "SERVER BUFIN:", len(bufIn))
+	log := logger.Lynk(lynkName).Peer(conn.RemoteAddr().String())
+	lynk, _ := client.GetLynk(lynkName)
+	if lynk.Mode == lynxutil.SendOnly {
+		log.Info("Ignored Pushed meta.info - The Lynk Is Send-Only")
+		return conn.Close()
+	}
+	// A meta.info larger than any we accept is refused before it is decrypted or decompressed
+	bufIn, err := ioutil.ReadAll(io.LimitReader(conn, lynxutil.MaxMetaSize+1))
+	if err != nil {
+		return err
+	} else if len(bufIn) > lynxutil.MaxMetaSize {
+		log.Warn("Ignored Pushed meta.info - It Is Too Large")
+		return errors.New("meta.info Is Too Large")
+	}
 
 	// Decrypt
 	key := []byte(lynxutil.PrivateKey)
 	var plainFile []byte
 	if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
+		metrics.DecryptFailures.Inc(logs.Server)
+		log.Warn("Could Not Decrypt Pushed meta.info: " + err.Error())
 		return err
 	}
 
-	// Decompress
-	r, _ := gzip.NewReader(bytes.NewBuffer(plainFile))
-	bufOut, _ := ioutil.ReadAll(r)
-	r.Read(bufOut)
+	// Decompress - a meta.info encrypted with another key decrypts to something that is not gzip
+	r, err := gzip.NewReader(bytes.NewBuffer(plainFile))
+	if err != nil {
+		metrics.DecryptFailures.Inc(logs.Server)
+		log.Warn("Could Not Decrypt Pushed meta.info: " + err.Error())
+		return err
+	}
+	bufOut, err := ioutil.ReadAll(io.LimitReader(r, lynxutil.MaxMetaSize+1))
 	r.Close()
-
-	// Creates the new meta.info
-	newMetainfo, err := os.Create(metaPath)
 	if err != nil {
-		fmt.Println("PUSH ERROR: " + err.Error())
+		return err
+	} else if len(bufOut) > lynxutil.MaxMetaSize {
+		log.Warn("Ignored Pushed meta.info - It Is Too Large")
+		return errors.New("meta.info Is Too Large")
+	}
+
+	// A pushed meta.info must be for the Lynk it was pushed to and keep its owner, and a peer which
+	// has not seen the latest meta.info must not roll the Lynk back
+	pushed, err := lynxutil.ParseMetaHeader(bufOut)
+	if err != nil || pushed.ID != lynk.ID {
+		log.Warn("Ignored Pushed meta.info - It Is For Another Lynk")
+		return errors.New("meta.info Is For Another Lynk")
+	}
+	if current, err := lynxutil.ReadMetaHeader(metaPath); err == nil {
+		if err = lynxutil.CheckPush(pushed, current); err != nil {
+			log.Warn("Ignored Pushed meta.info: " + err.Error())
+			return err
+		}
+	}
+
+	// Replaces the old meta.info in a single step
+	if err = lynxutil.WriteFileAtomic(metaPath, bufOut, 0644); err != nil {
+		log.Error("Could Not Write Pushed meta.info: " + err.Error())
 		return err
 	}
 
-	//fmt.Println(len(bufIn), "Bytes Received IN META")
-	//fmt.Println(bufOut)
-	newMetainfo.Write(bufOut)
+	log.Info("Received New meta.info")
 	client.ParseMetainfo(metaPath)
 
-	// Sets currentLynk so it can be used in rmFiles
-	currentLynk = lynxutil.GetLynk(client.GetLynks(), lynkName)
 	// Removes files that are no longer in meta.info
-	//filepath.Walk(lynxutil.HomePath+lynkName, rmFiles)
+	//currentLynk, _ := client.GetLynk(lynkName)
+	//filepath.Walk(config.HomePath+lynkName, rmFiles(currentLynk))
 
 	client.UpdateLynk(lynkName)
 	return nil // No errors if we reached this point
 }
 
-// Sends a file across the network to a peer.
+// Helper function for handleFileRequest - handles the case where a peer wants to exchange peer
+// lists for a Lynk with us.
+// @param string infohash - The ID of the Lynk the peer is asking about
+// @param *bufio.Reader reader - The reader holding the rest of the peer's request
+// @param net.Conn conn - The socket which the client is asking on
+// @return error - An error can be produced if we do not have the Lynk
+func handlePeerExchange(infohash string, reader *bufio.Reader, conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(lynxutil.PEXTimeout))
+
+	received := client.ReadPEXPeers(reader)
+	reply, err := client.HandlePeerExchange(infohash, received)
+	if err != nil {
+		return err
+	}
+
+	client.WritePEXPeers(conn, reply)
+	return nil
+}
+
+// Sends a file across the network to a peer, preceded by the reply "YES:<Length>\n". The upload
+// is a job so it can be paused - it then waits between pieces - or cancelled, and publishes its
+// progress.
 // @param string fileName - The name of the file to send to the peer. It will have path from root
 // of Lynx Directory.
+// @param int64 offset - How many bytes of the file the peer already has - 0 unless it is resuming
 // @param net.Conn conn - The socket over which we will send the file
 // @return error - An error can be produced when trying open a file or write over
 // the network - otherwise error will be nil.
-func sendFile(fileName string, conn net.Conn) error {
-	//fmt.Println(fileName)
+func sendFile(fileName string, offset int64, conn net.Conn) error {
+	cipherFile, err := encodeFile(fileName, offset, conn)
+	if err != nil {
+		return err
+	}
+
+	// Tells the peer how many bytes are coming so it can show its progress
+	fmt.Fprint(conn, "YES:"+strconv.Itoa(len(cipherFile))+"\n")
+
+	// fileName is "<LynkName>/<FileName>"
+	lynkName, name := fileName, fileName
+	if i := strings.Index(fileName, "/"); i >= 0 {
+		lynkName, name = fileName[:i], fileName[i+1:]
+	}
+	lynk, _ := client.GetLynk(lynkName) // Its bandwidth limits are kept by its ID
+	log := logger.Lynk(lynkName).Peer(conn.RemoteAddr().String())
+	log.Info("Sending " + name)
+	job := jobs.New(events.Upload, lynkName, name)
+	transfer := events.Start(job.ID, events.Upload, lynkName, name, conn.RemoteAddr().String(),
+		int64(len(cipherFile)))
+
+	// Sent in pieces so the transfer's progress can be followed and it can be paused in between.
+	// Pausing interrupts a wait for the bandwidth limits - the piece is then sent once resumed.
+	for sent := 0; sent < len(cipherFile); {
+		if job.State() == jobs.Paused {
+			transfer.Pause()
+		}
+		if err = job.Wait(); err != nil {
+			log.Info("Cancelled " + name)
+			transfer.End(events.Cancelled)
+			job.Finish(err)
+			return err
+		}
+
+		end := sent + sendChunk
+		if end > len(cipherFile) {
+			end = len(cipherFile)
+		}
+		w := transfer.Writer(ratelimit.Writer(job.Context(), lynk.ID, conn))
+		n, err := w.Write(cipherFile[sent:end])
+		sent += n
+		metrics.BytesSent.Add(float64(n), lynkName)
+		if err != nil && job.Err() == nil {
+			log.Warn("Could Not Send " + name + ": " + err.Error())
+			transfer.Fail(err)
+			job.Finish(err)
+			return err
+		}
+	}
+	transfer.Finish()
+	job.Finish(nil)
+	log.Info("Sent " + name)
 
+	return nil // No Errors occurred If We Reached Here
+}
+
+// Helper function which reads a file from the given offset on, then compresses and encrypts it.
+// Files of encrypted lynks are read sealed, so the offset is into the sealed file.
+// @param string fileName - The name of the file, with path from root of Lynx Directory
+// @param int64 offset - The number of bytes at the start of the file to skip
+// @param net.Conn conn - The socket the file will be sent over
+// @return []byte - The compressed and encrypted file
+// @return error - An error can be produced if the file cannot be read or encrypted
+func encodeFile(fileName string, offset int64, conn net.Conn) ([]byte, error) {
 	// Can use read when implementing chunking
-	fBytes, err := ioutil.ReadFile(lynxutil.HomePath + fileName)
+	fBytes, err := client.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > int64(len(fBytes)) {
+		return nil, errors.New("Invalid Resume Offset")
+	}
+	fBytes = fBytes[offset:]
 	//fmt.Println("File Contents: ", string(fBytes))
 
 	// Begin Compression
@@ -175,69 +368,87 @@ func sendFile(fileName string, conn net.Conn) error {
 	gz.Close()
 	// End Compression
 
-	// Begin Encryption
-	var cipherFile []byte
+	// Encryption
 	publicKey := lynxutil.Peer{IP: conn.LocalAddr().String()}.Key + lynxutil.PrivateKey
 	key := []byte(publicKey)
-	if cipherFile, err = mycrypt.Encrypt(key, b.Bytes()); err != nil {
-		return err
-	}
-	// End Encryption
+	return mycrypt.Encrypt(key, b.Bytes())
+}
 
-	_, err = conn.Write(cipherFile)
-	if err != nil {
-		return err
+// SyncLocalChanges - Checks each of our lynks for files that have been added or changed and
+// pushes a new meta.info for every lynk that has. Paused lynks are skipped, and receive-only
+// lynks undo their local changes instead.
+func SyncLocalChanges() {
+	for _, lynk := range client.GetLynks() {
+		if lynk.Paused {
+			continue
+		} else if lynk.Mode == lynxutil.ReceiveOnly {
+			client.RevertLocalChanges(lynk.Name)
+		} else if client.HasLocalChanges(lynk) {
+			client.CreateMeta(lynk.Name)
+			PushMeta(client.MetaPath(lynk.Name))
+		}
 	}
-
-	//fmt.Println(n, "Bytes were sent")
-
-	return nil // No Errors occurred If We Reached Here
 }
 
 // PushMeta - Sends the meta.info file to the tracker. Gets the tracker IP from the client.
+// Receive-only lynks are never pushed.
 // @param string metaPath - The meta.info path associated with the lynk we're interested in
 // @return error - An error can be produced when trying to connect to the tracker
-// over the network - otherwise error will be nil.
+// over the network, or if the lynk is receive-only - otherwise error will be nil.
 func PushMeta(metaPath string) error {
 	trackerIP := client.GetTracker(metaPath)
+	lynkName := client.GetLynkName(metaPath)
+	log := logger.Lynk(lynkName).Peer(trackerIP)
+	lynk, _ := client.GetLynk(lynkName)
+	if lynk.Mode == lynxutil.ReceiveOnly {
+		log.Warn("Did Not Push meta.info - The Lynk Is Receive-Only")
+		return errors.New("Lynk Is Receive-Only")
+	}
 	conn, err := net.Dial("tcp", trackerIP)
 	if err != nil {
-		fmt.Println(err)
+		log.Warn("Could Not Reach Tracker: " + err.Error())
 		return err
 	}
 
-	lynkName := client.GetLynkName(metaPath)
-	fmt.Fprintf(conn, "Meta_Push:"+lynkName+"\n") // Lets tracker know we are pushing
+	fmt.Fprint(conn, "Meta_Push:"+lynk.ID+"\n") // Lets tracker know we are pushing
 
-	err = sendFile(lynkName+"/meta.info", conn)
+	// The tracker reads everything up to the end of the connection as the meta.info
+	cipherFile, err := encodeFile(lynkName+"/meta.info", 0, conn)
+	if err == nil {
+		_, err = conn.Write(cipherFile)
+	}
 
 	if err != nil {
-		fmt.Println(err)
+		log.Warn("Could Not Push meta.info: " + err.Error())
 		return err
 	}
 
+	log.Info("Pushed meta.info To Tracker")
 	return conn.Close()
 }
 
-// Function which removes a file from a directory if it's not in the Lynk's files array
-// @param path string - the path where the root directory is located
-// @param file os.FileInfo - each file within the root or inner directories
-// @param err error - any error we way encounter along the way
-// @return error - An error can produced if we encounter an invalid file.
-func rmFiles(path string, file os.FileInfo, err error) error {
-
-	inMeta := false
-	for _, f := range currentLynk.Files {
-		if f.Name == file.Name() {
-			inMeta = true
+// Function which returns a walk function that removes a file from a directory if it's not in the
+// Lynk's files array
+// @param currentLynk lynxutil.Lynk - the Lynk being worked on in an update
+// @return filepath.WalkFunc - the walk function, whose params are:
+// path string - the path where the root directory is located
+// file os.FileInfo - each file within the root or inner directories
+// err error - any error we way encounter along the way
+func rmFiles(currentLynk lynxutil.Lynk) filepath.WalkFunc {
+	return func(path string, file os.FileInfo, err error) error {
+		inMeta := false
+		for _, f := range currentLynk.Files {
+			if f.Name == file.Name() {
+				inMeta = true
+			}
+		}
+
+		// Don't add directories or a meta.info file to the new meta.info
+		if !file.IsDir() && file.Name() != "meta.info" && !inMeta && !lynxutil.IsTempFile(file.Name()) {
+			//fmt.Println("Removing ", file.Name())
+			os.Remove(path)
 		}
-	}
 
-	// Don't add directories, trackers, or a meta.info file to the new meta.info
-	if !file.IsDir() && !strings.Contains(path, "_Tracker") && file.Name() != "meta.info" && !inMeta {
-		//fmt.Println("Removing ", file.Name())
-		os.Remove(path)
+		return nil
 	}
-
-	return nil
 }
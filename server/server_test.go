@@ -7,6 +7,7 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"capstone/client"
 	"capstone/lynxutil"
 	"capstone/mycrypt"
 	"compress/gzip"
@@ -23,7 +24,53 @@ import (
 var successful = 0
 
 // Total # of the tests.
-const total = 6
+const total = 8
+
+// Unit tests for the announcement of our Lynks to the local network
+// @param *testing.T t - The wrapper for the test
+func TestLANAnnouncement(t *testing.T) {
+	fmt.Println("\n----------------TestLANAnnouncement----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	cfg.ServerPort = "8090"
+	client.Configure(cfg)
+	defer client.Configure(old)
+	Configure(cfg)
+
+	if announcement := lanAnnouncement(); announcement != "" {
+		t.Error("Test failed, expected nothing to announce without Lynks. Got ", announcement)
+	} else {
+		fmt.Println("Successfully Announced Nothing")
+		successful++
+	}
+
+	os.Mkdir(home+"/Photos", 0755)
+	os.Mkdir(home+"/Music", 0755)
+	client.CreateMeta("Photos")
+	client.CreateMeta("Music")
+	var hashes []string
+	leaked := false
+	announcement := lanAnnouncement()
+	for _, lynk := range client.GetLynks() {
+		hashes = append(hashes, lynxutil.LynkInfoHash(lynk.ID).String())
+		leaked = leaked || strings.Contains(announcement, lynk.ID)
+	}
+	if len(hashes) != 2 || announcement != "Lynk_Announce:8090:"+strings.Join(hashes, ",")+"\n" ||
+		strings.Contains(announcement, "Photos") || leaked {
+		t.Error("Test failed, expected our port and the infohashes of our Lynks. Got ", announcement)
+	} else {
+		fmt.Println("Successfully Announced Lynk Infohashes")
+		successful++
+	}
+}
 
 // Unit tests for listen, handle, and send functions as well as push meta
 // @param *testing.T t - The wrapper for the test
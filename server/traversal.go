@@ -0,0 +1,41 @@
+// Package server - This file keeps us registered with the trackers of our Lynks so peers that
+// cannot reach us directly, because we are behind a NAT, can still connect to us.
+package server
+
+import (
+	"../client"
+	"../lynxutil"
+	"../transport"
+	"net"
+	"time"
+)
+
+// How often we check whether we have joined a Lynk with a tracker we are not registered with
+const traversalInterval = time.Minute
+
+// ListenTraversal - Registers with the tracker of every Lynk we hold. Connections punched or
+// relayed through a tracker are handled exactly like ones made straight to our server. This
+// function does not return.
+func ListenTraversal() {
+	listeners := make(map[string]*transport.Listener)
+	for {
+		for _, lynk := range client.GetLynks() {
+			if _, _, err := net.SplitHostPort(lynk.Tracker); err != nil {
+				continue // DHT only Lynks have no tracker to register with
+			}
+			if listeners[lynk.Tracker] != nil {
+				continue
+			}
+
+			l, err := transport.Listen(lynk.Tracker, lynxutil.GetAddrs(config.ServerPort),
+				handleFileRequest)
+			if err != nil {
+				logger.Lynk(lynk.Name).Peer(lynk.Tracker).Warn("Could Not Register With Tracker: " +
+					err.Error())
+				continue
+			}
+			listeners[lynk.Tracker] = l
+		}
+		time.Sleep(traversalInterval)
+	}
+}
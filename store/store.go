@@ -0,0 +1,234 @@
+// Package store - This package keeps the state of our Lynks in an embedded transactional database.
+// Lynk registrations, the files of each Lynk and the peers we know for it are written in single
+// transactions, so a crash can never leave them half written the way rewriting lynks.txt could.
+package store
+
+import (
+	"../lynxutil"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FileName - The name of the database file kept in the home directory
+const FileName = "lynx.db"
+
+// The buckets of the database
+var (
+	lynksBucket = []byte("lynks") // Lynk name -> lynkRecord
+	filesBucket = []byte("files") // Lynk name -> bucket of file name -> lynxutil.File
+	peersBucket = []byte("peers") // Lynk name -> bucket of host:port -> lynxutil.Peer
+	metaBucket  = []byte("meta")  // Facts about the database itself
+)
+
+// The key in metaBucket set once lynks.txt has been migrated
+var migratedKey = []byte("migrated")
+
+// How long Open waits for another process holding the database
+const openTimeout = time.Second
+
+// Store - An open database
+type Store struct {
+	db *bolt.DB
+}
+
+// lynkRecord - The part of a Lynk which is stored under its name
+type lynkRecord struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Root    string `json:"root,omitempty"` // Empty for a Lynk kept in the home directory
+	Owner   string `json:"owner"`
+	Synced  string `json:"synced"`
+	Tracker string `json:"tracker"`
+	Paused  bool   `json:"paused"`
+
+	Selection lynxutil.Selection `json:"selection"`
+	Mode      string             `json:"mode"`
+	Limits    lynxutil.Limits    `json:"limits"`
+
+	Encrypted bool   `json:"encrypted"`
+	Key       []byte `json:"key,omitempty"` // Only kept on trusted members of an encrypted Lynk
+	Revision  int    `json:"revision,omitempty"`
+}
+
+// Open - Opens the database at path, creating it and its buckets if needed
+// @param string path - The path of the database file
+// @return *Store - The open database
+// @return error - An error is produced if the database cannot be opened - for example because
+// another process has it open
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{lynksBucket, filesBucket, peersBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close - Closes the database
+// @return error - An error is produced if the database could not be flushed
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Migrated - Returns whether or not lynks.txt has already been migrated into the database
+func (s *Store) Migrated() bool {
+	migrated := false
+	s.db.View(func(tx *bolt.Tx) error {
+		migrated = tx.Bucket(metaBucket).Get(migratedKey) != nil
+		return nil
+	})
+	return migrated
+}
+
+// Migrate - Saves every given Lynk and marks the database migrated in one transaction, so the
+// migration either happens completely or not at all
+// @param []lynxutil.Lynk lynks - The Lynks read from the old files
+// @return error - An error is produced if the transaction failed
+func (s *Store) Migrate(lynks []lynxutil.Lynk) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, lynk := range lynks {
+			if err := putLynk(tx, lynk); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(metaBucket).Put(migratedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+// Lynks - Loads every stored Lynk along with its files and peers
+// @return []lynxutil.Lynk - The Lynks, ordered by name
+// @return error - An error is produced if a record cannot be read
+func (s *Store) Lynks() ([]lynxutil.Lynk, error) {
+	var lynks []lynxutil.Lynk
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(lynksBucket).ForEach(func(name, value []byte) error {
+			var record lynkRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return errors.New("Invalid Record For Lynk " + string(name))
+			}
+			lynk := lynxutil.Lynk{Name: record.Name, ID: record.ID, Root: record.Root,
+				Owner: record.Owner, Synced: record.Synced, Tracker: record.Tracker,
+				Paused: record.Paused, Selection: record.Selection, Mode: record.Mode,
+				Limits: record.Limits, Encrypted: record.Encrypted, Key: record.Key,
+				Revision: record.Revision}
+			if lynk.Mode == "" {
+				lynk.Mode = lynxutil.TwoWay // Lynks stored before there were modes
+			}
+
+			err := forEach(tx.Bucket(filesBucket).Bucket(name), func(value []byte) error {
+				var file lynxutil.File
+				err := json.Unmarshal(value, &file)
+				lynk.Files = append(lynk.Files, file)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			err = forEach(tx.Bucket(peersBucket).Bucket(name), func(value []byte) error {
+				var peer lynxutil.Peer
+				err := json.Unmarshal(value, &peer)
+				lynk.Peers = append(lynk.Peers, peer)
+				return err
+			})
+			lynks = append(lynks, lynk)
+			return err
+		})
+	})
+	return lynks, err
+}
+
+// SaveLynk - Replaces the stored registration, files and peers of a Lynk in one transaction
+// @param lynxutil.Lynk lynk - The Lynk to save
+// @return error - An error is produced if the transaction failed
+func (s *Store) SaveLynk(lynk lynxutil.Lynk) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putLynk(tx, lynk)
+	})
+}
+
+// DeleteLynk - Removes a Lynk along with its files and peers
+// @param string name - The name of the Lynk
+// @return error - An error is produced if the transaction failed
+func (s *Store) DeleteLynk(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteLynk(tx, []byte(name))
+	})
+}
+
+// Helper function which writes a Lynk within a transaction, replacing whatever was stored for it
+func putLynk(tx *bolt.Tx, lynk lynxutil.Lynk) error {
+	if lynk.Name == "" {
+		return errors.New("Lynk Has No Name")
+	}
+	name := []byte(lynk.Name)
+	if err := deleteLynk(tx, name); err != nil {
+		return err
+	}
+
+	record, _ := json.Marshal(lynkRecord{lynk.Name, lynk.ID, lynk.Root, lynk.Owner, lynk.Synced,
+		lynk.Tracker, lynk.Paused, lynk.Selection, lynk.Mode, lynk.Limits, lynk.Encrypted, lynk.Key,
+		lynk.Revision})
+	if err := tx.Bucket(lynksBucket).Put(name, record); err != nil {
+		return err
+	}
+
+	files, err := tx.Bucket(filesBucket).CreateBucket(name)
+	if err != nil {
+		return err
+	}
+	for _, file := range lynk.Files {
+		value, _ := json.Marshal(file)
+		if err := files.Put([]byte(file.Name), value); err != nil {
+			return err
+		}
+	}
+
+	peers, err := tx.Bucket(peersBucket).CreateBucket(name)
+	if err != nil {
+		return err
+	}
+	for _, peer := range lynk.Peers {
+		value, _ := json.Marshal(peer)
+		if err := peers.Put([]byte(peer.IP+":"+peer.Port), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper function which removes a Lynk within a transaction - it is fine if it is not there
+func deleteLynk(tx *bolt.Tx, name []byte) error {
+	if err := tx.Bucket(lynksBucket).Delete(name); err != nil {
+		return err
+	}
+	for _, bucket := range [][]byte{filesBucket, peersBucket} {
+		err := tx.Bucket(bucket).DeleteBucket(name)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper function which calls fn with every value of a bucket, which may be nil
+func forEach(b *bolt.Bucket, fn func(value []byte) error) error {
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(_, value []byte) error { return fn(value) })
+}
@@ -0,0 +1,98 @@
+// The unit tests for our store
+package store
+
+import (
+	"capstone/lynxutil"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 5
+
+// Unit tests for saving, loading and deleting Lynks
+// @param *testing.T t - The wrapper for the test
+func TestStore(t *testing.T) {
+	fmt.Println("\n----------------TestStore----------------")
+	dir, _ := ioutil.TempDir("", "store")
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir + "/" + FileName)
+	if err != nil {
+		t.Fatal("Test failed, expected to open the database. Got ", err)
+	}
+
+	lynk := lynxutil.Lynk{Name: "Tests", ID: "abc", Root: "/srv/tests", Owner: "Max",
+		Synced: "Unsynced", Tracker: "1.2.3.4:9000",
+		Files: []lynxutil.File{{Name: "a.txt", Length: 3, Chunks: "abc", ChunkLength: 3}},
+		Peers: []lynxutil.Peer{{IP: "1.2.3.5", Port: "8080"}}, DLing: true, Mode: lynxutil.SendOnly,
+		Limits: lynxutil.Limits{Upload: 50}}
+	s.SaveLynk(lynk)
+	lynk.Files = nil // Saving again replaces the old files
+	lynk.Paused = true
+	s.SaveLynk(lynk)
+	s.Close()
+
+	s, _ = Open(dir + "/" + FileName)
+	defer s.Close()
+	lynks, err := s.Lynks()
+	if err != nil || len(lynks) != 1 || len(lynks[0].Files) != 0 || !lynks[0].Paused ||
+		len(lynks[0].Peers) != 1 || lynks[0].Tracker != lynk.Tracker || lynks[0].DLing ||
+		lynks[0].Mode != lynxutil.SendOnly || lynks[0].ID != "abc" ||
+		lynks[0].Root != "/srv/tests" || lynks[0].Limits.Upload != 50 {
+		t.Error("Test failed, expected the saved Lynk back. Got ", lynks, err)
+	} else {
+		fmt.Println("Successfully Saved And Loaded Lynk")
+		successful++
+	}
+
+	s.DeleteLynk("Tests")
+	lynks, _ = s.Lynks()
+	if len(lynks) != 0 {
+		t.Error("Test failed, expected no Lynks. Got ", lynks)
+	} else {
+		fmt.Println("Successfully Deleted Lynk")
+		successful++
+	}
+
+	if s.SaveLynk(lynxutil.Lynk{}) == nil {
+		t.Error("Test failed, expected an error for a Lynk without a name.")
+	} else {
+		fmt.Println("Successfully Rejected Lynk Without Name")
+		successful++
+	}
+}
+
+// Unit tests for migrating Lynks into the database
+// @param *testing.T t - The wrapper for the test
+func TestMigrate(t *testing.T) {
+	fmt.Println("\n----------------TestMigrate----------------")
+	dir, _ := ioutil.TempDir("", "store")
+	defer os.RemoveAll(dir)
+
+	s, _ := Open(dir + "/" + FileName)
+	defer s.Close()
+
+	if s.Migrated() {
+		t.Error("Test failed, expected a new database not to be migrated.")
+	} else {
+		fmt.Println("Successfully Found New Database Unmigrated")
+		successful++
+	}
+
+	s.Migrate([]lynxutil.Lynk{{Name: "One"}, {Name: "Two"}})
+	lynks, _ := s.Lynks()
+	if !s.Migrated() || len(lynks) != 2 {
+		t.Error("Test failed, expected 2 migrated Lynks. Got ", lynks)
+	} else {
+		fmt.Println("Successfully Migrated Lynks")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -1,12 +1,58 @@
-// This is just a simple driver for our tracker. Only calls Listen()
+// The standalone tracker - tracks Lynks it does not sync itself, keeping their swarms in its own
+// tracker directory (-tracker-dir). Lynks are hosted through the admin API on -admin-addr, each
+// client is held to -tracker-rate requests a second, and SIGINT or SIGTERM stops it once the
+// requests being answered are done.
 // @author: Michael Bruce
 // @author: Max Kernchen
 // @verison: 2/17/2016
 package main
 
-import "capstone/tracker"
+import (
+	"capstone/logs"
+	"capstone/lynxutil"
+	"capstone/tracker"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
 
-// Function used to drive and test our tracker's functions
+// Loads our config and runs the tracker until it is told to stop
 func main() {
-	tracker.Listen()
+	config, err := lynxutil.LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := logs.Configure(config); err != nil {
+		fmt.Println(err)
+	}
+	tracker.Configure(config)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The tracker is stopped too if the admin API cannot be served
+	admin := make(chan error, 1)
+	if config.AdminAddr != "" {
+		go func() {
+			err := tracker.ServeAdmin(ctx, config.AdminAddr)
+			if err != nil {
+				stop()
+			}
+			admin <- err
+		}()
+	} else {
+		close(admin)
+	}
+
+	if err = tracker.Serve(ctx); err != nil {
+		fmt.Println(err)
+		os.Exit(lynxutil.SockErr)
+	}
+	if err = <-admin; err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
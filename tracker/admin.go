@@ -0,0 +1,293 @@
+// Package tracker - This file serves the admin API of a standalone tracker under /admin/v1. It lets
+// whoever runs the tracker see what it is tracking and host or drop Lynks the tracker does not
+// sync itself. Every request must carry the AdminToken of our config as a bearer token.
+package tracker
+
+import (
+	"../lynxutil"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// AdminPrefix - The path every admin route lives under
+const AdminPrefix = "/admin/v1/"
+
+// The errors HostLynk produces for a bad request rather than a failure of ours
+var (
+	errInvalidMeta = errors.New("Invalid meta.info")
+	errTracked     = errors.New("Lynk Already Tracked")
+)
+
+// AdminStatusJSON - The state of the tracker as the admin API returns it
+type AdminStatusJSON struct {
+	TrackerPort string `json:"trackerPort"`
+	TrackerDir  string `json:"trackerDir"`
+	Lynks       int    `json:"lynks"`
+	Peers       int    `json:"peers"`
+	Clients     int    `json:"clients"`     // Clients which have sent requests recently
+	TrackerRate int    `json:"trackerRate"` // Requests a second each client may send, 0 for any
+}
+
+// TrackedJSON - A Lynk we track as the admin API returns it
+type TrackedJSON struct {
+	ID    string     `json:"id"`
+	Name  string     `json:"name"`
+	Owner string     `json:"owner"`
+	Peers int        `json:"peers"`
+	Addrs [][]string `json:"addrs,omitempty"` // The addresses of each peer - only for one Lynk
+}
+
+// The body of every error response
+type adminErrorJSON struct {
+	Error string `json:"error"`
+}
+
+// ServeAdmin - Serves the admin API on addr until ctx is done, then waits up to shutdownGrace for
+// the requests being answered
+// @param context.Context ctx - Stops the admin API once it is done
+// @param string addr - The host:port to listen on
+// @return error - An error is produced if no AdminToken is configured or we cannot listen on addr
+func ServeAdmin(ctx context.Context, addr string) error {
+	if config.AdminToken == "" {
+		return errors.New("AdminToken Must Be Set To Serve The Admin API")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminPrefix, AdminHandler)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: requestTimeout}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving Admin API On " + addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped // ListenAndServe returns as soon as Shutdown starts
+	return nil
+}
+
+// AdminHandler - Routes a request under /admin/v1/ to the function that handles it. The routes
+// are status (GET), lynks (GET with optional ?offset= and limit=, POST with a meta.info as the
+// body to host its Lynk) and lynks/<id> (GET - includes the addresses of its peers, DELETE - stops
+// tracking it and removes its swarm).
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+func AdminHandler(rw http.ResponseWriter, req *http.Request) {
+	if !authorized(req) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeAdminError(rw, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, AdminPrefix), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "status":
+		if allowMethod(rw, req, "GET") {
+			writeAdminJSON(rw, http.StatusOK, adminStatus())
+		}
+	case len(parts) == 1 && parts[0] == "lynks":
+		if req.Method == "POST" {
+			hostLynk(rw, req)
+		} else if allowMethod(rw, req, "GET") {
+			listTracked(rw, req)
+		}
+	case len(parts) == 2 && parts[0] == "lynks":
+		lynk, ok := tLynks.Get(parts[1])
+		if !ok {
+			writeAdminError(rw, http.StatusNotFound, "Lynk "+parts[1]+" Not Found")
+		} else if req.Method == "DELETE" {
+			tracked := toTrackedJSON(lynk) // Read before its meta.info is removed
+			if err := DropLynk(lynk.ID); err != nil {
+				writeAdminError(rw, http.StatusInternalServerError, err.Error())
+			} else {
+				writeAdminJSON(rw, http.StatusOK, tracked)
+			}
+		} else if allowMethod(rw, req, "GET") {
+			tracked := toTrackedJSON(lynk)
+			for _, peer := range lynk.Peers {
+				tracked.Addrs = append(tracked.Addrs, peer.Addresses())
+			}
+			writeAdminJSON(rw, http.StatusOK, tracked)
+		}
+	default:
+		writeAdminError(rw, http.StatusNotFound, "No Such Route")
+	}
+}
+
+// HostLynk - Starts tracking the Lynk of a meta.info with an empty swarm. Unlike CreateSwarm we do
+// not join the swarm ourselves, as the Lynk is not synced here. The check that we do not track
+// the Lynk yet and the adding happen under swarmMu, so two requests cannot both host it.
+// @param []byte meta - The meta.info of the Lynk
+// @return lynxutil.Lynk - The Lynk as we track it
+// @return error - An error is produced if the meta.info has no name or a bad ID, we already track
+// the Lynk or its files cannot be written
+func HostLynk(meta []byte) (lynxutil.Lynk, error) {
+	header, err := lynxutil.ParseMetaHeader(meta)
+	if err != nil || header.Name == "" {
+		return lynxutil.Lynk{}, errInvalidMeta
+	}
+
+	swarmMu.Lock()
+	defer swarmMu.Unlock()
+	trackerDir := config.TrackerDir(header.ID)
+	if _, ok := tLynks.Get(header.ID); ok {
+		return lynxutil.Lynk{}, errTracked
+	} else if _, err := os.Stat(trackerDir); err == nil {
+		return lynxutil.Lynk{}, errTracked // A swarm findLynk has not loaded yet
+	}
+	if err := os.MkdirAll(trackerDir, 0755); err != nil {
+		return lynxutil.Lynk{}, err
+	}
+	if err := lynxutil.WriteFileAtomic(trackerDir+"meta.info", meta, 0644); err != nil {
+		return lynxutil.Lynk{}, err
+	}
+	if err := lynxutil.WriteFileAtomic(trackerDir+"swarm.info", nil, 0644); err != nil {
+		return lynxutil.Lynk{}, err
+	}
+
+	tLynks.Add(trackedLynk(header.ID))
+	indexName(header.ID)
+	logger.Lynk(header.Name).Info("Hosting Lynk " + header.ID)
+	lynk, _ := tLynks.Get(header.ID)
+	return lynk, nil
+}
+
+// DropLynk - Stops tracking a Lynk and removes its swarm and meta.info from the tracker directory.
+// Its directory is removed under swarmMu before the Lynk is forgotten, so findLynk cannot load it
+// again in between.
+// @param string lynkID - The ID of the Lynk
+// @return error - An error is produced if we do not track the Lynk or its files cannot be removed
+func DropLynk(lynkID string) error {
+	swarmMu.Lock()
+	defer swarmMu.Unlock()
+	if _, ok := tLynks.Get(lynkID); !ok {
+		return errors.New("Lynk Not Found")
+	}
+	if err := os.RemoveAll(config.TrackerDir(lynkID)); err != nil {
+		return err
+	}
+	forgetLynk(lynkID)
+	logger.Info("Dropped Lynk " + lynkID)
+	return nil
+}
+
+// Helper function which hosts the Lynk of the meta.info in a request's body
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request
+func hostLynk(rw http.ResponseWriter, req *http.Request) {
+	meta, err := ioutil.ReadAll(io.LimitReader(req.Body, lynxutil.MaxMetaSize+1))
+	if err != nil {
+		writeAdminError(rw, http.StatusBadRequest, err.Error())
+		return
+	} else if len(meta) > lynxutil.MaxMetaSize {
+		writeAdminError(rw, http.StatusRequestEntityTooLarge, "meta.info Is Too Large")
+		return
+	}
+
+	lynk, err := HostLynk(meta)
+	if err == errTracked {
+		writeAdminError(rw, http.StatusConflict, err.Error())
+	} else if err == errInvalidMeta {
+		writeAdminError(rw, http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		writeAdminError(rw, http.StatusInternalServerError, err.Error())
+	} else {
+		writeAdminJSON(rw, http.StatusCreated, toTrackedJSON(lynk))
+	}
+}
+
+// Helper function which lists the Lynks we track, a page at a time if the request asks for one
+// @param http.ResponseWriter rw - The response
+// @param *http.Request req - The request - ?offset= skips Lynks and ?limit= caps how many are sent
+func listTracked(rw http.ResponseWriter, req *http.Request) {
+	lynks := tLynks.List()
+	offset, limit := 0, len(lynks)
+	var err error
+	if value := req.URL.Query().Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			writeAdminError(rw, http.StatusBadRequest, "Invalid Offset: "+value)
+			return
+		}
+	}
+	if value := req.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeAdminError(rw, http.StatusBadRequest, "Invalid Limit: "+value)
+			return
+		}
+	}
+
+	tracked := []TrackedJSON{}
+	for i := offset; i < len(lynks) && len(tracked) < limit; i++ {
+		tracked = append(tracked, toTrackedJSON(lynks[i]))
+	}
+	rw.Header().Set("X-Total-Count", strconv.Itoa(len(lynks)))
+	writeAdminJSON(rw, http.StatusOK, tracked)
+}
+
+// Helper function which describes the state of the tracker
+// @return AdminStatusJSON - The state
+func adminStatus() AdminStatusJSON {
+	status := AdminStatusJSON{TrackerPort: config.TrackerPort, TrackerDir: config.TrackerDir(""),
+		Clients: clientCount(), TrackerRate: config.TrackerRate}
+	for _, lynk := range tLynks.List() {
+		status.Lynks++
+		status.Peers += len(lynk.Peers)
+	}
+	return status
+}
+
+// Helper function which converts a Lynk we track to its JSON form. Its name and owner come from
+// the tracker's copy of its meta.info.
+func toTrackedJSON(lynk lynxutil.Lynk) TrackedJSON {
+	tracked := TrackedJSON{ID: lynk.ID, Peers: len(lynk.Peers)}
+	header, err := lynxutil.ReadMetaHeader(config.TrackerDir(lynk.ID) + "meta.info")
+	if err == nil {
+		tracked.Name, tracked.Owner = header.Name, header.Owner
+	}
+	return tracked
+}
+
+// Helper function which checks the bearer token of a request against our AdminToken
+// @return bool - Whether or not the request may use the admin API
+func authorized(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return config.AdminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// Helper function which checks the method of a request, writing a 405 if it is not allowed
+// @return bool - Whether or not the method is allowed
+func allowMethod(rw http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		rw.Header().Set("Allow", method)
+		writeAdminError(rw, http.StatusMethodNotAllowed, "Method "+req.Method+" Not Allowed")
+		return false
+	}
+	return true
+}
+
+// Helper function which writes a value as JSON with the given status code
+func writeAdminJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// Helper function which writes an error as JSON with the given status code
+func writeAdminError(rw http.ResponseWriter, code int, msg string) {
+	writeAdminJSON(rw, code, adminErrorJSON{msg})
+}
@@ -0,0 +1,156 @@
+// Package tracker - This file runs the tracker as a service of its own. Serve answers peers until
+// it is told to stop and then waits for the requests it is answering, and a client sending more
+// requests a second than TrackerRate allows is turned away until it slows down.
+package tracker
+
+import (
+	"../logs"
+	"../metrics"
+	"../ratelimit"
+	"../transport"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long a peer has to send its request, and any meta.info it pushes
+const requestTimeout = time.Minute
+
+// How long Serve waits for the requests being answered once it is told to stop
+const shutdownGrace = 10 * time.Second
+
+// How long the bucket of a client which has stopped sending requests is kept
+const clientIdle = 10 * time.Minute
+
+// A client of the tracker and the bucket its requests are taken from
+type trackerClient struct {
+	bucket *ratelimit.Bucket
+	seen   time.Time // When it last sent a request
+}
+
+// Guards clients and lastPrune
+var clientsMu sync.Mutex
+
+// The clients which have sent requests recently by host
+var clients = make(map[string]*trackerClient)
+
+// When clients was last pruned of idle clients
+var lastPrune = time.Now()
+
+// Serve - Answers peers on the tracker port until ctx is done. The NAT rendezvous is also started
+// on the same port over UDP. Once ctx is done no new connections are accepted and Serve returns
+// when the requests being answered are done, or after shutdownGrace if they are not.
+// @param context.Context ctx - Stops the tracker once it is done
+// @return error - An error is produced if we cannot listen on the tracker port
+func Serve(ctx context.Context) error {
+	listener, err := net.Listen("tcp", ":"+config.TrackerPort)
+	if err != nil {
+		return err
+	}
+
+	r, err := transport.NewRendezvous(":" + config.TrackerPort)
+	if err != nil {
+		logger.Warn("Could Not Start NAT Rendezvous: " + err.Error())
+	} else {
+		rendezvous = r
+		go rendezvous.Serve()
+		defer rendezvous.Close()
+	}
+
+	logger.Info("Tracker Listening On " + listener.Addr().String())
+	serve(ctx, listener)
+	return nil
+}
+
+// Helper function which accepts connections on listener until ctx is done and spawns a goroutine
+// to handle each request, then waits for the requests being answered
+// @param context.Context ctx - Stops accepting connections once it is done
+// @param net.Listener listener - The listener, which is closed once ctx is done
+func serve(ctx context.Context, listener net.Listener) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var requests sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		} else if err != nil {
+			logger.Warn("Could Not Accept Connection: " + err.Error())
+			time.Sleep(100 * time.Millisecond) // Running out of file handles does not fix itself
+			continue
+		}
+
+		if !allowClient(conn.RemoteAddr().String()) {
+			metrics.RateLimited.Inc(logs.Tracker)
+			logger.Peer(conn.RemoteAddr().String()).Debug("Turned Away - Too Many Requests")
+			conn.Close()
+			continue
+		}
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			handleRequest(conn)
+		}()
+	}
+
+	logger.Info("Tracker Stopping - Waiting For Requests Being Answered")
+	done := make(chan struct{})
+	go func() {
+		requests.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.Info("Tracker Stopped")
+	case <-time.After(shutdownGrace):
+		logger.Warn("Tracker Stopped With Requests Still Being Answered")
+	}
+}
+
+// Helper function which takes a request from the bucket of the client at addr. Each client may
+// send TrackerRate requests a second, and a second's worth can be saved up.
+// @param string addr - The host:port the client connected from - only the host is counted
+// @return bool - Whether or not the request may be answered
+func allowClient(addr string) bool {
+	if config.TrackerRate <= 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	clientsMu.Lock()
+	now := time.Now()
+	if now.Sub(lastPrune) > clientIdle {
+		for key, idle := range clients {
+			if now.Sub(idle.seen) > clientIdle {
+				delete(clients, key)
+			}
+		}
+		lastPrune = now
+	}
+	c, ok := clients[host]
+	if !ok {
+		c = &trackerClient{bucket: ratelimit.NewBucket(int64(config.TrackerRate))}
+		clients[host] = c
+	}
+	c.seen = now
+	clientsMu.Unlock()
+
+	c.bucket.SetRate(int64(config.TrackerRate)) // Does nothing unless the rate was reconfigured
+	return c.bucket.Take(1)
+}
+
+// Helper function which returns the number of clients which have sent requests recently
+// @return int - The number of clients
+func clientCount() int {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return len(clients)
+}
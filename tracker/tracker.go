@@ -8,9 +8,13 @@ package tracker
 import (
 	"bufio"
 	"bytes"
+	"../logs"
 	"../lynxutil"
+	"../metrics"
 	"../mycrypt"
+	"../transport"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -18,39 +22,84 @@ import (
 	"net"
 	"net/textproto"
 	"os"
-	"os/user"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// An array of tLynks this tracker presides over
-var tLynks []lynxutil.Lynk
+// The tLynks this tracker presides over - reached from every request's goroutine and the cron
+// job, so they are only reached through the registry
+var tLynks = lynxutil.NewRegistry()
 
-// Function that deletes an entry from a lynk's peers array and the swarm.info file.
-// @param string peerToDelete - This is the peer struct we want to delete - uses the IP address
-// @param string lynkName - The lynk we want to delete it from
-func deletePeer(peerToDelete, lynkName string) {
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
+// Serialises changes to swarm.info files, which are read, changed and rewritten as a whole
+var swarmMu sync.Mutex
 
-	i := 0
-	for i < len(lynk.Peers) {
-		if peerToDelete == lynk.Peers[i].IP {
-			lynk.Peers = append(lynk.Peers[:i], lynk.Peers[i+1:]...)
-		}
-		i++
-	}
+// Guards lynkNames
+var namesMu sync.Mutex
 
-	swarmPath := lynxutil.HomePath + lynkName + "/" + lynkName + "_Tracker/" + "swarm.info"
+// The ID of each lynk we track by the name in its meta.info - peers running older versions name a
+// lynk instead of giving its ID, and reading every meta.info to find it is too slow for a tracker
+// hosting thousands of lynks
+var lynkNames = make(map[string]string)
 
-	os.Remove(swarmPath)
-	newSwarmInfo, _ := os.Create(swarmPath)
+// Introduces peers behind NATs to each other and relays for them when punching fails
+var rendezvous *transport.Rendezvous
 
-	i = 0
-	for i < len(lynk.Peers) {
-		newSwarmInfo.WriteString(lynk.Peers[i].IP + ":::" + lynk.Peers[i].Port + "\n")
-		i++
+// The settings of our node - replaced by Configure before the tracker is used
+var config = lynxutil.DefaultConfig()
+
+// Writes the tracker's log entries
+var logger = logs.New(logs.Tracker)
+
+// Function init registers the gauge of the number of peers in each swarm we track
+func init() {
+	metrics.NewGaugeFunc("lynx_tracker_swarm_peers", "Peers in each swarm this tracker tracks.",
+		"lynk", func() map[string]float64 {
+			sizes := make(map[string]float64)
+			for _, lynk := range tLynks.List() {
+				sizes[lynk.Name] = float64(len(lynk.Peers))
+			}
+			return sizes
+		})
+}
+
+// Configure - Sets the ports and directories the tracker uses, moves the tracker folders of older
+// versions out of our lynks and reloads the swarms we track from the tracker directory
+// @param lynxutil.Config cfg - The config loaded by our main package
+func Configure(cfg lynxutil.Config) {
+	config = cfg
+	if moved, err := lynxutil.MigrateTrackers(config); err != nil {
+		logger.Error("Could Not Migrate Trackers: " + err.Error())
+	} else if moved > 0 {
+		logger.Info("Moved " + strconv.Itoa(moved) + " Trackers To " + config.TrackerDir(""))
+	}
+	loadTrackers()
+}
+
+// Function that deletes an entry from a lynk's peers array and the swarm.info file.
+// @param lynxutil.Peer peerToDelete - This is the peer we want to delete - matches on any address
+// @param string lynkName - The lynk we want to delete it from - we track lynks by their ID
+func deletePeer(peerToDelete lynxutil.Peer, lynkName string) {
+	swarmMu.Lock()
+	defer swarmMu.Unlock()
+
+	found := tLynks.Update(lynkName, func(tLynk *lynxutil.Lynk) {
+		i := 0
+		for i < len(tLynk.Peers) {
+			if peerToDelete.Equal(tLynk.Peers[i]) {
+				tLynk.Peers = append(tLynk.Peers[:i], tLynk.Peers[i+1:]...)
+				continue
+			}
+			i++
+		}
+	})
+	if !found {
+		return
 	}
+	lynk, _ := tLynks.Get(lynkName)
+
+	writeSwarminfo(config.TrackerDir(lynk.ID)+"swarm.info", lynk.Peers)
 }
 
 // Deletes the current swarm.info and replaces it with a new version that
@@ -58,30 +107,33 @@ func deletePeer(peerToDelete, lynkName string) {
 // @return error - An error can be produced when issues arise from trying to create
 // or remove the swarm file - otherwise error will be nil.
 func updateSwarminfo(swarmPath string) error {
-	parseSwarminfo(swarmPath)
-
-	err := os.Remove(swarmPath)
-	if err != nil {
-		fmt.Println(err)
+	swarmMu.Lock()
+	defer swarmMu.Unlock()
+	lynkName := getTLynkName(swarmPath)
+	if err := parseSwarminfo(swarmPath); err != nil {
+		logger.Lynk(lynkName).Warn("Could Not Read swarm.info: " + err.Error())
 		return err
 	}
 
-	newSwarmInfo, err := os.Create(swarmPath)
+	lynk, _ := tLynks.Get(lynkName)
+
+	err := writeSwarminfo(swarmPath, lynk.Peers)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		logger.Lynk(lynkName).Error("Could Not Write swarm.info: " + err.Error())
 	}
+	return err
+}
 
-	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
-
-	i := 0
-	for i < len(lynk.Peers) {
-		newSwarmInfo.WriteString(lynk.Peers[i].IP + ":::" + lynk.Peers[i].Port + "\n")
-		i++
+// Helper function which replaces swarm.info with the given peers in a single step
+// @param string swarmPath - The path to the swarm.info file
+// @param []lynxutil.Peer peers - The peers of the lynk
+// @return error - An error can be produced when the swarm file cannot be written
+func writeSwarminfo(swarmPath string, peers []lynxutil.Peer) error {
+	var newSwarmInfo bytes.Buffer
+	for _, peer := range peers {
+		newSwarmInfo.WriteString(lynxutil.FormatPeer(peer) + "\n")
 	}
-
-	return newSwarmInfo.Close()
+	return lynxutil.WriteFileAtomic(swarmPath, newSwarmInfo.Bytes(), 0644)
 }
 
 // Parses the information in swarm.info file and places each entry into a Peer
@@ -91,10 +143,12 @@ func updateSwarminfo(swarmPath string) error {
 // the swarm file or from an invalid swarm file type - otherwise error will be nil.
 func parseSwarminfo(swarmPath string) error {
 	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
 
-	//fmt.Println(lynk)
-	lynk.Peers = nil // Resets peers array
+	// Resets peers array - to whatever could be parsed, even if that is nothing
+	var peers []lynxutil.Peer
+	defer func() {
+		tLynks.Update(lynkName, func(lynk *lynxutil.Lynk) { lynk.Peers = peers })
+	}()
 
 	swarmFile, err := os.Open(swarmPath)
 	if err != nil {
@@ -104,19 +158,17 @@ func parseSwarminfo(swarmPath string) error {
 	}
 
 	scanner := bufio.NewScanner(swarmFile)
-	tempPeer := lynxutil.Peer{}
 
-	// Scan each line
+	// Scan each line - each line holds every address of one peer
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text()) // Trim helps with errors in \n
-		split := strings.Split(line, ":::")
-
-		tempPeer.IP = split[0]
-		tempPeer.Port = split[1]
-		lynk.Peers = append(lynk.Peers, tempPeer)
+		tempPeer, err := lynxutil.ParsePeer(scanner.Text())
+		if err != nil {
+			continue // Skips blank or corrupt lines
+		}
+		peers = append(peers, tempPeer)
 	}
 
-	//fmt.Println(lynk.Peers)
+	//fmt.Println(peers)
 	return swarmFile.Close()
 }
 
@@ -127,38 +179,48 @@ func parseSwarminfo(swarmPath string) error {
 // the swarm file or if the file to be added already exists in the swarm file - otherwise
 // error will be nil.
 func addToSwarminfo(addPeer lynxutil.Peer, swarmPath string) error {
-	swarmFile, err := os.OpenFile(swarmPath, os.O_APPEND|os.O_WRONLY, 0644) // Opens for appending
-	if err != nil {
+	swarmMu.Lock()
+	defer swarmMu.Unlock()
+
+	if _, err := os.Stat(swarmPath); err != nil {
 		return err
 	}
 
 	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
-	if lynk == nil {
-		tLynks = append(tLynks, lynxutil.Lynk{Name: lynkName})
-		lynk = lynxutil.GetLynk(tLynks, lynkName)
-	}
+	tLynks.Add(trackedLynk(lynkName)) // Does nothing if we already track the lynk
 
 	parseSwarminfo(swarmPath)
 
-	i := 0
-	for i < len(lynk.Peers) {
-		if lynk.Peers[i].IP == addPeer.IP && lynk.Peers[i].Port == addPeer.Port {
-			return errors.New("Can't Add Duplicates To Swarminfo")
+	known, merged := false, false
+	tLynks.Update(lynkName, func(lynk *lynxutil.Lynk) {
+		i := 0
+		for i < len(lynk.Peers) && !known {
+			if lynk.Peers[i].Equal(addPeer) {
+				known = true
+				// A peer we already know may have new addresses - so we merge them in
+				merged = mergeAddrs(&lynk.Peers[i], addPeer)
+			}
+			i++
 		}
-		i++
+		if !known {
+			lynk.Peers = append(lynk.Peers, addPeer)
+		}
+	})
+	if known && !merged {
+		return errors.New("Can't Add Duplicates To Swarminfo")
 	}
 
-	// Write to swarminfo file using ::: to IP and Port
-	swarmFile.WriteString(addPeer.IP + ":::" + addPeer.Port + "\n")
-
-	return swarmFile.Close()
+	// Rewrite the swarminfo file - every address of each peer on one line
+	return updateSwarminfoFromPeers(swarmPath)
 }
 
-// Listen - Calls lynxutil to create a welcomeSocket that listens for TCP connections - once
-// someone connects a goroutine is spawned to handle the request
+// Listen - Answers peers on the tracker port for as long as we run, like Serve. We cannot
+// recover from not being able to listen, so we exit if we cannot.
 func Listen() {
-	lynxutil.Listen(handleRequest, lynxutil.TrackerPort)
+	if err := Serve(context.Background()); err != nil {
+		fmt.Println("Could Not Create Tracker Welcome Socket - Aborting.")
+		os.Exit(lynxutil.SockErr)
+	}
 }
 
 // Handles a request / push sent by a client, can either be a swarm or meta request or a push
@@ -167,61 +229,200 @@ func Listen() {
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
 func handleRequest(conn net.Conn) error {
-	request, err := bufio.NewReader(conn).ReadString('\n') // Waits for a String ending in newline
+	metrics.ActiveConnections.Inc(logs.Tracker)
+	defer metrics.ActiveConnections.Dec(logs.Tracker)
+
+	// A peer which never finishes its request would otherwise hold on to us forever
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	reader := bufio.NewReader(conn)
+	request, err := reader.ReadString('\n') // Waits for a String ending in newline
 	if err != nil {
+		conn.Close()
 		return err
 	}
 	request = strings.TrimSpace(request)
+	metrics.Requests.Inc(logs.Tracker, messageType(request))
 
-	if strings.Contains(request, "Meta_Push:") { // We are receiving a meta.info file
-		handlePush(request, conn)
+	if strings.HasPrefix(request, "Relay_") { // A peer behind a NAT needs its traffic relayed
+		conn.SetReadDeadline(time.Time{}) // Relayed traffic may pause for as long as it likes
+		if rendezvous != nil {
+			rendezvous.HandleRelay(request, conn, reader)
+		}
+	} else if strings.Contains(request, "Meta_Push:") { // We are receiving a meta.info file
+		if err := handlePush(request, conn); err != nil {
+			logger.Peer(conn.RemoteAddr().String()).Warn("Could Not Handle Push: " + err.Error())
+		}
 		notifyPeers(request)
 	} else if strings.Contains(request, "Disconnect:") {
-		// tmpArr[0] - Disconnect | tmpArr[1] - <IP> | tmpArr[2] - <LynkName>
-		tmpArr := strings.Split(request, ":")
-		deletePeer(tmpArr[1], tmpArr[2])
-	} else { // We are receiving a pull request
-		handlePull(request, conn)
+		// tmpArr[0] - Disconnect | tmpArr[1] - <LynkID> | tmpArr[2] - <Addr>,<Addr>
+		tmpArr := strings.SplitN(request, ":", 3)
+		if len(tmpArr) == 3 {
+			peer, err := lynxutil.NewPeer(strings.Split(tmpArr[2], ","))
+			if lynk, ok := findLynk(tmpArr[1]); ok && err == nil {
+				deletePeer(peer, lynk.Name)
+			}
+		}
+	} else if err := handlePull(request, reader, conn); err != nil { // We are receiving a pull
+		logger.Peer(conn.RemoteAddr().String()).Warn("Could Not Handle Pull: " + err.Error())
 	}
 	return conn.Close()
 }
 
+// Helper function which returns the message type a request is counted under - anything we do not
+// know is counted as "other" so peers cannot make up new series
+// @param string request - The request sent to tracker
+// @return string - The message type
+func messageType(request string) string {
+	switch {
+	case strings.HasPrefix(request, "Relay_"):
+		return "Relay"
+	case strings.Contains(request, "Meta_Push:"):
+		return "Meta_Push"
+	case strings.Contains(request, "Disconnect:"):
+		return "Disconnect"
+	case strings.HasPrefix(request, "Swarm_Request:"):
+		return "Swarm_Request"
+	case strings.HasPrefix(request, "Meta_Request:"):
+		return "Meta_Request"
+	}
+	return "other"
+}
+
 // Helper function for handleRequest - handles the case where a client is requesting a meta.info
-// or swarm.info file.
+// or swarm.info file. meta.info is only sent to a client with an invite from the lynk's owner.
 // @param net.Conn conn - The socket which the client is asking on
+// @param *bufio.Reader reader - The reader the request was read from - the invite follows it
 // @param string request - The request sent to tracker
-// @return error - An error can be produced when trying to send a file or if there is incorrect
-// syntax in the request - otherwise error will be nil.
-func handlePull(request string, conn net.Conn) error {
-	// Client syntax for request is "X_Request:<IP>:<Port>:<LynkName>\n"
-	// So tmpArr[0] - X_Request | tmpArr[1] - <IP> | tmpArr[2] - <Port> | tmpArr[3] - <LynkName>
-	tmpArr := strings.Split(request, ":")
-	if len(tmpArr) != 4 {
+// @return error - An error can be produced when trying to send a file, if there is incorrect
+// syntax in the request or the invite is refused - otherwise error will be nil.
+func handlePull(request string, reader *bufio.Reader, conn net.Conn) error {
+	requestType, lynkID, addrs, err := parsePull(request)
+	if err != nil {
 		conn.Close()
-		return errors.New("Invalid Request Syntax")
+		return err
+	}
+	lynk, ok := findLynk(lynkID)
+	if !ok {
+		conn.Close()
+		return errors.New("Lynk Not Found")
 	}
+	lynkName := lynk.Name
 
 	fileToSend := ""
 	// Checks to see if we are dealing w/ a Swarm or Meta Request
-	swarmPath := lynxutil.HomePath + tmpArr[3] + "/" + tmpArr[3] + "_Tracker/" + "swarm.info"
-	if tmpArr[0] == "Swarm_Request" {
+	swarmPath := config.TrackerDir(lynk.ID) + "swarm.info"
+	if requestType == "Swarm_Request" {
 		fileToSend = swarmPath
-	} else if tmpArr[0] == "Meta_Request" {
-		fileToSend = lynxutil.HomePath + tmpArr[3] + "/meta.info"
+	} else if requestType == "Meta_Request" {
+		if err = checkInvite(lynk, reader); err != nil {
+			conn.Close()
+			return errors.New("Refused Meta_Request: " + err.Error())
+		}
+		fileToSend = config.TrackerDir(lynk.ID) + "meta.info"
 	} else {
 		conn.Close()
 		return errors.New("Invalid Request Syntax")
 	}
 
-	tmpPeer := lynxutil.Peer{IP: strings.TrimSpace(tmpArr[1]), Port: strings.TrimSpace(tmpArr[2])}
-	err := sendFile(fileToSend, conn) // Sending The file
+	// The address the request came from is stored too - it is the one peers behind a NAT or
+	// with a different public address can actually be reached on
+	if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil && len(addrs) > 0 {
+		if _, port, err := net.SplitHostPort(addrs[0]); err == nil {
+			addrs = append(addrs, net.JoinHostPort(host, port))
+		}
+	}
+	tmpPeer, peerErr := lynxutil.NewPeer(addrs)
+	err = sendFile(fileToSend, conn) // Sending The file
 	if err != nil {
 		conn.Close()
 		return err
 	}
+	logger.Lynk(lynkName).Peer(conn.RemoteAddr().String()).Debug("Answered " + requestType)
 
-	addToSwarminfo(tmpPeer, swarmPath) // So we only add peer to swarmlist on success
-	return nil                         // No errors if we reached this point
+	if peerErr == nil {
+		addToSwarminfo(tmpPeer, swarmPath) // So we only add peer to swarmlist on success
+	}
+	return nil // No errors if we reached this point
+}
+
+// Helper function for handlePull - reads the invite sent on the line after a Meta_Request and
+// checks that it is an unexpired invite to the lynk, signed by the owner key in its meta.info
+// @param lynxutil.Lynk lynk - The lynk meta.info is requested for
+// @param *bufio.Reader reader - The reader the request was read from
+// @return error - An error is produced if no invite was sent or it is refused
+func checkInvite(lynk lynxutil.Lynk, reader *bufio.Reader) error {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return errors.New("No Invite Sent")
+	}
+	invite, err := lynxutil.ParseInvite(line)
+	if err != nil {
+		return err
+	}
+	header, err := lynxutil.ReadMetaHeader(config.TrackerDir(lynk.ID) + "meta.info")
+	if err != nil {
+		return err
+	}
+	return lynxutil.CheckInvite(invite, header)
+}
+
+// Helper function for handlePull - parses a pull request. The syntax is
+// "X_Request:<LynkID>:<Addr>,<Addr>\n" where each Addr is a host:port (IPv6 hosts are in
+// brackets). The older "X_Request:<IP>:<Port>:<LynkName>\n" syntax is still accepted.
+// @param string request - The request sent to tracker
+// @return string - The request type - Swarm_Request or Meta_Request
+// @return string - The ID of the lynk - or its name, from peers running older versions
+// @return []string - The addresses of the requesting peer
+// @return error - An error is produced if the request has invalid syntax
+func parsePull(request string) (string, string, []string, error) {
+	// tmpArr[0] - X_Request | tmpArr[1] - <LynkID> | tmpArr[2] - <Addrs>
+	tmpArr := strings.SplitN(strings.TrimSpace(request), ":", 3)
+	if len(tmpArr) != 3 {
+		return "", "", nil, errors.New("Invalid Request Syntax")
+	}
+
+	// Old syntax - tmpArr[1] - <IP> | split[0] - <Port> | split[1] - <LynkName>
+	if net.ParseIP(tmpArr[1]) != nil {
+		split := strings.Split(tmpArr[2], ":")
+		if len(split) != 2 {
+			return "", "", nil, errors.New("Invalid Request Syntax")
+		}
+		addr := net.JoinHostPort(tmpArr[1], strings.TrimSpace(split[0]))
+		return tmpArr[0], strings.TrimSpace(split[1]), []string{addr}, nil
+	}
+
+	return tmpArr[0], strings.TrimSpace(tmpArr[1]), strings.Split(tmpArr[2], ","), nil
+}
+
+// Helper function that adds any addresses of from that peer does not have yet
+// @param *lynxutil.Peer peer - The peer to add to
+// @param lynxutil.Peer from - The peer holding the new addresses
+// @return bool - Whether or not any addresses were added
+func mergeAddrs(peer *lynxutil.Peer, from lynxutil.Peer) bool {
+	added := false
+	peer.Addrs = peer.Addresses()
+	for _, addr := range from.Addresses() {
+		found := false
+		for _, have := range peer.Addrs {
+			if have == addr {
+				found = true
+			}
+		}
+		if !found {
+			peer.Addrs = append(peer.Addrs, addr)
+			added = true
+		}
+	}
+	return added
+}
+
+// Helper function which rewrites swarm.info from the in memory peers array - used when the
+// array is already up to date so swarm.info must not be parsed first.
+// @param string swarmPath - The path to the swarm.info file
+// @return error - An error can be produced when the swarm file cannot be created
+func updateSwarminfoFromPeers(swarmPath string) error {
+	lynk, _ := tLynks.Get(getTLynkName(swarmPath))
+	return writeSwarminfo(swarmPath, lynk.Peers)
 }
 
 // Helper function for handleRequest - handles the case where we are received meta.info file.
@@ -230,41 +431,70 @@ func handlePull(request string, conn net.Conn) error {
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
 func handlePush(request string, conn net.Conn) error {
-	// Client syntax for push is "Meta_Push:<LynkName>\n"
-	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkName>
+	// Client syntax for push is "Meta_Push:<LynkID>\n"
+	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkID>
 	tmpArr := strings.Split(request, ":")
-	metaPath := lynxutil.HomePath + tmpArr[1] + "/" + tmpArr[1] + "_Tracker/" + "meta.info"
+	lynk, ok := findLynk(tmpArr[1])
+	if !ok {
+		return errors.New("Lynk Not Found")
+	}
+	metaPath := config.TrackerDir(lynk.ID) + "meta.info"
 
-	bufIn, err := ioutil.ReadAll(conn)
+	log := logger.Lynk(lynk.Name).Peer(conn.RemoteAddr().String())
+	// A meta.info larger than any we accept is refused before it is decrypted or decompressed
+	bufIn, err := ioutil.ReadAll(io.LimitReader(conn, lynxutil.MaxMetaSize+1))
+	if err != nil {
+		return err
+	} else if len(bufIn) > lynxutil.MaxMetaSize {
+		log.Warn("Ignored Pushed meta.info - It Is Too Large")
+		return errors.New("meta.info Is Too Large")
+	}
 
 	// Decrypt
 	key := []byte(lynxutil.PrivateKey)
 	var plainFile []byte
 	if plainFile, err = mycrypt.Decrypt(key, bufIn); err != nil {
+		metrics.DecryptFailures.Inc(logs.Tracker)
 		return err
 	}
 
-	// Decompress
-	r, _ := gzip.NewReader(bytes.NewBuffer(plainFile))
-	bufOut, _ := ioutil.ReadAll(r)
-	r.Read(bufOut)
+	// Decompress - a meta.info encrypted with another key decrypts to something that is not gzip
+	r, err := gzip.NewReader(bytes.NewBuffer(plainFile))
+	if err != nil {
+		metrics.DecryptFailures.Inc(logs.Tracker)
+		return err
+	}
+	bufOut, err := ioutil.ReadAll(io.LimitReader(r, lynxutil.MaxMetaSize+1))
 	r.Close()
-
-	//fmt.Println(len(bufIn), "Bytes Received")
-
-	err = os.Remove(metaPath)
 	if err != nil {
-		fmt.Println(err)
 		return err
+	} else if len(bufOut) > lynxutil.MaxMetaSize {
+		log.Warn("Ignored Pushed meta.info - It Is Too Large")
+		return errors.New("meta.info Is Too Large")
 	}
 
-	newMetainfo, err := os.Create(metaPath)
-	if err != nil {
-		fmt.Println(err)
+	// A pushed meta.info must be for the Lynk it was pushed to and keep its owner, and a peer which
+	// has not seen the latest meta.info must not roll the Lynk back
+	pushed, err := lynxutil.ParseMetaHeader(bufOut)
+	if err != nil || pushed.ID != lynk.ID {
+		log.Warn("Ignored Pushed meta.info - It Is For Another Lynk")
+		return errors.New("meta.info Is For Another Lynk")
+	}
+	if current, err := lynxutil.ReadMetaHeader(metaPath); err == nil {
+		if err = lynxutil.CheckPush(pushed, current); err != nil {
+			log.Warn("Ignored Pushed meta.info: " + err.Error())
+			return err
+		}
+	}
+
+	// Replaces the old meta.info in a single step
+	if err = lynxutil.WriteFileAtomic(metaPath, bufOut, 0644); err != nil {
+		log.Error("Could Not Write Pushed meta.info: " + err.Error())
 		return err
 	}
-	newMetainfo.Write(bufOut)
+	indexName(lynk.ID)
 
+	log.Info("Received New meta.info")
 	return nil // No errors if we reached this point
 }
 
@@ -274,10 +504,14 @@ func handlePush(request string, conn net.Conn) error {
 // @return error - An error can be produced when trying to send a file or if there is incorrect
 // syntax in the request - otherwise error will be nil.
 func notifyPeers(request string) error {
-	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkName>
+	// So tmpArr[0] - Meta_Push | tmpArr[1] - <LynkID>
 	tmpArr := strings.Split(request, ":")
-	metaPath := lynxutil.HomePath + tmpArr[1] + "/" + tmpArr[1] + "_Tracker/" + "meta.info"
-	swarmPath := lynxutil.HomePath + tmpArr[1] + "/" + tmpArr[1] + "_Tracker/" + "swarm.info"
+	lynk, ok := findLynk(tmpArr[1])
+	if !ok {
+		return errors.New("Lynk Not Found")
+	}
+	metaPath := config.TrackerDir(lynk.ID) + "meta.info"
+	swarmPath := config.TrackerDir(lynk.ID) + "swarm.info"
 
 	// Opens the swarm file for the specific Lynk and notifies all of the listed peers
 	swarmFile, _ := os.Open(swarmPath)
@@ -285,15 +519,18 @@ func notifyPeers(request string) error {
 	tp := textproto.NewReader(r)
 	line, e := tp.ReadLine()
 	for e == nil {
-		peerArray := strings.Split(line, ":::")
-		// [0] is IP / [1 ]is Port
-		pConn, err := net.Dial("tcp", peerArray[0]+":"+peerArray[1])
+		peer, err := lynxutil.ParsePeer(line)
+		if err != nil {
+			line, e = tp.ReadLine()
+			continue
+		}
+		pConn, err := lynxutil.DialPeer(peer)
 		if err != nil {
 			line, e = tp.ReadLine()
 			continue
 		}
 
-		fmt.Fprintf(pConn, "Meta_Push:"+tmpArr[1]+"\n")
+		fmt.Fprint(pConn, "Meta_Push:"+lynk.ID+"\n")
 
 		fBytes, err := ioutil.ReadFile(metaPath)
 		//fmt.Println("fBytes: ", string(fBytes))
@@ -316,12 +553,13 @@ func notifyPeers(request string) error {
 
 		_, err = pConn.Write(cipherFile)
 		if err != nil {
-			fmt.Println("CONNECTION ERROR:", err)
+			logger.Lynk(lynk.Name).Peer(pConn.RemoteAddr().String()).Warn(
+				"Could Not Notify Peer: " + err.Error())
 			return err
 		}
 
 		time.Sleep(time.Duration(1) * time.Second)
-		//fmt.Println("TRACKER SENT", n, "BYTES TO PEER")
+		logger.Lynk(lynk.Name).Peer(pConn.RemoteAddr().String()).Debug("Notified Peer")
 
 		pConn.Close()
 		line, e = tp.ReadLine()
@@ -353,59 +591,134 @@ func sendFile(fileName string, conn net.Conn) error {
 	return fileToSend.Close()
 }
 
-// CreateSwarm - Creates a new swarm.info upon clicking of create button in gui
+// CreateSwarm - Creates a new swarm.info upon clicking of create button in gui. The swarm is kept
+// in the tracker directory under the lynk's ID.
 // @param string name - the name of the lynk
-func CreateSwarm(name string) {
-	p1 := lynxutil.Peer{IP: "", Port: "8080"}
-
-	currentuser, err := user.Current()
-	trackerDir := currentuser.HomeDir + "/Lynx/" + name + "/" + name + "_Tracker"
-	os.Mkdir(trackerDir, 0755)
+// @param string metaPath - the path of the lynk's meta.info, which the tracker keeps a copy of
+func CreateSwarm(name, metaPath string) {
+	header, err := lynxutil.ReadMetaHeader(metaPath)
+	if err != nil {
+		logger.Lynk(name).Error("Could Not Read meta.info: " + err.Error())
+		return
+	}
+	trackerDir := config.TrackerDir(header.ID)
+	os.MkdirAll(trackerDir, 0755)
 
-	_, err = os.Create(trackerDir + "/swarm.info")
+	err = lynxutil.WriteFileAtomic(trackerDir+"swarm.info", nil, 0644)
 	if err != nil {
-		fmt.Println(err)
+		logger.Lynk(name).Error("Could Not Create swarm.info: " + err.Error())
 	}
 
-	p1.IP = lynxutil.GetIP()
-	addToSwarminfo(p1, trackerDir+"/swarm.info")
+	// Adds ourselves with every address we have - both IPv4 and IPv6
+	p1, err := lynxutil.NewPeer(lynxutil.GetAddrs(config.ServerPort))
+	if err == nil {
+		addToSwarminfo(p1, trackerDir+"swarm.info")
+	}
 
-	lynxutil.FileCopy(currentuser.HomeDir+"/Lynx/"+name+"/meta.info", trackerDir+"/meta.info")
+	lynxutil.FileCopy(metaPath, trackerDir+"meta.info")
+	indexName(header.ID)
 }
 
-// Function which visits each tracker directory within the Lynx root
-// @param path: the path where the root directory is located
-// @param file: each file within the root or inner directories
-// @param err: any error we way encoutner along the way
-func visitTrackers(path string, file os.FileInfo, err error) error {
-	path = strings.Replace(path, "\\", "/", -1) // Switches windows \ to unix /
-	base := strings.TrimPrefix(path, lynxutil.HomePath)
-	split := strings.Split(base, "/")
+// Helper function which loads every lynk in the tracker directory, replacing the lynks we track -
+// the name of each directory is the ID of the lynk it holds
+func loadTrackers() {
+	tLynks.Reset(nil)
+	namesMu.Lock()
+	lynkNames = make(map[string]string)
+	namesMu.Unlock()
+
+	dirs, _ := ioutil.ReadDir(config.TrackerDir("")) // It may not exist yet on a fresh install
+	for _, dir := range dirs {
+		if dir.IsDir() && lynxutil.ValidLynkID(dir.Name()) {
+			tLynks.Add(trackedLynk(dir.Name()))
+			indexName(dir.Name())
+
+			swarmMu.Lock()
+			parseSwarminfo(config.TrackerDir(dir.Name()) + "swarm.info")
+			swarmMu.Unlock()
+		}
+	}
+}
 
-	// Checks that there is directory beneath another directory and has _tracker
-	if file.IsDir() && len(split) == 2 && strings.Contains(split[1], "_Tracker") {
-		//fmt.Println(file.Name())
-		lynkName := strings.TrimSuffix(file.Name(), "_Tracker")
-		tLynks = append(tLynks, lynxutil.Lynk{Name: lynkName})
-		// Need to populate Peers here.
+// Helper function which indexes a lynk we track under the name in its meta.info
+// @param string lynkID - The ID of the lynk
+func indexName(lynkID string) {
+	header, err := lynxutil.ReadMetaHeader(config.TrackerDir(lynkID) + "meta.info")
+	if err != nil || header.Name == "" {
+		return
 	}
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	lynkNames[header.Name] = lynkID
+}
 
-	return nil
+// Helper function which stops tracking a lynk. Its files are left for the caller to remove.
+// @param string lynkID - The ID of the lynk
+func forgetLynk(lynkID string) {
+	tLynks.Remove(lynkID)
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	for name, id := range lynkNames {
+		if id == lynkID {
+			delete(lynkNames, name)
+		}
+	}
+}
+
+// Helper function which returns a lynk we track. Lynks are tracked under their ID, which is
+// their name in tLynks as well.
+// @param string lynkID - The ID of the lynk
+// @return lynxutil.Lynk - The lynk
+func trackedLynk(lynkID string) lynxutil.Lynk {
+	return lynxutil.Lynk{Name: lynkID, ID: lynkID}
+}
+
+// Helper function which finds a lynk we track from the ID in a request. A lynk whose swarm was
+// created since we loaded the tracker directory is loaded too. Peers running older versions name
+// the lynk instead, which is looked up by the name in its meta.info.
+// @param string lynkID - The ID of the lynk - or its name, from peers running older versions
+// @return lynxutil.Lynk - The lynk
+// @return bool - Whether or not we track the lynk
+func findLynk(lynkID string) (lynxutil.Lynk, bool) {
+	lynkID = strings.TrimSpace(lynkID)
+	if lynk, ok := tLynks.Get(lynkID); ok {
+		return lynk, true
+	}
+	if lynxutil.ValidLynkID(lynkID) {
+		// Under swarmMu, as DropLynk removes the directory and forgets the lynk in one go
+		swarmMu.Lock()
+		info, err := os.Stat(config.TrackerDir(lynkID))
+		loaded := err == nil && info.IsDir()
+		if loaded {
+			tLynks.Add(trackedLynk(lynkID))
+			indexName(lynkID)
+		}
+		swarmMu.Unlock()
+		if loaded {
+			return tLynks.Get(lynkID)
+		}
+	}
+
+	namesMu.Lock()
+	id, ok := lynkNames[lynkID]
+	namesMu.Unlock()
+	if !ok {
+		return lynxutil.Lynk{}, false
+	}
+	return tLynks.Get(id)
 }
 
 // Function init runs before main and allows us to setup our tracker properly.
 func init() {
-	filepath.Walk(lynxutil.HomePath, visitTrackers)
+	loadTrackers()
 }
 
-// Helper function that returns a lynk's name give it's swarm.info filepath.
+// Helper function that returns the name we track a lynk under - its ID - given its swarm.info
+// filepath.
 // @param string swarmPath - The swarm.info path associated with the lynk we're interested in
-// @returns string - The lynk name
+// @returns string - The ID of the lynk
 func getTLynkName(swarmPath string) string {
-	tmpStr := strings.TrimSuffix(strings.TrimPrefix(swarmPath, lynxutil.HomePath), "/swarm.info")
-	split := strings.Split(tmpStr, "/")
-	//fmt.Println(split[0])
-	return split[0]
+	return strings.TrimSuffix(strings.TrimPrefix(swarmPath, config.TrackerDir("")), "/swarm.info")
 }
 
 // BroadcastNewIP - This function broadcasts a tracker's new IP address to all of its peers
@@ -413,14 +726,15 @@ func getTLynkName(swarmPath string) string {
 func BroadcastNewIP(swarmPath string) {
 	// Can update Meta here if needed
 	lynkName := getTLynkName(swarmPath)
-	lynk := lynxutil.GetLynk(tLynks, lynkName)
+	lynk, _ := tLynks.Get(lynkName)
 
 	i := 0
 	for i < len(lynk.Peers) {
 		//fmt.Println(i)
-		conn, err := net.Dial("tcp", lynk.Peers[i].IP+":"+lynk.Peers[i].Port)
+		conn, err := lynxutil.DialPeer(lynk.Peers[i])
 		if err == nil {
-			sendFile(lynxutil.HomePath+lynk.Name+"/meta.info", conn)
+			sendFile(config.TrackerDir(lynk.ID)+"meta.info", conn)
+			conn.Close()
 		}
 		//fmt.Println(lynk.Peers[i].IP)
 		i++
@@ -431,41 +745,44 @@ func BroadcastNewIP(swarmPath string) {
 // them if unable to connect.
 func PurgeOldIPs() {
 	// Loops through all tracker lynks.
-	for _, lynk := range tLynks {
-
+	for _, lynk := range tLynks.List() {
 		// Loops through all peers of a given lynk
-		i := 0
-		for i < len(lynk.Peers) {
-			conn, err := net.Dial("tcp", lynk.Peers[i].IP+":"+lynk.Peers[i].Port)
+		for _, peer := range lynk.Peers {
+			conn, err := lynxutil.DialPeer(peer)
 
 			// If we cannot connect, remove the peer
 			if err != nil {
-				deletePeer(lynk.Peers[i].IP, lynk.Name)
+				deletePeer(peer, lynk.Name)
+				continue
 			}
 			conn.Close()
-			i++
 		}
 	}
 }
 
 // TransferTracker - This function transfers the needed tracker files (swarm/meta.info) to the
 // specified IP and then deletes the local copies of these files.
-func TransferTracker(lynkName, owner, IP string) error {
-	conn, _ := net.Dial("tcp", IP+":"+lynxutil.TrackerPort)
+// @param string lynkID - The ID of the lynk
+func TransferTracker(lynkID, owner, IP string) error {
+	conn, err := net.Dial("tcp", net.JoinHostPort(IP, config.TrackerPort))
+	if err != nil {
+		return err
+	}
 
 	// Sends the new peer the needed tracker files
-	err := sendFile(lynxutil.HomePath+lynkName+"/"+lynkName+"_Tracker/swarm.info", conn)
+	err = sendFile(config.TrackerDir(lynkID)+"swarm.info", conn)
 	if err != nil {
 		return err
 	}
 
-	err = sendFile(lynxutil.HomePath+lynkName+"/"+lynkName+"_Tracker/meta.info", conn)
+	err = sendFile(config.TrackerDir(lynkID)+"meta.info", conn)
 	if err != nil {
 		return err
 	}
 
 	// Removes the tracker directory from this computer
-	os.RemoveAll(lynxutil.HomePath + lynkName + "/" + lynkName + "_Tracker/")
+	os.RemoveAll(config.TrackerDir(lynkID))
+	forgetLynk(lynkID)
 
 	return nil // No errors if we reach this point
 }
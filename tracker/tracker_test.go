@@ -6,20 +6,29 @@ package tracker
 
 import (
 	"bufio"
+	"bytes"
 	"capstone/lynxutil"
+	"capstone/mycrypt"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"os/user"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Count of the # of successful tests.
 var successful = 0
 
 // Total # of the tests.
-const total = 8
+const total = 17
 
 // Gets user's home directory */
 var cU, _ = user.Current()
@@ -28,10 +37,10 @@ var cU, _ = user.Current()
 var hPath = cU.HomeDir + "/Lynx/"
 
 // Uses homePath and our Tests Lynk to create swarm path */
-var sPath = hPath + "Tests/Tests_Tracker/swarm.info"
+var sPath = hPath + ".lynxtracker/Tests/swarm.info"
 
 // Uses homePath and our Tests Lynk to create meta path */
-var mPath = hPath + "Tests/Tests_Tracker/meta.info"
+var mPath = hPath + ".lynxtracker/Tests/meta.info"
 
 // Unit tests for listen, handle, and send functions
 // @param *testing.T t - The wrapper for the test
@@ -100,6 +109,286 @@ func TestListenHandleSend(t *testing.T) {
 
 }
 
+// Unit tests for finding the lynk a request names by its ID, or by its name from older peers
+// @param *testing.T t - The wrapper for the test
+func TestFindLynk(t *testing.T) {
+	fmt.Println("\n----------------TestFindLynk----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	Configure(cfg)
+
+	// A swarm created after the tracker directory was loaded
+	id, _ := lynxutil.NewLynkID()
+	os.MkdirAll(config.TrackerDir(id), 0755)
+	ioutil.WriteFile(config.TrackerDir(id)+"meta.info",
+		[]byte("announce:::127.0.0.1:9000\nid:::"+id+"\nlynkName:::Photos\nowner:::Max\n"), 0644)
+	found, ok := findLynk(id)
+	byName, nameOk := findLynk("Photos")
+	_, unknown := findLynk("Nope")
+	_, outside := findLynk("..")
+	if !ok || found.ID != id || !nameOk || byName.ID != id || unknown || outside {
+		t.Error("Test failed, expected to find the lynk by its ID. Got ", found, byName)
+	} else {
+		fmt.Println("Successfully Found Lynk By ID")
+		successful++
+	}
+
+	// A meta.info is only taken for the lynk it was pushed to
+	other, _ := lynxutil.NewLynkID()
+	rekey := push(id, "announce:::127.0.0.1:9000\nid:::"+other+"\nlynkName:::Photos\nowner:::Max\n")
+	broken := push(id, "announce:::127.0.0.1:9000\nid:::a:b\nlynkName:::Photos\nowner:::Max\n")
+	kept, _ := lynxutil.ReadMetaHeader(config.TrackerDir(id) + "meta.info")
+	same := push(id, "announce:::127.0.0.1:9000\nid:::"+id+"\nlynkName:::Photos\nowner:::Max\n"+
+		"revision:::2\n")
+	updated, _ := lynxutil.ReadMetaHeader(config.TrackerDir(id) + "meta.info")
+	if rekey == nil || broken == nil || kept.ID != id || same != nil || updated.Revision != 2 {
+		t.Error("Test failed, expected only the lynk's own meta.info to be taken. Got ", rekey,
+			broken, same, updated)
+	} else {
+		fmt.Println("Successfully Refused meta.info Of Another Lynk")
+		successful++
+	}
+
+	// Neither a huge push nor one which only grows huge once decompressed is read into memory
+	bomb := push(id, "announce:::127.0.0.1:9000\nid:::"+id+"\nlynkName:::Photos\nowner:::Max\n"+
+		"revision:::3\n"+strings.Repeat(" ", lynxutil.MaxMetaSize))
+	conn, peer := net.Pipe()
+	go func() {
+		peer.Write(make([]byte, lynxutil.MaxMetaSize+2))
+		peer.Close()
+	}()
+	huge := handlePush("Meta_Push:"+id, conn)
+	conn.Close()
+	kept, _ = lynxutil.ReadMetaHeader(config.TrackerDir(id) + "meta.info")
+	if bomb == nil || huge == nil || kept.Revision != 2 {
+		t.Error("Test failed, expected oversized meta.info to be refused. Got ", bomb, huge, kept)
+	} else {
+		fmt.Println("Successfully Refused Oversized meta.info")
+		successful++
+	}
+
+	// meta.info is only handed to peers with an unexpired invite signed by the owner's key
+	ownerKey, _ := lynxutil.LoadIdentity(home + "/owner.key")
+	otherKey, _ := lynxutil.LoadIdentity(home + "/other.key")
+	invited, _ := lynxutil.NewLynkID()
+	meta := "announce:::127.0.0.1:9000\nid:::" + invited + "\nlynkName:::Invited\nowner:::Max\n" +
+		"ownerKey:::" + lynxutil.EncodeOwnerKey(ownerKey.Public().(ed25519.PublicKey)) + "\n"
+	os.MkdirAll(config.TrackerDir(invited), 0755)
+	ioutil.WriteFile(config.TrackerDir(invited)+"meta.info", []byte(meta), 0644)
+	invite := lynxutil.Invite{LynkID: invited, Name: "Invited", Owner: "Max",
+		Trackers: []string{"127.0.0.1:9000"}}
+	valid, _ := lynxutil.NewInvite(invite, ownerKey, time.Hour)
+	forged, _ := lynxutil.NewInvite(invite, otherKey, time.Hour)
+	expired, _ := lynxutil.NewInvite(invite, ownerKey, -time.Minute)
+	if metaRequest(invited, valid) != meta || metaRequest(invited, "") != "" ||
+		metaRequest(invited, forged) != "" || metaRequest(invited, expired) != "" ||
+		metaRequest(id, valid) != "" ||
+		push(invited, strings.Replace(meta, "ownerKey:::", "ownerKey:::AAAA", 1)) == nil {
+		t.Error("Test failed, expected meta.info only for the owner's invite to the lynk.")
+	} else {
+		fmt.Println("Successfully Checked Invites Before Sending meta.info")
+		successful++
+	}
+}
+
+// Helper function which asks the tracker for a lynk's meta.info the way a joining peer does
+// @param string lynkID - The ID of the lynk
+// @param string invite - The invite shown to the tracker
+// @return string - What the tracker answered with
+func metaRequest(lynkID, invite string) string {
+	conn, peer := net.Pipe()
+	go handleRequest(conn)
+	peer.Write([]byte("Meta_Request:" + lynkID + ":127.0.0.1:8080\n" + invite + "\n"))
+	answer, _ := ioutil.ReadAll(peer)
+	peer.Close()
+	return string(answer)
+}
+
+// Helper function which pushes a meta.info to the tracker the way a peer does
+// @param string lynkID - The ID of the lynk it is pushed to
+// @param string meta - The meta.info
+// @return error - The error handlePush produced
+func push(lynkID, meta string) error {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(meta))
+	gz.Close()
+	cipherFile, _ := mycrypt.Encrypt([]byte(lynxutil.PrivateKey), b.Bytes())
+
+	conn, peer := net.Pipe()
+	go func() {
+		peer.Write(cipherFile)
+		peer.Close()
+	}()
+	defer conn.Close()
+	return handlePush("Meta_Push:"+lynkID, conn)
+}
+
+// Unit tests for limiting the requests of each client and stopping the tracker gracefully
+// @param *testing.T t - The wrapper for the test
+func TestServe(t *testing.T) {
+	fmt.Println("\n----------------TestServe----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	cfg.TrackerRate = 2
+	Configure(cfg)
+
+	first, second := allowClient("10.0.0.1:4000"), allowClient("10.0.0.1:4001")
+	third, other := allowClient("10.0.0.1:4002"), allowClient("10.0.0.2:4000")
+	if !first || !second || third || !other {
+		t.Error("Test failed, expected 2 requests a second from each client. Got ", first, second,
+			third, other)
+	} else {
+		fmt.Println("Successfully Limited Requests Per Client")
+		successful++
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		serve(ctx, listener)
+		close(stopped)
+	}()
+
+	// A request still being sent when we are told to stop is answered before we stop
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	waited := true
+	select {
+	case <-stopped:
+		waited = false
+	default:
+	}
+	conn.Write([]byte("Swarm_Request:Nope:127.0.0.1:8080\n"))
+	_, readErr := ioutil.ReadAll(conn)
+	_, dialErr := net.Dial("tcp", listener.Addr().String())
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		waited = false
+	}
+	if !waited || readErr != nil || dialErr == nil {
+		t.Error("Test failed, expected to stop once the request was answered. Got ", waited,
+			readErr, dialErr)
+	} else {
+		fmt.Println("Successfully Stopped Gracefully")
+		successful++
+	}
+}
+
+// Unit tests for hosting, listing and dropping lynks through the admin API
+// @param *testing.T t - The wrapper for the test
+func TestAdmin(t *testing.T) {
+	fmt.Println("\n----------------TestAdmin----------------")
+
+	home, err := ioutil.TempDir("", "lynx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	old := config
+	defer Configure(old)
+	cfg := old
+	cfg.HomePath = home + "/"
+	cfg.TrackerPath = home + "/trackers/"
+	cfg.AdminToken = "secret"
+	Configure(cfg)
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		AdminHandler(rw, req)
+		return rw
+	}
+
+	wrong := request("GET", "/admin/v1/status", "guess", "")
+	right := request("GET", "/admin/v1/status", "secret", "")
+	if wrong.Code != http.StatusUnauthorized || right.Code != http.StatusOK ||
+		!strings.Contains(right.Body.String(), `"lynks":0`) {
+		t.Error("Test failed, expected only the admin token to be let in. Got ", wrong.Code,
+			right.Body.String())
+	} else {
+		fmt.Println("Successfully Checked Admin Token")
+		successful++
+	}
+
+	id, _ := lynxutil.NewLynkID()
+	meta := "announce:::127.0.0.1:9000\nid:::" + id + "\nlynkName:::Photos\nowner:::Max\n"
+	// Only one of several requests hosting the same lynk at once wins
+	codes := make(chan int, 4)
+	for i := 0; i < cap(codes); i++ {
+		go func() { codes <- request("POST", "/admin/v1/lynks", "secret", meta).Code }()
+	}
+	created, conflicts := 0, 0
+	for i := 0; i < cap(codes); i++ {
+		switch <-codes {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+			conflicts++
+		}
+	}
+	list := request("GET", "/admin/v1/lynks?offset=0&limit=10", "secret", "")
+	one := request("GET", "/admin/v1/lynks/"+id, "secret", "")
+	byName, nameOk := findLynk("Photos")
+	if created != 1 || conflicts != cap(codes)-1 ||
+		list.Header().Get("X-Total-Count") != "1" || !strings.Contains(list.Body.String(), id) ||
+		!strings.Contains(one.Body.String(), `"name":"Photos"`) || !nameOk || byName.ID != id {
+		t.Error("Test failed, expected the lynk to be hosted once. Got ", created, conflicts,
+			list.Body.String(), one.Body.String())
+	} else {
+		fmt.Println("Successfully Hosted Lynk")
+		successful++
+	}
+
+	// Requests for the lynk while it is dropped must not bring it back
+	lookups := make(chan struct{})
+	go func() {
+		defer close(lookups)
+		for i := 0; i < 1000; i++ {
+			findLynk(id)
+		}
+	}()
+	dropped := request("DELETE", "/admin/v1/lynks/"+id, "secret", "")
+	<-lookups
+	_, statErr := os.Stat(config.TrackerDir(id))
+	_, found := findLynk(id)
+	_, foundByName := findLynk("Photos")
+	if dropped.Code != http.StatusOK || !os.IsNotExist(statErr) || found || foundByName {
+		t.Error("Test failed, expected the lynk to be dropped. Got ", dropped.Code, statErr)
+	} else {
+		fmt.Println("Successfully Dropped Lynk")
+		successful++
+	}
+}
+
 // Unit tests for parsing, updating, and adding to swarm.info
 // @param *testing.T t - The wrapper for the test
 func TestSwarminfo(t *testing.T) {
@@ -0,0 +1,541 @@
+// Package transport - This package hides how we actually reach a peer. A peer is dialed directly
+// when possible, through a UDP hole punched with the help of the tracker when it is behind a NAT,
+// and as a last resort through a relay on the tracker. Every path hands back a net.Conn so the
+// client and server code does not care which one was used.
+package transport
+
+import (
+	"../lynxutil"
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long we keep probing while punching a hole
+const punchTimeout = 5 * time.Second
+
+// How long we wait on the tracker to answer a rendezvous request
+const rendezvousTimeout = 3 * time.Second
+
+// How often the tracker's rendezvous request is resent in case it was lost
+const rendezvousRetry = 500 * time.Millisecond
+
+// How often a listener re-registers with the tracker to keep its NAT mapping alive
+const registerInterval = 20 * time.Second
+
+// How long the tracker remembers a registration it has not heard from
+const registrationTTL = 3 * registerInterval
+
+// How long a relay request waits on the other peer to connect to the tracker
+const relayTimeout = 10 * time.Second
+
+// The most registrations a rendezvous keeps - registrations are not authenticated, so without a
+// limit anyone could fill the tracker's memory
+const maxRegistrations = 10000
+
+// The most addresses we keep for one registration
+const maxRegisteredAddrs = 16
+
+// The most relays a rendezvous waits on at once
+const maxRelays = 256
+
+// Whether or not hole punching and relaying are tried - tests switch these off to force a path
+var usePunch = true
+var useRelay = true
+
+// Dial - Connects to a peer. The peer's own addresses are tried first, then a UDP hole punched
+// through the tracker, and finally a relay through the tracker.
+// @param lynxutil.Peer peer - The peer to connect to
+// @param string tracker - The host:port of a tracker the peer is registered with - may be empty
+// @return net.Conn - The connection to the peer
+// @return error - An error is produced if the peer could not be reached any way
+func Dial(peer lynxutil.Peer, tracker string) (net.Conn, error) {
+	conn, err := lynxutil.DialPeer(peer)
+	if err == nil {
+		return conn, nil
+	}
+	if _, _, tErr := net.SplitHostPort(tracker); tErr != nil {
+		return nil, err // No tracker to help us
+	}
+
+	if usePunch {
+		if conn, err = punch(peer, tracker); err == nil {
+			return conn, nil
+		}
+	}
+	if useRelay {
+		if conn, err = relay(peer, tracker); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Helper function which asks the tracker to introduce us to peer and then punches a hole to it
+// @param lynxutil.Peer peer - The peer to connect to
+// @param string tracker - The host:port of the tracker
+// @return net.Conn - The punched connection
+// @return error - An error is produced if the tracker does not know the peer or punching failed
+func punch(peer lynxutil.Peer, tracker string) (net.Conn, error) {
+	trackerAddr, err := net.ResolveUDPAddr("udp", tracker)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make(chan string, 1)
+	m := newMux(sock, true, func(msg string, from *net.UDPAddr) {
+		if sameAddr(from, trackerAddr) {
+			select {
+			case replies <- msg:
+			default:
+			}
+		}
+	})
+
+	// Syntax is "Punch_Request:<Addr>,<Addr>" - the addresses of the peer we want
+	request := "Punch_Request:" + strings.Join(peer.Addresses(), ",")
+	giveUp := time.After(rendezvousTimeout)
+	reply := ""
+	for reply == "" {
+		m.sendControl(trackerAddr, request)
+		select {
+		case reply = <-replies:
+		case <-time.After(rendezvousRetry):
+		case <-giveUp:
+			m.close()
+			return nil, errors.New("Tracker Did Not Answer Punch Request")
+		}
+	}
+
+	if !strings.HasPrefix(reply, "Punch_Peer:") {
+		m.close()
+		return nil, errors.New("Tracker Does Not Know Peer")
+	}
+	peerAddr, err := net.ResolveUDPAddr("udp", strings.TrimPrefix(reply, "Punch_Peer:"))
+	if err != nil {
+		m.close()
+		return nil, err
+	}
+
+	s, err := m.punch(peerAddr, punchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Helper function which asks the tracker to relay our connection to peer
+// @param lynxutil.Peer peer - The peer to connect to
+// @param string tracker - The host:port of the tracker
+// @return net.Conn - The relayed connection
+// @return error - An error is produced if the tracker could not reach the peer
+func relay(peer lynxutil.Peer, tracker string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", tracker, relayTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Syntax is "Relay_Request:<Addr>,<Addr>\n" - the tracker answers "Relay_OK\n" once the peer
+	// has connected and from then on simply copies bytes between us
+	fmt.Fprintln(conn, "Relay_Request:"+strings.Join(peer.Addresses(), ","))
+	conn.SetReadDeadline(time.Now().Add(relayTimeout + rendezvousTimeout))
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(reply) != "Relay_OK" {
+		conn.Close()
+		return nil, errors.New("Tracker Could Not Relay To Peer")
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	return &bufferedConn{conn, reader}, nil
+}
+
+// bufferedConn - A net.Conn whose reads go through a bufio.Reader that may already hold data
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read - Reads from the buffered reader rather than straight from the connection
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// Listener - Keeps us registered with a tracker so peers that cannot reach us directly can be
+// introduced to us for hole punching or relayed to us.
+type Listener struct {
+	m          *mux
+	tracker    *net.UDPAddr
+	trackerTCP string
+	addrs      []string
+	handler    func(net.Conn) error
+	done       chan struct{}
+}
+
+// Listen - Registers with the tracker and hands every punched or relayed connection to handler
+// @param string tracker - The host:port of the tracker
+// @param []string addrs - Our own host:port addresses - the ones peers find in swarm.info
+// @param func(net.Conn) error handler - The function used to handle each new connection
+// @return *Listener - The running listener
+// @return error - An error is produced if the tracker's address is invalid
+func Listen(tracker string, addrs []string, handler func(net.Conn) error) (*Listener, error) {
+	trackerAddr, err := net.ResolveUDPAddr("udp", tracker)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		tracker:    trackerAddr,
+		trackerTCP: tracker,
+		addrs:      addrs,
+		handler:    handler,
+		done:       make(chan struct{}),
+	}
+	l.m = newMux(sock, false, l.handleControl)
+	go l.register()
+	return l, nil
+}
+
+// Close - Stops registering with the tracker and closes our socket
+func (l *Listener) Close() {
+	select {
+	case <-l.done:
+		return
+	default:
+		close(l.done)
+	}
+	l.m.close()
+}
+
+// Sends "Punch_Register:<Addr>,<Addr>" to the tracker every registerInterval. This both tells the
+// tracker which public address our socket maps to and keeps that NAT mapping open.
+func (l *Listener) register() {
+	for {
+		l.m.sendControl(l.tracker, "Punch_Register:"+strings.Join(l.addrs, ","))
+		select {
+		case <-l.done:
+			return
+		case <-time.After(registerInterval):
+		}
+	}
+}
+
+// Handles a control message - only messages from our tracker are trusted
+func (l *Listener) handleControl(msg string, from *net.UDPAddr) {
+	if !sameAddr(from, l.tracker) {
+		return
+	}
+
+	if strings.HasPrefix(msg, "Punch_Peer:") {
+		peerAddr, err := net.ResolveUDPAddr("udp", strings.TrimPrefix(msg, "Punch_Peer:"))
+		if err != nil {
+			return
+		}
+		go func() {
+			s, err := l.m.punch(peerAddr, punchTimeout)
+			if err == nil {
+				l.handler(s)
+			}
+		}()
+	} else if strings.HasPrefix(msg, "Relay_Invite:") {
+		go l.acceptRelay(strings.TrimPrefix(msg, "Relay_Invite:"))
+	}
+}
+
+// Connects to the tracker to pick up a relayed connection
+// @param string token - The token the tracker gave the relay
+func (l *Listener) acceptRelay(token string) {
+	conn, err := net.DialTimeout("tcp", l.trackerTCP, relayTimeout)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(conn, "Relay_Accept:"+token)
+	l.handler(conn)
+}
+
+// Rendezvous - The tracker side of NAT traversal. It remembers the public UDP address of every
+// registered peer, introduces peers to each other for hole punching and relays connections.
+type Rendezvous struct {
+	sock          *net.UDPConn
+	mu            sync.Mutex
+	registrations map[string]*registration
+	relays        map[string]chan relayedConn
+	swept         time.Time // When expired registrations were last removed
+	closed        chan struct{}
+}
+
+// The public UDP address a peer registered from along with the addresses it advertises
+type registration struct {
+	addrs []string
+	udp   *net.UDPAddr
+	seen  time.Time
+}
+
+// A connection picked up by a peer that accepted a relay
+type relayedConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	picked chan struct{} // Closed once the requesting side has taken the connection
+	done   chan struct{} // Closed once the relay is finished
+}
+
+// NewRendezvous - Creates the tracker's rendezvous socket
+// @param string addr - The address to listen on for UDP - e.g. ":9000"
+// @return *Rendezvous - The rendezvous - call Serve to start answering requests
+// @return error - An error is produced if the socket cannot be created
+func NewRendezvous(addr string) (*Rendezvous, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sock, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rendezvous{
+		sock:          sock,
+		registrations: make(map[string]*registration),
+		relays:        make(map[string]chan relayedConn),
+		closed:        make(chan struct{}),
+	}, nil
+}
+
+// Addr - Returns the address of the rendezvous socket
+func (r *Rendezvous) Addr() string {
+	return r.sock.LocalAddr().String()
+}
+
+// Close - Closes the rendezvous socket
+func (r *Rendezvous) Close() error {
+	select {
+	case <-r.closed:
+		return nil
+	default:
+		close(r.closed)
+	}
+	return r.sock.Close()
+}
+
+// Serve - Answers registrations and punch requests until the rendezvous is closed
+func (r *Rendezvous) Serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := r.sock.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.closed:
+				return
+			default:
+				continue
+			}
+		}
+
+		msg := strings.TrimSpace(string(buf[:n]))
+		if strings.HasPrefix(msg, "Punch_Register:") {
+			addrs := observedAddrs(strings.Split(strings.TrimPrefix(msg, "Punch_Register:"), ","),
+				from)
+			r.register(from, addrs)
+		} else if strings.HasPrefix(msg, "Punch_Request:") {
+			reg := r.find(strings.Split(strings.TrimPrefix(msg, "Punch_Request:"), ","))
+			if reg == nil {
+				r.send(from, "Punch_Unknown")
+				continue
+			}
+			// Introduces both sides to each other so they can send probes at the same time
+			r.send(from, "Punch_Peer:"+reg.udp.String())
+			r.send(reg.udp, "Punch_Peer:"+from.String())
+		}
+	}
+}
+
+// HandleRelay - Handles a relay request or acceptance made to the tracker over TCP. For a
+// request this blocks until the relayed connection is finished.
+// @param string request - The request line - "Relay_Request:<Addrs>" or "Relay_Accept:<Token>"
+// @param net.Conn conn - The socket the request came in on
+// @param *bufio.Reader reader - The reader the request line was read from
+// @return error - An error is produced if the peer is unknown or never connected
+func (r *Rendezvous) HandleRelay(request string, conn net.Conn, reader *bufio.Reader) error {
+	request = strings.TrimSpace(request)
+
+	if strings.HasPrefix(request, "Relay_Accept:") {
+		token := strings.TrimPrefix(request, "Relay_Accept:")
+		r.mu.Lock()
+		accepted, ok := r.relays[token]
+		delete(r.relays, token)
+		r.mu.Unlock()
+		if !ok {
+			return errors.New("Unknown Relay Token")
+		}
+
+		picked, done := make(chan struct{}), make(chan struct{})
+		accepted <- relayedConn{conn, reader, picked, done}
+		select {
+		case <-picked:
+			<-done // The requesting side copies until both are finished
+			return nil
+		case <-time.After(rendezvousTimeout):
+			// The requesting side gave up just as we arrived, so nobody will pick us up
+			return errors.New("Relay Request Timed Out")
+		}
+	}
+
+	reg := r.find(strings.Split(strings.TrimPrefix(request, "Relay_Request:"), ","))
+	if reg == nil {
+		fmt.Fprintf(conn, "Relay_Unknown\n")
+		return errors.New("Unknown Peer")
+	}
+
+	token := newToken()
+	accepted := make(chan relayedConn, 1)
+	r.mu.Lock()
+	if len(r.relays) >= maxRelays {
+		r.mu.Unlock()
+		fmt.Fprintf(conn, "Relay_Failed\n")
+		return errors.New("Too Many Relays")
+	}
+	r.relays[token] = accepted
+	r.mu.Unlock()
+	r.send(reg.udp, "Relay_Invite:"+token)
+
+	select {
+	case other := <-accepted:
+		close(other.picked)
+		fmt.Fprintf(conn, "Relay_OK\n")
+		pipe(conn, reader, other.conn, other.reader)
+		close(other.done)
+		return nil
+	case <-time.After(relayTimeout):
+		r.mu.Lock()
+		delete(r.relays, token)
+		r.mu.Unlock()
+		fmt.Fprintf(conn, "Relay_Failed\n")
+		return errors.New("Peer Did Not Accept Relay")
+	}
+}
+
+// Helper function which binds the addresses a registration claims to the host it was sent from -
+// only the ports are taken from the claim. Otherwise anyone could register a victim's addresses
+// and be introduced to, or be relayed, the connections meant for it. A peer behind a NAT is still
+// found, as the tracker stores the address it sees each peer at in swarm.info too.
+// @param []string addrs - The host:port addresses claimed
+// @param *net.UDPAddr from - The address the registration came from
+// @return []string - The claimed ports on the host of from
+func observedAddrs(addrs []string, from *net.UDPAddr) []string {
+	var bound []string
+	for _, addr := range addrs {
+		_, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+		if err != nil {
+			continue
+		}
+		observed := net.JoinHostPort(from.IP.String(), port)
+		known := false
+		for _, have := range bound {
+			known = known || have == observed
+		}
+		if !known {
+			bound = append(bound, observed)
+		}
+	}
+	return bound
+}
+
+// Stores the registration of a peer. Expired registrations are swept out at most once every
+// registerInterval, and once maxRegistrations are kept only those we already have are refreshed.
+// @param *net.UDPAddr from - The address the registration came from
+// @param []string addrs - The addresses observedAddrs bound to from
+func (r *Rendezvous) register(from *net.UDPAddr, addrs []string) {
+	if len(addrs) == 0 {
+		return
+	} else if len(addrs) > maxRegisteredAddrs {
+		addrs = addrs[:maxRegisteredAddrs]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.swept) > registerInterval {
+		for key, reg := range r.registrations {
+			if time.Since(reg.seen) > registrationTTL {
+				delete(r.registrations, key)
+			}
+		}
+		r.swept = time.Now()
+	}
+
+	key := from.String()
+	if _, ok := r.registrations[key]; !ok && len(r.registrations) >= maxRegistrations {
+		return
+	}
+	r.registrations[key] = &registration{addrs: addrs, udp: from, seen: time.Now()}
+}
+
+// Finds the most recent live registration which shares an address with addrs
+func (r *Rendezvous) find(addrs []string) *registration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found *registration
+	for key, reg := range r.registrations {
+		if time.Since(reg.seen) > registrationTTL {
+			delete(r.registrations, key)
+			continue
+		}
+		for _, have := range reg.addrs {
+			for _, want := range addrs {
+				if have == strings.TrimSpace(want) && (found == nil || reg.seen.After(found.seen)) {
+					found = reg
+				}
+			}
+		}
+	}
+	return found
+}
+
+// Sends a control message from the rendezvous socket
+func (r *Rendezvous) send(addr *net.UDPAddr, msg string) {
+	r.sock.WriteToUDP([]byte(msg+"\n"), addr)
+}
+
+// Copies bytes in both directions until either side is finished, then closes both sides
+func pipe(a net.Conn, aReader io.Reader, b net.Conn, bReader io.Reader) {
+	finished := make(chan struct{}, 2)
+	go func() {
+		io.Copy(b, aReader)
+		finished <- struct{}{}
+	}()
+	go func() {
+		io.Copy(a, bReader)
+		finished <- struct{}{}
+	}()
+	<-finished
+	a.Close()
+	b.Close()
+	<-finished
+}
+
+// Checks whether two UDP addresses are the same, treating IPv4 and IPv4-mapped IPv6 as equal
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && (a.IP.Equal(b.IP) || b.IP.IsUnspecified())
+}
+
+// Generates a random token used to pair up the two sides of a relay
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,398 @@
+// The unit tests for our transport
+package transport
+
+import (
+	"../lynxutil"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Count of the # of successful tests.
+var successful = 0
+
+// Total # of the tests.
+const total = 11
+
+// An address nothing listens on, so dialing the peer directly always fails
+const unreachable = "127.0.0.1:1"
+
+// Starts a tracker on loopback which only does NAT traversal
+// @param *testing.T t - The wrapper for the test
+// @return string - The tracker's host:port
+// @return func() - Stops the tracker
+func startTracker(t *testing.T) (string, func()) {
+	welcome, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRendezvous(welcome.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Serve()
+
+	go func() {
+		for {
+			conn, err := welcome.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				request, err := reader.ReadString('\n')
+				if err == nil {
+					r.HandleRelay(request, conn, reader)
+				}
+				conn.Close()
+			}()
+		}
+	}()
+
+	return welcome.Addr().String(), func() {
+		welcome.Close()
+		r.Close()
+	}
+}
+
+// Echoes a single line back and closes the connection - stands in for the server
+func echo(conn net.Conn) error {
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err == nil {
+		fmt.Fprint(conn, line)
+	}
+	return conn.Close()
+}
+
+// Helper function which dials the peer, sends a line and checks it comes back
+func dialAndEcho(peer lynxutil.Peer, tracker string) (string, error) {
+	conn, err := Dial(peer, tracker)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "Hello Through The NAT\n")
+	reply, err := ioutil.ReadAll(conn)
+	return string(reply), err
+}
+
+// Forwards packets between two sockets like a poor network link - each packet is dropped with
+// the given probability and otherwise delayed by between delay and twice delay, so packets are
+// also reordered
+// @param *testing.T t - The wrapper for the test
+// @param *net.UDPAddr a - The address of one side
+// @param *net.UDPAddr b - The address of the other side
+// @param float64 loss - The probability of dropping a packet
+// @param time.Duration delay - The shortest delay of a packet
+// @return *net.UDPAddr - The address a sends to in order to reach b
+// @return *net.UDPAddr - The address b sends to in order to reach a
+// @return func() - Stops the link
+func lossyLink(t *testing.T, a, b *net.UDPAddr, loss float64,
+	delay time.Duration) (*net.UDPAddr, *net.UDPAddr, func()) {
+	toB, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toA, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	random := rand.New(rand.NewSource(1))
+	forward := func(in, out *net.UDPConn, to *net.UDPAddr) {
+		buf := make([]byte, 2048)
+		for {
+			n, _, err := in.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pkt := append([]byte(nil), buf[:n]...)
+			mu.Lock()
+			dropped := random.Float64() < loss
+			wait := delay + time.Duration(random.Int63n(int64(delay)))
+			mu.Unlock()
+			if !dropped {
+				time.AfterFunc(wait, func() { out.WriteToUDP(pkt, to) })
+			}
+		}
+	}
+	// a sends to toB, which is forwarded to b from toA - so b sees a at toA and the other way round
+	go forward(toB, toA, b)
+	go forward(toA, toB, a)
+
+	return toB.LocalAddr().(*net.UDPAddr), toA.LocalAddr().(*net.UDPAddr), func() {
+		toB.Close()
+		toA.Close()
+	}
+}
+
+// Unit tests for moving a file's worth of data over a punched stream on a lossy, slow link.
+// @param *testing.T t - The wrapper for the test
+func TestThroughput(t *testing.T) {
+	fmt.Println("\n----------------TestThroughput----------------")
+
+	sockA, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	sockB, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	a, b := newMux(sockA, true, nil), newMux(sockB, true, nil)
+	defer a.close()
+	defer b.close()
+
+	// 2% loss each way and a round trip of 50 to 100ms
+	bFromA, aFromB, stop := lossyLink(t, sockA.LocalAddr().(*net.UDPAddr),
+		sockB.LocalAddr().(*net.UDPAddr), 0.02, 25*time.Millisecond)
+	defer stop()
+
+	var receiver *session
+	var punchErr error
+	punched := make(chan struct{})
+	go func() {
+		receiver, punchErr = b.punch(aFromB, punchTimeout)
+		close(punched)
+	}()
+	sender, err := a.punch(bFromA, punchTimeout)
+	<-punched
+	if err != nil || punchErr != nil {
+		t.Fatal("Could Not Punch Through The Link: ", err, punchErr)
+	}
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+	start := time.Now()
+	go func() {
+		sender.Write(data)
+		sender.Close()
+	}()
+	received, err := ioutil.ReadAll(receiver)
+	elapsed := time.Since(start)
+
+	// Stop-and-wait would need over 40 seconds - one packet per round trip
+	rate := float64(len(received)) / 1024 / elapsed.Seconds()
+	if err != nil || !bytes.Equal(received, data) || elapsed > 10*time.Second {
+		t.Error("Test failed, expected 1 MB intact within 10 seconds. Got ", len(received),
+			err, elapsed)
+	} else {
+		fmt.Printf("Successfully Sent 1 MB In %v (%.0f KB/s)\n", elapsed, rate)
+		successful++
+	}
+}
+
+// Unit tests for the tracker only introducing peers at the addresses it saw them at, and for a
+// relay which is accepted just as its request gives up.
+// @param *testing.T t - The wrapper for the test
+func TestRendezvous(t *testing.T) {
+	fmt.Println("\n----------------TestRendezvous----------------")
+
+	r, err := NewRendezvous("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Serve()
+	defer r.Close()
+
+	addr, _ := net.ResolveUDPAddr("udp", r.Addr())
+	sock, _ := net.DialUDP("udp", nil, addr)
+	defer sock.Close()
+	sock.Write([]byte("Punch_Register:10.9.9.9:8080,192.168.1.5:8081\n"))
+	time.Sleep(100 * time.Millisecond)
+
+	if victim := r.find([]string{"10.9.9.9:8080"}); victim != nil ||
+		r.find([]string{"127.0.0.1:8080"}) == nil || r.find([]string{"127.0.0.1:8081"}) == nil {
+		t.Error("Test failed, expected the claimed ports on the observed host only. Got ", victim)
+	} else {
+		fmt.Println("Successfully Bound Registration To Its Source")
+		successful++
+	}
+
+	// Nobody is waiting on the other end of the token any more
+	r.mu.Lock()
+	r.relays["late"] = make(chan relayedConn, 1)
+	r.mu.Unlock()
+	ours, theirs := net.Pipe()
+	defer ours.Close()
+	returned := make(chan error, 1)
+	go func() {
+		returned <- r.HandleRelay("Relay_Accept:late", theirs, bufio.NewReader(theirs))
+	}()
+	select {
+	case err = <-returned:
+	case <-time.After(2 * rendezvousTimeout):
+		err = nil
+	}
+	if err == nil {
+		t.Error("Test failed, expected a late relay accept to give up.")
+	} else {
+		fmt.Println("Successfully Gave Up On Late Relay Accept")
+		successful++
+	}
+}
+
+// Unit tests for the limits on the registrations and relays a rendezvous keeps.
+// @param *testing.T t - The wrapper for the test
+func TestRendezvousLimits(t *testing.T) {
+	fmt.Println("\n----------------TestRendezvousLimits----------------")
+
+	r, err := NewRendezvous("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	r.register(peer, []string{"127.0.0.1:8080"})
+	r.mu.Lock()
+	for i := 0; i < maxRelays; i++ {
+		r.relays[newToken()] = make(chan relayedConn, 1)
+	}
+	r.mu.Unlock()
+	ours, theirs := net.Pipe()
+	defer ours.Close()
+	go ioutil.ReadAll(ours)
+	err = r.HandleRelay("Relay_Request:127.0.0.1:8080", theirs, bufio.NewReader(theirs))
+
+	if err == nil || len(r.relays) != maxRelays {
+		t.Error("Test failed, expected a relay beyond the limit to be refused. Got ", err)
+	} else {
+		fmt.Println("Successfully Refused Relay Beyond The Limit")
+		successful++
+	}
+
+	r.mu.Lock()
+	for i := 1; len(r.registrations) < maxRegistrations; i++ {
+		from := &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 8080}
+		r.registrations[from.String()] = &registration{addrs: []string{from.String()},
+			udp: from, seen: time.Now()}
+	}
+	r.mu.Unlock()
+	stranger := &net.UDPAddr{IP: net.IPv4(10, 1, 0, 1), Port: 8080}
+	r.register(stranger, []string{"10.1.0.1:8080"})
+	r.register(peer, []string{"127.0.0.1:8080", "127.0.0.1:8081"})
+
+	if r.find([]string{"10.1.0.1:8080"}) != nil || r.find([]string{"127.0.0.1:8081"}) == nil {
+		t.Error("Test failed, expected a full rendezvous to refresh registrations only.")
+	} else {
+		fmt.Println("Successfully Refused Registration Beyond The Limit")
+		successful++
+	}
+
+	// Ages every registration and pretends the last sweep was long ago
+	r.mu.Lock()
+	for _, reg := range r.registrations {
+		reg.seen = time.Now().Add(-2 * registrationTTL)
+	}
+	r.swept = time.Time{}
+	r.mu.Unlock()
+	r.register(stranger, []string{"10.1.0.1:8080"})
+
+	if len(r.registrations) != 1 || r.find([]string{"10.1.0.1:8080"}) == nil {
+		t.Error("Test failed, expected expired registrations to be swept. Got ",
+			len(r.registrations))
+	} else {
+		fmt.Println("Successfully Swept Expired Registrations")
+		successful++
+	}
+}
+
+// Unit tests for a session which is sent more than the application reads.
+// @param *testing.T t - The wrapper for the test
+func TestReceiveBuffer(t *testing.T) {
+	fmt.Println("\n----------------TestReceiveBuffer----------------")
+
+	sock, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	remote, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer remote.Close()
+	m := newMux(sock, false, nil)
+	defer m.close()
+	m.mu.Lock()
+	s := m.newSession(remote.LocalAddr().(*net.UDPAddr))
+	m.mu.Unlock()
+	defer m.remove(s)
+
+	full := uint32(maxReceiveBuffer / maxPayload)
+	payload := make([]byte, maxPayload)
+	for seq := uint32(0); seq < full+5; seq++ {
+		s.receive(seq, payload)
+	}
+	s.receive(full, payload)
+
+	if s.expected != full || s.buf.Len() != maxReceiveBuffer {
+		t.Error("Test failed, expected the buffer to stop at", maxReceiveBuffer, "bytes. Got ",
+			s.buf.Len())
+	} else {
+		fmt.Println("Successfully Stopped Acking With A Full Buffer")
+		successful++
+	}
+
+	s.Read(make([]byte, maxPayload))
+	s.receive(full, payload)
+
+	if s.expected != full+1 {
+		t.Error("Test failed, expected the resent packet to be taken once read made room.")
+	} else {
+		fmt.Println("Successfully Took Packet Once Read Made Room")
+		successful++
+	}
+}
+
+// Unit tests for reaching a peer we cannot dial directly by punching and by relaying.
+// @param *testing.T t - The wrapper for the test
+func TestDial(t *testing.T) {
+	tracker, stop := startTracker(t)
+	defer stop()
+
+	l, err := Listen(tracker, []string{unreachable}, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	time.Sleep(200 * time.Millisecond) // Gives the registration time to reach the tracker
+
+	peer, _ := lynxutil.NewPeer([]string{unreachable})
+
+	fmt.Println("\n----------------TestPunch----------------")
+
+	useRelay = false
+	reply, err := dialAndEcho(peer, tracker)
+	useRelay = true
+
+	if err != nil || reply != "Hello Through The NAT\n" {
+		t.Error("Test failed, expected echo through punched hole. Got ", reply, err)
+	} else {
+		fmt.Println("Successfully Punched Through To Peer")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestRelay----------------")
+
+	usePunch = false
+	reply, err = dialAndEcho(peer, tracker)
+	usePunch = true
+
+	if err != nil || reply != "Hello Through The NAT\n" {
+		t.Error("Test failed, expected echo through relay. Got ", reply, err)
+	} else {
+		fmt.Println("Successfully Relayed To Peer")
+		successful++
+	}
+
+	fmt.Println("\n----------------TestUnknownPeer----------------")
+
+	stranger, _ := lynxutil.NewPeer([]string{"127.0.0.1:2"})
+	_, err = Dial(stranger, tracker)
+
+	if err == nil {
+		t.Error("Test failed, expected an error for a peer the tracker does not know")
+	} else {
+		fmt.Println("Successfully Failed To Reach Unknown Peer")
+		successful++
+	}
+
+	fmt.Println("\nSuccess on ", successful, "/", total, " tests.")
+}
@@ -0,0 +1,525 @@
+// Package transport - This file holds the reliable stream we run over a hole punched UDP socket.
+// Several streams can share one socket, each one identified by the address of the other side.
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The types of binary packets sent over a punched socket. Text control messages from the
+// tracker always start with a letter so they can never be mistaken for one of these.
+const (
+	pktData     byte = 1
+	pktAck      byte = 2
+	pktFin      byte = 3
+	pktProbe    byte = 4
+	pktProbeAck byte = 5
+)
+
+// The largest payload carried by a single data packet - small enough to avoid fragmentation
+const maxPayload = 1200
+
+// The size of a packet header - 1 byte type and 4 byte sequence number
+const headerLength = 5
+
+// How long we wait on an ack before sending a packet again
+const retransmitInterval = 200 * time.Millisecond
+
+// The most packets sent but not yet acked - with 1200 byte packets about 77 KB per round trip
+const window = 64
+
+// The most bytes a session holds which the application has not read yet. Once it is full we stop
+// acking data, so the other side keeps resending until Read makes room.
+const maxReceiveBuffer = 4 * window * maxPayload
+
+// How many acks repeating the same sequence number make us resend the packet they are waiting on
+// without waiting for retransmitInterval
+const dupAckLimit = 3
+
+// How long we keep resending a packet before deciding the other side is gone
+const sendTimeout = 10 * time.Second
+
+// How often we send probes while punching a hole
+const probeInterval = 100 * time.Millisecond
+
+// timeoutError - The error returned when a read deadline passes
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// A mux owns one UDP socket and hands the packets it reads to the right session
+type mux struct {
+	sock      *net.UDPConn
+	owned     bool // Whether or not the socket should be closed along with its only session
+	mu        sync.Mutex
+	sessions  map[string]*session
+	onControl func(msg string, from *net.UDPAddr)
+	closed    chan struct{}
+}
+
+// session - A reliable, ordered stream to a single remote address. It implements net.Conn. Up to
+// window packets are in flight at once and every ack carries the next sequence number the other
+// side expects, so one ack covers every packet before it.
+type session struct {
+	m           *mux
+	remote      *net.UDPAddr
+	mu          sync.Mutex
+	cond        *sync.Cond // Signalled when data arrives, packets are acked or the session ends
+	buf         bytes.Buffer
+	expected    uint32            // The next sequence number we will read
+	pending     map[uint32][]byte // Packets which arrived ahead of expected
+	sendBase    uint32            // The oldest sequence number the other side has not acked
+	sendSeq     uint32            // The next sequence number we will send
+	unacked     []outPacket       // The packets from sendBase to sendSeq, oldest first
+	dupAcks     int               // Acks in a row for sendBase
+	progress    time.Time         // When the other side last acked something new
+	sendErr     error             // Set once the other side stops acking
+	eof         bool
+	closed      bool
+	deadline    time.Time
+	writeMu     sync.Mutex
+	established chan struct{}
+	once        sync.Once
+	stop        chan struct{} // Closed once the session is removed
+	stopOnce    sync.Once
+}
+
+// A packet sent over a session which has not been acked yet
+type outPacket struct {
+	kind    byte
+	payload []byte
+	sent    time.Time
+}
+
+// Creates a new mux reading from sock
+// @param *net.UDPConn sock - The socket to read from
+// @param bool owned - Whether the socket is closed when its session closes
+// @param func(string, *net.UDPAddr) onControl - Called with each text control message we read
+// @return *mux - The running mux
+func newMux(sock *net.UDPConn, owned bool, onControl func(string, *net.UDPAddr)) *mux {
+	m := &mux{
+		sock:      sock,
+		owned:     owned,
+		sessions:  make(map[string]*session),
+		onControl: onControl,
+		closed:    make(chan struct{}),
+	}
+	go m.readLoop()
+	return m
+}
+
+// Closes the mux and its socket
+func (m *mux) close() {
+	select {
+	case <-m.closed:
+		return
+	default:
+		close(m.closed)
+	}
+	m.sock.Close()
+}
+
+// Sends a text control message to addr
+func (m *mux) sendControl(addr *net.UDPAddr, msg string) error {
+	_, err := m.sock.WriteToUDP([]byte(msg+"\n"), addr)
+	return err
+}
+
+// Sends a binary packet to addr
+func (m *mux) sendPacket(addr *net.UDPAddr, kind byte, seq uint32, payload []byte) error {
+	pkt := make([]byte, headerLength+len(payload))
+	pkt[0] = kind
+	binary.BigEndian.PutUint32(pkt[1:headerLength], seq)
+	copy(pkt[headerLength:], payload)
+	_, err := m.sock.WriteToUDP(pkt, addr)
+	return err
+}
+
+// Reads packets until the socket is closed
+func (m *mux) readLoop() {
+	buf := make([]byte, maxPayload+headerLength+512)
+	for {
+		n, from, err := m.sock.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-m.closed:
+				return
+			default:
+				if strings.Contains(err.Error(), "closed") {
+					return
+				}
+				continue
+			}
+		}
+		if n == 0 {
+			continue
+		}
+
+		if buf[0] > pktProbeAck { // A text control message
+			if m.onControl != nil {
+				m.onControl(strings.TrimSpace(string(buf[:n])), from)
+			}
+			continue
+		}
+		if n < headerLength {
+			continue
+		}
+
+		kind := buf[0]
+		seq := binary.BigEndian.Uint32(buf[1:headerLength])
+		payload := append([]byte(nil), buf[headerLength:n]...)
+		m.handlePacket(kind, seq, payload, from)
+	}
+}
+
+// Hands a binary packet to its session. Sessions are only ever created by punch so packets from
+// anyone we have not been introduced to by the tracker are dropped.
+func (m *mux) handlePacket(kind byte, seq uint32, payload []byte, from *net.UDPAddr) {
+	m.mu.Lock()
+	s := m.sessions[from.String()]
+	m.mu.Unlock()
+
+	if s == nil {
+		return // A packet from someone we never punched towards
+	}
+
+	switch kind {
+	case pktProbe:
+		m.sendPacket(from, pktProbeAck, 0, nil)
+		s.establish()
+	case pktProbeAck:
+		s.establish()
+	case pktData:
+		s.receive(seq, payload)
+	case pktFin:
+		s.receiveFin(seq)
+	case pktAck:
+		s.receiveAck(seq)
+	}
+}
+
+// Creates a session for addr - m.mu must be held
+func (m *mux) newSession(addr *net.UDPAddr) *session {
+	s := &session{
+		m:           m,
+		remote:      addr,
+		pending:     make(map[uint32][]byte),
+		established: make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	m.sessions[addr.String()] = s
+	go s.retransmitLoop()
+	return s
+}
+
+// Punches a hole towards addr by sending probes until the other side answers
+// @param *net.UDPAddr addr - The public address of the other side, as seen by the tracker
+// @param time.Duration timeout - How long we keep probing
+// @return *session - The established session
+// @return error - An error is produced if the other side never answered
+func (m *mux) punch(addr *net.UDPAddr, timeout time.Duration) (*session, error) {
+	m.mu.Lock()
+	s := m.sessions[addr.String()]
+	if s == nil {
+		s = m.newSession(addr)
+	}
+	m.mu.Unlock()
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		m.sendPacket(addr, pktProbe, 0, nil)
+		select {
+		case <-s.established:
+			return s, nil
+		case <-ticker.C:
+		case <-deadline:
+			m.remove(s)
+			return nil, errors.New("Could Not Punch Through To " + addr.String())
+		case <-m.closed:
+			return nil, errors.New("Socket Closed")
+		}
+	}
+}
+
+// Removes a session from the mux
+func (m *mux) remove(s *session) {
+	s.stopOnce.Do(func() { close(s.stop) })
+	m.mu.Lock()
+	delete(m.sessions, s.remote.String())
+	m.mu.Unlock()
+	if m.owned {
+		m.close()
+	}
+}
+
+// Marks a session as established
+func (s *session) establish() {
+	s.once.Do(func() { close(s.established) })
+}
+
+// Handles an incoming data packet. Packets ahead of the one we expect are held until the gap is
+// filled, and every packet is answered with the next sequence number we expect - so duplicates
+// are acked again in case our ack was lost, and a gap shows up as repeated acks. Packets which
+// would grow the buffer past maxReceiveBuffer are dropped without an ack.
+func (s *session) receive(seq uint32, payload []byte) {
+	s.mu.Lock()
+	if s.eof {
+		// Nothing follows the end of the stream
+	} else if s.buf.Len()+len(payload) > maxReceiveBuffer {
+		// The application is not keeping up - without an ack the packet is sent again later
+		s.mu.Unlock()
+		return
+	} else if seq == s.expected {
+		s.buf.Write(payload)
+		s.expected++
+		for next, ok := s.pending[s.expected]; ok; next, ok = s.pending[s.expected] {
+			delete(s.pending, s.expected)
+			s.buf.Write(next)
+			s.expected++
+		}
+		s.cond.Broadcast()
+	} else if seq > s.expected && seq-s.expected < window {
+		s.pending[seq] = payload
+	}
+	ack := s.expected
+	s.mu.Unlock()
+
+	s.m.sendPacket(s.remote, pktAck, ack, nil)
+}
+
+// Handles the other side closing its half of the stream. It is only sent once all of its data
+// was acked, so it always comes in order.
+func (s *session) receiveFin(seq uint32) {
+	s.mu.Lock()
+	if seq == s.expected {
+		s.eof = true
+		s.expected++
+		s.cond.Broadcast()
+	}
+	ack := s.expected
+	s.mu.Unlock()
+	s.m.sendPacket(s.remote, pktAck, ack, nil)
+}
+
+// Handles an ack - every packet before the sequence number it carries has arrived
+func (s *session) receiveAck(ack uint32) {
+	var resend *outPacket
+	s.mu.Lock()
+	if ack-s.sendBase > 0 && ack-s.sendBase <= uint32(len(s.unacked)) {
+		s.unacked = s.unacked[ack-s.sendBase:]
+		s.sendBase = ack
+		s.dupAcks = 0
+		s.progress = time.Now()
+		s.cond.Broadcast()
+	} else if ack == s.sendBase && len(s.unacked) > 0 {
+		// The other side is missing the oldest packet but has what came after it
+		if s.dupAcks++; s.dupAcks == dupAckLimit {
+			s.unacked[0].sent = time.Now()
+			first := s.unacked[0]
+			resend = &first
+		}
+	}
+	s.mu.Unlock()
+
+	if resend != nil {
+		s.m.sendPacket(s.remote, resend.kind, ack, resend.payload)
+	}
+}
+
+// Resends every packet which has waited retransmitInterval for an ack, until the session is
+// removed. Once nothing has been acked for sendTimeout the session fails.
+func (s *session) retransmitLoop() {
+	ticker := time.NewTicker(retransmitInterval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.m.closed:
+			return
+		case <-ticker.C:
+		}
+
+		type resend struct {
+			seq uint32
+			pkt outPacket
+		}
+		var due []resend
+		now := time.Now()
+		s.mu.Lock()
+		if len(s.unacked) > 0 && s.sendErr == nil && now.Sub(s.progress) > sendTimeout {
+			s.sendErr = errors.New("Peer Stopped Acknowledging")
+			s.cond.Broadcast()
+		} else if s.sendErr == nil {
+			for i := range s.unacked {
+				if now.Sub(s.unacked[i].sent) >= retransmitInterval {
+					s.unacked[i].sent = now
+					due = append(due, resend{s.sendBase + uint32(i), s.unacked[i]})
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		for _, r := range due {
+			s.m.sendPacket(s.remote, r.pkt.kind, r.seq, r.pkt.payload)
+		}
+	}
+}
+
+// Queues a packet and sends it, first waiting until there is room in the window. s.mu must be
+// held and is released while sending.
+// @return error - An error is produced if the other side stopped acking or we were closed
+func (s *session) send(kind byte, payload []byte) error {
+	for len(s.unacked) >= window && s.sendErr == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.sendErr != nil {
+		return s.sendErr
+	} else if s.closed {
+		return errors.New("Use Of Closed Connection")
+	}
+
+	if len(s.unacked) == 0 {
+		s.progress = time.Now() // The timeout counts from the first packet left waiting
+	}
+	seq := s.sendSeq
+	s.sendSeq++
+	s.unacked = append(s.unacked, outPacket{kind, payload, time.Now()})
+	s.mu.Unlock()
+	err := s.m.sendPacket(s.remote, kind, seq, payload)
+	s.mu.Lock()
+	return err
+}
+
+// Waits until every packet we sent has been acked, the other side stops acking or timeout passes.
+// s.mu must be held.
+// @return error - An error is produced if not everything was acked
+func (s *session) drain(timeout time.Duration) error {
+	giveUp := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+	for len(s.unacked) > 0 && s.sendErr == nil && time.Now().Before(giveUp) {
+		s.cond.Wait()
+	}
+	if s.sendErr != nil {
+		return s.sendErr
+	} else if len(s.unacked) > 0 {
+		return errors.New("Peer Stopped Acknowledging")
+	}
+	return nil
+}
+
+// Read - Reads from the stream, blocking until data arrives, the stream ends or the deadline
+func (s *session) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.buf.Len() == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		if s.closed {
+			return 0, errors.New("Use Of Closed Connection")
+		}
+		if !s.deadline.IsZero() {
+			wait := time.Until(s.deadline)
+			if wait <= 0 {
+				return 0, timeoutError{}
+			}
+			timer := time.AfterFunc(wait, func() {
+				s.mu.Lock()
+				s.cond.Broadcast()
+				s.mu.Unlock()
+			})
+			s.cond.Wait()
+			timer.Stop()
+			continue
+		}
+		s.cond.Wait()
+	}
+	return s.buf.Read(b)
+}
+
+// Write - Writes to the stream, returning once every byte is sent or waiting in the window. An
+// error is produced once the other side has stopped acking, which may be noticed a Write late.
+func (s *session) Write(b []byte) (int, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		end := written + maxPayload
+		if end > len(b) {
+			end = len(b)
+		}
+		// The caller may reuse b as soon as we return, while the packet may still be resent
+		payload := append([]byte(nil), b[written:end]...)
+		if err := s.send(pktData, payload); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// Close - Waits for everything written to be acked, closes our half of the stream and removes
+// the session
+func (s *session) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	err := s.drain(sendTimeout)
+	if err == nil && s.send(pktFin, nil) == nil {
+		s.drain(time.Second) // The other side may be gone already, which is fine
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.m.remove(s)
+	return err
+}
+
+// LocalAddr - Returns the address of our UDP socket
+func (s *session) LocalAddr() net.Addr { return s.m.sock.LocalAddr() }
+
+// RemoteAddr - Returns the public address of the other side
+func (s *session) RemoteAddr() net.Addr { return s.remote }
+
+// SetDeadline - Sets the read deadline. Writes always time out after sendTimeout.
+func (s *session) SetDeadline(t time.Time) error { return s.SetReadDeadline(t) }
+
+// SetReadDeadline - Sets the time after which Read returns a timeout error
+func (s *session) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.deadline = t
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline - Writes always time out after sendTimeout so this does nothing
+func (s *session) SetWriteDeadline(t time.Time) error { return nil }